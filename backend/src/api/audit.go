@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditRepository appends to and queries the audit log. It has no way to change or delete entries.
+type AuditRepository interface {
+	RecordAudit(ctx context.Context, e models.AuditEntry) error
+	ListAudit(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
+}
+
+// AuditPublisher mirrors audit entries to an external sink (implemented by kafka.AuditAdapter).
+type AuditPublisher interface {
+	PublishAudit(ctx context.Context, e models.AuditEntry) error
+}
+
+// WithAudit records privileged actions in the audit log and serves it at /admin/audit.
+func WithAudit(a AuditRepository) Option { return func(s *Server) { s.auditLog = a } }
+
+// WithAuditMirror additionally publishes every recorded audit entry to p.
+func WithAuditMirror(p AuditPublisher) Option { return func(s *Server) { s.auditPub = p } }
+
+// maxAuditPage bounds GET /admin/audit; the default page is 100 entries.
+const maxAuditPage = 500
+
+// audit appends e to the audit log, stamped with the request ID of ctx, and mirrors it. Failures
+// are logged rather than returned: the action it records has already happened.
+func (s *Server) audit(ctx context.Context, e models.AuditEntry) {
+	if s.auditLog == nil {
+		return
+	}
+	e.ID, e.At, e.RequestID = uuid.NewString(), s.now(), RequestIDFrom(ctx)
+	if err := s.auditLog.RecordAudit(ctx, e); err != nil {
+		logger.Error("audit record", err, logger.FieldKV("action", e.Action), logger.FieldKV("actor", e.Actor), logger.FieldKV("target", e.Target))
+		return
+	}
+	if s.auditPub == nil {
+		return
+	}
+	if err := s.auditPub.PublishAudit(ctx, e); err != nil {
+		logger.Error("audit mirror", err, logger.FieldKV("id", e.ID), logger.FieldKV("action", e.Action))
+	}
+}
+
+// handleListAudit serves the audit log, newest first. from and to are RFC 3339 times; an action
+// ending in "." selects every action with that prefix.
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := models.AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target"), RoomID: q.Get("room_id"), Limit: 100}
+	for _, p := range []struct {
+		name string
+		into *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if v := q.Get(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, p.name+" must be an RFC 3339 time", http.StatusBadRequest)
+				return
+			}
+			*p.into = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditPage {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		f.Limit = n
+	}
+	list, err := s.auditLog.ListAudit(r.Context(), f)
+	if err != nil {
+		logger.Error("list audit", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+)
+
+type mockAudit struct{ entries []models.AuditEntry }
+
+func (m *mockAudit) RecordAudit(ctx context.Context, e models.AuditEntry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+func (m *mockAudit) ListAudit(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	out := []models.AuditEntry{}
+	for i := len(m.entries) - 1; i >= 0 && len(out) < f.Limit; i-- {
+		e := m.entries[i]
+		if (f.Actor == "" || e.Actor == f.Actor) && (f.Target == "" || e.Target == f.Target) && (f.RoomID == "" || e.RoomID == f.RoomID) &&
+			(f.Action == "" || e.Action == f.Action || (strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action))) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+type mockAuditMirror struct{ entries []models.AuditEntry }
+
+func (m *mockAuditMirror) PublishAudit(ctx context.Context, e models.AuditEntry) error {
+	m.entries = append(m.entries, e)
+	return errors.New("broker down")
+}
+
+func newAuditServer(rooms *mockRooms, audit *mockAudit, mirror *mockAuditMirror) *Server {
+	verifier := identityVerifier{"owner": {Subject: "owner"}, "bob": {Subject: "bob"}, "admin": {Subject: "admin", Groups: []string{"chat-admins"}}}
+	return NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500,
+		WithAdminGroups([]string{"chat-admins"}), WithRooms(rooms), WithAudit(audit), WithAuditMirror(mirror))
+}
+
+func TestAuditRoleChange(t *testing.T) {
+	rooms := newMockRooms()
+	rooms.rooms["dev"] = models.Room{RoomID: "dev", OwnerID: "owner"}
+	audit, mirror := &mockAudit{}, &mockAuditMirror{}
+	srv := newAuditServer(rooms, audit, mirror)
+
+	r := httptest.NewRequest("PUT", "/api/rooms/dev/roles/bob", strings.NewReader(`{"role":"moderator"}`))
+	r.Header.Set("Authorization", "Bearer owner")
+	r.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") != "req-1" {
+		t.Fatalf("grant: %d, request id %q", w.Code, w.Header().Get("X-Request-ID"))
+	}
+	serve(srv, "PUT", "/api/rooms/dev/roles/bob", "owner", `{"role":"owner"}`)
+
+	if len(audit.entries) != 2 {
+		t.Fatalf("expected two entries, got %+v", audit.entries)
+	}
+	first, second := audit.entries[0], audit.entries[1]
+	if first.Action != "room.role.grant" || first.Actor != "owner" || first.Target != "bob" || first.RoomID != "dev" || first.RequestID != "req-1" ||
+		first.Before != nil || first.After["role"] != models.RoleModerator {
+		t.Fatalf("unexpected first entry %+v", first)
+	}
+	if second.RequestID == "" || second.RequestID == "req-1" || second.Before["role"] != models.RoleModerator || second.After["role"] != models.RoleOwner {
+		t.Fatalf("unexpected second entry %+v", second)
+	}
+	if len(mirror.entries) != 2 || mirror.entries[0].ID != first.ID {
+		t.Fatalf("entries should be mirrored even when publishing fails: %+v", mirror.entries)
+	}
+}
+
+func TestRequestIDRejectsMalformedHeader(t *testing.T) {
+	srv := newAuditServer(newMockRooms(), &mockAudit{}, &mockAuditMirror{})
+	r := httptest.NewRequest("GET", "/api/admin/audit", nil)
+	r.Header.Set("X-Request-ID", "bad id\n")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if rid := w.Header().Get("X-Request-ID"); rid == "" || rid == "bad id\n" {
+		t.Fatalf("expected a generated request id, got %q", rid)
+	}
+}
+
+func TestListAudit(t *testing.T) {
+	audit := &mockAudit{entries: []models.AuditEntry{
+		{ID: "1", Actor: "mod", Action: "sanction.ban", Target: "bob"},
+		{ID: "2", Actor: "admin", Action: "export", RoomID: "dev"},
+		{ID: "3", Actor: "mod", Action: "sanction.unban", Target: "bob"},
+	}}
+	srv := newAuditServer(newMockRooms(), audit, &mockAuditMirror{})
+
+	if w := serve(srv, "GET", "/api/admin/audit", "bob", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("users may not read the audit log: %d", w.Code)
+	}
+	var list []models.AuditEntry
+	_ = json.Unmarshal(serve(srv, "GET", "/api/admin/audit?action=sanction.&target=bob", "admin", "").Body.Bytes(), &list)
+	if len(list) != 2 || list[0].ID != "3" || list[1].ID != "1" {
+		t.Fatalf("expected bob's sanctions newest first, got %+v", list)
+	}
+	_ = json.Unmarshal(serve(srv, "GET", "/api/admin/audit?limit=1", "admin", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != "3" {
+		t.Fatalf("limit: %+v", list)
+	}
+	for _, q := range []string{"limit=0", "limit=501", "from=yesterday"} {
+		if w := serve(srv, "GET", "/api/admin/audit?"+q, "admin", ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"src/apitoken"
+	"src/logger"
+	"src/models"
+)
+
+// IdentityVerifier is optionally implemented by a TokenVerifier that can also expose
+// the caller's identity (subject, groups, expiry) from the verified token.
+type IdentityVerifier interface {
+	VerifyIdentity(ctx context.Context, raw string) (models.Identity, error)
+}
+
+type ctxKey int
+
+const (
+	identityKey ctxKey = iota
+	requestIDKey
+)
+
+// authenticate verifies raw and returns the caller identity with its global role resolved.
+// Verifiers that cannot provide an identity yield an anonymous identity with the user role.
+// Service account API tokens are resolved locally into a scoped bot identity. A user's first
+// login creates their profile.
+func (s *Server) authenticate(ctx context.Context, raw string) (models.Identity, error) {
+	if apitoken.IsToken(raw) {
+		return s.authenticateAPIToken(ctx, raw)
+	}
+	var id models.Identity
+	var err error
+	if iv, ok := s.verifier.(IdentityVerifier); ok {
+		id, err = iv.VerifyIdentity(ctx, raw)
+	} else {
+		err = s.verifier.Verify(ctx, raw)
+	}
+	if err != nil {
+		return id, err
+	}
+	id.Role = s.globalRole(id)
+	s.ensureProfile(ctx, id)
+	return id, nil
+}
+
+// authError answers a failed verification: 503 while the identity provider is still being
+// discovered (clients should retry), 401 otherwise.
+func authError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrAuthUnavailable) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Authentication unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func withIdentity(ctx context.Context, id models.Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// IdentityFrom returns the identity stored by withAuth.
+func IdentityFrom(ctx context.Context) (models.Identity, bool) {
+	id, ok := ctx.Value(identityKey).(models.Identity)
+	return id, ok
+}
+
+// globalRole maps token groups to the highest matching global role.
+func (s *Server) globalRole(id models.Identity) models.Role {
+	switch {
+	case inGroups(id, s.adminGroups):
+		return models.RoleAdmin
+	case inGroups(id, s.moderatorGroups):
+		return models.RoleModerator
+	default:
+		return models.RoleUser
+	}
+}
+
+// require is withAuth restricted to callers whose global role is at least min.
+func (s *Server) require(min models.Role, next http.HandlerFunc) http.HandlerFunc {
+	return s.withAuth(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := IdentityFrom(r.Context())
+		if id.Role.Rank() < min.Rank() {
+			logger.Info("authorization denied", logger.FieldKV("sub", id.Subject), logger.FieldKV("role", id.Role), logger.FieldKV("required", min), logger.FieldKV("path", r.URL.Path))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// withAdmin guards admin endpoints.
+func (s *Server) withAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return s.require(models.RoleAdmin, next)
+}
+
+func inGroups(id models.Identity, groups []string) bool {
+	for _, g := range id.Groups {
+		for _, want := range groups {
+			if g == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// roomRole is the caller's effective role in room: the higher of the global role, ownership
+// of the room record and any per-room grant stored in Mongo.
+func (s *Server) roomRole(ctx context.Context, id models.Identity, room models.Room) models.Role {
+	best := id.Role
+	if best == "" {
+		best = models.RoleUser
+	}
+	if id.Subject == "" {
+		return best
+	}
+	if room.OwnerID == id.Subject && models.RoleOwner.Rank() > best.Rank() {
+		best = models.RoleOwner
+	}
+	if s.rooms != nil && room.RoomID != "" {
+		granted, err := s.rooms.GetRoomRole(ctx, room.RoomID, id.Subject)
+		if err != nil {
+			logger.Error("room role lookup", err, logger.FieldKV("room_id", room.RoomID))
+		} else if granted.Rank() > best.Rank() {
+			best = granted
+		}
+	}
+	return best
+}
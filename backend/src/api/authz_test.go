@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"src/config"
+	"src/models"
+	oidcutil "src/oidc"
+	"src/oidc/oidctest"
+	"testing"
+	"time"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+)
+
+// signedTokenVerifier verifies tokens minted by iss through the production
+// oidcutil.VerifyToken / IdentityFromToken path, with keys fetched from the issuer's JWKS.
+func signedTokenVerifier(t *testing.T, iss *oidctest.Issuer) *oidcutil.Verifier {
+	t.Helper()
+	p, err := coreoidc.NewProvider(context.Background(), iss.URL)
+	if err != nil {
+		t.Fatalf("discover test issuer: %v", err)
+	}
+	return oidcutil.NewVerifier(p.Verifier(&coreoidc.Config{ClientID: iss.Audience}))
+}
+
+func signToken(iss *oidctest.Issuer, sub string, groups []string, ttl time.Duration) string {
+	claims := oidctest.Claims{"exp": time.Now().Add(ttl).Unix()}
+	if groups != nil {
+		claims["groups"] = groups
+	}
+	return iss.Token(sub, claims)
+}
+
+func TestRoleBasedAuthorization(t *testing.T) {
+	iss := oidctest.New(config.Audience)
+	defer iss.Close()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rooms := newMockRooms()
+	rooms.rooms["dev"] = models.Room{RoomID: "dev", OwnerID: "owner"}
+	rooms.roles["dev/roommod"] = models.RoomRole{RoomID: "dev", UserID: "roommod", Role: models.RoleModerator}
+	rooms.msgs["m1"] = models.Message{MessageID: "m1", RoomID: "dev"}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, signedTokenVerifier(t, iss), nil, make(chan models.Message), 100,
+		WithRooms(rooms), WithHolds(&mockHolds{}), WithSanctions(&mockSanctions{list: []models.Sanction{{UserID: "user", Kind: models.SanctionBan, RoomID: "ops"}}}),
+		WithModeratorGroups([]string{"chat-moderators"}), WithAdminGroups([]string{"chat-admins"}))
+
+	admin := signToken(iss, "root", []string{"staff", "chat-admins"}, time.Hour)
+	globalMod := signToken(iss, "gmod", []string{"chat-moderators"}, time.Hour)
+	roomMod := signToken(iss, "roommod", nil, time.Hour)
+	owner := signToken(iss, "owner", nil, time.Hour)
+	user := signToken(iss, "user", []string{"staff"}, time.Hour)
+	expiredAdmin := signToken(iss, "root", []string{"chat-admins"}, -time.Hour)
+	forged := iss.TokenWithKey(otherKey, "key-1", "root", oidctest.Claims{"groups": []string{"chat-admins"}})
+
+	cases := []struct {
+		name, method, path, token, body string
+		want                            int
+	}{
+		{"admin lists holds", "GET", "/api/admin/holds", admin, "", 200},
+		{"moderator cannot list holds", "GET", "/api/admin/holds", globalMod, "", 403},
+		{"user cannot list holds", "GET", "/api/admin/holds", user, "", 403},
+		{"expired admin token", "GET", "/api/admin/holds", expiredAdmin, "", 401},
+		{"token signed by unknown key", "GET", "/api/admin/holds", forged, "", 401},
+		{"user lists pins", "GET", "/api/rooms/dev/pins", user, "", 200},
+		{"banned user cannot list pins", "GET", "/api/rooms/ops/pins", user, "", 403},
+		{"participant lists DM pins", "GET", "/api/rooms/" + models.DMRoomID("owner", "user") + "/pins", user, "", 200},
+		{"outsider cannot list DM pins", "GET", "/api/rooms/" + models.DMRoomID("owner", "roommod") + "/pins", user, "", 403},
+		{"user cannot pin", "POST", "/api/rooms/dev/pins", user, `{"message_id":"m1"}`, 403},
+		{"room moderator pins", "POST", "/api/rooms/dev/pins", roomMod, `{"message_id":"m1"}`, 201},
+		{"global moderator pins", "POST", "/api/rooms/dev/pins", globalMod, `{"message_id":"m1"}`, 201},
+		{"room moderator cannot grant roles", "PUT", "/api/rooms/dev/roles/user", roomMod, `{"role":"moderator"}`, 403},
+		{"global moderator cannot grant roles", "PUT", "/api/rooms/dev/roles/user", globalMod, `{"role":"moderator"}`, 403},
+		{"owner grants moderator", "PUT", "/api/rooms/dev/roles/user", owner, `{"role":"moderator"}`, 200},
+		{"invalid role", "PUT", "/api/rooms/dev/roles/user", owner, `{"role":"admin"}`, 400},
+		{"granted user now pins", "POST", "/api/rooms/dev/pins", user, `{"message_id":"m1"}`, 201},
+		{"user lists roles as room moderator", "GET", "/api/rooms/dev/roles", user, "", 200},
+		{"admin revokes", "DELETE", "/api/rooms/dev/roles/user", admin, "", 204},
+		{"revoked user cannot pin", "POST", "/api/rooms/dev/pins", user, `{"message_id":"m1"}`, 403},
+		{"admin moderates default room", "PUT", "/api/rooms/general/retention", admin, `{"retention":"24h"}`, 200},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := serve(srv, tc.method, tc.path, tc.token, tc.body); w.Code != tc.want {
+				t.Fatalf("expected %d got %d (%s)", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGlobalRoleMapping(t *testing.T) {
+	srv := NewServer(&mockProducer{}, &mockRepo{}, &mockVerifier{}, nil, make(chan models.Message), 100,
+		WithModeratorGroups([]string{"mods"}), WithAdminGroups([]string{"admins"}))
+	cases := []struct {
+		groups []string
+		want   models.Role
+	}{
+		{nil, models.RoleUser},
+		{[]string{"staff"}, models.RoleUser},
+		{[]string{"mods"}, models.RoleModerator},
+		{[]string{"mods", "admins"}, models.RoleAdmin},
+	}
+	for _, tc := range cases {
+		if got := srv.globalRole(models.Identity{Groups: tc.groups}); got != tc.want {
+			t.Errorf("groups %v: expected %s got %s", tc.groups, tc.want, got)
+		}
+	}
+}
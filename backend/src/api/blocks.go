@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"time"
+)
+
+// BlockRepository persists the users each user blocked.
+type BlockRepository interface {
+	BlockUser(ctx context.Context, b models.Block) error
+	UnblockUser(ctx context.Context, userID, blockedID string) error
+	ListBlocks(ctx context.Context, userID string) ([]models.Block, error)
+	IsBlocked(ctx context.Context, userID, blockedID string) (bool, error)
+}
+
+// WithBlocks lets users block each other.
+func WithBlocks(r BlockRepository) Option { return func(s *Server) { s.blocks = r } }
+
+const (
+	errNotInDM     = "you are not part of this conversation"
+	errBlockedInDM = "this user does not accept your messages"
+)
+
+// blockedBy returns the users userID blocked. Lookup failures are logged and treated as no blocks.
+func (s *Server) blockedBy(ctx context.Context, userID string) map[string]bool {
+	blocked := map[string]bool{}
+	if s.blocks == nil {
+		return blocked
+	}
+	list, err := s.blocks.ListBlocks(ctx, userID)
+	if err != nil {
+		logger.Error("block lookup", err, logger.FieldKV("sub", userID))
+		return blocked
+	}
+	for _, b := range list {
+		blocked[b.BlockedID] = true
+	}
+	return blocked
+}
+
+// dmRestriction returns why id may not post in the direct message room, or "" if they may (or the
+// room is not a DM).
+func (s *Server) dmRestriction(ctx context.Context, id models.Identity, room string) string {
+	a, b, ok := models.DMParticipants(room)
+	if !ok {
+		return ""
+	}
+	other := a
+	switch id.Subject {
+	case a:
+		other = b
+	case b:
+	default:
+		return errNotInDM
+	}
+	if s.blocks == nil {
+		return ""
+	}
+	blocked, err := s.blocks.IsBlocked(ctx, other, id.Subject)
+	if err != nil {
+		logger.Error("block lookup", err, logger.FieldKV("sub", other))
+		return ""
+	}
+	if blocked {
+		return errBlockedInDM
+	}
+	return ""
+}
+
+// visibleIn reports whether the message history of id shows m: not hidden messages, messages from
+// rooms id is banned from, other users' direct messages or users id blocked.
+func visibleIn(id models.Identity, m models.Message, bans map[string]time.Time, blocked map[string]bool) bool {
+	return !m.Hidden && !blocked[m.UserID] && id.Allows(models.ScopeRead, m.RoomID) && readsRoom(id, roomOf(m), bans)
+}
+
+// readsRoom reports whether id may read room: not banned from it, and a participant if it is a
+// direct message.
+func readsRoom(id models.Identity, room string, bans map[string]time.Time) bool {
+	if _, banned := bans[room]; banned {
+		return false
+	}
+	a, b, dm := models.DMParticipants(room)
+	return !dm || id.Subject == a || id.Subject == b
+}
+
+func (s *Server) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	list, err := s.blocks.ListBlocks(r.Context(), id.Subject)
+	if err != nil {
+		logger.Error("list blocks", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleBlock blocks a user; blocking someone already blocked is a no-op.
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	target := r.PathValue("userID")
+	if target == id.Subject {
+		http.Error(w, "you cannot block yourself", http.StatusBadRequest)
+		return
+	}
+	err := s.blocks.BlockUser(r.Context(), models.Block{UserID: id.Subject, BlockedID: target, CreatedAt: s.now()})
+	if err != nil && !errors.Is(err, models.ErrConflict) {
+		logger.Error("block user", err)
+		http.Error(w, "block failed", http.StatusInternalServerError)
+		return
+	}
+	s.control(r.Context(), models.ControlEvent{Type: models.ControlBlock, UserID: id.Subject, TargetID: target})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	target := r.PathValue("userID")
+	err := s.blocks.UnblockUser(r.Context(), id.Subject, target)
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "not blocked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("unblock user", err)
+		http.Error(w, "unblock failed", http.StatusInternalServerError)
+		return
+	}
+	s.control(r.Context(), models.ControlEvent{Type: models.ControlUnblock, UserID: id.Subject, TargetID: target})
+	w.WriteHeader(http.StatusNoContent)
+}
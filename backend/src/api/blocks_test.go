@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockBlocks struct {
+	mu   sync.Mutex
+	list []models.Block
+}
+
+func (m *mockBlocks) BlockUser(ctx context.Context, b models.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, x := range m.list {
+		if x.UserID == b.UserID && x.BlockedID == b.BlockedID {
+			return models.ErrConflict
+		}
+	}
+	m.list = append(m.list, b)
+	return nil
+}
+func (m *mockBlocks) UnblockUser(ctx context.Context, userID, blockedID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, x := range m.list {
+		if x.UserID == userID && x.BlockedID == blockedID {
+			m.list = append(m.list[:i], m.list[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+func (m *mockBlocks) ListBlocks(ctx context.Context, userID string) ([]models.Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []models.Block{}
+	for _, x := range m.list {
+		if x.UserID == userID {
+			out = append(out, x)
+		}
+	}
+	return out, nil
+}
+func (m *mockBlocks) IsBlocked(ctx context.Context, userID, blockedID string) (bool, error) {
+	list, _ := m.ListBlocks(ctx, userID)
+	for _, x := range list {
+		if x.BlockedID == blockedID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newBlockServer(repo *mockRepo, control *mockControl) *Server {
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}, "carol": {Subject: "carol"}}
+	return NewServer(&capturingProducer{}, repo, verifier, nil, make(chan models.Message), 500,
+		WithBlocks(&mockBlocks{}), WithControl(control))
+}
+
+func TestBlockUser(t *testing.T) {
+	dm := models.DMRoomID("alice", "bob")
+	repo := &mockRepo{msgs: []models.Message{
+		{MessageID: "1", UserID: "bob", RoomID: "general"},
+		{MessageID: "2", UserID: "carol", RoomID: "general"},
+		{MessageID: "3", UserID: "carol", RoomID: models.DMRoomID("bob", "carol")},
+	}}
+	control := &mockControl{}
+	srv := newBlockServer(repo, control)
+
+	if w := serve(srv, "PUT", "/api/users/me/blocks/alice", "alice", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("self block: %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := serve(srv, "PUT", "/api/users/me/blocks/bob", "alice", ""); w.Code != http.StatusNoContent {
+			t.Fatalf("block: %d", w.Code)
+		}
+	}
+	var blocks []models.Block
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users/me/blocks", "alice", "").Body.Bytes(), &blocks)
+	if len(blocks) != 1 || blocks[0].BlockedID != "bob" {
+		t.Fatalf("unexpected blocks %+v", blocks)
+	}
+
+	var history []models.Message
+	_ = json.Unmarshal(serve(srv, "GET", "/api/messages", "alice", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].MessageID != "2" {
+		t.Fatalf("history should hide blocked users and other people's DMs: %+v", history)
+	}
+
+	body := `{"content":"hi","room_id":"` + dm + `"}`
+	if w := serve(srv, "POST", "/api/messages", "bob", body); w.Code != http.StatusForbidden || strings.TrimSpace(w.Body.String()) != errBlockedInDM {
+		t.Fatalf("DM from a blocked user: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(srv, "POST", "/api/messages", "carol", body); w.Code != http.StatusForbidden || strings.TrimSpace(w.Body.String()) != errNotInDM {
+		t.Fatalf("DM from an outsider: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(srv, "POST", "/api/messages", "alice", body); w.Code != http.StatusAccepted {
+		t.Fatalf("the blocker may still write: %d", w.Code)
+	}
+
+	if w := serve(srv, "DELETE", "/api/users/me/blocks/bob", "alice", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unblock: %d", w.Code)
+	}
+	if w := serve(srv, "DELETE", "/api/users/me/blocks/bob", "alice", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("unblock twice: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages", "bob", body); w.Code != http.StatusAccepted {
+		t.Fatalf("DM after unblocking: %d", w.Code)
+	}
+	if len(control.events) != 3 || control.events[0].Type != models.ControlBlock || control.events[0].TargetID != "bob" || control.events[2].Type != models.ControlUnblock {
+		t.Fatalf("unexpected control events %+v", control.events)
+	}
+}
+
+func TestHubAppliesBlocks(t *testing.T) {
+	control := &mockControl{}
+	srv := newBlockServer(&mockRepo{}, control)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(srv, "PUT", "/api/users/me/blocks/bob", "alice", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("block: %d", w.Code)
+	}
+	srv.hub.Broadcast(models.Message{MessageID: "blocked", UserID: "bob", RoomID: "general"})
+	srv.hub.Broadcast(models.Message{MessageID: "private", UserID: "carol", RoomID: models.DMRoomID("bob", "carol")})
+	srv.hub.Broadcast(models.Message{MessageID: "dm", UserID: "carol", RoomID: models.DMRoomID("alice", "carol")})
+	var got models.Message
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil || got.MessageID != "dm" {
+		t.Fatalf("expected only alice's own DM, got %+v (%v)", got, err)
+	}
+
+	// Blocks made on another replica arrive as control events.
+	srv.ApplyControl(models.ControlEvent{Type: models.ControlUnblock, UserID: "alice", TargetID: "bob"})
+	srv.hub.Broadcast(models.Message{MessageID: "unblocked", UserID: "bob", RoomID: "general"})
+	if err := conn.ReadJSON(&got); err != nil || got.MessageID != "unblocked" {
+		t.Fatalf("expected bob's message after the unblock, got %+v (%v)", got, err)
+	}
+}
+
+func TestForgedAuthorStillBlocked(t *testing.T) {
+	producer := &capturingProducer{}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}}
+	srv := NewServer(producer, &mockRepo{}, verifier, nil, make(chan models.Message), 500,
+		WithBlocks(&mockBlocks{list: []models.Block{{UserID: "alice", BlockedID: "bob"}}}))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(srv, "POST", "/api/messages", "bob", `{"content":"hi","room_id":"general","user_id":"carol"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("post: %d", w.Code)
+	}
+	if len(producer.msgs) != 1 || producer.msgs[0].UserID != "bob" {
+		t.Fatalf("the client's user_id should be replaced by the caller: %+v", producer.msgs)
+	}
+	srv.hub.Broadcast(producer.msgs[0])
+	srv.hub.Broadcast(models.Message{MessageID: "next", UserID: "carol", RoomID: "general"})
+	var got models.Message
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil || got.MessageID != "next" {
+		t.Fatalf("the forged message should stay hidden from the blocker, got %+v (%v)", got, err)
+	}
+	srv.repo = &mockRepo{msgs: producer.msgs}
+	var history []models.Message
+	_ = json.Unmarshal(serve(srv, "GET", "/api/messages", "alice", "").Body.Bytes(), &history)
+	if len(history) != 0 {
+		t.Fatalf("history should hide the forged message: %+v", history)
+	}
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"src/command"
+	"src/dispatcher"
+	"src/logger"
+	"src/models"
+	"src/richtext"
+	"src/webhook"
+	"strings"
+	"time"
+)
+
+// CommandRepository persists externally registered slash commands. They are read on every
+// invocation so all replicas see registrations immediately.
+type CommandRepository interface {
+	CreateCommand(ctx context.Context, c models.ExternalCommand) error
+	ListCommands(ctx context.Context) ([]models.ExternalCommand, error)
+	GetCommand(ctx context.Context, name string) (models.ExternalCommand, error)
+	DeleteCommand(ctx context.Context, name string) error
+}
+
+// maxSanctionDuration bounds /mute and /ban; longer sanctions are a job for the moderation API.
+const maxSanctionDuration = 30 * 24 * time.Hour
+
+// registerBuiltins adds the built-in commands whose dependencies are configured.
+func (s *Server) registerBuiltins() {
+	builtins := []command.Command{
+		{Name: "help", Usage: "/help", Description: "List the commands you can use here", Run: s.cmdHelp},
+		{Name: "me", Usage: "/me <action>", Description: "Post an action, e.g. /me waves", Run: cmdMe},
+	}
+	if s.rooms != nil {
+		builtins = append(builtins,
+			command.Command{Name: "topic", Usage: "/topic [text]", Description: "Set or clear the room topic", MinRole: models.RoleModerator, Run: s.cmdTopic},
+			command.Command{Name: "invite", Usage: "/invite <user>", Description: "Add a user to the room", MinRole: models.RoleModerator, Run: s.cmdInvite},
+		)
+	}
+	if s.sanctions != nil {
+		builtins = append(builtins,
+			command.Command{Name: "mute", Usage: "/mute <user> [duration] [reason]", Description: "Stop a user posting in the room", MinRole: models.RoleModerator, Run: s.cmdSanction(models.SanctionMute)},
+			command.Command{Name: "ban", Usage: "/ban <user> [duration] [reason]", Description: "Stop a user reading or posting in the room", MinRole: models.RoleModerator, Run: s.cmdSanction(models.SanctionBan)},
+			command.Command{Name: "kick", Usage: "/kick <user> [reason]", Description: "Disconnect a user; they may reconnect", MinRole: models.RoleModerator, Run: s.cmdSanction(models.SanctionKick)},
+		)
+	}
+	for _, c := range builtins {
+		if err := s.commands.Register(c); err != nil {
+			panic("register /" + c.Name + ": " + err.Error())
+		}
+	}
+}
+
+// commandOutcome is the result of a slash command as seen by the transport: an ephemeral reply
+// for the caller and, optionally, the message it published.
+type commandOutcome struct {
+	reply  models.CommandReply
+	posted *models.Message
+	status string
+}
+
+// execCommand runs a parsed command for id and publishes the message it produced, if any.
+// Unknown commands, missing permissions and usage errors are reported in the reply rather than
+// as errors, so WebSocket and REST callers see the same text.
+func (s *Server) execCommand(ctx context.Context, id models.Identity, msg models.Message, name, args string) commandOutcome {
+	out := commandOutcome{reply: models.CommandReply{Type: models.FrameCommandReply, Command: name, RoomID: msg.RoomID}}
+	cmd, ok, err := s.lookupCommand(ctx, name)
+	if err != nil {
+		logger.Error("command lookup", err, logger.FieldKV("command", name))
+		out.reply.Text = "/" + name + " is unavailable right now"
+		return out
+	}
+	if !ok {
+		out.reply.Text = fmt.Sprintf("unknown command /%s, try /help", name)
+		return out
+	}
+	role := s.roleIn(ctx, id, msg.RoomID)
+	if !cmd.Allowed(role) {
+		out.reply.Text = fmt.Sprintf("/%s requires the %s role in this room", name, cmd.MinRole)
+		return out
+	}
+	msg.Timestamp = s.now()
+	res, err := cmd.Run(ctx, command.Invocation{Name: name, Args: args, Caller: id, Role: role, Message: msg})
+	var usage command.UsageError
+	switch {
+	case errors.As(err, &usage):
+		out.reply.Text = usage.Error()
+		return out
+	case err != nil:
+		logger.Error("command failed", err, logger.FieldKV("command", name), logger.FieldKV("sub", id.Subject))
+		out.reply.Text = "/" + name + " failed"
+		return out
+	}
+	out.reply.Text = res.Reply
+	if res.Message != nil {
+		if reason := s.restriction(ctx, id, msg.RoomID); reason != "" {
+			out.reply.Text = reason
+			return out
+		}
+		status, err := s.acceptMessage(ctx, id.Subject, res.Message)
+		if err != nil {
+			out.reply.Text = err.Error()
+			return out
+		}
+		out.posted, out.status = res.Message, status
+	}
+	return out
+}
+
+// lookupCommand resolves name to a built-in or, failing that, an external command.
+func (s *Server) lookupCommand(ctx context.Context, name string) (command.Command, bool, error) {
+	if c, ok := s.commands.Lookup(name); ok {
+		return c, true, nil
+	}
+	if s.extCommands == nil {
+		return command.Command{}, false, nil
+	}
+	ext, err := s.extCommands.GetCommand(ctx, name)
+	if errors.Is(err, models.ErrNotFound) {
+		return command.Command{}, false, nil
+	}
+	if err != nil {
+		return command.Command{}, false, err
+	}
+	return externalCommand(ext, s.commandClient), true, nil
+}
+
+func externalCommand(ext models.ExternalCommand, client *http.Client) command.Command {
+	return command.Command{Name: ext.Name, Usage: ext.Usage, Description: ext.Description, MinRole: ext.MinRole, Run: command.Remote(ext.URL, ext.Secret, client)}
+}
+
+// roleIn returns id's effective role in roomID; rooms without a record only carry global roles.
+func (s *Server) roleIn(ctx context.Context, id models.Identity, roomID string) models.Role {
+	room := models.Room{RoomID: roomID}
+	if s.rooms != nil {
+		if r, err := s.rooms.GetRoom(ctx, roomID); err == nil {
+			room = r
+		}
+	}
+	return s.roomRole(ctx, id, room)
+}
+
+func cmdMe(_ context.Context, inv command.Invocation) (command.Result, error) {
+	if inv.Args == "" {
+		return command.Result{}, command.UsageError("usage: /me <action>")
+	}
+	msg := inv.Message
+	msg.Content, msg.Format = "*"+richtext.EscapeMarkdown(msg.UserID+" "+inv.Args)+"*", richtext.FormatMarkdown
+	return command.Result{Message: &msg}, nil
+}
+
+func (s *Server) cmdHelp(ctx context.Context, inv command.Invocation) (command.Result, error) {
+	cmds := s.commands.List()
+	if s.extCommands != nil {
+		ext, err := s.extCommands.ListCommands(ctx)
+		if err != nil {
+			return command.Result{}, err
+		}
+		for _, e := range ext {
+			cmds = append(cmds, externalCommand(e, nil))
+		}
+	}
+	var b strings.Builder
+	b.WriteString("Available commands:")
+	for _, c := range cmds {
+		if c.Allowed(inv.Role) {
+			fmt.Fprintf(&b, "\n%s - %s", c.Usage, c.Description)
+		}
+	}
+	return command.Result{Reply: b.String()}, nil
+}
+
+func (s *Server) cmdTopic(ctx context.Context, inv command.Invocation) (command.Result, error) {
+	room := inv.Message.RoomID
+	if err := s.rooms.SetRoomTopic(ctx, room, inv.Args); err != nil {
+		return command.Result{}, err
+	}
+	s.hub.Broadcast(models.Event{Type: models.EventTopic, RoomID: room, Actor: inv.Caller.Subject, Text: inv.Args, Timestamp: inv.Message.Timestamp})
+	logger.Info("room topic set", logger.FieldKV("room_id", room), logger.FieldKV("actor", inv.Caller.Subject))
+	if inv.Args == "" {
+		return command.Result{Reply: "topic cleared"}, nil
+	}
+	return command.Result{Reply: "topic updated"}, nil
+}
+
+func (s *Server) cmdInvite(ctx context.Context, inv command.Invocation) (command.Result, error) {
+	user := strings.TrimPrefix(inv.Args, "@")
+	if user == "" || strings.ContainsAny(user, " \t") {
+		return command.Result{}, command.UsageError("usage: /invite <user>")
+	}
+	room := inv.Message.RoomID
+	m := models.RoomMember{RoomID: room, UserID: user, AddedBy: inv.Caller.Subject, AddedAt: inv.Message.Timestamp}
+	if err := s.rooms.AddRoomMember(ctx, m); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			return command.Result{}, command.UsageError(user + " is already a member of " + room)
+		}
+		return command.Result{}, err
+	}
+	s.hub.Broadcast(models.Event{Type: models.EventMemberJoined, RoomID: room, UserID: user, Actor: inv.Caller.Subject, Timestamp: m.AddedAt})
+	if s.hookEvents != nil {
+		if err := s.hookEvents.Enqueue(ctx, dispatcher.MemberJoined(m)); err != nil {
+			logger.Error("enqueue member joined", err, logger.FieldKV("room_id", room), logger.FieldKV("user_id", user))
+		}
+	}
+	return command.Result{Reply: "invited " + user + " to " + room}, nil
+}
+
+// cmdSanction returns the command applying kind to a user in the room.
+func (s *Server) cmdSanction(kind string) command.Handler {
+	usage := "usage: /" + kind + " <user> [duration] [reason]"
+	if kind == models.SanctionKick {
+		usage = "usage: /kick <user> [reason]"
+	}
+	return func(ctx context.Context, inv command.Invocation) (command.Result, error) {
+		fields := strings.Fields(inv.Args)
+		if len(fields) == 0 {
+			return command.Result{}, command.UsageError(usage)
+		}
+		sn := models.Sanction{Kind: kind, UserID: strings.TrimPrefix(fields[0], "@"), RoomID: inv.Message.RoomID}
+		rest := fields[1:]
+		if len(rest) > 0 && kind != models.SanctionKick {
+			if d, err := time.ParseDuration(rest[0]); err == nil {
+				if d <= 0 || d > maxSanctionDuration {
+					return command.Result{}, command.UsageError("duration must be positive and at most 720h")
+				}
+				exp := s.now().Add(d)
+				sn.ExpiresAt, rest = &exp, rest[1:]
+			}
+		}
+		sn.Reason = strings.Join(rest, " ")
+		var refused sanctionError
+		if err := s.sanction(ctx, inv.Caller, inv.Role, &sn); errors.As(err, &refused) {
+			return command.Result{}, command.UsageError(refused.Error())
+		} else if err != nil {
+			return command.Result{}, err
+		}
+		switch {
+		case kind == models.SanctionKick:
+			return command.Result{Reply: "kicked " + sn.UserID}, nil
+		case sn.ExpiresAt == nil:
+			return command.Result{Reply: fmt.Sprintf("%s is %s in %s", sn.UserID, pastTense(kind), sn.RoomID)}, nil
+		}
+		return command.Result{Reply: fmt.Sprintf("%s is %s in %s until %s", sn.UserID, pastTense(kind), sn.RoomID, sn.ExpiresAt.Format(time.RFC3339))}, nil
+	}
+}
+
+func pastTense(kind string) string {
+	if kind == models.SanctionBan {
+		return "banned"
+	}
+	return "muted"
+}
+
+// createdCommand is returned once at registration; Secret is the only time the signing secret is visible.
+type createdCommand struct {
+	models.ExternalCommand
+	Secret string `json:"secret"`
+}
+
+func (s *Server) handleCreateCommand(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string      `json:"name"`
+		URL         string      `json:"url"`
+		Description string      `json:"description"`
+		Usage       string      `json:"usage"`
+		MinRole     models.Role `json:"min_role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimPrefix(req.Name, "/")
+	if !command.ValidName(req.Name) {
+		http.Error(w, "name must match [a-z][a-z0-9_-]{0,31}", http.StatusBadRequest)
+		return
+	}
+	if !absoluteHTTPURL(req.URL) {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if req.MinRole == "" {
+		req.MinRole = models.RoleUser
+	}
+	if req.MinRole.Rank() == 0 {
+		http.Error(w, "unknown min_role", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.commands.Lookup(req.Name); ok {
+		http.Error(w, "name is taken by a built-in command", http.StatusConflict)
+		return
+	}
+	if req.Usage == "" {
+		req.Usage = "/" + req.Name
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		logger.Error("generate command secret", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	c := models.ExternalCommand{Name: req.Name, URL: req.URL, Secret: secret, Description: req.Description, Usage: req.Usage, MinRole: req.MinRole, CreatedBy: id.Subject, CreatedAt: s.now()}
+	if err := s.extCommands.CreateCommand(r.Context(), c); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			http.Error(w, "command exists", http.StatusConflict)
+			return
+		}
+		logger.Error("create command", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("command registered", logger.FieldKV("command", c.Name), logger.FieldKV("url", c.URL), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "command.create", Target: c.Name,
+		After: map[string]interface{}{"url": c.URL, "min_role": c.MinRole}})
+	writeJSON(w, http.StatusCreated, createdCommand{ExternalCommand: c, Secret: secret})
+}
+
+// handleListCommands returns built-in and external commands.
+func (s *Server) handleListCommands(w http.ResponseWriter, r *http.Request) {
+	ext, err := s.extCommands.ListCommands(r.Context())
+	if err != nil {
+		logger.Error("list commands", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"builtin": s.commands.List(), "external": ext})
+}
+
+func (s *Server) handleDeleteCommand(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.extCommands.DeleteCommand(r.Context(), name); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("delete command", err)
+		http.Error(w, "delete failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("command unregistered", logger.FieldKV("command", name), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "command.delete", Target: name})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListMembers(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	if _, ok := s.authorizeRoom(w, r, roomID, models.RoleUser); !ok {
+		return
+	}
+	list, err := s.rooms.ListRoomMembers(r.Context(), roomID)
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"src/webhook"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type mockCommands struct {
+	cmds map[string]models.ExternalCommand
+}
+
+func (m *mockCommands) CreateCommand(ctx context.Context, c models.ExternalCommand) error {
+	if _, ok := m.cmds[c.Name]; ok {
+		return models.ErrConflict
+	}
+	m.cmds[c.Name] = c
+	return nil
+}
+func (m *mockCommands) ListCommands(ctx context.Context) ([]models.ExternalCommand, error) {
+	out := []models.ExternalCommand{}
+	for _, c := range m.cmds {
+		out = append(out, c)
+	}
+	return out, nil
+}
+func (m *mockCommands) GetCommand(ctx context.Context, name string) (models.ExternalCommand, error) {
+	c, ok := m.cmds[name]
+	if !ok {
+		return c, models.ErrNotFound
+	}
+	return c, nil
+}
+func (m *mockCommands) DeleteCommand(ctx context.Context, name string) error {
+	if _, ok := m.cmds[name]; !ok {
+		return models.ErrNotFound
+	}
+	delete(m.cmds, name)
+	return nil
+}
+
+type mockSanctions struct{ list []models.Sanction }
+
+func (m *mockSanctions) CreateSanction(ctx context.Context, s models.Sanction) error {
+	m.list = append(m.list, s)
+	return nil
+}
+func (m *mockSanctions) ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error) {
+	out := []models.Sanction{}
+	for _, s := range m.list {
+		if s.UserID == userID && s.LiftedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt)) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+func (m *mockSanctions) ListSanctions(ctx context.Context, userID, roomID string) ([]models.Sanction, error) {
+	out := []models.Sanction{}
+	for _, s := range m.list {
+		if (userID == "" || s.UserID == userID) && (roomID == "" || s.RoomID == roomID) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+func (m *mockSanctions) GetSanction(ctx context.Context, id string) (models.Sanction, error) {
+	for _, s := range m.list {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return models.Sanction{}, models.ErrNotFound
+}
+func (m *mockSanctions) LiftSanction(ctx context.Context, id string, at time.Time) (models.Sanction, error) {
+	for i, s := range m.list {
+		if s.ID != id {
+			continue
+		}
+		if s.LiftedAt != nil {
+			return s, models.ErrConflict
+		}
+		m.list[i].LiftedAt = &at
+		return m.list[i], nil
+	}
+	return models.Sanction{}, models.ErrNotFound
+}
+
+type commandFixture struct {
+	srv       *Server
+	producer  *capturingProducer
+	rooms     *mockRooms
+	sanctions *mockSanctions
+	ext       *mockCommands
+	hooks     *mockHookEvents
+	now       time.Time
+}
+
+type mockHookEvents struct{ events []models.HookEvent }
+
+func (m *mockHookEvents) Enqueue(ctx context.Context, ev models.HookEvent) error {
+	m.events = append(m.events, ev)
+	return nil
+}
+
+func newCommandFixture() *commandFixture {
+	f := &commandFixture{producer: &capturingProducer{}, rooms: newMockRooms(), sanctions: &mockSanctions{}, ext: &mockCommands{cmds: map[string]models.ExternalCommand{}}, hooks: &mockHookEvents{}, now: time.Now().UTC()}
+	verifier := identityVerifier{
+		"alice": {Subject: "alice"},
+		"mod":   {Subject: "mod", Groups: []string{"chat-moderators"}},
+		"admin": {Subject: "admin", Groups: []string{"chat-admins"}},
+	}
+	f.srv = NewServer(f.producer, &mockRepo{}, verifier, nil, make(chan models.Message), 500,
+		WithModeratorGroups([]string{"chat-moderators"}), WithAdminGroups([]string{"chat-admins"}), WithRooms(f.rooms), WithSanctions(f.sanctions), WithCommands(f.ext, time.Second), WithHookEvents(f.hooks), WithClock(func() time.Time { return f.now }))
+	return f
+}
+
+// post sends content to the general room as token and decodes the JSON answer.
+func (f *commandFixture) post(t *testing.T, token, content string) (int, map[string]string) {
+	t.Helper()
+	body, _ := json.Marshal(models.Message{Content: content})
+	w := serve(f.srv, "POST", "/api/messages", token, string(body))
+	out := map[string]string{}
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	if w.Code != 200 && w.Code != 202 {
+		out["error"] = strings.TrimSpace(w.Body.String())
+	}
+	return w.Code, out
+}
+
+func TestBuiltinCommands(t *testing.T) {
+	f := newCommandFixture()
+
+	if code, out := f.post(t, "alice", "/me waves"); code != 202 || out["message_id"] == "" {
+		t.Fatalf("/me: expected 202 got %d %v", code, out)
+	}
+	if got := f.producer.msgs[0]; got.Content != "*alice waves*" || got.Format != "markdown" || got.UserID != "alice" {
+		t.Fatalf("/me published %+v", got)
+	}
+	if code, _ := f.post(t, "alice", "/me likes *bold*"); code != 202 {
+		t.Fatalf("/me with markup: expected 202 got %d", code)
+	}
+	if got := f.producer.msgs[1]; got.Content != `*alice likes \*bold\**` || got.HTML != "<p><em>alice likes *bold*</em></p>" {
+		t.Fatalf("/me should keep the typed text: %q %q", got.Content, got.HTML)
+	}
+	if code, out := f.post(t, "alice", "/me"); code != 200 || !strings.HasPrefix(out["text"], "usage:") {
+		t.Fatalf("/me without action: %d %v", code, out)
+	}
+	if code, out := f.post(t, "alice", "/nope"); code != 200 || out["type"] != models.FrameCommandReply || !strings.Contains(out["text"], "unknown command /nope") {
+		t.Fatalf("unknown command: %d %v", code, out)
+	}
+	if _, out := f.post(t, "alice", "/topic Release day"); !strings.Contains(out["text"], "requires the moderator role") {
+		t.Fatalf("/topic by a user should be refused: %v", out)
+	}
+	if _, out := f.post(t, "mod", "/topic Release day"); out["text"] != "topic updated" || f.rooms.rooms["general"].Topic != "Release day" {
+		t.Fatalf("/topic by a moderator: %v %+v", out, f.rooms.rooms["general"])
+	}
+	if _, out := f.post(t, "mod", "/invite @bob"); out["text"] != "invited bob to general" || len(f.rooms.members) != 1 {
+		t.Fatalf("/invite: %v %+v", out, f.rooms.members)
+	}
+	if _, out := f.post(t, "mod", "/invite bob"); !strings.Contains(out["text"], "already a member") {
+		t.Fatalf("/invite twice: %v", out)
+	}
+	if len(f.hooks.events) != 1 || f.hooks.events[0].Type != models.HookEventMemberJoined || f.hooks.events[0].RoomID != "general" {
+		t.Fatalf("/invite should queue one member.joined webhook event: %+v", f.hooks.events)
+	}
+	if _, out := f.post(t, "alice", "/help"); strings.Contains(out["text"], "/topic") || !strings.Contains(out["text"], "/me <action>") {
+		t.Fatalf("/help for a user should hide moderator commands: %q", out["text"])
+	}
+	if _, out := f.post(t, "mod", "/help"); !strings.Contains(out["text"], "/mute <user>") {
+		t.Fatalf("/help for a moderator: %q", out["text"])
+	}
+	if code, _ := f.post(t, "alice", "/usr/bin is a path"); code != 202 || f.producer.msgs[len(f.producer.msgs)-1].Content != "/usr/bin is a path" {
+		t.Fatalf("non-command slash text should be posted as is")
+	}
+	if len(f.producer.msgs) != 3 {
+		t.Fatalf("only /me and plain text should publish, got %d messages", len(f.producer.msgs))
+	}
+}
+
+func TestMuteCommand(t *testing.T) {
+	f := newCommandFixture()
+
+	if _, out := f.post(t, "alice", "/mute mod"); !strings.Contains(out["text"], "requires the moderator role") {
+		t.Fatalf("users may not mute: %v", out)
+	}
+	if _, out := f.post(t, "mod", "/mute mod"); out["text"] != "you cannot mute yourself" {
+		t.Fatalf("self mute: %v", out)
+	}
+	f.rooms.roles["general/carol"] = models.RoomRole{RoomID: "general", UserID: "carol", Role: models.RoleOwner}
+	if _, out := f.post(t, "mod", "/mute carol"); !strings.Contains(out["text"], "owner role") {
+		t.Fatalf("muting a room owner should be refused: %v", out)
+	}
+	if _, out := f.post(t, "mod", "/mute alice 10m spamming links"); !strings.Contains(out["text"], "alice is muted in general until") {
+		t.Fatalf("/mute: %v", out)
+	}
+	sn := f.sanctions.list[0]
+	if sn.Reason != "spamming links" || sn.RoomID != "general" || sn.ExpiresAt == nil || sn.CreatedBy != "mod" {
+		t.Fatalf("unexpected sanction %+v", sn)
+	}
+
+	if code, out := f.post(t, "alice", "hello"); code != 403 || out["error"] != errMuted {
+		t.Fatalf("muted user posting: %d %v", code, out)
+	}
+	if _, out := f.post(t, "alice", "/me sneaks"); out["text"] != errMuted {
+		t.Fatalf("muted user /me: %v", out)
+	}
+	body := `{"content":"elsewhere","room_id":"random"}`
+	if w := serve(f.srv, "POST", "/api/messages", "alice", body); w.Code != 202 {
+		t.Fatalf("room mute should not apply to other rooms: %d", w.Code)
+	}
+	f.now = f.now.Add(11 * time.Minute)
+	if code, _ := f.post(t, "alice", "hello again"); code != 202 {
+		t.Fatalf("expired mute should lift: %d", code)
+	}
+}
+
+func TestExternalCommands(t *testing.T) {
+	f := newCommandFixture()
+	var secret string
+	var calls int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var req struct{ Text string }
+		_ = json.Unmarshal(body, &req)
+		if req.Text == "status" {
+			io.WriteString(w, `{"response_type":"ephemeral","text":"all green"}`)
+			return
+		}
+		io.WriteString(w, `{"response_type":"in_channel","text":"deploying `+req.Text+`"}`)
+	}))
+	defer receiver.Close()
+
+	if w := serve(f.srv, "POST", "/api/admin/commands", "mod", `{"name":"deploy","url":"`+receiver.URL+`"}`); w.Code != 403 {
+		t.Fatalf("non-admin register: expected 403 got %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/admin/commands", "admin", `{"name":"me","url":"`+receiver.URL+`"}`); w.Code != 409 {
+		t.Fatalf("shadowing a built-in: expected 409 got %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/admin/commands", "admin", `{"name":"deploy","url":"ftp://x"}`); w.Code != 400 {
+		t.Fatalf("bad url: expected 400 got %d", w.Code)
+	}
+	w := serve(f.srv, "POST", "/api/admin/commands", "admin", `{"name":"/deploy","url":"`+receiver.URL+`","description":"Ship it","usage":"/deploy <service>"}`)
+	if w.Code != 201 {
+		t.Fatalf("register: expected 201 got %d %s", w.Code, w.Body.String())
+	}
+	var created createdCommand
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	secret = created.Secret
+	if created.Name != "deploy" || secret == "" || created.MinRole != models.RoleUser || f.ext.cmds["deploy"].Secret != secret {
+		t.Fatalf("unexpected registration %+v", created)
+	}
+
+	if code, out := f.post(t, "alice", "/deploy status"); code != 200 || out["text"] != "all green" {
+		t.Fatalf("ephemeral answer: %d %v", code, out)
+	}
+	if code, _ := f.post(t, "alice", "/deploy api"); code != 202 {
+		t.Fatalf("in_channel answer: expected 202 got %d", code)
+	}
+	if got := f.producer.msgs[0]; got.UserID != "command:deploy" || got.DisplayName != "deploy" || !got.Bot || got.Content != "deploying api" {
+		t.Fatalf("in_channel answer published %+v", got)
+	}
+	if _, out := f.post(t, "alice", "/help"); !strings.Contains(out["text"], "/deploy <service> - Ship it") {
+		t.Fatalf("/help should list external commands: %q", out["text"])
+	}
+
+	w = serve(f.srv, "GET", "/api/admin/commands", "admin", "")
+	if w.Code != 200 || strings.Contains(w.Body.String(), secret) || !strings.Contains(w.Body.String(), `"name":"deploy"`) {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(f.srv, "DELETE", "/api/admin/commands/deploy", "admin", ""); w.Code != 204 {
+		t.Fatalf("delete: expected 204 got %d", w.Code)
+	}
+	if _, out := f.post(t, "alice", "/deploy api"); !strings.Contains(out["text"], "unknown command") || calls != 2 {
+		t.Fatalf("deleted command: %v (calls %d)", out, calls)
+	}
+}
+
+func TestCommandReplyIsEphemeralOnWebSocket(t *testing.T) {
+	f := newCommandFixture()
+	ts := httptest.NewServer(f.srv)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	dial := func(token string) *websocket.Conn {
+		d := *websocket.DefaultDialer
+		d.Subprotocols = []string{wsProtocol, bearerProtocolPrefix + token}
+		conn, _, err := d.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if fr, err := readFrame(t, conn); err != nil || fr.Type != models.FrameAuthOK {
+			t.Fatalf("expected auth_ok got %+v %v", fr, err)
+		}
+		return conn
+	}
+	alice, mod := dial("alice"), dial("mod")
+	defer alice.Close()
+	defer mod.Close()
+
+	_ = alice.WriteJSON(models.Message{Content: "/topic hijack"})
+	var reply models.CommandReply
+	_ = alice.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := alice.ReadJSON(&reply); err != nil || reply.Type != models.FrameCommandReply || reply.Command != "topic" || !strings.Contains(reply.Text, "requires") {
+		t.Fatalf("expected command_reply got %+v %v", reply, err)
+	}
+
+	// The moderator sees the topic event from their own command, then its reply; nothing about
+	// alice's failed attempt was sent to them.
+	_ = mod.WriteJSON(models.Message{Content: "/topic Launch"})
+	var ev models.Event
+	_ = mod.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := mod.ReadJSON(&ev); err != nil || ev.Type != models.EventTopic || ev.Text != "Launch" {
+		t.Fatalf("expected topic event got %+v %v", ev, err)
+	}
+	if err := mod.ReadJSON(&reply); err != nil || reply.Text != "topic updated" {
+		t.Fatalf("expected reply got %+v %v", reply, err)
+	}
+}
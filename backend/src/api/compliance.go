@@ -0,0 +1,187 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HoldRepository abstracts legal hold persistence.
+type HoldRepository interface {
+	CreateLegalHold(ctx context.Context, h models.LegalHold) error
+	ListLegalHolds(ctx context.Context) ([]models.LegalHold, error)
+	ReleaseLegalHold(ctx context.Context, id string, at time.Time) error
+}
+
+func (s *Server) handleCreateHold(w http.ResponseWriter, r *http.Request) {
+	var h models.LegalHold
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil || h.Reason == "" {
+		http.Error(w, "bad request (reason required)", http.StatusBadRequest)
+		return
+	}
+	if !h.To.IsZero() && h.To.Before(h.From) {
+		http.Error(w, "to before from", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	h.ID, h.CreatedBy, h.CreatedAt, h.ReleasedAt = uuid.NewString(), id.Subject, s.now(), nil
+	if err := s.holds.CreateLegalHold(r.Context(), h); err != nil {
+		logger.Error("create legal hold", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("legal hold placed", logger.FieldKV("hold_id", h.ID), logger.FieldKV("actor", id.Subject), logger.FieldKV("room_id", h.RoomID), logger.FieldKV("user_id", h.UserID))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "hold.create", Target: h.ID, RoomID: h.RoomID, Reason: h.Reason,
+		After: map[string]interface{}{"user_id": h.UserID, "from": h.From, "to": h.To}})
+	writeJSON(w, http.StatusCreated, h)
+}
+
+func (s *Server) handleListHolds(w http.ResponseWriter, r *http.Request) {
+	list, err := s.holds.ListLegalHolds(r.Context())
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	holdID := r.PathValue("id")
+	if err := s.holds.ReleaseLegalHold(r.Context(), holdID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "release failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("legal hold released", logger.FieldKV("hold_id", holdID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "hold.release", Target: holdID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportManifest describes a zipped export bundle.
+type exportManifest struct {
+	Filter      models.MessageFilter `json:"filter"`
+	GeneratedAt time.Time            `json:"generated_at"`
+	GeneratedBy string               `json:"generated_by"`
+	Files       []exportFile         `json:"files"`
+}
+
+type exportFile struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	SHA256   string `json:"sha256"`
+}
+
+// handleExport streams messages matching room_id / user_id / from / to (RFC 3339) as
+// newline-delimited JSON, or with format=zip as a bundle with a checksummed manifest.
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := models.MessageFilter{RoomID: q.Get("room_id"), UserID: q.Get("user_id")}
+	for key, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid "+key, http.StatusBadRequest)
+				return
+			}
+			*dst = t
+		}
+	}
+	if filter.RoomID == "" && filter.UserID == "" {
+		http.Error(w, "room_id or user_id required", http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "ndjson" && format != "zip" {
+		http.Error(w, "format must be ndjson or zip", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("export started", logger.FieldKV("actor", id.Subject), logger.FieldKV("room_id", filter.RoomID), logger.FieldKV("user_id", filter.UserID))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "export", Target: filter.UserID, RoomID: filter.RoomID,
+		After: map[string]interface{}{"from": filter.From, "to": filter.To, "format": format}})
+	stamp := s.now().Format("20060102T150405Z")
+	switch format {
+	case "", "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="export-`+stamp+`.ndjson"`)
+		if _, err := s.writeNDJSON(r.Context(), w, filter); err != nil {
+			// Headers are already sent; the truncated body is the only signal left.
+			logger.Error("export stream", err)
+		}
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="export-`+stamp+`.zip"`)
+		zw := zip.NewWriter(w)
+		f, err := zw.Create("messages.ndjson")
+		if err != nil {
+			logger.Error("export zip", err)
+			return
+		}
+		h := sha256.New()
+		counter := &countingWriter{w: io.MultiWriter(f, h)}
+		n, err := s.writeNDJSON(r.Context(), counter, filter)
+		if err != nil {
+			// Omitting the manifest marks the bundle as incomplete.
+			logger.Error("export stream", err)
+			_ = zw.Close()
+			return
+		}
+		manifest := exportManifest{Filter: filter, GeneratedAt: s.now(), GeneratedBy: id.Subject,
+			Files: []exportFile{{Name: "messages.ndjson", Messages: n, Bytes: counter.n, SHA256: hex.EncodeToString(h.Sum(nil))}}}
+		mb, _ := json.MarshalIndent(manifest, "", "  ")
+		if mf, err := zw.Create("manifest.json"); err == nil {
+			_, _ = mf.Write(mb)
+		}
+		sum := sha256.Sum256(mb)
+		if sf, err := zw.Create("manifest.json.sha256"); err == nil {
+			_, _ = io.WriteString(sf, hex.EncodeToString(sum[:])+"  manifest.json\n")
+		}
+		if err := zw.Close(); err != nil {
+			logger.Error("export zip close", err)
+		}
+	}
+}
+
+// writeNDJSON streams matching messages one JSON document per line, flushing as it goes.
+func (s *Server) writeNDJSON(ctx context.Context, w io.Writer, filter models.MessageFilter) (int, error) {
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	err := s.repo.StreamMessages(ctx, filter, func(m models.Message) error {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%500 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockHolds struct{ holds []models.LegalHold }
+
+func (m *mockHolds) CreateLegalHold(ctx context.Context, h models.LegalHold) error {
+	m.holds = append(m.holds, h)
+	return nil
+}
+func (m *mockHolds) ListLegalHolds(ctx context.Context) ([]models.LegalHold, error) {
+	return m.holds, nil
+}
+func (m *mockHolds) ReleaseLegalHold(ctx context.Context, id string, at time.Time) error {
+	for i := range m.holds {
+		if m.holds[i].ID == id && m.holds[i].ReleasedAt == nil {
+			m.holds[i].ReleasedAt = &at
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func newComplianceServer(repo *mockRepo, holds *mockHolds) *Server {
+	verifier := identityVerifier{"admin": {Subject: "admin", Groups: []string{"chat-admins"}}, "user": {Subject: "user"}}
+	return NewServer(&mockProducer{}, repo, verifier, nil, make(chan models.Message), 100,
+		WithAdminGroups([]string{"chat-admins"}), WithHolds(holds))
+}
+
+func serve(srv *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func exportFixture() *mockRepo {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &mockRepo{msgs: []models.Message{
+		{MessageID: "1", UserID: "alice", RoomID: "dev", Content: "a", Timestamp: base},
+		{MessageID: "2", UserID: "bob", RoomID: "dev", Content: "b", Timestamp: base.Add(time.Hour)},
+		{MessageID: "3", UserID: "alice", RoomID: "ops", Content: "c", Timestamp: base.Add(2 * time.Hour)},
+	}}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	srv := newComplianceServer(exportFixture(), &mockHolds{})
+	if w := serve(srv, "GET", "/api/admin/export?room_id=dev", "user", ""); w.Code != 403 {
+		t.Fatalf("non-admin export: expected 403 got %d", w.Code)
+	}
+	if w := serve(srv, "GET", "/api/admin/export", "admin", ""); w.Code != 400 {
+		t.Fatalf("unscoped export: expected 400 got %d", w.Code)
+	}
+	w := serve(srv, "GET", "/api/admin/export?user_id=alice&to=2025-01-01T01:30:00Z", "admin", "")
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var ids []string
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var m models.Message
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		ids = append(ids, m.MessageID)
+	}
+	if strings.Join(ids, ",") != "1" {
+		t.Fatalf("expected only message 1, got %v", ids)
+	}
+}
+
+func TestExportZipManifestChecksum(t *testing.T) {
+	srv := newComplianceServer(exportFixture(), &mockHolds{})
+	w := serve(srv, "GET", "/api/admin/export?room_id=dev&format=zip", "admin", "")
+	if w.Code != 200 {
+		t.Fatalf("expected 200 got %d", w.Code)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	var manifest exportManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	sum := sha256.Sum256(files["messages.ndjson"])
+	got := manifest.Files[0]
+	if got.Messages != 2 || got.SHA256 != hex.EncodeToString(sum[:]) || got.Bytes != int64(len(files["messages.ndjson"])) {
+		t.Fatalf("manifest does not match payload: %+v", got)
+	}
+	msum := sha256.Sum256(files["manifest.json"])
+	if !strings.HasPrefix(string(files["manifest.json.sha256"]), hex.EncodeToString(msum[:])) {
+		t.Fatalf("manifest checksum mismatch")
+	}
+}
+
+func TestLegalHoldLifecycle(t *testing.T) {
+	holds := &mockHolds{}
+	srv := newComplianceServer(&mockRepo{}, holds)
+	if w := serve(srv, "POST", "/api/admin/holds", "user", `{"room_id":"dev","reason":"case-1"}`); w.Code != 403 {
+		t.Fatalf("non-admin hold: expected 403 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/admin/holds", "admin", `{"room_id":"dev"}`); w.Code != 400 {
+		t.Fatalf("hold without reason: expected 400 got %d", w.Code)
+	}
+	w := serve(srv, "POST", "/api/admin/holds", "admin", `{"room_id":"dev","reason":"case-1"}`)
+	if w.Code != 201 || len(holds.holds) != 1 || holds.holds[0].CreatedBy != "admin" {
+		t.Fatalf("hold not created: %d %+v", w.Code, holds.holds)
+	}
+	if w := serve(srv, "DELETE", "/api/admin/holds/"+holds.holds[0].ID, "admin", ""); w.Code != 204 {
+		t.Fatalf("release: expected 204 got %d", w.Code)
+	}
+	if w := serve(srv, "DELETE", "/api/admin/holds/"+holds.holds[0].ID, "admin", ""); w.Code != 404 {
+		t.Fatalf("double release: expected 404 got %d", w.Code)
+	}
+}
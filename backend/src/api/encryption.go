@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+)
+
+// KeyRotator rotates the data keys that encrypt message content at rest.
+type KeyRotator interface {
+	RotateRoomKey(ctx context.Context, roomID string) (models.RoomKey, error)
+}
+
+// WithEncryption enables on-demand room key rotation; set it only when encryption at rest is on.
+func WithEncryption(k KeyRotator) Option { return func(s *Server) { s.roomKeys = k } }
+
+// handleRotateRoomKey starts a new data key version for a room, e.g. after a suspected leak. New
+// messages use it right away; the rekey job re-encrypts older ones and then drops the old version.
+func (s *Server) handleRotateRoomKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	roomID := r.PathValue("id")
+	k, err := s.roomKeys.RotateRoomKey(r.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			http.Error(w, "rotation already in progress", http.StatusConflict)
+			return
+		}
+		logger.Error("rotate room key", err, logger.FieldKV("room_id", roomID))
+		http.Error(w, "rotation failed", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "room.key.rotate", Target: k.RoomID, RoomID: k.RoomID,
+		Before: map[string]interface{}{"version": k.Version - 1},
+		After:  map[string]interface{}{"version": k.Version, "master_key_id": k.MasterKeyID}})
+	writeJSON(w, http.StatusOK, k)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"src/models"
+	"strings"
+	"testing"
+)
+
+type mockRotator struct{ versions map[string]int }
+
+func (m *mockRotator) RotateRoomKey(ctx context.Context, roomID string) (models.RoomKey, error) {
+	if roomID == "busy" {
+		return models.RoomKey{}, models.ErrConflict
+	}
+	m.versions[roomID]++
+	return models.RoomKey{RoomID: roomID, Version: m.versions[roomID], Wrapped: []byte("wrapped"), MasterKeyID: "k1"}, nil
+}
+
+func TestRotateRoomKey(t *testing.T) {
+	audit := &mockAudit{}
+	verifier := identityVerifier{"bob": {Subject: "bob"}, "admin": {Subject: "admin", Groups: []string{"chat-admins"}}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500,
+		WithAdminGroups([]string{"chat-admins"}), WithAudit(audit), WithEncryption(&mockRotator{versions: map[string]int{"dev": 1}}))
+
+	if w := serve(srv, "POST", "/api/admin/rooms/dev/rotate-key", "bob", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/admin/rooms/busy/rotate-key", "admin", ""); w.Code != http.StatusConflict {
+		t.Fatalf("concurrent rotation: %d", w.Code)
+	}
+	w := serve(srv, "POST", "/api/admin/rooms/dev/rotate-key", "admin", "")
+	if w.Code != http.StatusOK || w.Body.String() == "" || strings.Contains(w.Body.String(), "d3JhcHBlZA") {
+		t.Fatalf("rotate: %d %s", w.Code, w.Body.String())
+	}
+	if len(audit.entries) != 1 || audit.entries[0].Action != "room.key.rotate" || audit.entries[0].Before["version"] != 1 || audit.entries[0].After["version"] != 2 {
+		t.Fatalf("unexpected audit %+v", audit.entries)
+	}
+}
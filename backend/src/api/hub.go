@@ -2,24 +2,54 @@ package api
 
 import (
 	"src/logger"
+	"src/models"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // Hub manages websocket clients and broadcasts messages to them.
+// Each connection has a write lock because gorilla/websocket allows only one concurrent writer
+// and frames are written both by broadcasts and by the connection's own session handling.
 type Hub struct {
 	mu      sync.RWMutex
-	clients map[*websocket.Conn]struct{}
+	clients map[*websocket.Conn]*client
 }
 
-func NewHub() *Hub { return &Hub{clients: make(map[*websocket.Conn]struct{})} }
+// client is the per-connection state: the authenticated user, the rooms they take part in, the
+// rooms they are banned from, mapped to the ban's expiry (zero = until lifted), the users they
+// blocked, when the connection last sent a frame and whether the user is automatically away. They
+// are only changed under the hub lock.
+type client struct {
+	wmu        sync.Mutex
+	userID     string
+	rooms      map[string]bool
+	roomBans   map[string]time.Time
+	blocked    map[string]bool
+	lastActive time.Time
+	away       bool
+}
+
+// wants reports whether the client should receive a frame of room written by author.
+func (c *client) wants(room, author string, now time.Time) bool {
+	if until, ok := c.roomBans[room]; ok && (until.IsZero() || now.Before(until)) {
+		return false
+	}
+	if a, b, ok := models.DMParticipants(room); ok && c.userID != a && c.userID != b {
+		return false
+	}
+	return author == "" || !c.blocked[author]
+}
+
+func NewHub() *Hub { return &Hub{clients: make(map[*websocket.Conn]*client)} }
 
-func (h *Hub) Add(conn *websocket.Conn) {
+// Add registers conn for userID.
+func (h *Hub) Add(conn *websocket.Conn, userID string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.clients[conn] = struct{}{}
-	logger.Info("websocket client connected", logger.FieldKV("remote_addr", conn.RemoteAddr().String()))
+	h.clients[conn] = &client{userID: userID, rooms: map[string]bool{}, roomBans: map[string]time.Time{}, blocked: map[string]bool{}, lastActive: time.Now()}
+	logger.Info("websocket client connected", logger.FieldKV("remote_addr", conn.RemoteAddr().String()), logger.FieldKV("sub", userID))
 }
 
 func (h *Hub) Remove(conn *websocket.Conn) {
@@ -31,25 +61,233 @@ func (h *Hub) Remove(conn *websocket.Conn) {
 }
 
 func (h *Hub) Broadcast(msg interface{}) {
+	h.BroadcastExcept(msg, nil)
+}
+
+// BroadcastExcept sends the message to all connected clients except the provided connection.
+// Messages and events of a room are not sent to users banned from it, direct messages only to
+// their two participants, and messages not to users who blocked their author. Posting in a room
+// or being added to it makes it one of the user's rooms.
+func (h *Hub) BroadcastExcept(msg interface{}, except *websocket.Conn) {
+	room, author := broadcastSource(msg)
+	h.learn(msg)
+	now := time.Now()
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	for c := range h.clients {
-		if err := c.WriteJSON(msg); err != nil {
+	for c, cl := range h.clients {
+		if c == except || ((room != "" || author != "") && !cl.wants(room, author, now)) {
+			continue
+		}
+		cl.wmu.Lock()
+		err := c.WriteJSON(msg)
+		cl.wmu.Unlock()
+		if err != nil {
 			logger.Error("websocket write error", err, logger.FieldKV("remote_addr", c.RemoteAddr().String()))
 		}
 	}
 }
 
-// BroadcastExcept sends the message to all connected clients except the provided connection.
-func (h *Hub) BroadcastExcept(msg interface{}, except *websocket.Conn) {
+// broadcastSource is the room a broadcast belongs to, or "" for frames that are not room scoped,
+// and the author of chat messages.
+func broadcastSource(msg interface{}) (room, author string) {
+	switch m := msg.(type) {
+	case models.Message:
+		return roomOf(m), m.UserID
+	case models.Event:
+		return m.RoomID, ""
+	}
+	return "", ""
+}
+
+// learn records the rooms a broadcast shows users taking part in: a message's room for its author
+// and both participants of a direct message, and the room a member was added to.
+func (h *Hub) learn(msg interface{}) {
+	switch m := msg.(type) {
+	case models.Message:
+		room := roomOf(m)
+		if a, b, ok := models.DMParticipants(room); ok {
+			h.Enter(a, room)
+			h.Enter(b, room)
+		}
+		h.Enter(m.UserID, room)
+	case models.Event:
+		if m.Type == models.EventMemberJoined {
+			h.Enter(m.UserID, m.RoomID)
+		}
+	}
+}
+
+// Enter records that userID takes part in rooms on their connections.
+func (h *Hub) Enter(userID string, rooms ...string) {
+	if userID == "" || len(rooms) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			for _, room := range rooms {
+				cl.rooms[room] = true
+			}
+		}
+	}
+}
+
+// BroadcastStatus sends a status event to the connections of its user and of users taking part in
+// one of rooms who are not banned from it and did not block the user.
+func (h *Hub) BroadcastStatus(ev models.Event, rooms []string) {
+	now := time.Now()
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	for c := range h.clients {
-		if c == except {
+	for c, cl := range h.clients {
+		if cl.userID != ev.UserID && !cl.sharesRoom(rooms, ev.UserID, now) {
 			continue
 		}
-		if err := c.WriteJSON(msg); err != nil {
+		cl.wmu.Lock()
+		err := c.WriteJSON(ev)
+		cl.wmu.Unlock()
+		if err != nil {
 			logger.Error("websocket write error", err, logger.FieldKV("remote_addr", c.RemoteAddr().String()))
 		}
 	}
 }
+
+// sharesRoom reports whether the client takes part in one of rooms it may read and did not block
+// author.
+func (c *client) sharesRoom(rooms []string, author string, now time.Time) bool {
+	for _, room := range rooms {
+		if c.rooms[room] && c.wants(room, author, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserConns returns the connections of userID.
+func (h *Hub) UserConns(userID string) []*websocket.Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var out []*websocket.Conn
+	for c, cl := range h.clients {
+		if cl.userID == userID {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// BanFromRoom stops delivering room to userID's connections until until (zero = until lifted).
+func (h *Hub) BanFromRoom(userID, room string, until time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			cl.roomBans[room] = until
+		}
+	}
+}
+
+// UnbanFromRoom resumes delivering room to userID's connections.
+func (h *Hub) UnbanFromRoom(userID, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			delete(cl.roomBans, room)
+		}
+	}
+}
+
+// Block stops delivering blockedID's messages to userID's connections.
+func (h *Hub) Block(userID, blockedID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			cl.blocked[blockedID] = true
+		}
+	}
+}
+
+// Unblock resumes delivering blockedID's messages to userID's connections.
+func (h *Hub) Unblock(userID, blockedID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			delete(cl.blocked, blockedID)
+		}
+	}
+}
+
+// Touch records activity on conn and reports whether its user was automatically away, which it
+// ends for all of the user's connections.
+func (h *Hub) Touch(conn *websocket.Conn) (wasAway bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cl, ok := h.clients[conn]
+	if !ok {
+		return false
+	}
+	cl.lastActive = time.Now()
+	if !cl.away {
+		return false
+	}
+	for _, other := range h.clients {
+		if other.userID == cl.userID {
+			other.away = false
+		}
+	}
+	return true
+}
+
+// Activity returns the latest activity on any of userID's connections (zero without connections)
+// and whether the user is automatically away.
+func (h *Hub) Activity(userID string) (last time.Time, away bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			away = away || cl.away
+			if cl.lastActive.After(last) {
+				last = cl.lastActive
+			}
+		}
+	}
+	return last, away
+}
+
+// SetAway records whether userID is automatically away on their connections.
+func (h *Hub) SetAway(userID string, away bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, cl := range h.clients {
+		if cl.userID == userID {
+			cl.away = away
+		}
+	}
+}
+
+// Send writes msg to a single registered connection.
+func (h *Hub) Send(conn *websocket.Conn, msg interface{}) error {
+	return h.write(conn, func() error { return conn.WriteJSON(msg) })
+}
+
+// Close sends a close frame with code and reason to a registered connection.
+func (h *Hub) Close(conn *websocket.Conn, code int, reason string) error {
+	return h.write(conn, func() error {
+		return conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	})
+}
+
+func (h *Hub) write(conn *websocket.Conn, fn func() error) error {
+	h.mu.RLock()
+	cl, ok := h.clients[conn]
+	h.mu.RUnlock()
+	if !ok {
+		return websocket.ErrCloseSent
+	}
+	cl.wmu.Lock()
+	defer cl.wmu.Unlock()
+	return fn()
+}
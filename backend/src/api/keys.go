@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"src/logger"
+	"src/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KeyRepository is the public key directory for end-to-end encrypted DMs. It holds public keys only.
+type KeyRepository interface {
+	PutDeviceKeys(ctx context.Context, k models.DeviceKeys) error
+	GetDeviceKeys(ctx context.Context, userID, deviceID string) (models.DeviceKeys, error)
+	ListDeviceKeys(ctx context.Context, userID string) ([]models.DeviceKeys, error)
+	AddOneTimePreKeys(ctx context.Context, userID, deviceID string, keys []models.PreKey) error
+	ClaimKeyBundles(ctx context.Context, userID, deviceID string) ([]models.KeyBundle, error)
+	DeleteDeviceKeys(ctx context.Context, userID, deviceID string) error
+}
+
+// WithKeys enables the device key directory and end-to-end encrypted direct messages.
+func WithKeys(r KeyRepository) Option { return func(s *Server) { s.keys = r } }
+
+// WithMaxEncrypted bounds the total decoded ciphertext bytes of an encrypted message (default 256 KiB).
+func WithMaxEncrypted(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxEncrypted = n
+		}
+	}
+}
+
+const (
+	// maxDevices bounds the devices a user may register; an encrypted message carries at most one
+	// ciphertext per device of either participant.
+	maxDevices = 10
+	// maxPreKeyUpload bounds the one-time prekeys registered per request.
+	maxPreKeyUpload = 100
+	// maxPublicKey and maxCiphertext bound decoded keys and per-device ciphertexts.
+	maxPublicKey  = 256
+	maxCiphertext = 64 << 10
+	// defaultMaxEncrypted bounds all ciphertexts of a message together unless WithMaxEncrypted.
+	defaultMaxEncrypted = 256 << 10
+)
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// validKey reports whether v is standard base64 of 1 to maxPublicKey bytes.
+func validKey(v string) bool {
+	b, err := base64.StdEncoding.DecodeString(v)
+	return err == nil && len(b) > 0 && len(b) <= maxPublicKey
+}
+
+// checkPreKeys validates one-time prekeys: unsigned public keys with distinct IDs.
+func checkPreKeys(keys []models.PreKey) string {
+	if len(keys) > maxPreKeyUpload {
+		return "at most 100 one-time prekeys per request"
+	}
+	seen := map[int64]bool{}
+	for _, k := range keys {
+		if seen[k.KeyID] || !validKey(k.PublicKey) {
+			return "one-time prekeys need distinct key_id and a base64 public_key"
+		}
+		seen[k.KeyID] = true
+	}
+	return ""
+}
+
+// userParam returns the {userID} path value, with "me" standing for the caller.
+func userParam(r *http.Request, id models.Identity) string {
+	if u := r.PathValue("userID"); u != "me" {
+		return u
+	}
+	return id.Subject
+}
+
+// handleListDevices lists a user's registered devices and their public keys, without handing out
+// one-time prekeys.
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	list, err := s.keys.ListDeviceKeys(r.Context(), userParam(r, id))
+	if err != nil {
+		logger.Error("list devices", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleClaimKeys hands out a key bundle for each of a user's devices (or device_id only), using
+// up one one-time prekey per device.
+func (s *Server) handleClaimKeys(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	list, err := s.keys.ClaimKeyBundles(r.Context(), userParam(r, id), r.URL.Query().Get("device_id"))
+	if err != nil {
+		logger.Error("claim key bundles", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handlePutDevice registers the caller's device, replacing the device's earlier bundle.
+func (s *Server) handlePutDevice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IdentityKey    string          `json:"identity_key"`
+		SignedPreKey   models.PreKey   `json:"signed_prekey"`
+		OneTimePreKeys []models.PreKey `json:"one_time_prekeys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validKey(req.IdentityKey) || !validKey(req.SignedPreKey.PublicKey) || !validKey(req.SignedPreKey.Signature) {
+		http.Error(w, "bad request (base64 identity_key and signed_prekey with signature required)", http.StatusBadRequest)
+		return
+	}
+	if reason := checkPreKeys(req.OneTimePreKeys); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+	deviceID := r.PathValue("deviceID")
+	if !deviceIDPattern.MatchString(deviceID) {
+		http.Error(w, "device id must match [A-Za-z0-9._-]{1,64}", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	devices, err := s.keys.ListDeviceKeys(r.Context(), id.Subject)
+	if err != nil {
+		logger.Error("list devices", err)
+		http.Error(w, "register failed", http.StatusInternalServerError)
+		return
+	}
+	known := false
+	for _, d := range devices {
+		known = known || d.DeviceID == deviceID
+	}
+	if !known && len(devices) >= maxDevices {
+		http.Error(w, "too many devices; remove one first", http.StatusConflict)
+		return
+	}
+	k := models.DeviceKeys{UserID: id.Subject, DeviceID: deviceID, IdentityKey: req.IdentityKey, SignedPreKey: req.SignedPreKey,
+		OneTimePreKeys: req.OneTimePreKeys, PreKeysLeft: len(req.OneTimePreKeys), UpdatedAt: s.now()}
+	if err := s.keys.PutDeviceKeys(r.Context(), k); err != nil {
+		logger.Error("register device", err)
+		http.Error(w, "register failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("device keys registered", logger.FieldKV("sub", id.Subject), logger.FieldKV("device_id", deviceID), logger.FieldKV("replaced", known))
+	writeJSON(w, http.StatusOK, k)
+}
+
+// handleAddPreKeys replenishes the one-time prekeys of the caller's device.
+func (s *Server) handleAddPreKeys(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OneTimePreKeys []models.PreKey `json:"one_time_prekeys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.OneTimePreKeys) == 0 {
+		http.Error(w, "bad request (one_time_prekeys required)", http.StatusBadRequest)
+		return
+	}
+	if reason := checkPreKeys(req.OneTimePreKeys); reason != "" {
+		http.Error(w, reason, http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	if err := s.keys.AddOneTimePreKeys(r.Context(), id.Subject, r.PathValue("deviceID"), req.OneTimePreKeys); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "device not registered", http.StatusNotFound)
+			return
+		}
+		logger.Error("add prekeys", err)
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteDevice(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	deviceID := r.PathValue("deviceID")
+	if err := s.keys.DeleteDeviceKeys(r.Context(), id.Subject, deviceID); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "device not registered", http.StatusNotFound)
+			return
+		}
+		logger.Error("remove device", err)
+		http.Error(w, "remove failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("device keys removed", logger.FieldKV("sub", id.Subject), logger.FieldKV("device_id", deviceID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptEncrypted publishes an end-to-end encrypted DM from id. The ciphertext is never rendered,
+// matched against the message schema or moderated; only its envelope is checked.
+func (s *Server) acceptEncrypted(ctx context.Context, id models.Identity, msg *models.Message) (string, error) {
+	if s.keys == nil {
+		return "", errInvalidMessage("encrypted messages are not enabled")
+	}
+	if err := s.checkEncrypted(ctx, id, *msg); err != nil {
+		return "", err
+	}
+	// Recipients look the sender's keys up by user_id, so it is always the caller.
+	msg.UserID, msg.Format, msg.HTML = id.Subject, "", ""
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.NewString()
+	}
+	msg.Timestamp = time.Now().UTC()
+	return s.publish(ctx, *msg), nil
+}
+
+// checkEncrypted validates the envelope of an encrypted message: a DM without plaintext, the
+// supported algorithm, a registered sender device and base64 ciphertexts addressed only to devices
+// of the two participants, at most s.maxEncrypted bytes together.
+func (s *Server) checkEncrypted(ctx context.Context, id models.Identity, msg models.Message) error {
+	a, b, dm := models.DMParticipants(msg.RoomID)
+	if !dm {
+		return errInvalidMessage("encrypted messages are only allowed in direct messages")
+	}
+	e := msg.Encrypted
+	switch {
+	case msg.Content != "":
+		return errInvalidMessage("encrypted messages carry no content")
+	case e.Algorithm != models.E2EEAlgorithm:
+		return errInvalidMessage("unsupported algorithm")
+	case len(e.Ciphertexts) == 0 || len(e.Ciphertexts) > 2*maxDevices:
+		return errInvalidMessage("an encrypted message needs 1 to 20 ciphertexts")
+	}
+	seen := map[string]bool{}
+	total := 0
+	for _, c := range e.Ciphertexts {
+		if c.UserID != a && c.UserID != b {
+			return errInvalidMessage("ciphertexts may only be addressed to the conversation's devices")
+		}
+		key := c.UserID + "/" + c.DeviceID
+		if seen[key] || !deviceIDPattern.MatchString(c.DeviceID) {
+			return errInvalidMessage("invalid or duplicate recipient device")
+		}
+		seen[key] = true
+		if c.Type != 0 && c.Type != 1 {
+			return errInvalidMessage("ciphertext type must be 0 or 1")
+		}
+		body, err := base64.StdEncoding.DecodeString(c.Body)
+		if err != nil || len(body) == 0 || len(body) > maxCiphertext {
+			return errInvalidMessage("ciphertexts must be base64 of at most 64 KiB")
+		}
+		if total += len(body); total > s.maxEncrypted {
+			return errInvalidMessage(fmt.Sprintf("ciphertexts may total at most %d bytes", s.maxEncrypted))
+		}
+	}
+	if _, err := s.keys.GetDeviceKeys(ctx, id.Subject, e.SenderDevice); err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			logger.Error("sender device lookup", err, logger.FieldKV("sub", id.Subject))
+		}
+		return errInvalidMessage("unknown sender device")
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"src/moderation"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type mockKeys struct {
+	mu      sync.Mutex
+	devices []models.DeviceKeys
+}
+
+func (m *mockKeys) find(userID, deviceID string) int {
+	for i, d := range m.devices {
+		if d.UserID == userID && d.DeviceID == deviceID {
+			return i
+		}
+	}
+	return -1
+}
+func (m *mockKeys) PutDeviceKeys(ctx context.Context, k models.DeviceKeys) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i := m.find(k.UserID, k.DeviceID); i >= 0 {
+		m.devices[i] = k
+		return nil
+	}
+	m.devices = append(m.devices, k)
+	return nil
+}
+func (m *mockKeys) GetDeviceKeys(ctx context.Context, userID, deviceID string) (models.DeviceKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.find(userID, deviceID)
+	if i < 0 {
+		return models.DeviceKeys{}, models.ErrNotFound
+	}
+	return m.devices[i], nil
+}
+func (m *mockKeys) ListDeviceKeys(ctx context.Context, userID string) ([]models.DeviceKeys, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []models.DeviceKeys{}
+	for _, d := range m.devices {
+		if d.UserID == userID {
+			d.PreKeysLeft = len(d.OneTimePreKeys)
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+func (m *mockKeys) AddOneTimePreKeys(ctx context.Context, userID, deviceID string, keys []models.PreKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.find(userID, deviceID)
+	if i < 0 {
+		return models.ErrNotFound
+	}
+	m.devices[i].OneTimePreKeys = append(m.devices[i].OneTimePreKeys, keys...)
+	return nil
+}
+func (m *mockKeys) ClaimKeyBundles(ctx context.Context, userID, deviceID string) ([]models.KeyBundle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []models.KeyBundle{}
+	for i, d := range m.devices {
+		if d.UserID != userID || (deviceID != "" && d.DeviceID != deviceID) {
+			continue
+		}
+		b := models.KeyBundle{UserID: d.UserID, DeviceID: d.DeviceID, IdentityKey: d.IdentityKey, SignedPreKey: d.SignedPreKey}
+		if len(d.OneTimePreKeys) > 0 {
+			otk := d.OneTimePreKeys[0]
+			b.OneTimePreKey, m.devices[i].OneTimePreKeys = &otk, d.OneTimePreKeys[1:]
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+func (m *mockKeys) DeleteDeviceKeys(ctx context.Context, userID, deviceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := m.find(userID, deviceID)
+	if i < 0 {
+		return models.ErrNotFound
+	}
+	m.devices = append(m.devices[:i], m.devices[i+1:]...)
+	return nil
+}
+
+// deviceBody is a registration with base64 keys ("a2V5" is "key").
+const deviceBody = `{"identity_key":"a2V5","signed_prekey":{"key_id":1,"public_key":"a2V5","signature":"c2ln"},
+	"one_time_prekeys":[{"key_id":10,"public_key":"b3Rr"},{"key_id":11,"public_key":"b3Rr"}]}`
+
+func TestKeyDirectory(t *testing.T) {
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}}
+	srv := NewServer(&capturingProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithKeys(&mockKeys{}))
+
+	if w := serve(srv, "PUT", "/api/users/me/devices/phone", "bob", `{"identity_key":"not base64!"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid keys: %d", w.Code)
+	}
+	if w := serve(srv, "PUT", "/api/users/me/devices/bad%20id", "bob", deviceBody); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid device id: %d", w.Code)
+	}
+	for _, dev := range []string{"phone", "laptop"} {
+		if w := serve(srv, "PUT", "/api/users/me/devices/"+dev, "bob", deviceBody); w.Code != http.StatusOK {
+			t.Fatalf("register %s: %d %s", dev, w.Code, w.Body.String())
+		}
+	}
+
+	var bundles []models.KeyBundle
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users/bob/keys", "alice", "").Body.Bytes(), &bundles)
+	if len(bundles) != 2 || bundles[0].OneTimePreKey == nil || bundles[0].OneTimePreKey.KeyID != 10 || bundles[0].IdentityKey != "a2V5" {
+		t.Fatalf("unexpected bundles %+v", bundles)
+	}
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users/bob/keys?device_id=phone", "alice", "").Body.Bytes(), &bundles)
+	if len(bundles) != 1 || bundles[0].OneTimePreKey.KeyID != 11 {
+		t.Fatalf("each claim should take the next one-time prekey: %+v", bundles)
+	}
+	bundles = nil
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users/bob/keys?device_id=phone", "alice", "").Body.Bytes(), &bundles)
+	if len(bundles) != 1 || bundles[0].OneTimePreKey != nil {
+		t.Fatalf("an exhausted device falls back to its signed prekey: %+v", bundles)
+	}
+
+	if w := serve(srv, "POST", "/api/users/me/devices/tablet/prekeys", "bob", `{"one_time_prekeys":[{"key_id":12,"public_key":"b3Rr"}]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("prekeys for an unknown device: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/users/me/devices/phone/prekeys", "bob", `{"one_time_prekeys":[{"key_id":12,"public_key":"b3Rr"}]}`); w.Code != http.StatusNoContent {
+		t.Fatalf("add prekeys: %d", w.Code)
+	}
+	w := serve(srv, "GET", "/api/users/me/devices", "bob", "")
+	var devices []models.DeviceKeys
+	_ = json.Unmarshal(w.Body.Bytes(), &devices)
+	if len(devices) != 2 || devices[0].DeviceID != "phone" || devices[0].PreKeysLeft != 1 || strings.Contains(w.Body.String(), "b3Rr") {
+		t.Fatalf("listing should count but not reveal one-time prekeys: %s", w.Body.String())
+	}
+
+	if w := serve(srv, "DELETE", "/api/users/me/devices/laptop", "bob", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("remove: %d", w.Code)
+	}
+	if w := serve(srv, "DELETE", "/api/users/me/devices/laptop", "bob", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("remove twice: %d", w.Code)
+	}
+}
+
+func TestEncryptedDirectMessages(t *testing.T) {
+	prod := &capturingProducer{}
+	keys := &mockKeys{devices: []models.DeviceKeys{{UserID: "alice", DeviceID: "phone"}, {UserID: "bob", DeviceID: "laptop"}}}
+	// A rule that rejects every message shows ciphertext skips moderation.
+	modRepo := &mockModeration{queue: map[string]models.FlaggedMessage{},
+		cfg: models.ModerationConfig{Rules: []models.ModerationRule{{Filter: "regex", Action: "reject", Patterns: []string{"^"}}}}}
+	engine := moderation.NewEngine(modRepo, time.Minute)
+	if err := engine.Apply(modRepo.cfg); err != nil {
+		t.Fatal(err)
+	}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}}
+	srv := NewServer(prod, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithKeys(keys), WithModeration(engine, modRepo))
+
+	dm := models.DMRoomID("alice", "bob")
+	envelope := func(room, content, sender, recipient, body string) string {
+		return `{"room_id":"` + room + `","content":"` + content + `","user_id":"mallory","encrypted":{"algorithm":"` + models.E2EEAlgorithm +
+			`","sender_device":"` + sender + `","ciphertexts":[{"user_id":"` + recipient + `","device_id":"laptop","type":0,"body":"` + body + `"}]}}`
+	}
+	for name, body := range map[string]string{
+		"not a DM":          envelope("general", "", "phone", "bob", "Y3Q="),
+		"plaintext":         envelope(dm, "hello", "phone", "bob", "Y3Q="),
+		"unknown sender":    envelope(dm, "", "tablet", "bob", "Y3Q="),
+		"outside recipient": envelope(dm, "", "phone", "carol", "Y3Q="),
+		"not base64":        envelope(dm, "", "phone", "bob", "!!"),
+	} {
+		if w := serve(srv, "POST", "/api/messages", "alice", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400 got %d", name, w.Code)
+		}
+	}
+	if w := serve(srv, "POST", "/api/messages", "alice", `{"room_id":"`+dm+`","content":"hi"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("plaintext should still be moderated: %d", w.Code)
+	}
+
+	if w := serve(srv, "POST", "/api/messages", "alice", envelope(dm, "", "phone", "bob", "Y3Q=")); w.Code != http.StatusAccepted {
+		t.Fatalf("encrypted DM: %d %s", w.Code, w.Body.String())
+	}
+	if len(prod.msgs) != 1 {
+		t.Fatalf("expected one published message, got %+v", prod.msgs)
+	}
+	got := prod.msgs[0]
+	if got.UserID != "alice" || got.HTML != "" || got.Encrypted == nil || got.Encrypted.Ciphertexts[0].Body != "Y3Q=" {
+		t.Fatalf("ciphertext should be relayed as sent, from the caller: %+v", got)
+	}
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteJSON(json.RawMessage(envelope("general", "", "phone", "bob", "Y3Q=")))
+	if f, err := readFrame(t, conn); err != nil || f.Type != models.FrameError || !strings.Contains(f.Error, "direct messages") {
+		t.Fatalf("expected an error frame, got %+v (%v)", f, err)
+	}
+	// Frames are handled in order, so the error for the second shows the first was processed.
+	_ = conn.WriteJSON(json.RawMessage(envelope(dm, "", "phone", "bob", "Y3Q=")))
+	_ = conn.WriteJSON(json.RawMessage(envelope(dm, "", "tablet", "bob", "Y3Q=")))
+	if f, err := readFrame(t, conn); err != nil || f.Type != models.FrameError || f.Error != "unknown sender device" {
+		t.Fatalf("expected an error frame, got %+v (%v)", f, err)
+	}
+	if len(prod.msgs) != 2 || prod.msgs[1].Encrypted == nil || prod.msgs[1].UserID != "alice" {
+		t.Fatalf("websocket ciphertext not published: %+v", prod.msgs)
+	}
+}
+
+func TestEncryptedSizeLimits(t *testing.T) {
+	keys := &mockKeys{devices: []models.DeviceKeys{{UserID: "alice", DeviceID: "phone"}, {UserID: "bob", DeviceID: "laptop"}, {UserID: "bob", DeviceID: "tablet"}}}
+	verifier := identityVerifier{"alice": {Subject: "alice"}}
+	srv := NewServer(&capturingProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithKeys(keys), WithMaxEncrypted(6))
+
+	// Each ciphertext is 4 bytes, within the per-device bound but 8 bytes together.
+	body := `{"room_id":"` + models.DMRoomID("alice", "bob") + `","encrypted":{"algorithm":"` + models.E2EEAlgorithm + `","sender_device":"phone","ciphertexts":[` +
+		`{"user_id":"bob","device_id":"laptop","type":0,"body":"Y3QxMg=="},{"user_id":"bob","device_id":"tablet","type":0,"body":"Y3QxMg=="}]}}`
+	if w := serve(srv, "POST", "/api/messages", "alice", body); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "at most 6 bytes") {
+		t.Fatalf("ciphertexts over the total limit: %d %s", w.Code, w.Body.String())
+	}
+	huge := `{"content":"` + strings.Repeat("a", int(srv.maxBody())) + `"}`
+	if w := serve(srv, "POST", "/api/messages", "alice", huge); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body: expected 413 got %d", w.Code)
+	}
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.WriteMessage(websocket.TextMessage, []byte(huge))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Fatalf("expected the oversized frame to close the socket with 1009, got %v", err)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/metrics"
+	"src/models"
+	"src/moderation"
+	"strings"
+	"time"
+)
+
+// ModerationRepository abstracts the moderation configuration and review queue (implemented by
+// store.ModerationAdapter).
+type ModerationRepository interface {
+	GetModerationConfig(ctx context.Context) (models.ModerationConfig, error)
+	SaveModerationConfig(ctx context.Context, cfg models.ModerationConfig) error
+	QueueFlagged(ctx context.Context, f models.FlaggedMessage) error
+	ListFlagged(ctx context.Context, roomID, status string) ([]models.FlaggedMessage, error)
+	GetFlagged(ctx context.Context, id string) (models.FlaggedMessage, error)
+	ReviewFlagged(ctx context.Context, id, status, by string, at time.Time) (models.FlaggedMessage, error)
+}
+
+// WithModeration runs ingested messages through engine and enables the review queue and the
+// configuration endpoints backed by repo.
+func WithModeration(engine *moderation.Engine, repo ModerationRepository) Option {
+	return func(s *Server) { s.moderation, s.modRepo = engine, repo }
+}
+
+// statusHeld is the acceptMessage status of a message held for review.
+const statusHeld = "held"
+
+// screen runs a validated msg, submitted by the authenticated sender, through the moderation
+// chain. Masked content replaces msg's content (and is rendered again); a rejected message returns
+// an error safe to show to the sender. flagged reports that the message must be held for review,
+// with the reasons.
+func (s *Server) screen(sender string, msg *models.Message) (flagged bool, reasons []string, err error) {
+	if s.moderation == nil {
+		return false, nil, nil
+	}
+	v := s.moderation.Check(*msg, sender, s.now())
+	if v.Action == "" {
+		return false, nil, nil
+	}
+	metrics.IncModerated(v.Action)
+	logger.Info("message moderated", logger.FieldKV("action", v.Action), logger.FieldKV("message_id", msg.MessageID),
+		logger.FieldKV("user_id", msg.UserID), logger.FieldKV("room_id", msg.RoomID), logger.FieldKV("reasons", strings.Join(v.Reasons, "; ")))
+	if v.Action == models.ActionReject {
+		// Only the filter is named; reasons may quote the configured patterns.
+		filter, _, _ := strings.Cut(v.Reasons[0], ":")
+		return false, nil, errInvalidMessage("message rejected by moderation (" + filter + ")")
+	}
+	if v.Content != msg.Content {
+		msg.Content = v.Content
+		if err := renderContent(msg); err != nil {
+			return false, nil, errInvalidMessage("unsupported format")
+		}
+	}
+	return v.Action == models.ActionFlag, v.Reasons, nil
+}
+
+// moderate screens msg and queues it for review when flagged; held reports that it must not be
+// published now.
+func (s *Server) moderate(ctx context.Context, sender string, msg *models.Message) (held bool, err error) {
+	flagged, reasons, err := s.screen(sender, msg)
+	if err != nil || !flagged {
+		return false, err
+	}
+	f := models.FlaggedMessage{ID: msg.MessageID, Message: *msg, Reasons: reasons, Status: models.ReviewPending, CreatedAt: s.now()}
+	if err := s.modRepo.QueueFlagged(ctx, f); err != nil {
+		logger.Error("queue flagged message", err, logger.FieldKV("message_id", msg.MessageID))
+		return false, errInvalidMessage("message could not be queued for review")
+	}
+	return true, nil
+}
+
+// authorizeModeration checks that the caller moderates roomID, or is a global moderator when
+// roomID is empty or rooms are not enabled.
+func (s *Server) authorizeModeration(w http.ResponseWriter, r *http.Request, roomID string) (models.Identity, bool) {
+	if roomID != "" && s.rooms != nil {
+		return s.authorizeRoom(w, r, roomID, models.RoleModerator)
+	}
+	id, _ := IdentityFrom(r.Context())
+	if id.Role.Rank() < models.RoleModerator.Rank() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return id, false
+	}
+	return id, true
+}
+
+func (s *Server) handleListFlagged(w http.ResponseWriter, r *http.Request) {
+	roomID, status := r.URL.Query().Get("room_id"), r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ReviewPending
+	case "all":
+		status = ""
+	case models.ReviewPending, models.ReviewApproved, models.ReviewRejected:
+	default:
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.authorizeModeration(w, r, roomID); !ok {
+		return
+	}
+	list, err := s.modRepo.ListFlagged(r.Context(), roomID, status)
+	if err != nil {
+		logger.Error("list flagged messages", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleApproveFlagged(w http.ResponseWriter, r *http.Request) {
+	s.reviewFlagged(w, r, models.ReviewApproved)
+}
+
+func (s *Server) handleRejectFlagged(w http.ResponseWriter, r *http.Request) {
+	s.reviewFlagged(w, r, models.ReviewRejected)
+}
+
+// reviewFlagged settles a pending flagged message; an approved message is published unchanged,
+// with its original timestamp.
+func (s *Server) reviewFlagged(w http.ResponseWriter, r *http.Request, status string) {
+	f, err := s.modRepo.GetFlagged(r.Context(), r.PathValue("id"))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("get flagged message", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	id, ok := s.authorizeModeration(w, r, f.Message.RoomID)
+	if !ok {
+		return
+	}
+	f, err = s.modRepo.ReviewFlagged(r.Context(), f.ID, status, id.Subject, s.now())
+	switch {
+	case errors.Is(err, models.ErrConflict):
+		http.Error(w, "already reviewed", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error("review flagged message", err)
+		http.Error(w, "review failed", http.StatusInternalServerError)
+		return
+	}
+	if status == models.ReviewApproved {
+		s.publish(r.Context(), f.Message)
+	}
+	logger.Info("flagged message reviewed", logger.FieldKV("message_id", f.ID), logger.FieldKV("status", status), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "moderation." + status, Target: f.Message.UserID, RoomID: roomOf(f.Message),
+		Before: map[string]interface{}{"message_id": f.ID, "status": models.ReviewPending}, After: map[string]interface{}{"message_id": f.ID, "status": status}})
+	writeJSON(w, http.StatusOK, f)
+}
+
+func (s *Server) handleGetModerationConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.modRepo.GetModerationConfig(r.Context())
+	if err != nil {
+		logger.Error("get moderation config", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, cfg)
+}
+
+// handlePutModerationConfig replaces the configuration. It takes effect here at once and on other
+// replicas at their next reload.
+func (s *Server) handlePutModerationConfig(w http.ResponseWriter, r *http.Request) {
+	var cfg models.ModerationConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = []models.ModerationRule{}
+	}
+	if _, err := moderation.Compile(cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	prev, err := s.modRepo.GetModerationConfig(r.Context())
+	if err != nil {
+		logger.Error("get moderation config", err)
+	}
+	// Mongo keeps milliseconds; the engine compares UpdatedAt to skip reloading its own config.
+	cfg.UpdatedBy, cfg.UpdatedAt = id.Subject, s.now().Truncate(time.Millisecond)
+	if err := s.modRepo.SaveModerationConfig(r.Context(), cfg); err != nil {
+		logger.Error("save moderation config", err)
+		http.Error(w, "save failed", http.StatusInternalServerError)
+		return
+	}
+	if err := s.moderation.Apply(cfg); err != nil {
+		logger.Error("apply moderation config", err)
+	}
+	logger.Info("moderation config updated", logger.FieldKV("rules", len(cfg.Rules)), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "moderation.config",
+		Before: map[string]interface{}{"rules": len(prev.Rules), "updated_at": prev.UpdatedAt}, After: map[string]interface{}{"rules": len(cfg.Rules), "updated_at": cfg.UpdatedAt}})
+	writeJSON(w, http.StatusOK, cfg)
+}
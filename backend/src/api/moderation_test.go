@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"src/models"
+	"src/moderation"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockModeration struct {
+	cfg   models.ModerationConfig
+	queue map[string]models.FlaggedMessage
+}
+
+func (m *mockModeration) GetModerationConfig(ctx context.Context) (models.ModerationConfig, error) {
+	return m.cfg, nil
+}
+func (m *mockModeration) SaveModerationConfig(ctx context.Context, cfg models.ModerationConfig) error {
+	m.cfg = cfg
+	return nil
+}
+func (m *mockModeration) QueueFlagged(ctx context.Context, f models.FlaggedMessage) error {
+	m.queue[f.ID] = f
+	return nil
+}
+func (m *mockModeration) ListFlagged(ctx context.Context, roomID, status string) ([]models.FlaggedMessage, error) {
+	out := []models.FlaggedMessage{}
+	for _, f := range m.queue {
+		if (roomID == "" || f.Message.RoomID == roomID) && (status == "" || f.Status == status) {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+func (m *mockModeration) GetFlagged(ctx context.Context, id string) (models.FlaggedMessage, error) {
+	f, ok := m.queue[id]
+	if !ok {
+		return f, models.ErrNotFound
+	}
+	return f, nil
+}
+func (m *mockModeration) ReviewFlagged(ctx context.Context, id, status, by string, at time.Time) (models.FlaggedMessage, error) {
+	f, ok := m.queue[id]
+	if !ok {
+		return f, models.ErrNotFound
+	}
+	if f.Status != models.ReviewPending {
+		return f, models.ErrConflict
+	}
+	f.Status, f.ReviewedBy, f.ReviewedAt = status, by, &at
+	m.queue[id] = f
+	return f, nil
+}
+
+func TestModeration(t *testing.T) {
+	prod := &capturingProducer{}
+	repo := &mockModeration{queue: map[string]models.FlaggedMessage{}}
+	verifier := identityVerifier{
+		"admin": {Subject: "admin", Groups: []string{"chat-admins"}},
+		"mod":   {Subject: "mod", Groups: []string{"chat-moderators"}},
+		"alice": {Subject: "alice"},
+	}
+	srv := NewServer(prod, &mockRepo{}, verifier, nil, make(chan models.Message), 1000,
+		WithAdminGroups([]string{"chat-admins"}), WithModeratorGroups([]string{"chat-moderators"}),
+		WithModeration(moderation.NewEngine(repo, time.Minute), repo))
+
+	if w := serve(srv, "PUT", "/api/admin/moderation", "alice", `{"rules":[]}`); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin config: expected 403 got %d", w.Code)
+	}
+	if w := serve(srv, "PUT", "/api/admin/moderation", "admin", `{"rules":[{"filter":"spam","action":"mask","repeats":2,"window":"1m"}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid config: expected 400 got %d", w.Code)
+	}
+	cfg := `{"rules":[
+		{"filter":"blocklist","action":"mask","words":["darn"]},
+		{"filter":"regex","action":"reject","patterns":["(?i)free money"]},
+		{"filter":"links","action":"flag","allow_domains":["example.com"]}]}`
+	if w := serve(srv, "PUT", "/api/admin/moderation", "admin", cfg); w.Code != http.StatusOK || len(repo.cfg.Rules) != 3 || repo.cfg.UpdatedBy != "admin" {
+		t.Fatalf("put config: %d %+v", w.Code, repo.cfg)
+	}
+
+	// Masked content is published, rendered from the masked text.
+	if w := serve(srv, "POST", "/api/messages", "alice", `{"content":"Darn it"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("masked: expected 202 got %d", w.Code)
+	}
+	if got := prod.msgs[0]; got.Content != "**** it" || strings.Contains(got.HTML, "arn") {
+		t.Fatalf("mask not applied: %+v", got)
+	}
+
+	w := serve(srv, "POST", "/api/messages", "alice", `{"content":"FREE MONEY here"}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "rejected by moderation (regex)") || strings.Contains(w.Body.String(), "free money") {
+		t.Fatalf("reject: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(srv, "POST", "/api/messages", "alice", `{"content":"look https://evil.test","room_id":"dev"}`)
+	var accepted map[string]string
+	_ = json.NewDecoder(w.Body).Decode(&accepted)
+	if w.Code != http.StatusAccepted || accepted["status"] != statusHeld || len(prod.msgs) != 1 {
+		t.Fatalf("flag: %d %v, published %d", w.Code, accepted, len(prod.msgs))
+	}
+	flagged, ok := repo.queue[accepted["message_id"]]
+	if !ok || flagged.Status != models.ReviewPending || len(flagged.Reasons) != 1 || flagged.Reasons[0] != "links: link to evil.test" {
+		t.Fatalf("not queued: %+v", flagged)
+	}
+
+	if w := serve(srv, "GET", "/api/moderation/queue", "alice", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("user queue: expected 403 got %d", w.Code)
+	}
+	var list []models.FlaggedMessage
+	w = serve(srv, "GET", "/api/moderation/queue?room_id=dev", "mod", "")
+	_ = json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("queue: %d %v", w.Code, list)
+	}
+	if w := serve(srv, "GET", "/api/moderation/queue?status=bogus", "mod", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad status: expected 400 got %d", w.Code)
+	}
+
+	path := "/api/moderation/queue/" + flagged.ID
+	if w := serve(srv, "POST", path+"/approve", "alice", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("user approve: expected 403 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", path+"/approve", "mod", ""); w.Code != http.StatusOK || len(prod.msgs) != 2 || prod.msgs[1].MessageID != flagged.ID {
+		t.Fatalf("approve: %d, published %d", w.Code, len(prod.msgs))
+	}
+	if w := serve(srv, "POST", path+"/reject", "mod", ""); w.Code != http.StatusConflict {
+		t.Fatalf("second review: expected 409 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/moderation/queue/missing/approve", "mod", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("missing: expected 404 got %d", w.Code)
+	}
+}
+
+func TestScheduledMessagesAreScreened(t *testing.T) {
+	repo := &mockModeration{queue: map[string]models.FlaggedMessage{}}
+	engine := moderation.NewEngine(repo, time.Minute)
+	if err := engine.Apply(models.ModerationConfig{Rules: []models.ModerationRule{
+		{Filter: models.FilterLinks, Action: models.ActionFlag},
+		{Filter: models.FilterBlocklist, Action: models.ActionMask, Words: []string{"darn"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	sched := &mockScheduled{}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, &mockVerifier{}, nil, make(chan models.Message), 1000,
+		WithScheduled(sched, 0), WithModeration(engine, repo))
+	sendAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	if w := serve(srv, "POST", "/api/scheduled", "t", `{"content":"see www.evil.test","send_at":"`+sendAt+`"}`); w.Code != http.StatusBadRequest || len(repo.queue) != 0 {
+		t.Fatalf("flagged scheduled message: expected 400 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/scheduled", "t", `{"content":"darn","send_at":"`+sendAt+`"}`); w.Code != http.StatusCreated || sched.items[0].Message.Content != "****" {
+		t.Fatalf("masked scheduled message: %d %+v", w.Code, sched.items)
+	}
+}
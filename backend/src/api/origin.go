@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"src/logger"
+	"src/metrics"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may open a WebSocket. Requests without an Origin
+// header (non-browser clients) and same-origin requests are always allowed; anything else must
+// match an allowed pattern, or be a localhost origin when AllowLocalhost is set (development).
+type OriginPolicy struct {
+	patterns       []originPattern
+	allowAll       bool
+	AllowLocalhost bool
+}
+
+type originPattern struct {
+	scheme, host, port string
+	wildcard           bool // host is a suffix: "*.example.com" matches any subdomain, not the apex
+}
+
+// NewOriginPolicy parses patterns like "https://chat.example.com", "https://*.example.com" or
+// "http://localhost:8081". A lone "*" allows every origin.
+func NewOriginPolicy(patterns []string, allowLocalhost bool) (*OriginPolicy, error) {
+	p := &OriginPolicy{AllowLocalhost: allowLocalhost}
+	for _, raw := range patterns {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if raw == "*" {
+			p.allowAll = true
+			continue
+		}
+		scheme, rest, ok := strings.Cut(raw, "://")
+		if !ok || (scheme != "http" && scheme != "https") || rest == "" || strings.ContainsAny(rest, "/?#") {
+			return nil, fmt.Errorf("invalid origin pattern %q", raw)
+		}
+		host, port := splitHostPort(rest)
+		pat := originPattern{scheme: scheme, host: strings.ToLower(host), port: port}
+		if strings.HasPrefix(pat.host, "*.") {
+			pat.wildcard = true
+			pat.host = pat.host[1:] // keep the leading dot
+		}
+		if pat.host == "" || pat.host == "." || strings.Contains(pat.host[1:], "*") {
+			return nil, fmt.Errorf("invalid origin pattern %q", raw)
+		}
+		p.patterns = append(p.patterns, pat)
+	}
+	return p, nil
+}
+
+// Check reports whether r may upgrade and, if not, why.
+func (p *OriginPolicy) Check(r *http.Request) (bool, string) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.allowAll {
+		return true, ""
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return false, "malformed origin"
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true, ""
+	}
+	host, port := splitHostPort(u.Host)
+	host = strings.ToLower(host)
+	if p.AllowLocalhost && isLocalhost(host) {
+		return true, ""
+	}
+	for _, pat := range p.patterns {
+		if pat.matches(u.Scheme, host, port) {
+			return true, ""
+		}
+	}
+	return false, "origin not allowed"
+}
+
+func (pat originPattern) matches(scheme, host, port string) bool {
+	if scheme != pat.scheme || port != pat.port {
+		return false
+	}
+	if pat.wildcard {
+		return strings.HasSuffix(host, pat.host) && len(host) > len(pat.host)
+	}
+	return host == pat.host
+}
+
+// checkOrigin is the upgrader hook; rejections are logged with their reason and counted.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	ok, reason := s.origins.Check(r)
+	if !ok {
+		logger.Info("websocket origin rejected", logger.FieldKV("origin", r.Header.Get("Origin")), logger.FieldKV("host", r.Host), logger.FieldKV("reason", reason), logger.FieldKV("remote_addr", r.RemoteAddr))
+		metrics.IncWSOriginRejected(reason)
+	}
+	return ok
+}
+
+func splitHostPort(hostport string) (string, string) {
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		return h, p
+	}
+	return strings.Trim(hostport, "[]"), ""
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	p, err := NewOriginPolicy([]string{"https://chat.example.com", "https://*.example.org", "http://app.test:8081"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, _ := NewOriginPolicy(nil, true)
+	allowAll, _ := NewOriginPolicy([]string{"*"}, false)
+	cases := []struct {
+		name   string
+		policy *OriginPolicy
+		origin string
+		want   bool
+	}{
+		{"no origin header", p, "", true},
+		{"same origin", p, "https://api.example.net", true},
+		{"exact match", p, "https://chat.example.com", true},
+		{"exact match is case insensitive", p, "https://CHAT.example.com", true},
+		{"scheme mismatch", p, "http://chat.example.com", false},
+		{"unexpected port", p, "https://chat.example.com:8443", false},
+		{"port match", p, "http://app.test:8081", true},
+		{"wildcard subdomain", p, "https://a.example.org", true},
+		{"wildcard nested subdomain", p, "https://a.b.example.org", true},
+		{"wildcard excludes apex", p, "https://example.org", false},
+		{"suffix trick", p, "https://evilexample.org", false},
+		{"lookalike host", p, "https://chat.example.com.evil.net", false},
+		{"cross site", p, "https://evil.example", false},
+		{"null origin", p, "null", false},
+		{"localhost without dev mode", p, "http://localhost:8081", false},
+		{"dev localhost", dev, "http://localhost:8081", true},
+		{"dev loopback ip", dev, "http://127.0.0.1:3000", true},
+		{"dev ipv6 loopback", dev, "http://[::1]:3000", true},
+		{"dev still rejects remote", dev, "https://evil.example", false},
+		{"allow all", allowAll, "https://evil.example", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://api.example.net/api/ws", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if ok, reason := tc.policy.Check(r); ok != tc.want {
+				t.Fatalf("origin %q: expected %v got %v (%s)", tc.origin, tc.want, ok, reason)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyRejectsBadPatterns(t *testing.T) {
+	for _, bad := range []string{"chat.example.com", "ftp://x", "https://", "https://*.", "https://a.*.com", "https://x/path"} {
+		if _, err := NewOriginPolicy([]string{bad}, false); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestWebSocketRejectsCrossSiteOrigin(t *testing.T) {
+	f := newWSFixture(t)
+	header := http.Header{"Origin": {"https://evil.example"}}
+	conn, resp, err := f.dialHeader(t, signToken(f.iss, "u1", nil, time.Hour), header)
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected cross-site upgrade to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 got %v", resp)
+	}
+}
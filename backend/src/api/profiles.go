@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"src/logger"
+	"src/models"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ProfileRepository stores user profiles.
+type ProfileRepository interface {
+	CreateProfile(ctx context.Context, p models.Profile) error
+	GetProfile(ctx context.Context, userID string) (models.Profile, error)
+	GetProfiles(ctx context.Context, userIDs []string) ([]models.Profile, error)
+	UpdateProfile(ctx context.Context, userID string, u models.ProfileUpdate, at time.Time) (models.Profile, error)
+	SetStatus(ctx context.Context, userID string, st *models.UserStatus) (models.Profile, error)
+	SetAutoStatus(ctx context.Context, userID string, st *models.UserStatus, now time.Time) (models.Profile, error)
+}
+
+// WithProfiles enables user profiles, created from token claims on each user's first login.
+func WithProfiles(r ProfileRepository) Option {
+	return func(s *Server) { s.profiles, s.profilesSeen = r, &seenSet{m: map[string]bool{}} }
+}
+
+const (
+	maxDisplayName = 64
+	maxAvatarURL   = 2048
+	// maxProfileLookup bounds the ids of one GET /users.
+	maxProfileLookup = 100
+	// maxSeenProfiles bounds the users remembered as having a profile; the set starts over when full.
+	maxSeenProfiles = 100000
+)
+
+// seenSet remembers users known to have a profile, so authentication skips the lookup.
+type seenSet struct {
+	mu sync.Mutex
+	m  map[string]bool
+}
+
+func (s *seenSet) has(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[userID]
+}
+
+func (s *seenSet) add(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.m) >= maxSeenProfiles {
+		s.m = map[string]bool{}
+	}
+	s.m[userID] = true
+}
+
+// ensureProfile creates the profile of a user seen for the first time from their token claims.
+// Failures are logged; the request goes on without a profile and the next one tries again.
+func (s *Server) ensureProfile(ctx context.Context, id models.Identity) {
+	if s.profiles == nil || id.Bot || id.Subject == "" || s.profilesSeen.has(id.Subject) {
+		return
+	}
+	now := s.now()
+	p := models.Profile{UserID: id.Subject, DisplayName: claimName(id.Name), Email: id.Email, CreatedAt: now, UpdatedAt: now}
+	if checkAvatarURL(id.Picture) == "" {
+		p.AvatarURL = id.Picture
+	}
+	err := s.profiles.CreateProfile(ctx, p)
+	switch {
+	case err == nil:
+		logger.Info("profile created", logger.FieldKV("sub", id.Subject))
+	case !errors.Is(err, models.ErrConflict):
+		logger.Error("create profile", err, logger.FieldKV("sub", id.Subject))
+		return
+	}
+	s.profilesSeen.add(id.Subject)
+}
+
+// claimName makes a display name of a name claim: control characters dropped, trimmed and cut to
+// maxDisplayName characters.
+func claimName(name string) string {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if utf8.RuneCountInString(name) > maxDisplayName {
+		name = strings.TrimSpace(string([]rune(name)[:maxDisplayName]))
+	}
+	return name
+}
+
+// checkDisplayName returns why name is not a valid display name, or "".
+func checkDisplayName(name string) string {
+	if name == "" || name != strings.TrimSpace(name) || utf8.RuneCountInString(name) > maxDisplayName || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return "display_name must be 1 to 64 characters without surrounding spaces or control characters"
+	}
+	return ""
+}
+
+// checkAvatarURL returns why u is not a valid avatar URL, or "".
+func checkAvatarURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || len(u) > maxAvatarURL || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+		return "avatar_url must be an https URL of at most 2048 characters"
+	}
+	return ""
+}
+
+// handleGetMyProfile returns the caller's own profile, including their email.
+func (s *Server) handleGetMyProfile(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	p, err := s.profiles.GetProfile(r.Context(), id.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("get profile", err, logger.FieldKV("sub", id.Subject))
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.shown(p))
+}
+
+// handlePatchMyProfile changes the caller's display name and/or avatar ("" removes the avatar) and
+// tells connected clients on every replica.
+func (s *Server) handlePatchMyProfile(w http.ResponseWriter, r *http.Request) {
+	var u models.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil || (u.DisplayName == nil && u.AvatarURL == nil) {
+		http.Error(w, "bad request (display_name or avatar_url required)", http.StatusBadRequest)
+		return
+	}
+	if u.DisplayName != nil {
+		if reason := checkDisplayName(*u.DisplayName); reason != "" {
+			http.Error(w, reason, http.StatusBadRequest)
+			return
+		}
+	}
+	if u.AvatarURL != nil && *u.AvatarURL != "" {
+		if reason := checkAvatarURL(*u.AvatarURL); reason != "" {
+			http.Error(w, reason, http.StatusBadRequest)
+			return
+		}
+	}
+	id, _ := IdentityFrom(r.Context())
+	p, err := s.profiles.UpdateProfile(r.Context(), id.Subject, u, s.now())
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("update profile", err, logger.FieldKV("sub", id.Subject))
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	p = s.shown(p)
+	public := p.Public()
+	s.control(r.Context(), models.ControlEvent{Type: models.ControlProfile, UserID: id.Subject, Profile: &public})
+	writeJSON(w, http.StatusOK, p)
+}
+
+// handleListProfiles resolves up to 100 comma separated ids to public profiles; unknown ids are
+// left out.
+func (s *Server) handleListProfiles(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if v = strings.TrimSpace(v); v != "" && !seen[v] {
+			seen[v] = true
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) == 0 || len(ids) > maxProfileLookup {
+		http.Error(w, "ids must list 1 to 100 comma separated user ids", http.StatusBadRequest)
+		return
+	}
+	list, err := s.profiles.GetProfiles(r.Context(), ids)
+	if err != nil {
+		logger.Error("list profiles", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	for i := range list {
+		list[i] = s.shown(list[i]).Public()
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// shown is p as returned to clients, without an expired status.
+func (s *Server) shown(p models.Profile) models.Profile {
+	if !p.Status.Active(s.now()) {
+		p.Status = nil
+	}
+	return p
+}
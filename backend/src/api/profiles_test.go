@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockProfiles struct {
+	mu       sync.Mutex
+	profiles map[string]models.Profile
+	creates  int
+}
+
+func (m *mockProfiles) CreateProfile(ctx context.Context, p models.Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.creates++
+	if _, ok := m.profiles[p.UserID]; ok {
+		return models.ErrConflict
+	}
+	m.profiles[p.UserID] = p
+	return nil
+}
+func (m *mockProfiles) GetProfile(ctx context.Context, userID string) (models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[userID]
+	if !ok {
+		return p, models.ErrNotFound
+	}
+	return p, nil
+}
+func (m *mockProfiles) GetProfiles(ctx context.Context, userIDs []string) ([]models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []models.Profile{}
+	for _, id := range userIDs {
+		if p, ok := m.profiles[id]; ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+func (m *mockProfiles) UpdateProfile(ctx context.Context, userID string, u models.ProfileUpdate, at time.Time) (models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[userID]
+	if !ok {
+		return p, models.ErrNotFound
+	}
+	if u.DisplayName != nil {
+		p.DisplayName = *u.DisplayName
+	}
+	if u.AvatarURL != nil {
+		p.AvatarURL = *u.AvatarURL
+	}
+	p.UpdatedAt = at
+	m.profiles[userID] = p
+	return p, nil
+}
+
+func (m *mockProfiles) SetStatus(ctx context.Context, userID string, st *models.UserStatus) (models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[userID]
+	if !ok {
+		return p, models.ErrNotFound
+	}
+	p.Status = st
+	m.profiles[userID] = p
+	return p, nil
+}
+func (m *mockProfiles) SetAutoStatus(ctx context.Context, userID string, st *models.UserStatus, now time.Time) (models.Profile, error) {
+	m.mu.Lock()
+	p, ok := m.profiles[userID]
+	m.mu.Unlock()
+	cur := p.Status
+	if !ok || (st == nil && (cur == nil || !cur.Auto)) || (st != nil && cur.Active(now) && !cur.Auto) {
+		return p, models.ErrConflict
+	}
+	return m.SetStatus(ctx, userID, st)
+}
+
+func (m *mockProfiles) status(userID string) *models.UserStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.profiles[userID].Status
+}
+
+func TestProfiles(t *testing.T) {
+	profiles := &mockProfiles{profiles: map[string]models.Profile{}}
+	control := &mockControl{}
+	verifier := identityVerifier{
+		"alice": {Subject: "alice", Name: "Alice\x07 Liddell", Email: "alice@example.com", Picture: "https://img.example/alice.png"},
+		"bob":   {Subject: "bob", Picture: "javascript:alert(1)"},
+	}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithProfiles(profiles), WithControl(control))
+
+	w := serve(srv, "GET", "/api/users/me", "alice", "")
+	var p models.Profile
+	_ = json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != http.StatusOK || p.DisplayName != "Alice Liddell" || p.Email != "alice@example.com" || p.AvatarURL != "https://img.example/alice.png" {
+		t.Fatalf("first login should create the profile from claims: %d %s", w.Code, w.Body.String())
+	}
+	serve(srv, "GET", "/api/users/me", "alice", "")
+	serve(srv, "GET", "/api/users/me", "bob", "")
+	if profiles.creates != 2 || profiles.profiles["bob"].AvatarURL != "" {
+		t.Fatalf("expected one create per user and no unsafe avatar: %d %+v", profiles.creates, profiles.profiles["bob"])
+	}
+
+	for name, body := range map[string]string{
+		"empty":        `{}`,
+		"blank name":   `{"display_name":" "}`,
+		"long name":    `{"display_name":"` + strings.Repeat("x", 65) + `"}`,
+		"http avatar":  `{"avatar_url":"http://img.example/a.png"}`,
+		"not json":     `nope`,
+		"control char": `{"display_name":"a\u0000b"}`,
+	} {
+		if w := serve(srv, "PATCH", "/api/users/me", "alice", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400 got %d", name, w.Code)
+		}
+	}
+
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serve(srv, "PATCH", "/api/users/me", "alice", `{"display_name":"Alice","avatar_url":""}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"display_name":"Alice"`) || strings.Contains(w.Body.String(), "avatar_url") {
+		t.Fatalf("patch: %d %s", w.Code, w.Body.String())
+	}
+	if len(control.events) != 1 || control.events[0].Type != models.ControlProfile || control.events[0].Profile.Email != "" {
+		t.Fatalf("profile changes should reach other replicas without the email: %+v", control.events)
+	}
+	var ev models.Event
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ev); err != nil || ev.Type != models.EventProfile || ev.UserID != "alice" || ev.Profile.DisplayName != "Alice" {
+		t.Fatalf("expected a profile event, got %+v (%v)", ev, err)
+	}
+
+	w = serve(srv, "GET", "/api/users?ids=alice,carol,alice,bob", "bob", "")
+	var list []models.Profile
+	_ = json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list) != 2 || strings.Contains(w.Body.String(), "alice@example.com") {
+		t.Fatalf("lookup should return known users without emails: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(srv, "GET", "/api/users?ids=", "bob", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("empty lookup: %d", w.Code)
+	}
+}
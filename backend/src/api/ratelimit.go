@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"src/metrics"
+	"src/models"
+	"src/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate limited endpoints.
+const (
+	limitMessages = "messages"
+	limitWS       = "ws"
+)
+
+// RateLimits configures ingestion rate limits. Rules are keyed "<endpoint>.<key>": endpoint is
+// "messages" (POST /api/messages) or "ws" (frames read from a WebSocket) and key is "user" (the
+// authenticated subject) or "ip". Missing rules are unlimited. Incoming webhooks are limited per
+// hook by WithWebhooks instead.
+type RateLimits struct {
+	// Limiter holds the buckets; nil keeps them in process.
+	Limiter ratelimit.Limiter
+	Rules   map[string]ratelimit.Rule
+	// ProxyHops is the number of trusted proxies in front of the API that append to
+	// X-Forwarded-For; 0 keys IP limits on the connection's address.
+	ProxyHops int
+}
+
+// WithRateLimits enables per-user and per-IP limits on message ingestion.
+func WithRateLimits(l RateLimits) Option {
+	return func(s *Server) {
+		if l.Limiter != nil {
+			s.limiter = l.Limiter
+		}
+		s.limitRules, s.proxyHops = l.Rules, l.ProxyHops
+	}
+}
+
+// throttle takes a token from the caller's user and IP buckets for endpoint. It reports how long
+// to wait when either is empty.
+func (s *Server) throttle(ctx context.Context, endpoint string, id models.Identity, ip string) (bool, time.Duration) {
+	now := s.now()
+	for _, k := range [...][2]string{{"user", id.Subject}, {"ip", ip}} {
+		rule := s.limitRules[endpoint+"."+k[0]]
+		if k[1] == "" || !rule.Enabled() {
+			continue
+		}
+		if ok, wait := s.limiter.Allow(ctx, endpoint+":"+k[0]+":"+k[1], rule, now); !ok {
+			metrics.IncRateLimited(endpoint, k[0])
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// tooManyRequests answers 429 with Retry-After in whole seconds.
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
+
+// clientIP is the caller's address: the X-Forwarded-For entry added by the outermost of hops
+// trusted proxies, or the connection's address. Entries further left are client supplied and
+// ignored.
+func clientIP(r *http.Request, hops int) string {
+	if hops > 0 {
+		var chain []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, p := range strings.Split(h, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					chain = append(chain, p)
+				}
+			}
+		}
+		if len(chain) >= hops {
+			return chain[len(chain)-hops]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
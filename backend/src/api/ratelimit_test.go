@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"src/config"
+	"src/models"
+	"src/oidc/oidctest"
+	"src/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageRateLimits(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}, "carol": {Subject: "carol"}}
+	srv := NewServer(&capturingProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 1000,
+		WithClock(func() time.Time { return now }),
+		WithRateLimits(RateLimits{Rules: map[string]ratelimit.Rule{
+			"messages.user": {Rate: 2, Per: time.Minute, Burst: 2},
+			"messages.ip":   {Rate: 3, Per: time.Minute, Burst: 3},
+		}, ProxyHops: 1}))
+
+	post := func(token, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/messages", strings.NewReader(`{"content":"hi"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Forwarded-For", "203.0.113.9, "+ip)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := post("alice", "198.51.100.1"); w.Code != http.StatusAccepted {
+			t.Fatalf("message %d: expected 202 got %d", i, w.Code)
+		}
+	}
+	w := post("alice", "198.51.100.2")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("user limit: expected 429 with Retry-After 30, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Requests refused by the user limit take nothing from the IP bucket: 198.51.100.1 has had two
+	// requests, 198.51.100.2 none.
+	if w := post("bob", "198.51.100.1"); w.Code != http.StatusAccepted {
+		t.Fatalf("bob: expected 202 got %d", w.Code)
+	}
+	if w := post("carol", "198.51.100.1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("ip limit: expected 429 got %d", w.Code)
+	}
+	if w := post("carol", "198.51.100.2"); w.Code != http.StatusAccepted {
+		t.Fatalf("other ip: expected 202 got %d", w.Code)
+	}
+
+	now = now.Add(30 * time.Second)
+	if w := post("alice", "198.51.100.2"); w.Code != http.StatusAccepted {
+		t.Fatalf("after refill: expected 202 got %d", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		xff  []string
+		hops int
+		want string
+	}{
+		{nil, 0, "192.0.2.1"},
+		{[]string{"10.0.0.1"}, 0, "192.0.2.1"},
+		{[]string{"spoofed, 10.0.0.1"}, 1, "10.0.0.1"},
+		{[]string{"spoofed, 10.0.0.1", "10.0.0.2"}, 2, "10.0.0.1"},
+		{[]string{"10.0.0.1"}, 2, "192.0.2.1"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, h := range tc.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientIP(r, tc.hops); got != tc.want {
+			t.Errorf("clientIP(%q, %d) = %q, want %q", tc.xff, tc.hops, got, tc.want)
+		}
+	}
+}
+
+func TestWebSocketRateLimit(t *testing.T) {
+	iss := oidctest.New(config.Audience)
+	srv := NewServer(&mockProducer{}, &mockRepo{}, signedTokenVerifier(t, iss), nil, make(chan models.Message, 1), 100,
+		WithRateLimits(RateLimits{Rules: map[string]ratelimit.Rule{"ws.user": {Rate: 1, Per: time.Hour, Burst: 1}}}))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() { ts.Close(); iss.Close() })
+	f := &wsFixture{iss: iss, ts: ts, url: "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"}
+
+	conn, _, err := f.dial(t, signToken(iss, "u1", nil, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ok, err := readFrame(t, conn); err != nil || ok.Type != models.FrameAuthOK {
+		t.Fatalf("expected auth_ok got %+v (%v)", ok, err)
+	}
+	if err := conn.WriteJSON(models.Message{Content: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(models.Message{Content: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := readFrame(t, conn)
+	if err != nil || frame.Type != models.FrameError || frame.Error != "rate limit exceeded" || frame.RetryAfter != 3600 {
+		t.Fatalf("expected rate limit error frame, got %+v (%v)", frame, err)
+	}
+	// The connection stays open; further frames keep being refused.
+	if err := conn.WriteJSON(models.Message{Content: "three"}); err != nil {
+		t.Fatal(err)
+	}
+	if frame, err := readFrame(t, conn); err != nil || frame.Type != models.FrameError {
+		t.Fatalf("expected another error frame, got %+v (%v)", frame, err)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"strings"
+	"time"
+)
+
+// ReportRepository persists user reports of messages.
+type ReportRepository interface {
+	GetMessage(ctx context.Context, messageID string) (models.Message, error)
+	AddReport(ctx context.Context, msg models.Message, e models.ReportEntry) (models.Report, error)
+	ListReports(ctx context.Context, f models.ReportFilter) ([]models.Report, error)
+	ResolveReport(ctx context.Context, messageID, status, by, note string, at time.Time) (models.Report, error)
+	SetMessageHidden(ctx context.Context, messageID string, hidden bool) error
+}
+
+// WithReports lets users report messages; a message is hidden once hideThreshold users reported it
+// (0 = never).
+func WithReports(r ReportRepository, hideThreshold int) Option {
+	return func(s *Server) { s.reports, s.hideThreshold = r, hideThreshold }
+}
+
+// maxReportReason bounds the free text of a report.
+const maxReportReason = 500
+
+// handleReport records the caller's report of a message. Messages the caller cannot see are
+// reported as not found.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" || len(req.Reason) > maxReportReason {
+		http.Error(w, "bad request (reason required, at most 500 bytes)", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	msg, err := s.reports.GetMessage(r.Context(), r.PathValue("id"))
+	if err == nil && !visibleIn(id, msg, s.roomBans(r.Context(), id.Subject), nil) {
+		err = models.ErrNotFound
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("get reported message", err)
+		http.Error(w, "report failed", http.StatusInternalServerError)
+		return
+	}
+	if msg.UserID == id.Subject {
+		http.Error(w, "you cannot report your own message", http.StatusBadRequest)
+		return
+	}
+	rep, err := s.reports.AddReport(r.Context(), msg, models.ReportEntry{ReporterID: id.Subject, Reason: strings.TrimSpace(req.Reason), At: s.now()})
+	if errors.Is(err, models.ErrConflict) {
+		http.Error(w, "already reported", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error("add report", err)
+		http.Error(w, "report failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("message reported", logger.FieldKV("message_id", msg.MessageID), logger.FieldKV("reporter", id.Subject), logger.FieldKV("count", rep.Count))
+	if s.hideThreshold > 0 && rep.Count >= s.hideThreshold && !rep.Hidden {
+		if err := s.setHidden(r.Context(), msg, true, ""); err != nil {
+			logger.Error("hide reported message", err, logger.FieldKV("message_id", msg.MessageID))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setHidden hides or restores msg and tells connected clients; actor is empty for automatic hiding.
+func (s *Server) setHidden(ctx context.Context, msg models.Message, hidden bool, actor string) error {
+	if err := s.reports.SetMessageHidden(ctx, msg.MessageID, hidden); err != nil {
+		return err
+	}
+	ev := models.Event{Type: models.EventHide, RoomID: roomOf(msg), MessageID: msg.MessageID, Actor: actor, Timestamp: s.now()}
+	if !hidden {
+		ev.Type = models.EventUnhide
+	}
+	s.hub.Broadcast(ev)
+	logger.Info("message "+ev.Type, logger.FieldKV("message_id", msg.MessageID), logger.FieldKV("room_id", ev.RoomID), logger.FieldKV("actor", actor))
+	return nil
+}
+
+func (s *Server) handleListReports(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := models.ReportFilter{Status: q.Get("status"), RoomID: q.Get("room_id"), AuthorID: q.Get("author_id")}
+	switch f.Status {
+	case "":
+		f.Status = models.ReportOpen
+	case "all":
+		f.Status = ""
+	case models.ReportOpen, models.ReportDismissed, models.ReportActioned:
+	default:
+		http.Error(w, "unknown status", http.StatusBadRequest)
+		return
+	}
+	list, err := s.reports.ListReports(r.Context(), f)
+	if err != nil {
+		logger.Error("list reports", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleResolveReport dismisses a report, restoring a hidden message, or actions it, keeping the
+// message hidden.
+func (s *Server) handleResolveReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Status != models.ReportDismissed && req.Status != models.ReportActioned) {
+		http.Error(w, "status must be dismissed or actioned", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	rep, err := s.reports.ResolveReport(r.Context(), r.PathValue("id"), req.Status, id.Subject, req.Note, s.now())
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	case errors.Is(err, models.ErrConflict):
+		http.Error(w, "already resolved", http.StatusConflict)
+		return
+	case err != nil:
+		logger.Error("resolve report", err)
+		http.Error(w, "resolve failed", http.StatusInternalServerError)
+		return
+	}
+	wasHidden := rep.Hidden
+	if hide := req.Status == models.ReportActioned; hide != rep.Hidden {
+		msg := models.Message{MessageID: rep.MessageID, RoomID: rep.RoomID, UserID: rep.AuthorID}
+		if err := s.setHidden(r.Context(), msg, hide, id.Subject); err != nil {
+			logger.Error("update reported message", err, logger.FieldKV("message_id", rep.MessageID))
+			http.Error(w, "resolve failed", http.StatusInternalServerError)
+			return
+		}
+		rep.Hidden = hide
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "report." + req.Status, Target: rep.AuthorID, RoomID: rep.RoomID, Reason: req.Note,
+		Before: map[string]interface{}{"message_id": rep.MessageID, "status": models.ReportOpen, "hidden": wasHidden},
+		After:  map[string]interface{}{"message_id": rep.MessageID, "status": rep.Status, "hidden": rep.Hidden}})
+	writeJSON(w, http.StatusOK, rep)
+}
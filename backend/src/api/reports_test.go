@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockReports struct {
+	mu      sync.Mutex
+	msgs    map[string]models.Message
+	reports map[string]*models.Report
+}
+
+func newMockReports(msgs ...models.Message) *mockReports {
+	m := &mockReports{msgs: map[string]models.Message{}, reports: map[string]*models.Report{}}
+	for _, msg := range msgs {
+		m.msgs[msg.MessageID] = msg
+	}
+	return m
+}
+
+func (m *mockReports) GetMessage(ctx context.Context, id string) (models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.msgs[id]
+	if !ok {
+		return msg, models.ErrNotFound
+	}
+	return msg, nil
+}
+func (m *mockReports) AddReport(ctx context.Context, msg models.Message, e models.ReportEntry) (models.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rep, ok := m.reports[msg.MessageID]
+	if !ok {
+		rep = &models.Report{MessageID: msg.MessageID, RoomID: roomOf(msg), AuthorID: msg.UserID, CreatedAt: e.At}
+		m.reports[msg.MessageID] = rep
+	}
+	for _, x := range rep.Reports {
+		if x.ReporterID == e.ReporterID {
+			return *rep, models.ErrConflict
+		}
+	}
+	rep.Reports, rep.Count, rep.Status, rep.UpdatedAt = append(rep.Reports, e), rep.Count+1, models.ReportOpen, e.At
+	return *rep, nil
+}
+func (m *mockReports) ListReports(ctx context.Context, f models.ReportFilter) ([]models.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []models.Report{}
+	for _, rep := range m.reports {
+		if (f.Status == "" || rep.Status == f.Status) && (f.RoomID == "" || rep.RoomID == f.RoomID) && (f.AuthorID == "" || rep.AuthorID == f.AuthorID) {
+			out = append(out, *rep)
+		}
+	}
+	return out, nil
+}
+func (m *mockReports) ResolveReport(ctx context.Context, id, status, by, note string, at time.Time) (models.Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rep, ok := m.reports[id]
+	if !ok {
+		return models.Report{}, models.ErrNotFound
+	}
+	if rep.Status != models.ReportOpen {
+		return *rep, models.ErrConflict
+	}
+	rep.Status, rep.ResolvedBy, rep.ResolvedAt, rep.Note = status, by, &at, note
+	return *rep, nil
+}
+func (m *mockReports) SetMessageHidden(ctx context.Context, id string, hidden bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg := m.msgs[id]
+	msg.Hidden = hidden
+	m.msgs[id] = msg
+	if rep, ok := m.reports[id]; ok {
+		rep.Hidden = hidden
+	}
+	return nil
+}
+
+func newReportServer(reports *mockReports, audit *mockAudit, threshold int) *Server {
+	verifier := identityVerifier{
+		"alice": {Subject: "alice"}, "bob": {Subject: "bob"}, "carol": {Subject: "carol"},
+		"admin": {Subject: "admin", Groups: []string{"chat-admins"}},
+	}
+	return NewServer(&capturingProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500,
+		WithAdminGroups([]string{"chat-admins"}), WithReports(reports, threshold), WithAudit(audit))
+}
+
+func TestReportMessage(t *testing.T) {
+	reports := newMockReports(
+		models.Message{MessageID: "m1", UserID: "alice", RoomID: "general", Content: "rude"},
+		models.Message{MessageID: "dm", UserID: "alice", RoomID: models.DMRoomID("alice", "bob")},
+	)
+	srv := newReportServer(reports, &mockAudit{}, 0)
+
+	if w := serve(srv, "POST", "/api/messages/m1/report", "bob", `{"reason":" "}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("empty reason: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/m1/report", "alice", `{"reason":"spam"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("own message: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/missing/report", "bob", `{"reason":"spam"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("missing message: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/dm/report", "carol", `{"reason":"spam"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("other people's DMs cannot be reported: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/m1/report", "bob", `{"reason":"harassment"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("report: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/m1/report", "bob", `{"reason":"again"}`); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate report: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages/m1/report", "carol", `{"reason":"spam"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("second reporter: %d", w.Code)
+	}
+	rep := reports.reports["m1"]
+	if rep.Count != 2 || rep.AuthorID != "alice" || rep.Reports[0].Reason != "harassment" || rep.Hidden {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+
+	if w := serve(srv, "GET", "/api/admin/reports", "bob", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("users may not list reports: %d", w.Code)
+	}
+	var list []models.Report
+	_ = json.Unmarshal(serve(srv, "GET", "/api/admin/reports?room_id=general", "admin", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].MessageID != "m1" {
+		t.Fatalf("open reports: %+v", list)
+	}
+	if w := serve(srv, "GET", "/api/admin/reports?status=bogus", "admin", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown status: %d", w.Code)
+	}
+}
+
+func TestReportThresholdHidesMessage(t *testing.T) {
+	reports := newMockReports(models.Message{MessageID: "m1", UserID: "alice", RoomID: "general"})
+	audit := &mockAudit{}
+	srv := newReportServer(reports, audit, 2)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve(srv, "POST", "/api/messages/m1/report", "bob", `{"reason":"spam"}`)
+	if reports.msgs["m1"].Hidden {
+		t.Fatalf("hidden below the threshold")
+	}
+	serve(srv, "POST", "/api/messages/m1/report", "carol", `{"reason":"spam"}`)
+	if !reports.msgs["m1"].Hidden || !reports.reports["m1"].Hidden {
+		t.Fatalf("message should be hidden at the threshold")
+	}
+	var ev models.Event
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&ev); err != nil || ev.Type != models.EventHide || ev.MessageID != "m1" {
+		t.Fatalf("expected a hide event, got %+v (%v)", ev, err)
+	}
+
+	if w := serve(srv, "POST", "/api/admin/reports/m1/resolve", "admin", `{"status":"open"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid resolution: %d", w.Code)
+	}
+	w := serve(srv, "POST", "/api/admin/reports/m1/resolve", "admin", `{"status":"dismissed","note":"banter"}`)
+	var rep models.Report
+	_ = json.Unmarshal(w.Body.Bytes(), &rep)
+	if w.Code != http.StatusOK || rep.Status != models.ReportDismissed || rep.Hidden || reports.msgs["m1"].Hidden {
+		t.Fatalf("dismissing should restore the message: %d %+v", w.Code, rep)
+	}
+	if err := conn.ReadJSON(&ev); err != nil || ev.Type != models.EventUnhide || ev.Actor != "admin" {
+		t.Fatalf("expected an unhide event, got %+v (%v)", ev, err)
+	}
+	if w := serve(srv, "POST", "/api/admin/reports/m1/resolve", "admin", `{"status":"actioned"}`); w.Code != http.StatusConflict {
+		t.Fatalf("resolving twice: %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/admin/reports/missing/resolve", "admin", `{"status":"actioned"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("missing report: %d", w.Code)
+	}
+	if len(audit.entries) != 1 || audit.entries[0].Action != "report.dismissed" || audit.entries[0].Target != "alice" {
+		t.Fatalf("unexpected audit log %+v", audit.entries)
+	}
+}
+
+func TestHiddenMessagesLeaveHistory(t *testing.T) {
+	repo := &mockRepo{msgs: []models.Message{{MessageID: "1", Content: "ok"}, {MessageID: "2", Content: "bad", Hidden: true}}}
+	srv := NewServer(&mockProducer{}, repo, identityVerifier{"bob": {Subject: "bob"}}, nil, make(chan models.Message), 500)
+	var history []models.Message
+	_ = json.Unmarshal(serve(srv, "GET", "/api/messages", "bob", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].MessageID != "1" {
+		t.Fatalf("hidden messages should not be listed: %+v", history)
+	}
+}
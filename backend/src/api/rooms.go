@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"strings"
+	"time"
+)
+
+// RoomRepository abstracts room and pin persistence.
+type RoomRepository interface {
+	CreateRoom(ctx context.Context, room models.Room) error
+	GetRoom(ctx context.Context, roomID string) (models.Room, error)
+	GetMessage(ctx context.Context, messageID string) (models.Message, error)
+	PinMessage(ctx context.Context, pin models.Pin) error
+	UnpinMessage(ctx context.Context, roomID, messageID string) error
+	ListPins(ctx context.Context, roomID string) ([]models.Pin, error)
+	SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error
+	GetRoomRole(ctx context.Context, roomID, userID string) (models.Role, error)
+	SetRoomRole(ctx context.Context, rr models.RoomRole) error
+	RemoveRoomRole(ctx context.Context, roomID, userID string) error
+	ListRoomRoles(ctx context.Context, roomID string) ([]models.RoomRole, error)
+	SetRoomTopic(ctx context.Context, roomID, topic string) error
+	AddRoomMember(ctx context.Context, m models.RoomMember) error
+	ListRoomMembers(ctx context.Context, roomID string) ([]models.RoomMember, error)
+	// UserRooms lists the rooms a user owns, was added to or posted in, and their direct messages.
+	UserRooms(ctx context.Context, userID string) ([]string, error)
+}
+
+// errRoomUsed stops the scan for a room's first message.
+var errRoomUsed = errors.New("room has messages")
+
+// handleCreateRoom creates a room record owned by the caller. The default room and direct messages
+// never get one, and only admins may claim a room that already has messages.
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID string `json:"room_id"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.RoomID) == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	room := models.Room{RoomID: strings.TrimSpace(req.RoomID), Name: req.Name, OwnerID: id.Subject, CreatedAt: time.Now().UTC()}
+	if _, _, dm := models.DMParticipants(room.RoomID); dm || room.RoomID == models.DefaultRoomID {
+		http.Error(w, "room cannot be created", http.StatusBadRequest)
+		return
+	}
+	if id.Role.Rank() < models.RoleAdmin.Rank() {
+		err := s.repo.StreamMessages(r.Context(), models.MessageFilter{RoomID: room.RoomID}, func(models.Message) error { return errRoomUsed })
+		if errors.Is(err, errRoomUsed) {
+			http.Error(w, "room exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error("create room", err, logger.FieldKV("room_id", room.RoomID))
+			http.Error(w, "create failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.rooms.CreateRoom(r.Context(), room); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			http.Error(w, "room exists", http.StatusConflict)
+			return
+		}
+		logger.Error("create room", err, logger.FieldKV("room_id", room.RoomID))
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, room)
+}
+
+// handleListPins lists a room's pins to those who may read its history.
+func (s *Server) handleListPins(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	roomID := r.PathValue("id")
+	if !readsRoom(id, roomID, s.roomBans(r.Context(), id.Subject)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	pins, err := s.rooms.ListPins(r.Context(), roomID)
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, pins)
+}
+
+func (s *Server) handlePin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	roomID := r.PathValue("id")
+	id, ok := s.authorizeRoom(w, r, roomID, models.RoleModerator)
+	if !ok {
+		return
+	}
+	msg, err := s.rooms.GetMessage(r.Context(), req.MessageID)
+	if errors.Is(err, models.ErrNotFound) || (err == nil && roomOf(msg) != roomID) {
+		http.Error(w, "message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	pin := models.Pin{RoomID: roomID, MessageID: req.MessageID, PinnedBy: id.Subject, PinnedAt: time.Now().UTC()}
+	if err := s.rooms.PinMessage(r.Context(), pin); err != nil {
+		logger.Error("pin message", err, logger.FieldKV("room_id", roomID), logger.FieldKV("message_id", req.MessageID))
+		http.Error(w, "pin failed", http.StatusInternalServerError)
+		return
+	}
+	s.hub.Broadcast(models.Event{Type: models.EventPin, RoomID: roomID, MessageID: pin.MessageID, Actor: id.Subject, Timestamp: pin.PinnedAt})
+	writeJSON(w, http.StatusCreated, pin)
+}
+
+func (s *Server) handleUnpin(w http.ResponseWriter, r *http.Request) {
+	roomID, messageID := r.PathValue("id"), r.PathValue("messageID")
+	id, ok := s.authorizeRoom(w, r, roomID, models.RoleModerator)
+	if !ok {
+		return
+	}
+	if err := s.rooms.UnpinMessage(r.Context(), roomID, messageID); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not pinned", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "unpin failed", http.StatusInternalServerError)
+		return
+	}
+	s.hub.Broadcast(models.Event{Type: models.EventUnpin, RoomID: roomID, MessageID: messageID, Actor: id.Subject, Timestamp: time.Now().UTC()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetRetention sets the room's retention override; "0s" reverts to the global period.
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Retention string `json:"retention"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(req.Retention)
+	if err != nil || d < 0 || (d > 0 && d < time.Hour) {
+		http.Error(w, "retention must be 0 or a duration of at least 1h", http.StatusBadRequest)
+		return
+	}
+	roomID := r.PathValue("id")
+	id, ok := s.authorizeRoom(w, r, roomID, models.RoleModerator)
+	if !ok {
+		return
+	}
+	prev, _ := s.rooms.GetRoom(r.Context(), roomID)
+	if err := s.rooms.SetRoomRetention(r.Context(), roomID, d); err != nil {
+		logger.Error("set retention", err, logger.FieldKV("room_id", roomID))
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "room.retention", Target: roomID, RoomID: roomID,
+		Before: map[string]interface{}{"retention_seconds": prev.RetentionSeconds},
+		After:  map[string]interface{}{"retention_seconds": int64(d / time.Second)}})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"room_id": roomID, "retention_seconds": int64(d / time.Second)})
+}
+
+// authorizeRoom loads the room and checks the caller's effective role there is at least min,
+// writing the error response otherwise. The default room exists without a record.
+func (s *Server) authorizeRoom(w http.ResponseWriter, r *http.Request, roomID string, min models.Role) (models.Identity, bool) {
+	id, _ := IdentityFrom(r.Context())
+	room, err := s.rooms.GetRoom(r.Context(), roomID)
+	if errors.Is(err, models.ErrNotFound) && roomID == models.DefaultRoomID {
+		room, err = models.Room{RoomID: roomID}, nil
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return id, false
+	}
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return id, false
+	}
+	if role := s.roomRole(r.Context(), id, room); role.Rank() < min.Rank() {
+		logger.Info("room authorization denied", logger.FieldKV("sub", id.Subject), logger.FieldKV("room_id", roomID), logger.FieldKV("role", role), logger.FieldKV("required", min))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return id, false
+	}
+	return id, true
+}
+
+func (s *Server) handleListRoomRoles(w http.ResponseWriter, r *http.Request) {
+	roomID := r.PathValue("id")
+	if _, ok := s.authorizeRoom(w, r, roomID, models.RoleModerator); !ok {
+		return
+	}
+	list, err := s.rooms.ListRoomRoles(r.Context(), roomID)
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleSetRoomRole grants moderator or owner in a room; only owners (or admins) may do so.
+func (s *Server) handleSetRoomRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Role != models.RoleModerator && req.Role != models.RoleOwner) {
+		http.Error(w, "role must be moderator or owner", http.StatusBadRequest)
+		return
+	}
+	roomID, userID := r.PathValue("id"), r.PathValue("userID")
+	id, ok := s.authorizeRoom(w, r, roomID, models.RoleOwner)
+	if !ok {
+		return
+	}
+	prev, _ := s.rooms.GetRoomRole(r.Context(), roomID, userID)
+	rr := models.RoomRole{RoomID: roomID, UserID: userID, Role: req.Role, GrantedBy: id.Subject, GrantedAt: s.now()}
+	if err := s.rooms.SetRoomRole(r.Context(), rr); err != nil {
+		logger.Error("set room role", err, logger.FieldKV("room_id", roomID))
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "room.role.grant", Target: userID, RoomID: roomID,
+		Before: roleState(prev), After: roleState(rr.Role)})
+	logger.Info("room role granted", logger.FieldKV("room_id", roomID), logger.FieldKV("user_id", userID), logger.FieldKV("role", rr.Role), logger.FieldKV("actor", id.Subject))
+	writeJSON(w, http.StatusOK, rr)
+}
+
+func (s *Server) handleRemoveRoomRole(w http.ResponseWriter, r *http.Request) {
+	roomID, userID := r.PathValue("id"), r.PathValue("userID")
+	id, ok := s.authorizeRoom(w, r, roomID, models.RoleOwner)
+	if !ok {
+		return
+	}
+	prev, _ := s.rooms.GetRoomRole(r.Context(), roomID, userID)
+	if err := s.rooms.RemoveRoomRole(r.Context(), roomID, userID); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "room.role.revoke", Target: userID, RoomID: roomID, Before: roleState(prev)})
+	logger.Info("room role revoked", logger.FieldKV("room_id", roomID), logger.FieldKV("user_id", userID), logger.FieldKV("actor", id.Subject))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// roleState is the audit record of a room role grant; nil when there is none.
+func roleState(role models.Role) map[string]interface{} {
+	if role == "" {
+		return nil
+	}
+	return map[string]interface{}{"role": role}
+}
+
+// roomOf returns the message room, treating legacy room-less messages as the default room.
+func roomOf(m models.Message) string {
+	if m.RoomID == "" {
+		return models.DefaultRoomID
+	}
+	return m.RoomID
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockRooms struct {
+	rooms     map[string]models.Room
+	msgs      map[string]models.Message
+	pins      []models.Pin
+	retention map[string]time.Duration
+	roles     map[string]models.RoomRole // key room/user
+	members   []models.RoomMember
+}
+
+func newMockRooms() *mockRooms {
+	return &mockRooms{rooms: map[string]models.Room{}, msgs: map[string]models.Message{}, retention: map[string]time.Duration{}, roles: map[string]models.RoomRole{}}
+}
+
+func (m *mockRooms) CreateRoom(ctx context.Context, room models.Room) error {
+	if _, ok := m.rooms[room.RoomID]; ok {
+		return models.ErrConflict
+	}
+	m.rooms[room.RoomID] = room
+	return nil
+}
+func (m *mockRooms) GetRoom(ctx context.Context, id string) (models.Room, error) {
+	r, ok := m.rooms[id]
+	if !ok {
+		return r, models.ErrNotFound
+	}
+	return r, nil
+}
+func (m *mockRooms) GetMessage(ctx context.Context, id string) (models.Message, error) {
+	msg, ok := m.msgs[id]
+	if !ok {
+		return msg, models.ErrNotFound
+	}
+	return msg, nil
+}
+func (m *mockRooms) PinMessage(ctx context.Context, p models.Pin) error {
+	m.pins = append(m.pins, p)
+	return nil
+}
+func (m *mockRooms) UnpinMessage(ctx context.Context, roomID, messageID string) error {
+	for i, p := range m.pins {
+		if p.RoomID == roomID && p.MessageID == messageID {
+			m.pins = append(m.pins[:i], m.pins[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+func (m *mockRooms) SetRoomRetention(ctx context.Context, roomID string, d time.Duration) error {
+	m.retention[roomID] = d
+	return nil
+}
+func (m *mockRooms) GetRoomRole(ctx context.Context, roomID, userID string) (models.Role, error) {
+	return m.roles[roomID+"/"+userID].Role, nil
+}
+func (m *mockRooms) SetRoomRole(ctx context.Context, rr models.RoomRole) error {
+	m.roles[rr.RoomID+"/"+rr.UserID] = rr
+	return nil
+}
+func (m *mockRooms) RemoveRoomRole(ctx context.Context, roomID, userID string) error {
+	if _, ok := m.roles[roomID+"/"+userID]; !ok {
+		return models.ErrNotFound
+	}
+	delete(m.roles, roomID+"/"+userID)
+	return nil
+}
+func (m *mockRooms) ListRoomRoles(ctx context.Context, roomID string) ([]models.RoomRole, error) {
+	out := []models.RoomRole{}
+	for _, rr := range m.roles {
+		if rr.RoomID == roomID {
+			out = append(out, rr)
+		}
+	}
+	return out, nil
+}
+func (m *mockRooms) SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	r := m.rooms[roomID]
+	r.RoomID, r.Topic = roomID, topic
+	m.rooms[roomID] = r
+	return nil
+}
+func (m *mockRooms) AddRoomMember(ctx context.Context, rm models.RoomMember) error {
+	for _, x := range m.members {
+		if x.RoomID == rm.RoomID && x.UserID == rm.UserID {
+			return models.ErrConflict
+		}
+	}
+	m.members = append(m.members, rm)
+	return nil
+}
+func (m *mockRooms) ListRoomMembers(ctx context.Context, roomID string) ([]models.RoomMember, error) {
+	out := []models.RoomMember{}
+	for _, x := range m.members {
+		if x.RoomID == roomID {
+			out = append(out, x)
+		}
+	}
+	return out, nil
+}
+func (m *mockRooms) UserRooms(ctx context.Context, userID string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, r := range m.rooms {
+		seen[r.RoomID] = seen[r.RoomID] || r.OwnerID == userID
+	}
+	for _, x := range m.members {
+		seen[x.RoomID] = seen[x.RoomID] || x.UserID == userID
+	}
+	for _, msg := range m.msgs {
+		a, b, _ := models.DMParticipants(roomOf(msg))
+		seen[roomOf(msg)] = seen[roomOf(msg)] || msg.UserID == userID || a == userID || b == userID
+	}
+	out := []string{}
+	for room, in := range seen {
+		if in {
+			out = append(out, room)
+		}
+	}
+	return out, nil
+}
+func (m *mockRooms) ListPins(ctx context.Context, roomID string) ([]models.Pin, error) {
+	out := []models.Pin{}
+	for _, p := range m.pins {
+		if p.RoomID == roomID {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// identityVerifier maps raw tokens to identities; unknown tokens are rejected.
+type identityVerifier map[string]models.Identity
+
+func (v identityVerifier) Verify(ctx context.Context, raw string) error {
+	_, err := v.VerifyIdentity(ctx, raw)
+	return err
+}
+func (v identityVerifier) VerifyIdentity(ctx context.Context, raw string) (models.Identity, error) {
+	id, ok := v[raw]
+	if !ok {
+		return id, context.Canceled
+	}
+	return id, nil
+}
+
+func TestPins(t *testing.T) {
+	rooms := newMockRooms()
+	rooms.rooms["dev"] = models.Room{RoomID: "dev", OwnerID: "owner"}
+	rooms.msgs["m1"] = models.Message{MessageID: "m1", RoomID: "dev"}
+	rooms.msgs["m2"] = models.Message{MessageID: "m2", RoomID: "other"}
+	rooms.msgs["legacy"] = models.Message{MessageID: "legacy"}
+	verifier := identityVerifier{
+		"owner": {Subject: "owner"},
+		"mod":   {Subject: "mod", Groups: []string{"chat-moderators"}},
+		"user":  {Subject: "user"},
+	}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 100,
+		WithRooms(rooms), WithModeratorGroups([]string{"chat-moderators"}))
+
+	cases := []struct {
+		name, method, path, token, body string
+		want                            int
+	}{
+		{"user cannot pin", "POST", "/api/rooms/dev/pins", "user", `{"message_id":"m1"}`, 403},
+		{"owner pins", "POST", "/api/rooms/dev/pins", "owner", `{"message_id":"m1"}`, 201},
+		{"message from other room", "POST", "/rooms/dev/pins", "owner", `{"message_id":"m2"}`, 404},
+		{"unknown room", "POST", "/api/rooms/nope/pins", "mod", `{"message_id":"m1"}`, 404},
+		{"owner of dev is not moderator of general", "POST", "/api/rooms/general/pins", "owner", `{"message_id":"legacy"}`, 403},
+		{"moderator pins legacy message in default room", "POST", "/api/rooms/general/pins", "mod", `{"message_id":"legacy"}`, 201},
+		{"anyone lists", "GET", "/api/rooms/dev/pins", "user", "", 200},
+		{"user cannot unpin", "DELETE", "/api/rooms/dev/pins/m1", "user", "", 403},
+		{"moderator unpins", "DELETE", "/api/rooms/dev/pins/m1", "mod", "", 204},
+		{"unpin twice", "DELETE", "/api/rooms/dev/pins/m1", "mod", "", 404},
+		{"unauthenticated", "GET", "/api/rooms/dev/pins", "", "", 401},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("expected %d got %d (%s)", tc.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if len(rooms.pins) != 1 || rooms.pins[0].MessageID != "legacy" || rooms.pins[0].PinnedBy != "mod" {
+		t.Fatalf("unexpected pins %+v", rooms.pins)
+	}
+}
+
+func TestCreateRoomSetsOwner(t *testing.T) {
+	rooms := newMockRooms()
+	srv := NewServer(&mockProducer{}, &mockRepo{}, identityVerifier{"alice": {Subject: "alice"}}, nil, make(chan models.Message), 100, WithRooms(rooms))
+	for i, want := range []int{201, 409} {
+		r := httptest.NewRequest("POST", "/api/rooms", strings.NewReader(`{"room_id":"dev","name":"Dev"}`))
+		r.Header.Set("Authorization", "Bearer alice")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Fatalf("request %d: expected %d got %d", i, want, w.Code)
+		}
+		if i == 0 {
+			var room models.Room
+			_ = json.NewDecoder(w.Body).Decode(&room)
+			if room.OwnerID != "alice" {
+				t.Fatalf("expected owner alice got %q", room.OwnerID)
+			}
+		}
+	}
+}
+
+func TestCreateRoomRejectsUsedRooms(t *testing.T) {
+	repo := &mockRepo{msgs: []models.Message{{RoomID: "ops", UserID: "bob", Content: "hi"}}}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "admin": {Subject: "admin", Groups: []string{"admins"}}}
+	srv := NewServer(&mockProducer{}, repo, verifier, nil, make(chan models.Message), 100, WithRooms(newMockRooms()), WithAdminGroups([]string{"admins"}))
+	cases := []struct {
+		token, room string
+		want        int
+	}{
+		{"alice", models.DefaultRoomID, 400},
+		{"admin", models.DefaultRoomID, 400},
+		{"alice", "dm:alice,bob", 400},
+		{"alice", "ops", 409},
+		{"admin", "ops", 201},
+	}
+	for _, tc := range cases {
+		if w := serve(srv, "POST", "/api/rooms", tc.token, `{"room_id":"`+tc.room+`"}`); w.Code != tc.want {
+			t.Fatalf("%s creating %s: expected %d got %d", tc.token, tc.room, tc.want, w.Code)
+		}
+	}
+}
+
+func TestSetRetention(t *testing.T) {
+	rooms := newMockRooms()
+	rooms.rooms["dev"] = models.Room{RoomID: "dev", OwnerID: "owner"}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, identityVerifier{"owner": {Subject: "owner"}, "user": {Subject: "user"}}, nil, make(chan models.Message), 100, WithRooms(rooms))
+	cases := []struct {
+		token, body string
+		want        int
+	}{
+		{"user", `{"retention":"48h"}`, 403},
+		{"owner", `{"retention":"5m"}`, 400},
+		{"owner", `{"retention":"nope"}`, 400},
+		{"owner", `{"retention":"48h"}`, 200},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("PUT", "/api/rooms/dev/retention", strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Fatalf("%s %s: expected %d got %d", tc.token, tc.body, tc.want, w.Code)
+		}
+	}
+	if rooms.retention["dev"] != 48*time.Hour {
+		t.Fatalf("retention not stored: %v", rooms.retention)
+	}
+}
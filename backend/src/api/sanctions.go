@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SanctionRepository persists mutes and bans.
+type SanctionRepository interface {
+	CreateSanction(ctx context.Context, s models.Sanction) error
+	ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error)
+	ListSanctions(ctx context.Context, userID, roomID string) ([]models.Sanction, error)
+	GetSanction(ctx context.Context, id string) (models.Sanction, error)
+	LiftSanction(ctx context.Context, id string, at time.Time) (models.Sanction, error)
+}
+
+// ControlPublisher fans control events out to every replica (implemented by kafka.ControlAdapter).
+type ControlPublisher interface {
+	PublishControl(ctx context.Context, ev models.ControlEvent) error
+}
+
+// WithControl publishes bans and kicks so every replica closes the user's connections; without it
+// they only affect this replica.
+func WithControl(p ControlPublisher) Option { return func(s *Server) { s.controlPub = p } }
+
+const (
+	errMuted  = "you are muted in this room"
+	errBanned = "you are banned from this room"
+)
+
+// activeSanctions returns userID's sanctions in force. Lookup failures are logged and treated as
+// no sanctions, like room role lookups.
+func (s *Server) activeSanctions(ctx context.Context, userID string) []models.Sanction {
+	if s.sanctions == nil || userID == "" {
+		return nil
+	}
+	list, err := s.sanctions.ActiveSanctions(ctx, userID, s.now())
+	if err != nil {
+		logger.Error("sanction lookup", err, logger.FieldKV("sub", userID))
+		return nil
+	}
+	return list
+}
+
+// bannedGlobally reports whether a global ban covers userID.
+func (s *Server) bannedGlobally(ctx context.Context, userID string) bool {
+	for _, sn := range s.activeSanctions(ctx, userID) {
+		if sn.Kind == models.SanctionBan && sn.RoomID == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// roomBans maps the rooms userID is banned from to the ban's expiry (zero = until lifted).
+func (s *Server) roomBans(ctx context.Context, userID string) map[string]time.Time {
+	bans := map[string]time.Time{}
+	for _, sn := range s.activeSanctions(ctx, userID) {
+		if sn.Kind != models.SanctionBan || sn.RoomID == "" {
+			continue
+		}
+		var until time.Time
+		if sn.ExpiresAt != nil {
+			until = *sn.ExpiresAt
+		}
+		if prev, ok := bans[sn.RoomID]; !ok || (!prev.IsZero() && (until.IsZero() || until.After(prev))) {
+			bans[sn.RoomID] = until
+		}
+	}
+	return bans
+}
+
+// restriction returns why id may not post in room, or "" if they may: a ban, a mute (bans win), or
+// for direct messages not being a participant or being blocked by the other one.
+func (s *Server) restriction(ctx context.Context, id models.Identity, room string) string {
+	now, reason := s.now(), ""
+	for _, sn := range s.activeSanctions(ctx, id.Subject) {
+		if !sn.Applies(room, now) {
+			continue
+		}
+		switch sn.Kind {
+		case models.SanctionBan:
+			return errBanned
+		case models.SanctionMute:
+			reason = errMuted
+		}
+	}
+	if reason != "" {
+		return reason
+	}
+	return s.dmRestriction(ctx, id, room)
+}
+
+// sanctionError is a refused sanction; its text is safe to show to the moderator.
+type sanctionError string
+
+func (e sanctionError) Error() string { return string(e) }
+
+// sanction applies sn on behalf of actor, whose effective role where sn applies is role. Mutes and
+// bans are stored, every action is audited, and bans and kicks are sent to every replica. Nobody may
+// sanction themselves or a user whose role there (ownership or a grant; other users' token groups
+// are unknown here) is at least their own. Since a global target's role is unknown, global
+// sanctions need the admin role.
+func (s *Server) sanction(ctx context.Context, actor models.Identity, role models.Role, sn *models.Sanction) error {
+	if sn.UserID == actor.Subject {
+		return sanctionError("you cannot " + sn.Kind + " yourself")
+	}
+	if sn.RoomID == "" && role.Rank() < models.RoleAdmin.Rank() {
+		return sanctionError("global sanctions require the admin role")
+	}
+	target := models.RoleUser
+	if sn.RoomID != "" {
+		target = s.roleIn(ctx, models.Identity{Subject: sn.UserID}, sn.RoomID)
+	}
+	if target.Rank() >= role.Rank() {
+		return sanctionError("you cannot " + sn.Kind + " a user with the " + string(target) + " role")
+	}
+	sn.ID, sn.CreatedBy, sn.CreatedAt = uuid.NewString(), actor.Subject, s.now()
+	if sn.Kind != models.SanctionKick {
+		if err := s.sanctions.CreateSanction(ctx, *sn); err != nil {
+			return err
+		}
+	}
+	s.audit(ctx, models.AuditEntry{Actor: actor.Subject, Action: "sanction." + sn.Kind, Target: sn.UserID, RoomID: sn.RoomID, Reason: sn.Reason,
+		After: map[string]interface{}{"sanction_id": sn.ID, "expires_at": sn.ExpiresAt}})
+	switch sn.Kind {
+	case models.SanctionBan:
+		s.control(ctx, models.ControlEvent{Type: models.ControlBan, UserID: sn.UserID, RoomID: sn.RoomID, ExpiresAt: sn.ExpiresAt})
+	case models.SanctionKick:
+		s.control(ctx, models.ControlEvent{Type: models.ControlKick, UserID: sn.UserID})
+	}
+	logger.Info("user sanctioned", logger.FieldKV("kind", sn.Kind), logger.FieldKV("user_id", sn.UserID), logger.FieldKV("room_id", sn.RoomID), logger.FieldKV("actor", actor.Subject))
+	return nil
+}
+
+// control applies ev to this replica's connections and publishes it for the others.
+func (s *Server) control(ctx context.Context, ev models.ControlEvent) {
+	s.ApplyControl(ev)
+	if s.controlPub == nil {
+		return
+	}
+	if err := s.controlPub.PublishControl(ctx, ev); err != nil {
+		logger.Error("publish control event", err, logger.FieldKV("type", ev.Type), logger.FieldKV("user_id", ev.UserID))
+	}
+}
+
+// ApplyControl acts on a control event for this replica's connections: global bans and kicks close
+// them, room bans stop delivering the room and blocks the blocked user's messages. Profile changes
+// are pushed to every connection, status changes only to users sharing one of ev.Rooms who did not
+// block the user. It is idempotent, so the publishing replica may apply its own events twice.
+func (s *Server) ApplyControl(ev models.ControlEvent) {
+	switch {
+	case ev.Type == models.ControlBan && ev.RoomID != "":
+		var until time.Time
+		if ev.ExpiresAt != nil {
+			until = *ev.ExpiresAt
+		}
+		s.hub.BanFromRoom(ev.UserID, ev.RoomID, until)
+	case ev.Type == models.ControlBan:
+		s.disconnect(ev.UserID, "banned")
+	case ev.Type == models.ControlKick:
+		s.disconnect(ev.UserID, "kicked")
+	case ev.Type == models.ControlUnban && ev.RoomID != "":
+		s.hub.UnbanFromRoom(ev.UserID, ev.RoomID)
+	case ev.Type == models.ControlBlock:
+		s.hub.Block(ev.UserID, ev.TargetID)
+	case ev.Type == models.ControlUnblock:
+		s.hub.Unblock(ev.UserID, ev.TargetID)
+	case ev.Type == models.ControlProfile && ev.Profile != nil:
+		s.hub.Broadcast(models.Event{Type: models.EventProfile, UserID: ev.UserID, Profile: ev.Profile, Timestamp: ev.Profile.UpdatedAt})
+	case ev.Type == models.ControlStatus:
+		s.hub.SetAway(ev.UserID, ev.Status != nil && ev.Status.Auto)
+		s.hub.BroadcastStatus(models.Event{Type: models.EventStatus, UserID: ev.UserID, Status: ev.Status, Timestamp: time.Now().UTC()}, ev.Rooms)
+	}
+}
+
+func (s *Server) disconnect(userID, reason string) {
+	for _, conn := range s.hub.UserConns(userID) {
+		_ = s.hub.Close(conn, closeRemoved, reason)
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) handleCreateSanction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind     string `json:"kind"`
+		UserID   string `json:"user_id"`
+		RoomID   string `json:"room_id"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "bad request (user_id required)", http.StatusBadRequest)
+		return
+	}
+	switch req.Kind {
+	case models.SanctionMute, models.SanctionBan, models.SanctionKick:
+	default:
+		http.Error(w, "kind must be mute, ban or kick", http.StatusBadRequest)
+		return
+	}
+	var d time.Duration
+	if req.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 || req.Kind == models.SanctionKick {
+			http.Error(w, "duration must be a positive duration (not allowed for kicks)", http.StatusBadRequest)
+			return
+		}
+	}
+	id, ok := s.authorizeModeration(w, r, req.RoomID)
+	if !ok {
+		return
+	}
+	role := id.Role
+	if req.RoomID != "" {
+		role = s.roleIn(r.Context(), id, req.RoomID)
+	}
+	sn := models.Sanction{Kind: req.Kind, UserID: req.UserID, RoomID: req.RoomID, Reason: req.Reason}
+	if d > 0 {
+		exp := s.now().Add(d)
+		sn.ExpiresAt = &exp
+	}
+	var refused sanctionError
+	if err := s.sanction(r.Context(), id, role, &sn); errors.As(err, &refused) {
+		http.Error(w, refused.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		logger.Error("create sanction", err)
+		http.Error(w, "sanction failed", http.StatusInternalServerError)
+		return
+	}
+	if sn.Kind == models.SanctionKick {
+		writeJSON(w, http.StatusAccepted, sn)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sn)
+}
+
+// handleListSanctions lists sanctions by user and room; room_id "*" selects global ones, which
+// like an unfiltered list need a global moderator.
+func (s *Server) handleListSanctions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	scope := q.Get("room_id")
+	if scope == "*" {
+		scope = ""
+	}
+	if _, ok := s.authorizeModeration(w, r, scope); !ok {
+		return
+	}
+	list, err := s.sanctions.ListSanctions(r.Context(), q.Get("user_id"), q.Get("room_id"))
+	if err != nil {
+		logger.Error("list sanctions", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	if q.Get("active") == "true" {
+		now, active := s.now(), list[:0]
+		for _, sn := range list {
+			if sn.LiftedAt == nil && (sn.ExpiresAt == nil || now.Before(*sn.ExpiresAt)) {
+				active = append(active, sn)
+			}
+		}
+		list = active
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleLiftSanction ends a mute or ban early.
+func (s *Server) handleLiftSanction(w http.ResponseWriter, r *http.Request) {
+	sn, err := s.sanctions.GetSanction(r.Context(), r.PathValue("id"))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("get sanction", err)
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	id, ok := s.authorizeModeration(w, r, sn.RoomID)
+	if !ok {
+		return
+	}
+	sn, err = s.sanctions.LiftSanction(r.Context(), sn.ID, s.now())
+	switch {
+	case errors.Is(err, models.ErrConflict):
+		http.Error(w, "already lifted", http.StatusConflict)
+		return
+	case errors.Is(err, models.ErrNotFound):
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error("lift sanction", err)
+		http.Error(w, "lift failed", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "sanction.un" + sn.Kind, Target: sn.UserID, RoomID: sn.RoomID,
+		Before: map[string]interface{}{"sanction_id": sn.ID, "expires_at": sn.ExpiresAt}, After: map[string]interface{}{"sanction_id": sn.ID, "lifted_at": sn.LiftedAt}})
+	if sn.Kind == models.SanctionBan {
+		s.control(r.Context(), models.ControlEvent{Type: models.ControlUnban, UserID: sn.UserID, RoomID: sn.RoomID})
+	}
+	logger.Info("sanction lifted", logger.FieldKV("kind", sn.Kind), logger.FieldKV("user_id", sn.UserID), logger.FieldKV("room_id", sn.RoomID), logger.FieldKV("actor", id.Subject))
+	writeJSON(w, http.StatusOK, sn)
+}
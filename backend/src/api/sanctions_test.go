@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type mockControl struct{ events []models.ControlEvent }
+
+func (m *mockControl) PublishControl(ctx context.Context, ev models.ControlEvent) error {
+	m.events = append(m.events, ev)
+	return nil
+}
+
+type sanctionFixture struct {
+	srv       *Server
+	rooms     *mockRooms
+	sanctions *mockSanctions
+	audit     *mockAudit
+	control   *mockControl
+}
+
+func newSanctionFixture(repo *mockRepo) *sanctionFixture {
+	f := &sanctionFixture{rooms: newMockRooms(), sanctions: &mockSanctions{}, audit: &mockAudit{}, control: &mockControl{}}
+	verifier := identityVerifier{
+		"alice": {Subject: "alice"},
+		"dave":  {Subject: "dave"},
+		"mod":   {Subject: "mod", Groups: []string{"chat-moderators"}},
+		"admin": {Subject: "admin", Groups: []string{"chat-admins"}},
+	}
+	f.rooms.roles["general/dave"] = models.RoomRole{RoomID: "general", UserID: "dave", Role: models.RoleModerator}
+	f.srv = NewServer(&capturingProducer{}, repo, verifier, nil, make(chan models.Message), 500,
+		WithModeratorGroups([]string{"chat-moderators"}), WithAdminGroups([]string{"chat-admins"}), WithRooms(f.rooms), WithSanctions(f.sanctions),
+		WithAudit(f.audit), WithControl(f.control))
+	return f
+}
+
+func TestSanctionAPI(t *testing.T) {
+	repo := &mockRepo{msgs: []models.Message{{MessageID: "1", RoomID: "general", Content: "a"}, {MessageID: "2", RoomID: "random", Content: "b"}}}
+	f := newSanctionFixture(repo)
+
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "alice", `{"kind":"ban","user_id":"dave","room_id":"general"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("users may not ban: %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "dave", `{"kind":"ban","user_id":"alice"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("room moderators may not ban globally: %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "dave", `{"kind":"ban","user_id":"alice","room_id":"general","duration":"-1h"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("negative duration: %d", w.Code)
+	}
+	w := serve(f.srv, "POST", "/api/moderation/sanctions", "dave", `{"kind":"ban","user_id":"alice","room_id":"general","duration":"1h","reason":"abuse"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("room ban: %d %s", w.Code, w.Body.String())
+	}
+	var ban models.Sanction
+	_ = json.Unmarshal(w.Body.Bytes(), &ban)
+	if ban.ID == "" || ban.CreatedBy != "dave" || ban.ExpiresAt == nil {
+		t.Fatalf("unexpected ban %+v", ban)
+	}
+
+	if w := serve(f.srv, "POST", "/api/messages", "alice", `{"content":"hi","room_id":"general"}`); w.Code != http.StatusForbidden || strings.TrimSpace(w.Body.String()) != errBanned {
+		t.Fatalf("banned user posting: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(f.srv, "POST", "/api/messages", "alice", `{"content":"hi","room_id":"random"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("posting elsewhere: %d", w.Code)
+	}
+	var history []models.Message
+	_ = json.Unmarshal(serve(f.srv, "GET", "/api/messages", "alice", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].RoomID != "random" {
+		t.Fatalf("history should hide the banned room: %+v", history)
+	}
+
+	var list []models.Sanction
+	_ = json.Unmarshal(serve(f.srv, "GET", "/api/moderation/sanctions?user_id=alice&active=true", "mod", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != ban.ID {
+		t.Fatalf("active sanctions: %+v", list)
+	}
+
+	if w := serve(f.srv, "DELETE", "/api/moderation/sanctions/"+ban.ID, "alice", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("users may not lift bans: %d", w.Code)
+	}
+	if w := serve(f.srv, "DELETE", "/api/moderation/sanctions/"+ban.ID, "dave", ""); w.Code != http.StatusOK {
+		t.Fatalf("lift: %d", w.Code)
+	}
+	if w := serve(f.srv, "DELETE", "/api/moderation/sanctions/"+ban.ID, "dave", ""); w.Code != http.StatusConflict {
+		t.Fatalf("lift twice: %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/messages", "alice", `{"content":"back","room_id":"general"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("posting after the ban was lifted: %d", w.Code)
+	}
+
+	if len(f.audit.entries) != 2 || f.audit.entries[0].Action != "sanction.ban" || f.audit.entries[0].Reason != "abuse" || f.audit.entries[1].Action != "sanction.unban" {
+		t.Fatalf("unexpected audit log %+v", f.audit.entries)
+	}
+	if len(f.control.events) != 2 || f.control.events[0].Type != models.ControlBan || f.control.events[1].Type != models.ControlUnban {
+		t.Fatalf("unexpected control events %+v", f.control.events)
+	}
+}
+
+func TestGlobalBanRejectsRequests(t *testing.T) {
+	f := newSanctionFixture(&mockRepo{})
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "admin", `{"kind":"ban","user_id":"admin"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("self ban: %d", w.Code)
+	}
+	for _, kind := range []string{"ban", "mute", "kick"} {
+		if w := serve(f.srv, "POST", "/api/moderation/sanctions", "mod", `{"kind":"`+kind+`","user_id":"admin"}`); w.Code != http.StatusForbidden {
+			t.Fatalf("global moderators may not %s globally: %d", kind, w.Code)
+		}
+	}
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "admin", `{"kind":"ban","user_id":"alice"}`); w.Code != http.StatusCreated {
+		t.Fatalf("global ban: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(f.srv, "GET", "/api/messages", "alice", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("globally banned user reading: %d", w.Code)
+	}
+	if w := serve(f.srv, "GET", "/api/messages", "dave", ""); w.Code != http.StatusOK {
+		t.Fatalf("other users are unaffected: %d", w.Code)
+	}
+}
+
+func dialAs(t *testing.T, ts *httptest.Server, token string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	f := &wsFixture{ts: ts, url: "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"}
+	conn, resp, err := f.dial(t, token)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+		if ok, err := readFrame(t, conn); err != nil || ok.Type != models.FrameAuthOK {
+			t.Fatalf("expected auth_ok got %+v (%v)", ok, err)
+		}
+	}
+	return conn, resp, err
+}
+
+func TestKickAndBanCloseWebSockets(t *testing.T) {
+	f := newSanctionFixture(&mockRepo{})
+	ts := httptest.NewServer(f.srv)
+	t.Cleanup(ts.Close)
+
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "admin", `{"kind":"kick","user_id":"alice","duration":"1h"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("kicks take no duration: %d", w.Code)
+	}
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "admin", `{"kind":"kick","user_id":"alice"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("kick: %d", w.Code)
+	}
+	if _, err := readFrame(t, conn); !websocket.IsCloseError(err, closeRemoved) {
+		t.Fatalf("expected close %d after a kick, got %v", closeRemoved, err)
+	}
+	if len(f.sanctions.list) != 0 || len(f.audit.entries) != 1 || f.audit.entries[0].Action != "sanction.kick" {
+		t.Fatalf("kicks are audited but not stored: %+v %+v", f.sanctions.list, f.audit.entries)
+	}
+
+	// Kicked users may reconnect; banned ones are disconnected and refused.
+	if conn, _, err = dialAs(t, ts, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, out := (&commandFixture{srv: f.srv}).post(t, "mod", "/ban alice 1h"); !strings.HasPrefix(out["text"], "alice is banned in general until") {
+		t.Fatalf("/ban: %v", out)
+	}
+	// A room ban keeps the connection but stops delivering the room.
+	f.srv.hub.Broadcast(models.Message{MessageID: "1", RoomID: "general", Content: "hidden"})
+	f.srv.hub.Broadcast(models.Message{MessageID: "2", RoomID: "random", Content: "visible"})
+	var got models.Message
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil || got.MessageID != "2" {
+		t.Fatalf("room banned user should only get other rooms, got %+v (%v)", got, err)
+	}
+
+	if w := serve(f.srv, "POST", "/api/moderation/sanctions", "admin", `{"kind":"ban","user_id":"alice"}`); w.Code != http.StatusCreated {
+		t.Fatalf("global ban: %d", w.Code)
+	}
+	if _, err := readFrame(t, conn); !websocket.IsCloseError(err, closeRemoved) {
+		t.Fatalf("expected close %d after a ban, got %v", closeRemoved, err)
+	}
+	if _, resp, err := dialAs(t, ts, "alice"); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("banned users may not reconnect: %v", err)
+	}
+}
+
+func TestApplyControlFromOtherReplicas(t *testing.T) {
+	f := newSanctionFixture(&mockRepo{})
+	ts := httptest.NewServer(f.srv)
+	t.Cleanup(ts.Close)
+	conn, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.srv.ApplyControl(models.ControlEvent{Type: models.ControlBan, UserID: "alice", RoomID: "general"})
+	f.srv.hub.Broadcast(models.Event{Type: models.EventPin, RoomID: "general"})
+	f.srv.ApplyControl(models.ControlEvent{Type: models.ControlUnban, UserID: "alice", RoomID: "general"})
+	f.srv.hub.Broadcast(models.Message{MessageID: "after", RoomID: "general"})
+	var got models.Message
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&got); err != nil || got.MessageID != "after" {
+		t.Fatalf("expected only the message sent after the unban, got %+v (%v)", got, err)
+	}
+
+	f.srv.ApplyControl(models.ControlEvent{Type: models.ControlKick, UserID: "alice"})
+	if _, err := readFrame(t, conn); !websocket.IsCloseError(err, closeRemoved) {
+		t.Fatalf("expected close %d, got %v", closeRemoved, err)
+	}
+	if len(f.control.events) != 0 {
+		t.Fatalf("applying a remote event must not republish it: %+v", f.control.events)
+	}
+}
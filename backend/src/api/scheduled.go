@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/command"
+	"src/logger"
+	"src/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScheduledRepository abstracts storage of messages scheduled for later delivery.
+type ScheduledRepository interface {
+	CreateScheduled(ctx context.Context, sm models.ScheduledMessage) error
+	ListScheduled(ctx context.Context, createdBy string) ([]models.ScheduledMessage, error)
+	CancelScheduled(ctx context.Context, id, createdBy string) error
+}
+
+func (s *Server) handleCreateScheduled(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		models.Message
+		SendAt time.Time `json:"send_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	now := s.now()
+	if !req.SendAt.After(now) {
+		http.Error(w, "send_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	if s.maxScheduleAhead > 0 && req.SendAt.Sub(now) > s.maxScheduleAhead {
+		http.Error(w, "send_at too far in the future", http.StatusBadRequest)
+		return
+	}
+	msg := req.Message
+	if len(msg.Content) > s.maxMsgLen {
+		http.Error(w, "message too long", http.StatusBadRequest)
+		return
+	}
+	if msg.Encrypted != nil {
+		http.Error(w, "encrypted messages cannot be scheduled", http.StatusBadRequest)
+		return
+	}
+	// Commands run with the caller's role at invocation time, so they cannot be deferred.
+	if _, _, ok := command.Parse(msg.Content); ok {
+		http.Error(w, "slash commands cannot be scheduled", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	msg.MessageID = uuid.NewString()
+	msg.Timestamp = req.SendAt.UTC()
+	stampAuthor(&msg, id)
+	if msg.RoomID == "" {
+		msg.RoomID = models.DefaultRoomID
+	}
+	if reason := s.restriction(r.Context(), id, msg.RoomID); reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+	if err := renderContent(&msg); err != nil {
+		http.Error(w, "unsupported format", http.StatusBadRequest)
+		return
+	}
+	if s.validator != nil {
+		if err := s.validator.Validate(msg); err != nil {
+			http.Error(w, "invalid", http.StatusBadRequest)
+			return
+		}
+	}
+	// The review queue publishes right away on approval, so flagged messages are refused here.
+	if flagged, _, err := s.screen(id.Subject, &msg); err != nil || flagged {
+		if err == nil {
+			err = errInvalidMessage("message would be held for review and cannot be scheduled")
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sm := models.ScheduledMessage{ID: uuid.NewString(), Message: msg, SendAt: req.SendAt.UTC(), CreatedBy: id.Subject, CreatedAt: now, Status: models.ScheduledPending}
+	if err := s.scheduled.CreateScheduled(r.Context(), sm); err != nil {
+		logger.Error("create scheduled", err)
+		http.Error(w, "schedule failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sm)
+}
+
+func (s *Server) handleListScheduled(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	list, err := s.scheduled.ListScheduled(r.Context(), id.Subject)
+	if err != nil {
+		http.Error(w, "fetch failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	if err := s.scheduled.CancelScheduled(r.Context(), r.PathValue("id"), id.Subject); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "cancel failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockScheduled struct{ items []models.ScheduledMessage }
+
+func (m *mockScheduled) CreateScheduled(ctx context.Context, sm models.ScheduledMessage) error {
+	m.items = append(m.items, sm)
+	return nil
+}
+func (m *mockScheduled) ListScheduled(ctx context.Context, createdBy string) ([]models.ScheduledMessage, error) {
+	out := []models.ScheduledMessage{}
+	for _, sm := range m.items {
+		if sm.CreatedBy == createdBy && sm.Status == models.ScheduledPending {
+			out = append(out, sm)
+		}
+	}
+	return out, nil
+}
+func (m *mockScheduled) CancelScheduled(ctx context.Context, id, createdBy string) error {
+	for i := range m.items {
+		if m.items[i].ID == id && m.items[i].CreatedBy == createdBy && m.items[i].Status == models.ScheduledPending {
+			m.items[i].Status = models.ScheduledCanceled
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func TestScheduledMessages(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sched := &mockScheduled{}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}}, nil, make(chan models.Message), 100,
+		WithScheduled(sched, 24*time.Hour), WithClock(func() time.Time { return now }))
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	if w := do("POST", "/api/scheduled", "alice", `{"content":"late","send_at":"2025-01-01T11:00:00Z"}`); w.Code != 400 {
+		t.Fatalf("past send_at: expected 400 got %d", w.Code)
+	}
+	if w := do("POST", "/api/scheduled", "alice", `{"content":"far","send_at":"2025-03-01T11:00:00Z"}`); w.Code != 400 {
+		t.Fatalf("beyond max ahead: expected 400 got %d", w.Code)
+	}
+	if w := do("POST", "/api/scheduled", "alice", `{"content":"/topic later","send_at":"2025-01-01T13:00:00Z"}`); w.Code != 400 {
+		t.Fatalf("slash command: expected 400 got %d", w.Code)
+	}
+	w := do("POST", "/api/scheduled", "alice", `{"content":"**soon**","format":"markdown","send_at":"2025-01-01T13:00:00Z"}`)
+	if w.Code != 201 {
+		t.Fatalf("expected 201 got %d", w.Code)
+	}
+	var created models.ScheduledMessage
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if created.Message.MessageID == "" || created.Message.HTML != "<p><strong>soon</strong></p>" || created.Message.RoomID != models.DefaultRoomID {
+		t.Fatalf("message not prepared: %+v", created.Message)
+	}
+	if w := do("GET", "/api/scheduled", "bob", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("bob should not see alice's messages: %s", w.Body.String())
+	}
+	if w := do("DELETE", "/api/scheduled/"+created.ID, "bob", ""); w.Code != 404 {
+		t.Fatalf("bob cancel: expected 404 got %d", w.Code)
+	}
+	if w := do("DELETE", "/api/scheduled/"+created.ID, "alice", ""); w.Code != 204 {
+		t.Fatalf("alice cancel: expected 204 got %d", w.Code)
+	}
+	if w := do("GET", "/api/scheduled", "alice", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("canceled message still listed: %s", w.Body.String())
+	}
+}
@@ -3,11 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"src/logger"
+	"src/command"
 	"src/metrics"
 	"src/models"
+	"src/moderation"
+	"src/ratelimit"
+	"src/richtext"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,6 +28,8 @@ type Producer interface {
 type Repository interface {
 	InsertMessage(ctx context.Context, msg models.Message) error
 	GetAllMessages(ctx context.Context) ([]models.Message, error)
+	// StreamMessages iterates matching messages in timestamp order without loading them all.
+	StreamMessages(ctx context.Context, filter models.MessageFilter, fn func(models.Message) error) error
 }
 
 // TokenVerifier abstracts OIDC token verification.
@@ -31,18 +38,130 @@ type TokenVerifier interface {
 }
 
 type Server struct {
-	mux        *http.ServeMux
-	hub        *Hub
-	validator  *MessageValidator
-	producer   Producer
-	repo       Repository
-	verifier   TokenVerifier
-	maxMsgLen  int
-	broadcastC <-chan models.Message
-}
-
-func NewServer(p Producer, r Repository, v TokenVerifier, validator *MessageValidator, broadcast <-chan models.Message, maxLen int) *Server {
-	s := &Server{mux: http.NewServeMux(), hub: NewHub(), validator: validator, producer: p, repo: r, verifier: v, maxMsgLen: maxLen, broadcastC: broadcast}
+	mux             *http.ServeMux
+	hub             *Hub
+	validator       *MessageValidator
+	producer        Producer
+	repo            Repository
+	verifier        TokenVerifier
+	maxMsgLen       int
+	maxEncrypted    int
+	broadcastC      <-chan models.Message
+	rooms           RoomRepository
+	moderatorGroups []string
+	adminGroups     []string
+	holds           HoldRepository
+	scheduled       ScheduledRepository
+	// maxScheduleAhead bounds send_at for scheduled messages (0 = unlimited).
+	maxScheduleAhead time.Duration
+	// now is the server clock, replaceable in tests.
+	now func() time.Time
+	// origins guards WebSocket upgrades (same-origin only unless configured).
+	origins  *OriginPolicy
+	upgrader websocket.Upgrader
+	tokens   TokenRepository
+	hooks    WebhookRepository
+	// hookRule rate limits incoming webhooks per hook.
+	hookRule      ratelimit.Rule
+	subscriptions SubscriptionRepository
+	hookEvents    HookEvents
+	// commands holds the built-in slash commands; extCommands the externally registered ones.
+	commands      *command.Registry
+	extCommands   CommandRepository
+	commandClient *http.Client
+	sanctions     SanctionRepository
+	// limiter holds rate limit buckets for limitRules (keyed "<endpoint>.<key>") and hookRule.
+	limiter    ratelimit.Limiter
+	limitRules map[string]ratelimit.Rule
+	proxyHops  int
+	// moderation screens ingested messages; modRepo holds its configuration and review queue.
+	moderation *moderation.Engine
+	modRepo    ModerationRepository
+	// auditLog records privileged actions and auditPub mirrors them; controlPub tells other
+	// replicas about bans and kicks.
+	auditLog   AuditRepository
+	auditPub   AuditPublisher
+	controlPub ControlPublisher
+	blocks     BlockRepository
+	// reports holds user reports; hideThreshold reports hide a message (0 = never).
+	reports       ReportRepository
+	hideThreshold int
+	// keys is the public key directory of end-to-end encrypted DMs.
+	keys KeyRepository
+	// roomKeys rotates the keys that encrypt message content at rest.
+	roomKeys KeyRotator
+	// profiles holds user profiles; profilesSeen the users known to have one.
+	profiles     ProfileRepository
+	profilesSeen *seenSet
+	// awayAfter is the WebSocket inactivity after which users are set away (0 = never).
+	awayAfter time.Duration
+}
+
+// Option configures optional Server dependencies; routes for unset dependencies are not registered.
+type Option func(*Server)
+
+// WithRooms enables room creation and pinned message endpoints.
+func WithRooms(r RoomRepository) Option { return func(s *Server) { s.rooms = r } }
+
+// WithModeratorGroups sets the token groups mapped to the global moderator role.
+func WithModeratorGroups(groups []string) Option {
+	return func(s *Server) { s.moderatorGroups = groups }
+}
+
+// WithAdminGroups sets the token groups mapped to the global admin role (required by /admin endpoints).
+func WithAdminGroups(groups []string) Option { return func(s *Server) { s.adminGroups = groups } }
+
+// WithHolds enables legal hold management.
+func WithHolds(h HoldRepository) Option { return func(s *Server) { s.holds = h } }
+
+// WithScheduled enables scheduled message endpoints; maxAhead limits how far ahead send_at may be.
+func WithScheduled(r ScheduledRepository, maxAhead time.Duration) Option {
+	return func(s *Server) { s.scheduled, s.maxScheduleAhead = r, maxAhead }
+}
+
+// WithOriginPolicy sets the origins allowed to open WebSockets.
+func WithOriginPolicy(p *OriginPolicy) Option { return func(s *Server) { s.origins = p } }
+
+// WithTokens enables service accounts and their API tokens.
+func WithTokens(t TokenRepository) Option { return func(s *Server) { s.tokens = t } }
+
+// WithWebhooks enables incoming webhooks, each limited to perMinute requests (0 = unlimited).
+func WithWebhooks(h WebhookRepository, perMinute int) Option {
+	return func(s *Server) { s.hooks, s.hookRule = h, ratelimit.PerMinute(perMinute) }
+}
+
+// WithSubscriptions enables outgoing webhook subscription management.
+func WithSubscriptions(r SubscriptionRepository) Option {
+	return func(s *Server) { s.subscriptions = r }
+}
+
+// WithHookEvents sends room events other than messages to outgoing webhook subscriptions.
+func WithHookEvents(e HookEvents) Option {
+	return func(s *Server) { s.hookEvents = e }
+}
+
+// WithCommands enables externally registered slash commands; timeout bounds each call to a
+// command endpoint.
+func WithCommands(c CommandRepository, timeout time.Duration) Option {
+	return func(s *Server) { s.extCommands, s.commandClient = c, &http.Client{Timeout: timeout} }
+}
+
+// WithSanctions enables mutes, bans and kicks (the /mute, /ban and /kick commands and the
+// moderation API) and their enforcement.
+func WithSanctions(r SanctionRepository) Option { return func(s *Server) { s.sanctions = r } }
+
+// WithClock overrides the server clock.
+func WithClock(now func() time.Time) Option { return func(s *Server) { s.now = now } }
+
+func NewServer(p Producer, r Repository, v TokenVerifier, validator *MessageValidator, broadcast <-chan models.Message, maxLen int, opts ...Option) *Server {
+	s := &Server{mux: http.NewServeMux(), hub: NewHub(), validator: validator, producer: p, repo: r, verifier: v, maxMsgLen: maxLen, maxEncrypted: defaultMaxEncrypted, broadcastC: broadcast,
+		now: func() time.Time { return time.Now().UTC() }, origins: &OriginPolicy{}, commands: command.NewRegistry(),
+		limiter: ratelimit.NewMemory()}
+	for _, o := range opts {
+		o(s)
+	}
+	s.registerBuiltins()
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin, Subprotocols: []string{wsProtocol}}
 	s.routes()
 	go s.broadcastLoop()
 	return s
@@ -51,124 +170,247 @@ func NewServer(p Producer, r Repository, v TokenVerifier, validator *MessageVali
 func (s *Server) routes() {
 	s.mux.HandleFunc("/ws", s.handleWS)
 	s.mux.HandleFunc("/api/ws", s.handleWS)
-	s.mux.HandleFunc("/messages", s.withAuth(s.handleMessages))
-	s.mux.HandleFunc("/api/messages", s.withAuth(s.handleMessages))
+	s.mux.HandleFunc("/messages", s.withTokenAuth(s.handleMessages))
+	s.mux.HandleFunc("/api/messages", s.withTokenAuth(s.handleMessages))
+	if s.rooms != nil {
+		s.handle("POST /rooms", s.withAuth(s.handleCreateRoom))
+		s.handle("GET /rooms/{id}/pins", s.withAuth(s.handleListPins))
+		s.handle("POST /rooms/{id}/pins", s.withAuth(s.handlePin))
+		s.handle("DELETE /rooms/{id}/pins/{messageID}", s.withAuth(s.handleUnpin))
+		s.handle("PUT /rooms/{id}/retention", s.withAuth(s.handleSetRetention))
+		s.handle("GET /rooms/{id}/roles", s.withAuth(s.handleListRoomRoles))
+		s.handle("PUT /rooms/{id}/roles/{userID}", s.withAuth(s.handleSetRoomRole))
+		s.handle("DELETE /rooms/{id}/roles/{userID}", s.withAuth(s.handleRemoveRoomRole))
+		s.handle("GET /rooms/{id}/members", s.withAuth(s.handleListMembers))
+	}
+	s.handle("GET /admin/export", s.withAdmin(s.handleExport))
+	if s.holds != nil {
+		s.handle("POST /admin/holds", s.withAdmin(s.handleCreateHold))
+		s.handle("GET /admin/holds", s.withAdmin(s.handleListHolds))
+		s.handle("DELETE /admin/holds/{id}", s.withAdmin(s.handleReleaseHold))
+	}
+	if s.scheduled != nil {
+		s.handle("POST /scheduled", s.withAuth(s.handleCreateScheduled))
+		s.handle("GET /scheduled", s.withAuth(s.handleListScheduled))
+		s.handle("DELETE /scheduled/{id}", s.withAuth(s.handleCancelScheduled))
+	}
+	if s.tokens != nil {
+		s.handle("POST /admin/service-accounts", s.withAdmin(s.handleCreateServiceAccount))
+		s.handle("GET /admin/service-accounts", s.withAdmin(s.handleListServiceAccounts))
+		s.handle("DELETE /admin/service-accounts/{id}", s.withAdmin(s.handleDisableServiceAccount))
+		s.handle("POST /admin/service-accounts/{id}/tokens", s.withAdmin(s.handleCreateAPIToken))
+		s.handle("GET /admin/service-accounts/{id}/tokens", s.withAdmin(s.handleListAPITokens))
+		s.handle("DELETE /admin/service-accounts/{id}/tokens/{tokenID}", s.withAdmin(s.handleRevokeAPIToken))
+	}
+	if s.hooks != nil {
+		// Incoming hooks authenticate with their HMAC signature instead of a bearer token.
+		s.handle("POST /hooks/{id}", s.handleIncomingHook)
+		s.handle("POST /admin/webhooks", s.withAdmin(s.handleCreateHook))
+		s.handle("GET /admin/webhooks", s.withAdmin(s.handleListHooks))
+		s.handle("DELETE /admin/webhooks/{id}", s.withAdmin(s.handleRevokeHook))
+	}
+	if s.subscriptions != nil {
+		s.handle("POST /admin/subscriptions", s.withAdmin(s.handleCreateSubscription))
+		s.handle("GET /admin/subscriptions", s.withAdmin(s.handleListSubscriptions))
+		s.handle("DELETE /admin/subscriptions/{id}", s.withAdmin(s.handleDisableSubscription))
+		s.handle("GET /admin/subscriptions/{id}/deliveries", s.withAdmin(s.handleListDeliveries))
+		s.handle("POST /admin/subscriptions/{id}/deliveries/{deliveryID}/retry", s.withAdmin(s.handleRetryDelivery))
+	}
+	if s.extCommands != nil {
+		s.handle("POST /admin/commands", s.withAdmin(s.handleCreateCommand))
+		s.handle("GET /admin/commands", s.withAdmin(s.handleListCommands))
+		s.handle("DELETE /admin/commands/{name}", s.withAdmin(s.handleDeleteCommand))
+	}
+	if s.moderation != nil {
+		s.handle("GET /moderation/queue", s.withAuth(s.handleListFlagged))
+		s.handle("POST /moderation/queue/{id}/approve", s.withAuth(s.handleApproveFlagged))
+		s.handle("POST /moderation/queue/{id}/reject", s.withAuth(s.handleRejectFlagged))
+		s.handle("GET /admin/moderation", s.withAdmin(s.handleGetModerationConfig))
+		s.handle("PUT /admin/moderation", s.withAdmin(s.handlePutModerationConfig))
+	}
+	if s.sanctions != nil {
+		s.handle("POST /moderation/sanctions", s.withAuth(s.handleCreateSanction))
+		s.handle("GET /moderation/sanctions", s.withAuth(s.handleListSanctions))
+		s.handle("DELETE /moderation/sanctions/{id}", s.withAuth(s.handleLiftSanction))
+	}
+	if s.blocks != nil {
+		s.handle("GET /users/me/blocks", s.withAuth(s.handleListBlocks))
+		s.handle("PUT /users/me/blocks/{userID}", s.withAuth(s.handleBlock))
+		s.handle("DELETE /users/me/blocks/{userID}", s.withAuth(s.handleUnblock))
+	}
+	if s.reports != nil {
+		s.handle("POST /messages/{id}/report", s.withAuth(s.handleReport))
+		s.handle("GET /admin/reports", s.withAdmin(s.handleListReports))
+		s.handle("POST /admin/reports/{id}/resolve", s.withAdmin(s.handleResolveReport))
+	}
+	if s.auditLog != nil {
+		s.handle("GET /admin/audit", s.withAdmin(s.handleListAudit))
+	}
+	if s.keys != nil {
+		s.handle("GET /users/{userID}/devices", s.withAuth(s.handleListDevices))
+		s.handle("GET /users/{userID}/keys", s.withAuth(s.handleClaimKeys))
+		s.handle("PUT /users/me/devices/{deviceID}", s.withAuth(s.handlePutDevice))
+		s.handle("POST /users/me/devices/{deviceID}/prekeys", s.withAuth(s.handleAddPreKeys))
+		s.handle("DELETE /users/me/devices/{deviceID}", s.withAuth(s.handleDeleteDevice))
+	}
+	if s.profiles != nil {
+		s.handle("GET /users", s.withAuth(s.handleListProfiles))
+		s.handle("GET /users/me", s.withAuth(s.handleGetMyProfile))
+		s.handle("PATCH /users/me", s.withAuth(s.handlePatchMyProfile))
+		s.handle("PUT /users/me/status", s.withAuth(s.handlePutStatus))
+		s.handle("DELETE /users/me/status", s.withAuth(s.handleDeleteStatus))
+	}
+	if s.roomKeys != nil {
+		s.handle("POST /admin/rooms/{id}/rotate-key", s.withAdmin(s.handleRotateRoomKey))
+	}
+}
+
+// handle registers a "METHOD /path" pattern both bare and under /api, like the message routes.
+func (s *Server) handle(pattern string, h http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	s.mux.HandleFunc(method+" "+path, h)
+	s.mux.HandleFunc(method+" /api"+path, h)
+}
+
+// ServeHTTP tags every request with an ID, taken from a well-formed X-Request-ID header or
+// generated, and echoes it in the response so callers can find their actions in the audit log.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rid := r.Header.Get(requestIDHeader)
+	if !validRequestID(rid) {
+		rid = uuid.NewString()
+	}
+	w.Header().Set(requestIDHeader, rid)
+	s.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, rid)))
 }
 
-func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { s.mux.ServeHTTP(w, r) }
+const requestIDHeader = "X-Request-ID"
 
-// withAuth simple bearer token extraction passed to verifier.
+// validRequestID accepts up to 128 printable ASCII characters, so client-chosen IDs cannot smuggle
+// anything into logs.
+func validRequestID(rid string) bool {
+	if rid == "" || len(rid) > 128 {
+		return false
+	}
+	for i := 0; i < len(rid); i++ {
+		if rid[i] < 0x21 || rid[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDFrom returns the ID ServeHTTP assigned to the request, or "" outside a request.
+func RequestIDFrom(ctx context.Context) string {
+	rid, _ := ctx.Value(requestIDKey).(string)
+	return rid
+}
+
+// withAuth simple bearer token extraction passed to verifier. Service account tokens are refused
+// with 403; only routes wrapped in withTokenAuth accept them.
 func (s *Server) withAuth(next http.HandlerFunc) http.HandlerFunc {
+	return s.authed(false, next)
+}
+
+// withTokenAuth is withAuth that also admits service account tokens; the handler enforces their scopes.
+func (s *Server) withTokenAuth(next http.HandlerFunc) http.HandlerFunc {
+	return s.authed(true, next)
+}
+
+func (s *Server) authed(allowBots bool, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if auth == "" || len(auth) < 8 || auth[:7] != "Bearer " {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if err := s.verifier.Verify(r.Context(), auth[7:]); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		id, err := s.authenticate(r.Context(), auth[7:])
+		if err != nil {
+			authError(w, err)
 			return
 		}
-		next(w, r)
-	}
-}
-
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-
-func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token == "" || s.verifier.Verify(r.Context(), token) != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		logger.Error("websocket upgrade failed", err)
-		return
-	}
-	s.hub.Add(conn)
-	metrics.IncWSConnections()
-	go func() {
-		defer func() { s.hub.Remove(conn); metrics.DecWSConnections() }()
-		for {
-			var msg models.Message
-			if err := conn.ReadJSON(&msg); err != nil {
-				logger.Error("ws read", err)
-				return
-			}
-			if msg.MessageID == "" {
-				msg.MessageID = uuid.NewString()
-			}
-			if msg.Timestamp.IsZero() {
-				msg.Timestamp = time.Now().UTC()
-			}
-			if len(msg.Content) > s.maxMsgLen {
-				continue
-			}
-			if s.validator != nil {
-				if err := s.validator.Validate(msg); err != nil {
-					continue
-				}
-			}
-			if err := s.producer.Publish(r.Context(), msg); err != nil {
-				logger.Error("publish fail", err)
-				// Fallback: directly broadcast and persist so connected clients aren't blocked by Kafka
-				s.hub.BroadcastExcept(msg, conn)
-				if s.repo != nil {
-					if perr := s.repo.InsertMessage(context.Background(), msg); perr != nil {
-						logger.Error("fallback persist fail", perr)
-					}
-				}
-				metrics.IncMsgIngested()
-				continue
-			}
-			metrics.IncMsgIngested()
+		if id.Bot && !allowBots {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if s.bannedGlobally(r.Context(), id.Subject) {
+			http.Error(w, "you are banned", http.StatusForbidden)
+			return
 		}
-	}()
+		next(w, r.WithContext(withIdentity(r.Context(), id)))
+	}
 }
 
 func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		var msg models.Message
-		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBody())).Decode(&msg); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		if len(msg.Content) > s.maxMsgLen {
-			http.Error(w, "message too long", http.StatusBadRequest)
+		id, _ := IdentityFrom(r.Context())
+		if ok, wait := s.throttle(r.Context(), limitMessages, id, clientIP(r, s.proxyHops)); !ok {
+			tooManyRequests(w, wait)
 			return
 		}
-		if msg.MessageID == "" {
-			msg.MessageID = uuid.NewString()
+		if msg.RoomID == "" {
+			msg.RoomID = models.DefaultRoomID
 		}
-		msg.Timestamp = time.Now().UTC()
-		if s.validator != nil {
-			if err := s.validator.Validate(msg); err != nil {
-				http.Error(w, "invalid", http.StatusBadRequest)
+		if !id.Allows(models.ScopePost, msg.RoomID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		stampAuthor(&msg, id)
+		if name, args, ok := command.Parse(msg.Content); ok {
+			out := s.execCommand(r.Context(), id, msg, name, args)
+			if out.posted == nil {
+				writeJSON(w, http.StatusOK, out.reply)
 				return
 			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"message_id": out.posted.MessageID, "status": out.status, "reply": out.reply.Text})
+			return
 		}
-		if err := s.producer.Publish(r.Context(), msg); err != nil {
-			// Fallback: broadcast and persist immediately if enqueue fails
-			s.hub.Broadcast(msg)
-			if s.repo != nil {
-				_ = s.repo.InsertMessage(r.Context(), msg)
-			}
-			metrics.IncMsgIngested()
-			w.WriteHeader(http.StatusAccepted)
-			_ = json.NewEncoder(w).Encode(map[string]string{"message_id": msg.MessageID, "status": "broadcasted-fallback"})
+		if reason := s.restriction(r.Context(), id, msg.RoomID); reason != "" {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		var status string
+		var err error
+		if msg.Encrypted != nil {
+			status, err = s.acceptEncrypted(r.Context(), id, &msg)
+		} else {
+			status, err = s.acceptMessage(r.Context(), id.Subject, &msg)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		metrics.IncMsgIngested()
 		w.WriteHeader(http.StatusAccepted)
-		_ = json.NewEncoder(w).Encode(map[string]string{"message_id": msg.MessageID, "status": "enqueued"})
+		_ = json.NewEncoder(w).Encode(map[string]string{"message_id": msg.MessageID, "status": status})
 	case http.MethodGet:
+		id, _ := IdentityFrom(r.Context())
+		if !id.Allows(models.ScopeRead, "") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		list, err := s.repo.GetAllMessages(r.Context())
 		if err != nil {
 			http.Error(w, "fetch failed", http.StatusInternalServerError)
 			return
 		}
+		bans, blocked := s.roomBans(r.Context(), id.Subject), s.blockedBy(r.Context(), id.Subject)
+		visible := list[:0]
+		for _, m := range list {
+			if visibleIn(id, m, bans, blocked) {
+				visible = append(visible, m)
+			}
+		}
+		list = visible
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(list)
 	default:
@@ -176,6 +418,73 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errInvalidMessage is returned by acceptMessage; its text is safe to show to the client.
+type errInvalidMessage string
+
+func (e errInvalidMessage) Error() string { return string(e) }
+
+// acceptMessage fills in the server side fields of msg, renders and validates it and publishes it
+// on behalf of sender, the authenticated principal. When the producer fails the message is
+// broadcast and persisted directly so clients are not blocked by Kafka. It returns the status
+// reported to REST clients.
+func (s *Server) acceptMessage(ctx context.Context, sender string, msg *models.Message) (string, error) {
+	if len(msg.Content) > s.maxMsgLen {
+		return "", errInvalidMessage("message too long")
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.NewString()
+	}
+	msg.Timestamp = time.Now().UTC()
+	if msg.RoomID == "" {
+		msg.RoomID = models.DefaultRoomID
+	}
+	if err := renderContent(msg); err != nil {
+		return "", errInvalidMessage("unsupported format")
+	}
+	if s.validator != nil {
+		if err := s.validator.Validate(*msg); err != nil {
+			return "", errInvalidMessage("invalid")
+		}
+	}
+	// Moderation counts spam per authenticated sender, whatever user_id the message shows.
+	if held, err := s.moderate(ctx, sender, msg); err != nil || held {
+		if held {
+			return statusHeld, nil
+		}
+		return "", err
+	}
+	return s.publish(ctx, *msg), nil
+}
+
+// publish enqueues an accepted message and returns its status.
+func (s *Server) publish(ctx context.Context, msg models.Message) string {
+	metrics.IncMsgIngested()
+	if err := s.producer.Publish(ctx, msg); err != nil {
+		// Fallback: broadcast and persist immediately if enqueue fails
+		s.hub.Broadcast(msg)
+		if s.repo != nil {
+			_ = s.repo.InsertMessage(ctx, msg)
+		}
+		return "broadcasted-fallback"
+	}
+	return "enqueued"
+}
+
+// renderContent normalizes msg.Format and overwrites msg.HTML with the sanitized rendering,
+// so client supplied HTML never reaches broadcast or persistence.
+func renderContent(msg *models.Message) error {
+	format, err := richtext.NormalizeFormat(msg.Format)
+	if err != nil {
+		return err
+	}
+	rendered, err := richtext.Render(format, msg.Content)
+	if err != nil {
+		return err
+	}
+	msg.Format, msg.HTML = format, rendered
+	return nil
+}
+
 func (s *Server) broadcastLoop() {
 	for m := range s.broadcastC {
 		s.hub.Broadcast(m)
@@ -184,7 +493,20 @@ func (s *Server) broadcastLoop() {
 	}
 }
 
+// writeJSON encodes v with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
 // Helper to parse max length env already resolved upstream; fallback logic kept here if input <1
+// maxBody bounds a message request body or WebSocket frame: content escaped as JSON, ciphertexts
+// as base64 and room for the rest of the envelope.
+func (s *Server) maxBody() int64 {
+	return 6*int64(s.maxMsgLen) + 2*int64(s.maxEncrypted) + 64<<10
+}
+
 func ParseMaxLen(v string, fallback int) int {
 	if n, err := strconv.Atoi(v); err == nil && n > 0 {
 		return n
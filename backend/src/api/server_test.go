@@ -14,11 +14,25 @@ func (m *mockProducer) Publish(ctx context.Context, msg models.Message) error {
 	return nil
 }
 
-type mockRepo struct{}
+type mockRepo struct{ msgs []models.Message }
 
 func (m *mockRepo) InsertMessage(ctx context.Context, msg models.Message) error { return nil }
 func (m *mockRepo) GetAllMessages(ctx context.Context) ([]models.Message, error) {
-	return []models.Message{}, nil
+	return append([]models.Message{}, m.msgs...), nil
+}
+func (m *mockRepo) StreamMessages(ctx context.Context, f models.MessageFilter, fn func(models.Message) error) error {
+	for _, msg := range m.msgs {
+		if (f.RoomID != "" && msg.RoomID != f.RoomID) || (f.UserID != "" && msg.UserID != f.UserID) {
+			continue
+		}
+		if (!f.From.IsZero() && msg.Timestamp.Before(f.From)) || (!f.To.IsZero() && msg.Timestamp.After(f.To)) {
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type mockVerifier struct{ deny bool }
@@ -39,3 +53,37 @@ func TestUnauthorized(t *testing.T) {
 		t.Fatalf("expected 401 got %d", w.Result().StatusCode)
 	}
 }
+
+type unavailableVerifier struct{}
+
+func (unavailableVerifier) Verify(ctx context.Context, raw string) error {
+	return models.ErrAuthUnavailable
+}
+
+func TestAuthUnavailable(t *testing.T) {
+	srv := NewServer(&mockProducer{}, &mockRepo{}, unavailableVerifier{}, nil, make(chan models.Message), 100)
+	for _, path := range []string{"/api/messages", "/api/ws"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer x")
+		r.Header.Set("Sec-WebSocket-Protocol", "chatapp.v1, bearer.x")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != 503 || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: expected 503 with Retry-After got %d", path, w.Code)
+		}
+	}
+}
+
+func TestRenderContentOverwritesClientHTML(t *testing.T) {
+	msg := models.Message{Content: "**hi** <script>x</script>", Format: "markdown", HTML: "<img src=x onerror=alert(1)>"}
+	if err := renderContent(&msg); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if msg.HTML != "<p><strong>hi</strong> </p>" {
+		t.Fatalf("unexpected html %q", msg.HTML)
+	}
+	bad := models.Message{Content: "x", Format: "html"}
+	if err := renderContent(&bad); err == nil {
+		t.Fatal("expected unsupported format error")
+	}
+}
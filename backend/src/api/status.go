@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/logger"
+	"src/models"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WithAutoAway sets users away after they sent nothing on any of their WebSockets for after
+// (0 = never). It needs WithProfiles.
+func WithAutoAway(after time.Duration) Option { return func(s *Server) { s.awayAfter = after } }
+
+const (
+	maxStatusText = 100
+	// maxStatusDuration bounds how long a status may be set for; without a duration it stays until cleared.
+	maxStatusDuration = 30 * 24 * time.Hour
+)
+
+// handlePutStatus sets the caller's status and tells their own connections and those of users who
+// share a room with them (see Hub.BroadcastStatus).
+func (s *Server) handlePutStatus(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		State    string `json:"state"`
+		Text     string `json:"text"`
+		Duration string `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	switch req.State {
+	case models.StatusAvailable, models.StatusBusy, models.StatusAway, models.StatusDND:
+	default:
+		http.Error(w, "state must be available, busy, away or dnd", http.StatusBadRequest)
+		return
+	}
+	if req.Text != strings.TrimSpace(req.Text) || utf8.RuneCountInString(req.Text) > maxStatusText || strings.IndexFunc(req.Text, unicode.IsControl) >= 0 {
+		http.Error(w, "text must be at most 100 characters without surrounding spaces or control characters", http.StatusBadRequest)
+		return
+	}
+	now := s.now()
+	st := &models.UserStatus{State: req.State, Text: req.Text, UpdatedAt: now}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxStatusDuration {
+			http.Error(w, "duration must be a positive duration of at most 720h", http.StatusBadRequest)
+			return
+		}
+		exp := now.Add(d)
+		st.ExpiresAt = &exp
+	}
+	id, _ := IdentityFrom(r.Context())
+	if !s.setStatus(w, r.Context(), id.Subject, st) {
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleDeleteStatus clears the caller's status.
+func (s *Server) handleDeleteStatus(w http.ResponseWriter, r *http.Request) {
+	id, _ := IdentityFrom(r.Context())
+	if s.setStatus(w, r.Context(), id.Subject, nil) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) setStatus(w http.ResponseWriter, ctx context.Context, userID string, st *models.UserStatus) bool {
+	if _, err := s.profiles.SetStatus(ctx, userID, st); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return false
+		}
+		logger.Error("set status", err, logger.FieldKV("sub", userID))
+		http.Error(w, "update failed", http.StatusInternalServerError)
+		return false
+	}
+	s.control(ctx, models.ControlEvent{Type: models.ControlStatus, UserID: userID, Status: st, Rooms: s.userRooms(ctx, userID)})
+	return true
+}
+
+// userRooms returns the rooms userID takes part in, or none without a room repository.
+func (s *Server) userRooms(ctx context.Context, userID string) []string {
+	if s.rooms == nil {
+		return nil
+	}
+	rooms, err := s.rooms.UserRooms(ctx, userID)
+	if err != nil {
+		logger.Error("list user rooms", err, logger.FieldKV("sub", userID))
+	}
+	return rooms
+}
+
+// watchIdle starts the inactivity timer of a WebSocket session. Connecting counts as activity, so
+// it also ends an automatic away. The caller stops the returned timer when the session ends. The
+// timer gets the subject rather than reading sess.id, which reauth replaces on the read loop (with
+// the same subject).
+func (s *Server) watchIdle(ctx context.Context, sess *wsSession) *time.Timer {
+	userID := sess.id.Subject
+	s.autoStatus(ctx, userID, nil)
+	sess.idle = time.AfterFunc(s.awayAfter, func() { s.idleWS(ctx, sess, userID) })
+	return sess.idle
+}
+
+// activeWS records a frame from the session's user, ending their automatic away.
+func (s *Server) activeWS(ctx context.Context, sess *wsSession) {
+	if sess.idle == nil {
+		return
+	}
+	sess.idle.Reset(s.awayAfter)
+	if s.hub.Touch(sess.conn) {
+		s.autoStatus(ctx, sess.id.Subject, nil)
+	}
+}
+
+// idleWS sets the session's user away once none of their connections on this replica was active
+// for awayAfter; a status the user chose is left alone.
+func (s *Server) idleWS(ctx context.Context, sess *wsSession, userID string) {
+	last, away := s.hub.Activity(userID)
+	if rest := s.awayAfter - time.Since(last); rest > 0 {
+		// Another connection of the user was active since.
+		sess.idle.Reset(rest)
+		return
+	}
+	if !away {
+		s.autoStatus(ctx, userID, &models.UserStatus{State: models.StatusAway, Auto: true, UpdatedAt: s.now()})
+	}
+}
+
+// autoStatus sets (or with nil ends) an automatic status and announces it when it took effect.
+func (s *Server) autoStatus(ctx context.Context, userID string, st *models.UserStatus) {
+	if _, err := s.profiles.SetAutoStatus(ctx, userID, st, s.now()); err != nil {
+		if !errors.Is(err, models.ErrConflict) && ctx.Err() == nil {
+			logger.Error("automatic status", err, logger.FieldKV("sub", userID))
+		}
+		return
+	}
+	s.control(ctx, models.ControlEvent{Type: models.ControlStatus, UserID: userID, Status: st, Rooms: s.userRooms(ctx, userID)})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readEvent returns the next event of type typ on conn.
+func readEvent(t *testing.T, conn *websocket.Conn, typ string) models.Event {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var ev models.Event
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatalf("waiting for %s event: %v", typ, err)
+		}
+		if ev.Type == typ {
+			return ev
+		}
+	}
+}
+
+// waitStatus returns the status in the next status event about userID on conn.
+func waitStatus(t *testing.T, conn *websocket.Conn, userID string) *models.UserStatus {
+	t.Helper()
+	for {
+		if ev := readEvent(t, conn, models.EventStatus); ev.UserID == userID {
+			return ev.Status
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	profiles := &mockProfiles{profiles: map[string]models.Profile{}}
+	blocks := &mockBlocks{list: []models.Block{{UserID: "carol", BlockedID: "alice"}}}
+	rooms := newMockRooms()
+	rooms.members = []models.RoomMember{{RoomID: "dev", UserID: "alice"}, {RoomID: "dev", UserID: "bob"}, {RoomID: "dev", UserID: "carol"}}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}, "carol": {Subject: "carol"}, "dave": {Subject: "dave"}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithProfiles(profiles), WithBlocks(blocks), WithRooms(rooms))
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return now }
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	bob, _, err := dialAs(t, ts, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, _, err := dialAs(t, ts, "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dave, _, err := dialAs(t, ts, "dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string]string{
+		"unknown state": `{"state":"sleeping"}`,
+		"long text":     `{"state":"busy","text":"` + strings.Repeat("a", 101) + `"}`,
+		"bad duration":  `{"state":"busy","duration":"-1h"}`,
+		"too long":      `{"state":"busy","duration":"721h"}`,
+	} {
+		if w := serve(srv, "PUT", "/api/users/me/status", "alice", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400 got %d", name, w.Code)
+		}
+	}
+	w := serve(srv, "PUT", "/api/users/me/status", "alice", `{"state":"dnd","text":"Focus time","duration":"2h"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set status: %d %s", w.Code, w.Body.String())
+	}
+	ev := readEvent(t, bob, models.EventStatus)
+	if ev.UserID != "alice" || ev.Status.State != models.StatusDND || ev.Status.Text != "Focus time" || !ev.Status.ExpiresAt.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("unexpected status event %+v", ev)
+	}
+
+	var p models.Profile
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users/me", "alice", "").Body.Bytes(), &p)
+	if p.Status == nil || p.Status.State != models.StatusDND {
+		t.Fatalf("status should be stored with the profile: %+v", p)
+	}
+	now = now.Add(3 * time.Hour)
+	var list []models.Profile
+	_ = json.Unmarshal(serve(srv, "GET", "/api/users?ids=alice", "bob", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Status != nil {
+		t.Fatalf("expired statuses should not be shown: %+v", list)
+	}
+
+	if w := serve(srv, "DELETE", "/api/users/me/status", "alice", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("clear status: %d", w.Code)
+	}
+	if ev := readEvent(t, bob, models.EventStatus); ev.UserID != "alice" || ev.Status != nil {
+		t.Fatalf("expected a cleared status event, got %+v", ev)
+	}
+	// carol blocked alice: the next frame she gets is bob's status, not alice's.
+	serve(srv, "PUT", "/api/users/me/status", "bob", `{"state":"busy"}`)
+	if ev := readEvent(t, carol, models.EventStatus); ev.UserID != "bob" {
+		t.Fatalf("status of a blocked user was delivered: %+v", ev)
+	}
+	// dave shares no room with alice or bob: the first status he gets is his own.
+	serve(srv, "PUT", "/api/users/me/status", "dave", `{"state":"away"}`)
+	if ev := readEvent(t, dave, models.EventStatus); ev.UserID != "dave" {
+		t.Fatalf("status delivered to a user sharing no room: %+v", ev)
+	}
+	// Posting in dev makes it one of dave's rooms.
+	srv.hub.Broadcast(models.Message{RoomID: "dev", UserID: "dave", Content: "hi"})
+	serve(srv, "PUT", "/api/users/me/status", "alice", `{"state":"busy"}`)
+	if ev := readEvent(t, dave, models.EventStatus); ev.UserID != "alice" {
+		t.Fatalf("expected alice's status after dave posted in dev, got %+v", ev)
+	}
+}
+
+func TestAutoAway(t *testing.T) {
+	profiles := &mockProfiles{profiles: map[string]models.Profile{}}
+	rooms := newMockRooms()
+	rooms.members = []models.RoomMember{{RoomID: "dev", UserID: "alice"}, {RoomID: "dev", UserID: "bob"}}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithProfiles(profiles), WithRooms(rooms), WithAutoAway(100*time.Millisecond))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	bob, _, err := dialAs(t, ts, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st := waitStatus(t, alice, "bob"); st == nil || st.State != models.StatusAway || !st.Auto {
+		t.Fatalf("expected bob to go away, got %+v", st)
+	}
+	_ = bob.WriteJSON(map[string]string{"type": models.FrameActivity})
+	if st := waitStatus(t, alice, "bob"); st != nil {
+		t.Fatalf("activity should end automatic away, got %+v", st)
+	}
+
+	// A status the user chose is kept through inactivity.
+	serve(srv, "PUT", "/api/users/me/status", "bob", `{"state":"busy"}`)
+	time.Sleep(300 * time.Millisecond)
+	if st := profiles.status("bob"); st == nil || st.State != models.StatusBusy {
+		t.Fatalf("automatic away overrode a chosen status: %+v", st)
+	}
+}
+
+func TestAutoAwayAfterReauth(t *testing.T) {
+	profiles := &mockProfiles{profiles: map[string]models.Profile{}}
+	rooms := newMockRooms()
+	rooms.members = []models.RoomMember{{RoomID: "dev", UserID: "alice"}, {RoomID: "dev", UserID: "bob"}}
+	verifier := identityVerifier{"alice": {Subject: "alice"}, "bob": {Subject: "bob"}, "bob-renewed": {Subject: "bob"}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 500, WithProfiles(profiles), WithRooms(rooms), WithAutoAway(100*time.Millisecond))
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	alice, _, err := dialAs(t, ts, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, _, err := dialAs(t, ts, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle timer reads the session while the read loop swaps in the renewed identity.
+	if err := bob.WriteJSON(models.SessionFrame{Type: models.FrameReauth, Token: "bob-renewed"}); err != nil {
+		t.Fatal(err)
+	}
+	if fr, err := readFrame(t, bob); err != nil || fr.Type != models.FrameAuthOK {
+		t.Fatalf("expected auth_ok after reauth, got %+v (%v)", fr, err)
+	}
+	if st := waitStatus(t, alice, "bob"); st == nil || st.State != models.StatusAway || !st.Auto {
+		t.Fatalf("expected bob to go away after reauth, got %+v", st)
+	}
+}
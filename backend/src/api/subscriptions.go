@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"src/logger"
+	"src/models"
+	"src/webhook"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SubscriptionRepository persists outgoing webhook subscriptions and their deliveries.
+type SubscriptionRepository interface {
+	CreateWebhookSubscription(ctx context.Context, s models.WebhookSubscription) error
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	DisableWebhookSubscription(ctx context.Context, id string, at time.Time) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]models.WebhookDelivery, error)
+	RequeueWebhookDelivery(ctx context.Context, subscriptionID, id string, now time.Time) error
+}
+
+// HookEvents queues events for outgoing webhook subscriptions (implemented by
+// dispatcher.Dispatcher). Chat messages reach it through Kafka; other events are enqueued directly.
+type HookEvents interface {
+	Enqueue(ctx context.Context, ev models.HookEvent) error
+}
+
+// maxListedDeliveries bounds GET /admin/subscriptions/{id}/deliveries.
+const maxListedDeliveries = 100
+
+// createdSubscription is returned once at creation; Secret is the only time the signing secret is visible.
+type createdSubscription struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Rooms  []string `json:"rooms"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !absoluteHTTPURL(req.URL) {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	for _, ev := range req.Events {
+		if !knownHookEvent(ev) {
+			http.Error(w, "unknown event "+ev, http.StatusBadRequest)
+			return
+		}
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		logger.Error("generate subscription secret", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	sub := models.WebhookSubscription{ID: uuid.NewString(), URL: req.URL, Secret: secret, Events: req.Events, Rooms: req.Rooms, CreatedBy: id.Subject, CreatedAt: s.now()}
+	if err := s.subscriptions.CreateWebhookSubscription(r.Context(), sub); err != nil {
+		logger.Error("create webhook subscription", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("webhook subscription created", logger.FieldKV("subscription_id", sub.ID), logger.FieldKV("url", sub.URL), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "subscription.create", Target: sub.ID,
+		After: map[string]interface{}{"url": sub.URL, "events": strings.Join(sub.Events, ","), "rooms": strings.Join(sub.Rooms, ",")}})
+	writeJSON(w, http.StatusCreated, createdSubscription{WebhookSubscription: sub, Secret: secret})
+}
+
+// absoluteHTTPURL reports whether raw is an absolute http(s) URL the server can call.
+func absoluteHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func knownHookEvent(ev string) bool {
+	for _, t := range models.HookEventTypes {
+		if t == ev {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	list, err := s.subscriptions.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		logger.Error("list webhook subscriptions", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleDisableSubscription(w http.ResponseWriter, r *http.Request) {
+	subID := r.PathValue("id")
+	if err := s.subscriptions.DisableWebhookSubscription(r.Context(), subID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("disable webhook subscription", err)
+		http.Error(w, "disable failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("webhook subscription disabled", logger.FieldKV("subscription_id", subID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "subscription.disable", Target: subID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := s.subscriptions.ListWebhookDeliveries(r.Context(), r.PathValue("id"), maxListedDeliveries)
+	if err != nil {
+		logger.Error("list webhook deliveries", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleRetryDelivery requeues a parked delivery for an immediate attempt.
+func (s *Server) handleRetryDelivery(w http.ResponseWriter, r *http.Request) {
+	subID, deliveryID := r.PathValue("id"), r.PathValue("deliveryID")
+	if err := s.subscriptions.RequeueWebhookDelivery(r.Context(), subID, deliveryID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "no parked delivery with this id", http.StatusNotFound)
+			return
+		}
+		logger.Error("requeue webhook delivery", err)
+		http.Error(w, "retry failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("webhook delivery requeued", logger.FieldKV("delivery_id", deliveryID), logger.FieldKV("actor", id.Subject))
+	w.WriteHeader(http.StatusAccepted)
+}
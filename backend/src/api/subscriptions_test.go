@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockSubscriptions struct {
+	subs       []models.WebhookSubscription
+	deliveries []models.WebhookDelivery
+}
+
+func (m *mockSubscriptions) CreateWebhookSubscription(ctx context.Context, s models.WebhookSubscription) error {
+	m.subs = append(m.subs, s)
+	return nil
+}
+func (m *mockSubscriptions) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return m.subs, nil
+}
+func (m *mockSubscriptions) DisableWebhookSubscription(ctx context.Context, id string, at time.Time) error {
+	for i := range m.subs {
+		if m.subs[i].ID == id && m.subs[i].DisabledAt == nil {
+			m.subs[i].DisabledAt = &at
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+func (m *mockSubscriptions) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]models.WebhookDelivery, error) {
+	out := []models.WebhookDelivery{}
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+func (m *mockSubscriptions) RequeueWebhookDelivery(ctx context.Context, subscriptionID, id string, now time.Time) error {
+	for i := range m.deliveries {
+		d := &m.deliveries[i]
+		if d.ID == id && d.SubscriptionID == subscriptionID && d.Status == models.DeliveryParked {
+			d.Status, d.NextAttemptAt = models.DeliveryPending, now
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func TestWebhookSubscriptionAdmin(t *testing.T) {
+	subs := &mockSubscriptions{}
+	verifier := identityVerifier{"admin": {Subject: "admin", Groups: []string{"chat-admins"}}, "alice": {Subject: "alice"}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 100,
+		WithAdminGroups([]string{"chat-admins"}), WithSubscriptions(subs))
+
+	cases := []struct {
+		token, body string
+		want        int
+	}{
+		{"alice", `{"url":"https://example.com/hook"}`, 403},
+		{"admin", `{"url":"ftp://example.com/hook"}`, 400},
+		{"admin", `{"url":"/relative"}`, 400},
+		{"admin", `{"url":"https://example.com/hook","events":["message.exploded"]}`, 400},
+		{"admin", `{"url":"https://example.com/hook","events":["message.edited"]}`, 400},
+	}
+	for _, c := range cases {
+		if w := serve(srv, "POST", "/api/admin/subscriptions", c.token, c.body); w.Code != c.want {
+			t.Fatalf("%s %s: expected %d got %d", c.token, c.body, c.want, w.Code)
+		}
+	}
+	w := serve(srv, "POST", "/api/admin/subscriptions", "admin", `{"url":"https://example.com/hook","events":["message.created"],"rooms":["ops"]}`)
+	var created createdSubscription
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if w.Code != 201 || created.Secret == "" || created.Rooms[0] != "ops" {
+		t.Fatalf("create: %d %+v", w.Code, created)
+	}
+	if w := serve(srv, "GET", "/api/admin/subscriptions", "admin", ""); strings.Contains(w.Body.String(), created.Secret) {
+		t.Fatalf("list must not expose the secret: %s", w.Body.String())
+	}
+
+	subs.deliveries = []models.WebhookDelivery{{ID: "d1", SubscriptionID: created.ID, Status: models.DeliveryParked}}
+	if w := serve(srv, "GET", "/api/admin/subscriptions/"+created.ID+"/deliveries", "admin", ""); w.Code != 200 || !strings.Contains(w.Body.String(), `"parked"`) {
+		t.Fatalf("list deliveries: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(srv, "POST", "/api/admin/subscriptions/other/deliveries/d1/retry", "admin", ""); w.Code != 404 {
+		t.Fatalf("retry under another subscription: expected 404 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/admin/subscriptions/"+created.ID+"/deliveries/d1/retry", "admin", ""); w.Code != 202 || subs.deliveries[0].Status != models.DeliveryPending {
+		t.Fatalf("retry: expected 202 and pending got %d %s", w.Code, subs.deliveries[0].Status)
+	}
+	if w := serve(srv, "DELETE", "/api/admin/subscriptions/"+created.ID, "admin", ""); w.Code != 204 {
+		t.Fatalf("disable: expected 204 got %d", w.Code)
+	}
+	if w := serve(srv, "DELETE", "/api/admin/subscriptions/"+created.ID, "admin", ""); w.Code != 404 {
+		t.Fatalf("disable twice: expected 404 got %d", w.Code)
+	}
+}
+
+func TestCatchAllSubscriptionSkipsDMs(t *testing.T) {
+	subs := &mockSubscriptions{}
+	verifier := identityVerifier{"admin": {Subject: "admin", Groups: []string{"chat-admins"}}}
+	srv := NewServer(&mockProducer{}, &mockRepo{}, verifier, nil, make(chan models.Message), 100,
+		WithAdminGroups([]string{"chat-admins"}), WithSubscriptions(subs))
+
+	dm := models.DMRoomID("alice", "bob")
+	for _, body := range []string{`{"url":"https://example.com/all"}`, `{"url":"https://example.com/dm","rooms":["` + dm + `"]}`} {
+		if w := serve(srv, "POST", "/api/admin/subscriptions", "admin", body); w.Code != 201 {
+			t.Fatalf("create %s: %d", body, w.Code)
+		}
+	}
+	all, listed := subs.subs[0], subs.subs[1]
+	if all.Matches(models.HookEventMessageCreated, dm) {
+		t.Fatal("a catch-all subscription must not receive direct messages")
+	}
+	if !all.Matches(models.HookEventMessageCreated, "general") {
+		t.Fatal("a catch-all subscription should receive other rooms")
+	}
+	if !listed.Matches(models.HookEventMessageCreated, dm) {
+		t.Fatal("a subscription naming the DM room should receive it")
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"src/apitoken"
+	"src/logger"
+	"src/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenRepository persists service accounts and their API tokens.
+type TokenRepository interface {
+	CreateServiceAccount(ctx context.Context, a models.ServiceAccount) error
+	ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error)
+	DisableServiceAccount(ctx context.Context, id string, at time.Time) error
+	CreateAPIToken(ctx context.Context, t models.APIToken) error
+	ListAPITokens(ctx context.Context, accountID string) ([]models.APIToken, error)
+	GetAPIToken(ctx context.Context, id string) (models.APIToken, error)
+	RevokeAPIToken(ctx context.Context, accountID, id string, at time.Time) error
+	TouchAPIToken(ctx context.Context, id string, at time.Time) error
+}
+
+// errInvalidAPIToken hides which check failed from the caller.
+var errInvalidAPIToken = errors.New("invalid api token")
+
+// botSubjectPrefix namespaces service account subjects so they never collide with OIDC users.
+const botSubjectPrefix = "bot:"
+
+// authenticateAPIToken resolves a service account token to a scoped bot identity.
+func (s *Server) authenticateAPIToken(ctx context.Context, raw string) (models.Identity, error) {
+	if s.tokens == nil {
+		return models.Identity{}, errInvalidAPIToken
+	}
+	tokenID, secret, _ := apitoken.Parse(raw)
+	t, err := s.tokens.GetAPIToken(ctx, tokenID)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			logger.Error("api token lookup", err)
+		}
+		return models.Identity{}, errInvalidAPIToken
+	}
+	now := s.now()
+	if !apitoken.Matches(secret, t.Hash) || t.RevokedAt != nil || (t.ExpiresAt != nil && now.After(*t.ExpiresAt)) {
+		return models.Identity{}, errInvalidAPIToken
+	}
+	acct, err := s.tokens.GetServiceAccount(ctx, t.AccountID)
+	if err != nil || acct.DisabledAt != nil {
+		return models.Identity{}, errInvalidAPIToken
+	}
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > time.Minute {
+		if err := s.tokens.TouchAPIToken(ctx, t.ID, now); err != nil {
+			logger.Error("api token touch", err, logger.FieldKV("token_id", t.ID))
+		}
+	}
+	id := models.Identity{Subject: botSubjectPrefix + acct.ID, Name: acct.Name, Role: models.RoleUser, Bot: true,
+		Scopes: append([]string{}, t.Scopes...), Rooms: t.Rooms}
+	if t.ExpiresAt != nil {
+		id.ExpiresAt = *t.ExpiresAt
+	}
+	return id, nil
+}
+
+// stampAuthor sets the author and bot badge from the caller's identity so clients cannot fake
+// them; a user_id or display_name sent by the client is ignored. Service accounts post as their
+// bot: subject with the account name as display name.
+func stampAuthor(msg *models.Message, id models.Identity) {
+	msg.UserID, msg.Bot, msg.DisplayName = id.Subject, id.Bot, ""
+	if id.Bot {
+		msg.DisplayName = id.Name
+	}
+}
+
+func (s *Server) handleCreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var a models.ServiceAccount
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil || strings.TrimSpace(a.Name) == "" {
+		http.Error(w, "bad request (name required)", http.StatusBadRequest)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	a.ID, a.Name, a.CreatedBy, a.CreatedAt, a.DisabledAt = uuid.NewString(), strings.TrimSpace(a.Name), id.Subject, s.now(), nil
+	if err := s.tokens.CreateServiceAccount(r.Context(), a); err != nil {
+		if errors.Is(err, models.ErrConflict) {
+			http.Error(w, "name already in use", http.StatusConflict)
+			return
+		}
+		logger.Error("create service account", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("service account created", logger.FieldKV("account_id", a.ID), logger.FieldKV("name", a.Name), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "service_account.create", Target: a.ID, After: map[string]interface{}{"name": a.Name}})
+	writeJSON(w, http.StatusCreated, a)
+}
+
+func (s *Server) handleListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	list, err := s.tokens.ListServiceAccounts(r.Context())
+	if err != nil {
+		logger.Error("list service accounts", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleDisableServiceAccount(w http.ResponseWriter, r *http.Request) {
+	accountID := r.PathValue("id")
+	if err := s.tokens.DisableServiceAccount(r.Context(), accountID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("disable service account", err)
+		http.Error(w, "disable failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("service account disabled", logger.FieldKV("account_id", accountID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "service_account.disable", Target: accountID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createdToken is returned once at creation; Token is the only time the plaintext is visible.
+type createdToken struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+func (s *Server) handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		Rooms     []string `json:"rooms"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "bad request (name and scopes required)", http.StatusBadRequest)
+		return
+	}
+	for _, sc := range req.Scopes {
+		if sc != models.ScopeRead && sc != models.ScopePost {
+			http.Error(w, "unknown scope "+sc, http.StatusBadRequest)
+			return
+		}
+	}
+	now := s.now()
+	var expires *time.Time
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		at := now.Add(d)
+		expires = &at
+	}
+	accountID := r.PathValue("id")
+	acct, err := s.tokens.GetServiceAccount(r.Context(), accountID)
+	if errors.Is(err, models.ErrNotFound) || (err == nil && acct.DisabledAt != nil) {
+		http.Error(w, "service account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error("get service account", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	plain, tokenID, hash, err := apitoken.Generate()
+	if err != nil {
+		logger.Error("generate api token", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	t := models.APIToken{ID: tokenID, AccountID: accountID, Name: req.Name, Hash: hash, Scopes: req.Scopes, Rooms: req.Rooms,
+		CreatedBy: id.Subject, CreatedAt: now, ExpiresAt: expires}
+	if err := s.tokens.CreateAPIToken(r.Context(), t); err != nil {
+		logger.Error("create api token", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("api token created", logger.FieldKV("account_id", accountID), logger.FieldKV("token_id", tokenID), logger.FieldKV("scopes", strings.Join(req.Scopes, ",")), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "api_token.create", Target: accountID,
+		After: map[string]interface{}{"token_id": tokenID, "name": t.Name, "scopes": strings.Join(t.Scopes, ","), "rooms": strings.Join(t.Rooms, ","), "expires_at": t.ExpiresAt}})
+	writeJSON(w, http.StatusCreated, createdToken{APIToken: t, Token: plain})
+}
+
+func (s *Server) handleListAPITokens(w http.ResponseWriter, r *http.Request) {
+	list, err := s.tokens.ListAPITokens(r.Context(), r.PathValue("id"))
+	if err != nil {
+		logger.Error("list api tokens", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	accountID, tokenID := r.PathValue("id"), r.PathValue("tokenID")
+	if err := s.tokens.RevokeAPIToken(r.Context(), accountID, tokenID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("revoke api token", err)
+		http.Error(w, "revoke failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("api token revoked", logger.FieldKV("account_id", accountID), logger.FieldKV("token_id", tokenID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "api_token.revoke", Target: accountID, Before: map[string]interface{}{"token_id": tokenID}})
+	w.WriteHeader(http.StatusNoContent)
+}
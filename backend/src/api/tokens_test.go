@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"src/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type mockTokens struct {
+	accounts map[string]models.ServiceAccount
+	tokens   map[string]models.APIToken
+	touched  int
+}
+
+func newMockTokens() *mockTokens {
+	return &mockTokens{accounts: map[string]models.ServiceAccount{}, tokens: map[string]models.APIToken{}}
+}
+
+func (m *mockTokens) CreateServiceAccount(ctx context.Context, a models.ServiceAccount) error {
+	for _, existing := range m.accounts {
+		if existing.Name == a.Name {
+			return models.ErrConflict
+		}
+	}
+	m.accounts[a.ID] = a
+	return nil
+}
+func (m *mockTokens) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	out := []models.ServiceAccount{}
+	for _, a := range m.accounts {
+		out = append(out, a)
+	}
+	return out, nil
+}
+func (m *mockTokens) GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error) {
+	a, ok := m.accounts[id]
+	if !ok {
+		return a, models.ErrNotFound
+	}
+	return a, nil
+}
+func (m *mockTokens) DisableServiceAccount(ctx context.Context, id string, at time.Time) error {
+	a, ok := m.accounts[id]
+	if !ok {
+		return models.ErrNotFound
+	}
+	a.DisabledAt = &at
+	m.accounts[id] = a
+	return nil
+}
+func (m *mockTokens) CreateAPIToken(ctx context.Context, t models.APIToken) error {
+	m.tokens[t.ID] = t
+	return nil
+}
+func (m *mockTokens) ListAPITokens(ctx context.Context, accountID string) ([]models.APIToken, error) {
+	out := []models.APIToken{}
+	for _, t := range m.tokens {
+		if t.AccountID == accountID {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+func (m *mockTokens) GetAPIToken(ctx context.Context, id string) (models.APIToken, error) {
+	t, ok := m.tokens[id]
+	if !ok {
+		return t, models.ErrNotFound
+	}
+	return t, nil
+}
+func (m *mockTokens) RevokeAPIToken(ctx context.Context, accountID, id string, at time.Time) error {
+	t, ok := m.tokens[id]
+	if !ok || t.AccountID != accountID {
+		return models.ErrNotFound
+	}
+	t.RevokedAt = &at
+	m.tokens[id] = t
+	return nil
+}
+func (m *mockTokens) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	t := m.tokens[id]
+	t.LastUsedAt = &at
+	m.tokens[id] = t
+	m.touched++
+	return nil
+}
+
+type capturingProducer struct{ msgs []models.Message }
+
+func (p *capturingProducer) Publish(ctx context.Context, msg models.Message) error {
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+type roomRepo struct{ mockRepo }
+
+func (roomRepo) GetAllMessages(ctx context.Context) ([]models.Message, error) {
+	return []models.Message{{MessageID: "1", RoomID: "general"}, {MessageID: "2", RoomID: "ops"}}, nil
+}
+
+// tokenFixture creates a service account through the admin API and returns a helper minting tokens for it.
+func tokenFixture(t *testing.T, srv *Server) (accountID string, mint func(body string) createdToken) {
+	t.Helper()
+	w := serve(srv, "POST", "/api/admin/service-accounts", "admin", `{"name":"deploy-bot"}`)
+	if w.Code != 201 {
+		t.Fatalf("create account: %d %s", w.Code, w.Body.String())
+	}
+	var acct models.ServiceAccount
+	_ = json.NewDecoder(w.Body).Decode(&acct)
+	return acct.ID, func(body string) createdToken {
+		t.Helper()
+		w := serve(srv, "POST", "/api/admin/service-accounts/"+acct.ID+"/tokens", "admin", body)
+		if w.Code != 201 {
+			t.Fatalf("create token: %d %s", w.Code, w.Body.String())
+		}
+		var ct createdToken
+		_ = json.NewDecoder(w.Body).Decode(&ct)
+		return ct
+	}
+}
+
+func newTokenServer(p Producer, r Repository, repo *mockTokens, now *time.Time) *Server {
+	verifier := identityVerifier{"admin": {Subject: "admin", Groups: []string{"chat-admins"}}, "alice": {Subject: "alice"}}
+	return NewServer(p, r, verifier, nil, make(chan models.Message), 100,
+		WithAdminGroups([]string{"chat-admins"}), WithTokens(repo), WithClock(func() time.Time { return *now }))
+}
+
+func TestServiceAccountAdmin(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := newMockTokens()
+	srv := newTokenServer(&mockProducer{}, &mockRepo{}, repo, &now)
+
+	if w := serve(srv, "POST", "/api/admin/service-accounts", "alice", `{"name":"x"}`); w.Code != 403 {
+		t.Fatalf("non-admin create: expected 403 got %d", w.Code)
+	}
+	accountID, mint := tokenFixture(t, srv)
+	if w := serve(srv, "POST", "/api/admin/service-accounts", "admin", `{"name":"deploy-bot"}`); w.Code != 409 {
+		t.Fatalf("duplicate name: expected 409 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/admin/service-accounts/"+accountID+"/tokens", "admin", `{"name":"ci","scopes":["admin"]}`); w.Code != 400 {
+		t.Fatalf("unknown scope: expected 400 got %d", w.Code)
+	}
+	ct := mint(`{"name":"ci","scopes":["post"]}`)
+	stored := repo.tokens[ct.ID]
+	if !strings.HasPrefix(ct.Token, "chat_") || stored.Hash == "" || strings.Contains(ct.Token, stored.Hash) {
+		t.Fatalf("unexpected token response: %+v stored %+v", ct, stored)
+	}
+	w := serve(srv, "GET", "/api/admin/service-accounts/"+accountID+"/tokens", "admin", "")
+	if w.Code != 200 || strings.Contains(w.Body.String(), stored.Hash) || strings.Contains(w.Body.String(), ct.Token) {
+		t.Fatalf("list must not expose secrets: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(srv, "GET", "/api/admin/service-accounts", ct.Token, ""); w.Code != 403 {
+		t.Fatalf("bot on admin route: expected 403 got %d", w.Code)
+	}
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	repo := newMockTokens()
+	prod := &capturingProducer{}
+	srv := newTokenServer(prod, &roomRepo{}, repo, &now)
+	accountID, mint := tokenFixture(t, srv)
+
+	poster := mint(`{"name":"poster","scopes":["post"]}`)
+	reader := mint(`{"name":"reader","scopes":["read"],"rooms":["ops"]}`)
+	expiring := mint(`{"name":"short","scopes":["read","post"],"expires_in":"1h"}`)
+	revoked := mint(`{"name":"old","scopes":["read","post"]}`)
+	if w := serve(srv, "DELETE", "/api/admin/service-accounts/"+accountID+"/tokens/"+revoked.ID, "admin", ""); w.Code != 204 {
+		t.Fatalf("revoke: expected 204 got %d", w.Code)
+	}
+
+	cases := []struct {
+		name, method, token, body string
+		want                      int
+	}{
+		{"post-only can post", "POST", poster.Token, `{"content":"deployed","user_id":"spoofed","bot":false}`, 202},
+		{"post-only cannot read", "GET", poster.Token, "", 403},
+		{"read-only cannot post", "POST", reader.Token, `{"content":"x","room_id":"ops"}`, 403},
+		{"revoked", "GET", revoked.Token, "", 401},
+		{"wrong secret", "GET", poster.Token[:len(poster.Token)-2] + "xx", "", 401},
+		{"valid until expiry", "GET", expiring.Token, "", 200},
+	}
+	for _, c := range cases {
+		if w := serve(srv, c.method, "/api/messages", c.token, c.body); w.Code != c.want {
+			t.Fatalf("%s: expected %d got %d", c.name, c.want, w.Code)
+		}
+	}
+
+	if len(prod.msgs) != 1 || !prod.msgs[0].Bot || prod.msgs[0].UserID != botSubjectPrefix+accountID || prod.msgs[0].DisplayName != "deploy-bot" {
+		t.Fatalf("bot message not stamped: %+v", prod.msgs)
+	}
+	if w := serve(srv, "POST", "/api/messages", "alice", `{"content":"hi","user_id":"alice","bot":true}`); w.Code != 202 || prod.msgs[1].Bot {
+		t.Fatalf("human must not set bot badge: %d %+v", w.Code, prod.msgs)
+	}
+
+	w := serve(srv, "GET", "/api/messages", reader.Token, "")
+	var list []models.Message
+	_ = json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != 200 || len(list) != 1 || list[0].RoomID != "ops" {
+		t.Fatalf("room restricted read: %d %+v", w.Code, list)
+	}
+
+	if repo.touched != 3 {
+		t.Fatalf("last use should be recorded once per token within a minute, got %d touches", repo.touched)
+	}
+	now = now.Add(2 * time.Hour)
+	if w := serve(srv, "GET", "/api/messages", expiring.Token, ""); w.Code != 401 {
+		t.Fatalf("expired token: expected 401 got %d", w.Code)
+	}
+	if w := serve(srv, "DELETE", "/api/admin/service-accounts/"+accountID, "admin", ""); w.Code != 204 {
+		t.Fatalf("disable: expected 204 got %d", w.Code)
+	}
+	if w := serve(srv, "POST", "/api/messages", poster.Token, `{"content":"x"}`); w.Code != 401 {
+		t.Fatalf("disabled account: expected 401 got %d", w.Code)
+	}
+}
+
+func TestAPITokenWebSocket(t *testing.T) {
+	now := time.Now().UTC()
+	repo := newMockTokens()
+	srv := newTokenServer(&mockProducer{}, &mockRepo{}, repo, &now)
+	_, mint := tokenFixture(t, srv)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws"
+	dial := func(token string) (*websocket.Conn, int, error) {
+		d := *websocket.DefaultDialer
+		d.Subprotocols = []string{wsProtocol, bearerProtocolPrefix + token}
+		conn, resp, err := d.Dial(url, nil)
+		if resp != nil {
+			return conn, resp.StatusCode, err
+		}
+		return conn, 0, err
+	}
+
+	if _, code, _ := dial(mint(`{"name":"rooms","scopes":["read"],"rooms":["ops"]}`).Token); code != 403 {
+		t.Fatalf("room restricted token: expected 403 got %d", code)
+	}
+	conn, _, err := dial(mint(`{"name":"reader","scopes":["read"]}`).Token)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if f, err := readFrame(t, conn); err != nil || f.Type != models.FrameAuthOK {
+		t.Fatalf("expected auth_ok got %+v %v", f, err)
+	}
+	_ = conn.WriteJSON(models.Message{Content: "hi"})
+	if f, err := readFrame(t, conn); err != nil || f.Type != models.FrameError {
+		t.Fatalf("read-only post: expected error frame got %+v %v", f, err)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"src/logger"
+	"src/metrics"
+	"src/models"
+	"src/webhook"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookRepository persists incoming webhooks.
+type WebhookRepository interface {
+	CreateIncomingWebhook(ctx context.Context, h models.IncomingWebhook) error
+	ListIncomingWebhooks(ctx context.Context, roomID string) ([]models.IncomingWebhook, error)
+	GetIncomingWebhook(ctx context.Context, id string) (models.IncomingWebhook, error)
+	RevokeIncomingWebhook(ctx context.Context, id string, at time.Time) error
+}
+
+const (
+	// maxHookBody bounds incoming webhook payloads.
+	maxHookBody = 64 << 10
+	// hookSignatureSkew is how far the signed timestamp may be from the server clock.
+	hookSignatureSkew = 5 * time.Minute
+	// maxHookUsername bounds the username override, which is only a display name.
+	maxHookUsername = 64
+	// hookSubjectPrefix names incoming webhooks as senders, next to bot: service accounts.
+	hookSubjectPrefix = "webhook:"
+	// hookBuckets prefixes the per-hook rate limit buckets, which share the RateLimits limiter.
+	hookBuckets = "hooks"
+)
+
+// hookPayload is the body accepted by POST /hooks/{id}.
+type hookPayload struct {
+	Text        string           `json:"text"`
+	Username    string           `json:"username"`
+	Attachments []hookAttachment `json:"attachments"`
+}
+
+type hookAttachment struct {
+	Title     string `json:"title"`
+	TitleLink string `json:"title_link"`
+	Text      string `json:"text"`
+}
+
+// message maps the payload to a markdown message; attachments become block quotes under the text.
+func (p hookPayload) message(h models.IncomingWebhook) models.Message {
+	var b strings.Builder
+	b.WriteString(p.Text)
+	for _, a := range p.Attachments {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		var lines []string
+		switch {
+		case a.Title != "" && a.TitleLink != "":
+			lines = append(lines, "**["+a.Title+"]("+a.TitleLink+")**")
+		case a.Title != "":
+			lines = append(lines, "**"+a.Title+"**")
+		}
+		if a.Text != "" {
+			lines = append(lines, strings.Split(a.Text, "\n")...)
+		}
+		b.WriteString("> " + strings.Join(lines, "\n> "))
+	}
+	user := strings.TrimSpace(p.Username)
+	if user == "" || len(user) > maxHookUsername {
+		user = h.Name
+	}
+	return models.Message{UserID: hookSubjectPrefix + h.ID, DisplayName: user, RoomID: h.RoomID, Content: b.String(), Format: "markdown", Bot: true}
+}
+
+// handleIncomingHook posts a signed payload into the hook's room. Unknown and revoked hooks both
+// answer 404 so hook ids cannot be probed.
+func (s *Server) handleIncomingHook(w http.ResponseWriter, r *http.Request) {
+	h, err := s.hooks.GetIncomingWebhook(r.Context(), r.PathValue("id"))
+	if err != nil || h.RevokedAt != nil {
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			logger.Error("get incoming webhook", err)
+			http.Error(w, "lookup failed", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBody))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	now := s.now()
+	if err := webhook.Verify(h.Secret, r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, now, hookSignatureSkew); err != nil {
+		logger.Info("incoming webhook rejected", logger.FieldKV("hook_id", h.ID), logger.FieldKV("reason", err.Error()))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if ok, wait := s.limiter.Allow(r.Context(), hookBuckets+":hook:"+h.ID, s.hookRule, now); !ok {
+		metrics.IncRateLimited(hookBuckets, "hook")
+		tooManyRequests(w, wait)
+		return
+	}
+	var p hookPayload
+	if err := json.Unmarshal(body, &p); err != nil || (strings.TrimSpace(p.Text) == "" && len(p.Attachments) == 0) {
+		http.Error(w, "bad request (text or attachments required)", http.StatusBadRequest)
+		return
+	}
+	msg := p.message(h)
+	status, err := s.acceptMessage(r.Context(), hookSubjectPrefix+h.ID, &msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message_id": msg.MessageID, "status": status})
+}
+
+// createdHook is returned once at creation; Secret is the only time the signing secret is visible.
+type createdHook struct {
+	models.IncomingWebhook
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+func (s *Server) handleCreateHook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoomID string `json:"room_id"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "bad request (name required)", http.StatusBadRequest)
+		return
+	}
+	if req.RoomID == "" {
+		req.RoomID = models.DefaultRoomID
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		logger.Error("generate webhook secret", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	h := models.IncomingWebhook{ID: uuid.NewString(), RoomID: req.RoomID, Name: strings.TrimSpace(req.Name), Secret: secret, CreatedBy: id.Subject, CreatedAt: s.now()}
+	if err := s.hooks.CreateIncomingWebhook(r.Context(), h); err != nil {
+		logger.Error("create incoming webhook", err)
+		http.Error(w, "create failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("incoming webhook created", logger.FieldKV("hook_id", h.ID), logger.FieldKV("room_id", h.RoomID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "webhook.create", Target: h.ID, RoomID: h.RoomID, After: map[string]interface{}{"name": h.Name}})
+	writeJSON(w, http.StatusCreated, createdHook{IncomingWebhook: h, Secret: secret, URL: "/api/hooks/" + h.ID})
+}
+
+func (s *Server) handleListHooks(w http.ResponseWriter, r *http.Request) {
+	list, err := s.hooks.ListIncomingWebhooks(r.Context(), r.URL.Query().Get("room_id"))
+	if err != nil {
+		logger.Error("list incoming webhooks", err)
+		http.Error(w, "list failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleRevokeHook(w http.ResponseWriter, r *http.Request) {
+	hookID := r.PathValue("id")
+	if err := s.hooks.RevokeIncomingWebhook(r.Context(), hookID, s.now()); err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		logger.Error("revoke incoming webhook", err)
+		http.Error(w, "revoke failed", http.StatusInternalServerError)
+		return
+	}
+	id, _ := IdentityFrom(r.Context())
+	logger.Info("incoming webhook revoked", logger.FieldKV("hook_id", hookID), logger.FieldKV("actor", id.Subject))
+	s.audit(r.Context(), models.AuditEntry{Actor: id.Subject, Action: "webhook.revoke", Target: hookID})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"src/models"
+	"src/webhook"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockHooks struct {
+	hooks map[string]models.IncomingWebhook
+}
+
+func (m *mockHooks) CreateIncomingWebhook(ctx context.Context, h models.IncomingWebhook) error {
+	m.hooks[h.ID] = h
+	return nil
+}
+func (m *mockHooks) ListIncomingWebhooks(ctx context.Context, roomID string) ([]models.IncomingWebhook, error) {
+	out := []models.IncomingWebhook{}
+	for _, h := range m.hooks {
+		if roomID == "" || h.RoomID == roomID {
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+func (m *mockHooks) GetIncomingWebhook(ctx context.Context, id string) (models.IncomingWebhook, error) {
+	h, ok := m.hooks[id]
+	if !ok {
+		return h, models.ErrNotFound
+	}
+	return h, nil
+}
+func (m *mockHooks) RevokeIncomingWebhook(ctx context.Context, id string, at time.Time) error {
+	h, ok := m.hooks[id]
+	if !ok || h.RevokedAt != nil {
+		return models.ErrNotFound
+	}
+	h.RevokedAt = &at
+	m.hooks[id] = h
+	return nil
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	prod := &capturingProducer{}
+	hooks := &mockHooks{hooks: map[string]models.IncomingWebhook{}}
+	verifier := identityVerifier{"admin": {Subject: "admin", Groups: []string{"chat-admins"}}, "alice": {Subject: "alice"}}
+	srv := NewServer(prod, &mockRepo{}, verifier, nil, make(chan models.Message), 1000,
+		WithAdminGroups([]string{"chat-admins"}), WithWebhooks(hooks, 2), WithClock(func() time.Time { return now }))
+
+	if w := serve(srv, "POST", "/api/admin/webhooks", "alice", `{"name":"ci"}`); w.Code != 403 {
+		t.Fatalf("non-admin create: expected 403 got %d", w.Code)
+	}
+	w := serve(srv, "POST", "/api/admin/webhooks", "admin", `{"name":"ci","room_id":"ops"}`)
+	var created createdHook
+	_ = json.NewDecoder(w.Body).Decode(&created)
+	if w.Code != 201 || created.Secret == "" || created.URL != "/api/hooks/"+created.ID {
+		t.Fatalf("create: %d %+v", w.Code, created)
+	}
+	if w := serve(srv, "GET", "/api/admin/webhooks?room_id=ops", "admin", ""); w.Code != 200 || strings.Contains(w.Body.String(), created.Secret) {
+		t.Fatalf("list must not expose the secret: %d %s", w.Code, w.Body.String())
+	}
+
+	post := func(secret, body string, signedAt time.Time) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", created.URL, strings.NewReader(body))
+		r.Header.Set(webhook.TimestampHeader, strconv.FormatInt(signedAt.Unix(), 10))
+		r.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, signedAt, []byte(body)))
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	payload := `{"text":"build *passed*","username":"jenkins","attachments":[{"title":"#42","title_link":"https://ci.example/42","text":"all green"}]}`
+	if w := post("wrong", payload, now); w.Code != 401 {
+		t.Fatalf("bad signature: expected 401 got %d", w.Code)
+	}
+	if w := post(created.Secret, payload, now.Add(-time.Hour)); w.Code != 401 {
+		t.Fatalf("stale timestamp: expected 401 got %d", w.Code)
+	}
+	if w := post(created.Secret, `{"username":"x"}`, now); w.Code != 400 {
+		t.Fatalf("empty payload: expected 400 got %d", w.Code)
+	}
+	if w := post(created.Secret, payload, now); w.Code != 202 {
+		t.Fatalf("valid hook: expected 202 got %d %s", w.Code, w.Body.String())
+	}
+	if len(prod.msgs) != 1 {
+		t.Fatalf("expected one published message, got %d", len(prod.msgs))
+	}
+	msg := prod.msgs[0]
+	if msg.RoomID != "ops" || msg.UserID != hookSubjectPrefix+created.ID || msg.DisplayName != "jenkins" || !msg.Bot || msg.MessageID == "" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if !strings.Contains(msg.HTML, `<a href="https://ci.example/42"`) || !strings.Contains(msg.HTML, "<em>passed</em>") || !strings.Contains(msg.HTML, "all green") {
+		t.Fatalf("payload not rendered: %s", msg.HTML)
+	}
+
+	// The burst of 2 is used up by the 400 and 202 above.
+	if w := post(created.Secret, payload, now); w.Code != 429 || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("rate limit: expected 429 with Retry-After 30 got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	now = now.Add(30 * time.Second)
+	if w := post(created.Secret, `{"text":"again"}`, now); w.Code != 202 || prod.msgs[1].DisplayName != "ci" {
+		t.Fatalf("after refill: expected 202 from hook name got %d %+v", w.Code, prod.msgs)
+	}
+
+	if w := serve(srv, "DELETE", "/api/admin/webhooks/"+created.ID, "admin", ""); w.Code != 204 {
+		t.Fatalf("revoke: expected 204 got %d", w.Code)
+	}
+	now = now.Add(time.Minute)
+	if w := post(created.Secret, payload, now); w.Code != 404 {
+		t.Fatalf("revoked hook: expected 404 got %d", w.Code)
+	}
+}
+
+func TestHookPayloadMessage(t *testing.T) {
+	h := models.IncomingWebhook{ID: "h1", Name: "alerts", RoomID: "ops"}
+	msg := hookPayload{Username: strings.Repeat("x", maxHookUsername+1), Attachments: []hookAttachment{{Title: "disk", Text: "a\nb"}}}.message(h)
+	if msg.UserID != "webhook:h1" || msg.DisplayName != "alerts" || msg.Content != "> **disk**\n> a\n> b" {
+		t.Fatalf("unexpected mapping: %+v", msg)
+	}
+}
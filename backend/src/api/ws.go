@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"src/command"
+	"src/logger"
+	"src/metrics"
+	"src/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsProtocol is offered by clients next to "bearer.<token>"; the server selects it so browsers
+	// accept the handshake without the token being echoed back.
+	wsProtocol = "chatapp.v1"
+	// bearerProtocolPrefix marks the subprotocol carrying the access token.
+	bearerProtocolPrefix = "bearer."
+	// closeUnauthorized is sent when authentication fails or the session expires.
+	closeUnauthorized = 4401
+	// closeRemoved ends the connections of banned or kicked users.
+	closeRemoved = 4403
+)
+
+// wsAuthTimeout bounds how long a connection without a handshake token may take to send its
+// auth frame.
+var wsAuthTimeout = 10 * time.Second
+
+// handleWS authenticates the WebSocket either during the handshake (Sec-WebSocket-Protocol:
+// chatapp.v1, bearer.<token>) or with an auth frame sent right after it. Tokens are never read
+// from the query string since those end up in proxy access logs. The session ends when the
+// token expires unless the client sends a reauth frame with a fresh token first.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	token := bearerProtocol(r)
+	var id models.Identity
+	if token != "" {
+		var err error
+		if id, err = s.authenticate(r.Context(), token); err != nil {
+			authError(w, err)
+			return
+		}
+		if !canSubscribe(id) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if s.bannedGlobally(r.Context(), id.Subject) {
+			http.Error(w, "you are banned", http.StatusForbidden)
+			return
+		}
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("websocket upgrade failed", err)
+		return
+	}
+	conn.SetReadLimit(s.maxBody())
+	if token == "" {
+		if id, err = s.awaitAuthFrame(r.Context(), conn); err != nil {
+			logger.Info("websocket auth failed", logger.FieldKV("remote_addr", conn.RemoteAddr().String()), logger.FieldKV("reason", err.Error()))
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeUnauthorized, "unauthorized"))
+			_ = conn.Close()
+			return
+		}
+		if s.bannedGlobally(r.Context(), id.Subject) {
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeRemoved, "banned"))
+			_ = conn.Close()
+			return
+		}
+	}
+	// The handler keeps running for the life of the connection so r.Context() stays valid for
+	// publishing.
+	s.serveWS(r.Context(), conn, id, clientIP(r, s.proxyHops))
+}
+
+// bearerProtocol extracts the token from a "bearer.<token>" subprotocol offer.
+func bearerProtocol(r *http.Request) string {
+	for _, p := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(p, bearerProtocolPrefix) {
+			return strings.TrimPrefix(p, bearerProtocolPrefix)
+		}
+	}
+	return ""
+}
+
+func (s *Server) awaitAuthFrame(ctx context.Context, conn *websocket.Conn) (models.Identity, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	var f models.SessionFrame
+	if err := conn.ReadJSON(&f); err != nil {
+		return models.Identity{}, err
+	}
+	if f.Type != models.FrameAuth || f.Token == "" {
+		return models.Identity{}, errAuthFrameRequired
+	}
+	id, err := s.authenticate(ctx, f.Token)
+	if err == nil && !canSubscribe(id) {
+		err = errSubscribeDenied
+	}
+	return id, err
+}
+
+// canSubscribe reports whether id may receive the live feed. The hub broadcasts every room, so
+// room restricted service account tokens are limited to REST.
+func canSubscribe(id models.Identity) bool {
+	return id.Allows(models.ScopeRead, "") && len(id.Rooms) == 0
+}
+
+type wsError string
+
+func (e wsError) Error() string { return string(e) }
+
+const (
+	errAuthFrameRequired = wsError("first frame must be an auth frame with a token")
+	errSubscribeDenied   = wsError("token may not subscribe to the live feed")
+)
+
+// wsSession is the authenticated state of one connection.
+type wsSession struct {
+	conn   *websocket.Conn
+	id     models.Identity
+	ip     string
+	expiry *time.Timer
+	// idle fires when the session sent nothing for awayAfter (nil without automatic away).
+	idle *time.Timer
+}
+
+func (s *Server) serveWS(ctx context.Context, conn *websocket.Conn, id models.Identity, ip string) {
+	s.hub.Add(conn, id.Subject)
+	metrics.IncWSConnections()
+	defer func() { s.hub.Remove(conn); metrics.DecWSConnections() }()
+	for room, until := range s.roomBans(ctx, id.Subject) {
+		s.hub.BanFromRoom(id.Subject, room, until)
+	}
+	for blocked := range s.blockedBy(ctx, id.Subject) {
+		s.hub.Block(id.Subject, blocked)
+	}
+	s.hub.Enter(id.Subject, s.userRooms(ctx, id.Subject)...)
+
+	sess := &wsSession{conn: conn, id: id, ip: ip}
+	if !id.ExpiresAt.IsZero() {
+		sess.expiry = time.AfterFunc(time.Until(id.ExpiresAt), func() { s.expireWS(conn) })
+		defer sess.expiry.Stop()
+	}
+	if s.profiles != nil && s.awayAfter > 0 {
+		defer s.watchIdle(ctx, sess).Stop()
+	}
+	s.sendAuthOK(sess)
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			logger.Error("ws read", err)
+			return
+		}
+		s.activeWS(ctx, sess)
+		// Every frame counts against the limits; refused frames are dropped, not queued.
+		if ok, wait := s.throttle(ctx, limitWS, sess.id, sess.ip); !ok {
+			_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: "rate limit exceeded", RetryAfter: retryAfterSeconds(wait)})
+			continue
+		}
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			continue
+		}
+		switch head.Type {
+		case "":
+			var msg models.Message
+			if err := json.Unmarshal(raw, &msg); err == nil {
+				s.ingestWS(ctx, sess, msg)
+			}
+		case models.FrameReauth:
+			var f models.SessionFrame
+			_ = json.Unmarshal(raw, &f)
+			s.reauthWS(ctx, sess, f.Token)
+		case models.FrameActivity:
+			// Recorded above.
+		default:
+			_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: "unknown frame type " + head.Type})
+		}
+	}
+}
+
+func (s *Server) sendAuthOK(sess *wsSession) {
+	f := models.SessionFrame{Type: models.FrameAuthOK}
+	if !sess.id.ExpiresAt.IsZero() {
+		exp := sess.id.ExpiresAt.UTC()
+		f.ExpiresAt = &exp
+	}
+	_ = s.hub.Send(sess.conn, f)
+}
+
+// reauthWS swaps in a fresh token for the same subject and pushes the session expiry out.
+// A rejected token leaves the current session (and its expiry) untouched.
+func (s *Server) reauthWS(ctx context.Context, sess *wsSession, token string) {
+	id, err := s.authenticate(ctx, token)
+	if err == nil && id.Subject != sess.id.Subject {
+		err = wsError("reauth token belongs to another subject")
+	}
+	if err == nil && !canSubscribe(id) {
+		err = errSubscribeDenied
+	}
+	if err != nil {
+		logger.Info("websocket reauth rejected", logger.FieldKV("sub", sess.id.Subject), logger.FieldKV("reason", err.Error()))
+		_ = s.hub.Send(sess.conn, models.SessionFrame{Type: models.FrameError, Error: "reauth rejected"})
+		return
+	}
+	sess.id = id
+	if sess.expiry != nil && !id.ExpiresAt.IsZero() {
+		sess.expiry.Reset(time.Until(id.ExpiresAt))
+	}
+	s.sendAuthOK(sess)
+}
+
+// expireWS tells the client its token expired and closes the connection.
+func (s *Server) expireWS(conn *websocket.Conn) {
+	metrics.IncWSSessionsExpired()
+	_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameSessionExpired})
+	_ = s.hub.Close(conn, closeUnauthorized, "token expired")
+	_ = conn.Close()
+}
+
+// ingestWS validates and publishes a chat message received on the socket.
+func (s *Server) ingestWS(ctx context.Context, sess *wsSession, msg models.Message) {
+	conn := sess.conn
+	if msg.RoomID == "" {
+		msg.RoomID = models.DefaultRoomID
+	}
+	if !sess.id.Allows(models.ScopePost, msg.RoomID) {
+		_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: "token may not post"})
+		return
+	}
+	stampAuthor(&msg, sess.id)
+	if name, args, ok := command.Parse(msg.Content); ok {
+		// Only the invoking connection sees the reply; a message the command posts is broadcast.
+		if out := s.execCommand(ctx, sess.id, msg, name, args); out.reply.Text != "" {
+			_ = s.hub.Send(conn, out.reply)
+		}
+		return
+	}
+	if reason := s.restriction(ctx, sess.id, msg.RoomID); reason != "" {
+		_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: reason})
+		return
+	}
+	if msg.Encrypted != nil {
+		if _, err := s.acceptEncrypted(ctx, sess.id, &msg); err != nil {
+			_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: err.Error()})
+		}
+		return
+	}
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.NewString()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now().UTC()
+	}
+	if len(msg.Content) > s.maxMsgLen {
+		return
+	}
+	if err := renderContent(&msg); err != nil {
+		return
+	}
+	if s.validator != nil {
+		if err := s.validator.Validate(msg); err != nil {
+			return
+		}
+	}
+	held, err := s.moderate(ctx, sess.id.Subject, &msg)
+	if err != nil {
+		_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameError, Error: err.Error()})
+		return
+	}
+	if held {
+		_ = s.hub.Send(conn, models.SessionFrame{Type: models.FrameHeld, MessageID: msg.MessageID})
+		return
+	}
+	if err := s.producer.Publish(ctx, msg); err != nil {
+		logger.Error("publish fail", err)
+		// Fallback: directly broadcast and persist so connected clients aren't blocked by Kafka
+		s.hub.BroadcastExcept(msg, conn)
+		if s.repo != nil {
+			if perr := s.repo.InsertMessage(context.Background(), msg); perr != nil {
+				logger.Error("fallback persist fail", perr)
+			}
+		}
+	}
+	metrics.IncMsgIngested()
+}
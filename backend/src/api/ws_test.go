@@ -0,0 +1,203 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"src/config"
+	"src/models"
+	"src/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type wsFixture struct {
+	iss       *oidctest.Issuer
+	ts        *httptest.Server
+	url       string
+	broadcast chan models.Message
+}
+
+func newWSFixture(t *testing.T) *wsFixture {
+	t.Helper()
+	iss := oidctest.New(config.Audience)
+	broadcast := make(chan models.Message, 1)
+	ts := httptest.NewServer(NewServer(&mockProducer{}, &mockRepo{}, signedTokenVerifier(t, iss), nil, broadcast, 100))
+	t.Cleanup(func() { ts.Close(); iss.Close() })
+	return &wsFixture{iss: iss, ts: ts, url: "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/ws", broadcast: broadcast}
+}
+
+// dial connects offering the token as a subprotocol (empty token: no subprotocols at all).
+func (f *wsFixture) dial(t *testing.T, token string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	return f.dialHeader(t, token, nil)
+}
+
+func (f *wsFixture) dialHeader(t *testing.T, token string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	d := *websocket.DefaultDialer
+	if token != "" {
+		d.Subprotocols = []string{wsProtocol, bearerProtocolPrefix + token}
+	}
+	return d.Dial(f.url, header)
+}
+
+// readFrame returns the next session frame, skipping chat messages.
+func readFrame(t *testing.T, conn *websocket.Conn) (models.SessionFrame, error) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var f models.SessionFrame
+		if err := conn.ReadJSON(&f); err != nil {
+			return f, err
+		}
+		if f.Type != "" {
+			return f, nil
+		}
+	}
+}
+
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	_, err := readFrame(t, conn)
+	if !websocket.IsCloseError(err, code) {
+		t.Fatalf("expected close %d got %v", code, err)
+	}
+}
+
+func TestWebSocketHandshakeAuth(t *testing.T) {
+	f := newWSFixture(t)
+	for name, token := range map[string]string{
+		"expired token":  signToken(f.iss, "u1", nil, -time.Minute),
+		"wrong audience": f.iss.Token("u1", oidctest.Claims{"aud": "someone-else"}),
+	} {
+		conn, resp, err := f.dial(t, token)
+		if err == nil {
+			conn.Close()
+			t.Fatalf("%s: expected handshake to fail", name)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401 got %v", name, resp)
+		}
+	}
+
+	conn, resp, err := f.dial(t, signToken(f.iss, "u1", nil, time.Hour))
+	if err != nil {
+		t.Fatalf("dial with valid token: %v", err)
+	}
+	defer conn.Close()
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != wsProtocol {
+		t.Fatalf("expected selected subprotocol %q got %q", wsProtocol, got)
+	}
+	ok, err := readFrame(t, conn)
+	if err != nil || ok.Type != models.FrameAuthOK || ok.ExpiresAt == nil {
+		t.Fatalf("expected auth_ok with expiry got %+v (%v)", ok, err)
+	}
+	f.broadcast <- models.Message{MessageID: "m1", Content: "hi"}
+	var msg models.Message
+	if err := conn.ReadJSON(&msg); err != nil || msg.MessageID != "m1" {
+		t.Fatalf("expected broadcast m1 got %+v (%v)", msg, err)
+	}
+}
+
+func TestWebSocketFirstFrameAuth(t *testing.T) {
+	f := newWSFixture(t)
+	orig := wsAuthTimeout
+	wsAuthTimeout = 200 * time.Millisecond
+	defer func() { wsAuthTimeout = orig }()
+
+	conn, _, err := f.dial(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(models.SessionFrame{Type: models.FrameAuth, Token: signToken(f.iss, "u1", nil, time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := readFrame(t, conn); err != nil || ok.Type != models.FrameAuthOK {
+		t.Fatalf("expected auth_ok got %+v (%v)", ok, err)
+	}
+
+	rejected := map[string]func(*websocket.Conn){
+		"bad token": func(c *websocket.Conn) {
+			_ = c.WriteJSON(models.SessionFrame{Type: models.FrameAuth, Token: "garbage"})
+		},
+		"message before auth": func(c *websocket.Conn) {
+			_ = c.WriteJSON(models.Message{Content: "hi"})
+		},
+		"no auth frame": func(c *websocket.Conn) {},
+	}
+	for name, send := range rejected {
+		t.Run(name, func(t *testing.T) {
+			c, _, err := f.dial(t, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			send(c)
+			expectClose(t, c, closeUnauthorized)
+		})
+	}
+
+	t.Run("query token ignored", func(t *testing.T) {
+		c, _, err := websocket.DefaultDialer.Dial(f.url+"?token="+signToken(f.iss, "u1", nil, time.Hour), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		expectClose(t, c, closeUnauthorized)
+	})
+}
+
+func TestWebSocketSessionExpiryAndReauth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a token to expire")
+	}
+	f := newWSFixture(t)
+	shortLived := func(sub string) string { return signToken(f.iss, sub, nil, 2*time.Second) }
+
+	expiring, _, err := f.dial(t, shortLived("u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expiring.Close()
+	renewed, _, err := f.dial(t, shortLived("u2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer renewed.Close()
+	for _, c := range []*websocket.Conn{expiring, renewed} {
+		if ok, err := readFrame(t, c); err != nil || ok.Type != models.FrameAuthOK {
+			t.Fatalf("expected auth_ok got %+v (%v)", ok, err)
+		}
+	}
+
+	if err := renewed.WriteJSON(models.SessionFrame{Type: models.FrameReauth, Token: signToken(f.iss, "someone-else", nil, time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if fr, err := readFrame(t, renewed); err != nil || fr.Type != models.FrameError {
+		t.Fatalf("expected reauth for another subject to be rejected, got %+v (%v)", fr, err)
+	}
+	if err := renewed.WriteJSON(models.SessionFrame{Type: models.FrameReauth, Token: signToken(f.iss, "u2", nil, time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	fr, err := readFrame(t, renewed)
+	if err != nil || fr.Type != models.FrameAuthOK || fr.ExpiresAt == nil || time.Until(*fr.ExpiresAt) < 30*time.Minute {
+		t.Fatalf("expected auth_ok with extended expiry got %+v (%v)", fr, err)
+	}
+
+	if fr, err := readFrame(t, expiring); err != nil || fr.Type != models.FrameSessionExpired {
+		t.Fatalf("expected session_expired got %+v (%v)", fr, err)
+	}
+	expectClose(t, expiring, closeUnauthorized)
+
+	// The renewed session outlives the original token.
+	f.broadcast <- models.Message{MessageID: "after-expiry"}
+	_ = renewed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg models.Message
+	if err := renewed.ReadJSON(&msg); err != nil || msg.MessageID != "after-expiry" {
+		t.Fatalf("renewed session dropped: %+v (%v)", msg, err)
+	}
+}
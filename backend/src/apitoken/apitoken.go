@@ -0,0 +1,61 @@
+// Package apitoken generates and parses long-lived API tokens for service accounts.
+//
+// A token looks like "chat_<id>_<secret>": id selects the stored record and secret is 32 random
+// bytes. Only the SHA-256 of the secret is stored; the plaintext is shown once at creation.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix marks API tokens so they can be told apart from OIDC JWTs without a lookup.
+const Prefix = "chat_"
+
+// Generate returns a new plaintext token, its id and the hash to store.
+func Generate() (token, id, hash string, err error) {
+	idBytes := make([]byte, 9)
+	secret := make([]byte, 32)
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	return Prefix + id + "_" + s, id, Hash(s), nil
+}
+
+// Parse splits a token into id and secret; ok is false for anything that is not an API token.
+func Parse(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, Prefix) {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(token[len(Prefix):], "_")
+	if !ok || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// Hash is the stored form of a secret. Secrets are high entropy, so a plain SHA-256 suffices.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches compares secret against a stored hash in constant time.
+func Matches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(secret)), []byte(hash)) == 1
+}
+
+// IsToken reports whether raw has the API token shape.
+func IsToken(raw string) bool {
+	_, _, ok := Parse(raw)
+	return ok
+}
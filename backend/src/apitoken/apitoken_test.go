@@ -0,0 +1,38 @@
+package apitoken
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateParseMatch(t *testing.T) {
+	tok, id, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tok, Prefix) || strings.Contains(hash, tok) {
+		t.Fatalf("unexpected token %q / hash %q", tok, hash)
+	}
+	gotID, secret, ok := Parse(tok)
+	if !ok || gotID != id {
+		t.Fatalf("parse %q: id=%q ok=%v", tok, gotID, ok)
+	}
+	if !Matches(secret, hash) {
+		t.Fatal("secret does not match its hash")
+	}
+	if Matches(secret+"x", hash) {
+		t.Fatal("tampered secret matched")
+	}
+	other, _, _, _ := Generate()
+	if other == tok {
+		t.Fatal("tokens are not unique")
+	}
+}
+
+func TestParseRejectsNonTokens(t *testing.T) {
+	for _, raw := range []string{"", "eyJhbGciOi.x.y", "chat_", "chat_abc", "chat__secret", "chat_id_", "xchat_id_s"} {
+		if IsToken(raw) {
+			t.Errorf("expected %q not to parse", raw)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package command parses slash commands and dispatches them to registered handlers.
+//
+// A message is a command when its content is "/<name>" optionally followed by whitespace and
+// arguments, with name matching [a-z][a-z0-9_-]*. Anything else that happens to start with a
+// slash (e.g. "/usr/bin" or "/ shrug") is ordinary text.
+package command
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"src/models"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// ValidName reports whether name can be invoked as a command.
+func ValidName(name string) bool { return namePattern.MatchString(name) }
+
+// Parse splits content into a command name and its trimmed arguments; ok is false when content
+// is not a command.
+func Parse(content string) (name, args string, ok bool) {
+	if !strings.HasPrefix(content, "/") {
+		return "", "", false
+	}
+	name = content[1:]
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, args = name[:i], name[i:]
+	}
+	if !ValidName(name) {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+// Invocation is one use of a command. Message is the message that carried it, with the author
+// and room already resolved.
+type Invocation struct {
+	Name    string
+	Args    string
+	Caller  models.Identity
+	Role    models.Role
+	Message models.Message
+}
+
+// Result is what a command produced. Message, when set, is published to the room like any other
+// message; Reply is shown only to the invoking connection.
+type Result struct {
+	Message *models.Message
+	Reply   string
+}
+
+// Handler runs a command.
+type Handler func(ctx context.Context, inv Invocation) (Result, error)
+
+// UsageError is returned by handlers for bad arguments; its text is shown to the caller.
+type UsageError string
+
+func (e UsageError) Error() string { return string(e) }
+
+// Command describes a registered command. Callers need at least MinRole in the room.
+type Command struct {
+	Name        string      `json:"name"`
+	Usage       string      `json:"usage,omitempty"`
+	Description string      `json:"description,omitempty"`
+	MinRole     models.Role `json:"min_role"`
+	Run         Handler     `json:"-"`
+}
+
+// Allowed reports whether role may run c.
+func (c Command) Allowed(role models.Role) bool { return role.Rank() >= c.MinRole.Rank() }
+
+// ErrDuplicate is returned when registering a name twice.
+var ErrDuplicate = errors.New("command already registered")
+
+// Registry holds the built-in commands. It is populated at startup and read-only afterwards.
+type Registry struct {
+	cmds map[string]Command
+}
+
+func NewRegistry() *Registry { return &Registry{cmds: map[string]Command{}} }
+
+// Register adds c; MinRole defaults to user.
+func (r *Registry) Register(c Command) error {
+	if !ValidName(c.Name) || c.Run == nil {
+		return errors.New("invalid command " + c.Name)
+	}
+	if _, ok := r.cmds[c.Name]; ok {
+		return ErrDuplicate
+	}
+	if c.MinRole == "" {
+		c.MinRole = models.RoleUser
+	}
+	r.cmds[c.Name] = c
+	return nil
+}
+
+// Lookup returns the command registered under name.
+func (r *Registry) Lookup(name string) (Command, bool) {
+	c, ok := r.cmds[name]
+	return c, ok
+}
+
+// List returns the registered commands sorted by name.
+func (r *Registry) List() []Command {
+	out := make([]Command, 0, len(r.cmds))
+	for _, c := range r.cmds {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
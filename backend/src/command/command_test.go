@@ -0,0 +1,117 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"src/models"
+	"src/webhook"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in, name, args string
+		ok             bool
+	}{
+		{"/me waves", "me", "waves", true},
+		{"/topic   Release  day ", "topic", "Release  day", true},
+		{"/help", "help", "", true},
+		{"/mute\tbob 10m", "mute", "bob 10m", true},
+		{"hello /me", "", "", false},
+		{"/usr/bin is a path", "", "", false},
+		{"/ shrug", "", "", false},
+		{"//comment", "", "", false},
+		{"/Me waves", "", "", false},
+	}
+	for _, tc := range cases {
+		name, args, ok := Parse(tc.in)
+		if name != tc.name || args != tc.args || ok != tc.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v; want %q, %q, %v", tc.in, name, args, ok, tc.name, tc.args, tc.ok)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	noop := func(context.Context, Invocation) (Result, error) { return Result{}, nil }
+	if err := r.Register(Command{Name: "topic", MinRole: models.RoleModerator, Run: noop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(Command{Name: "me", Run: noop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(Command{Name: "me", Run: noop}); err != ErrDuplicate {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	if err := r.Register(Command{Name: "Bad Name", Run: noop}); err == nil {
+		t.Fatalf("expected invalid name to be rejected")
+	}
+	me, ok := r.Lookup("me")
+	if !ok || me.MinRole != models.RoleUser || !me.Allowed(models.RoleUser) {
+		t.Fatalf("me should default to the user role: %+v", me)
+	}
+	topic, _ := r.Lookup("topic")
+	if topic.Allowed(models.RoleUser) || !topic.Allowed(models.RoleOwner) {
+		t.Fatalf("topic should require moderator")
+	}
+	if list := r.List(); len(list) != 2 || list[0].Name != "me" || list[1].Name != "topic" {
+		t.Fatalf("list not sorted: %+v", list)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	var got RemoteRequest
+	answer := `{"response_type":"ephemeral","text":"only you"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("s3cret", r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		_ = json.Unmarshal(body, &got)
+		io.WriteString(w, answer)
+	}))
+	defer srv.Close()
+
+	run := Remote(srv.URL, "s3cret", srv.Client())
+	inv := Invocation{Name: "deploy", Args: "api prod", Role: models.RoleUser, Message: models.Message{UserID: "alice", RoomID: "ops"}}
+	res, err := run(context.Background(), inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reply != "only you" || res.Message != nil {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if got.Command != "deploy" || got.Text != "api prod" || got.UserID != "alice" || got.RoomID != "ops" {
+		t.Fatalf("unexpected request %+v", got)
+	}
+
+	answer = `{"response_type":"in_channel","text":"deploying **api**"}`
+	res, err = run(context.Background(), inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Message == nil || res.Message.UserID != "command:deploy" || res.Message.DisplayName != "deploy" || !res.Message.Bot || res.Message.RoomID != "ops" {
+		t.Fatalf("in_channel answer should become a bot message: %+v", res)
+	}
+
+	if _, err := Remote(srv.URL, "wrong", srv.Client())(context.Background(), inv); err == nil {
+		t.Fatalf("expected an error for a rejected request")
+	}
+}
+
+func TestRemoteEmptyAnswer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	res, err := Remote(srv.URL, "s", srv.Client())(context.Background(), Invocation{Name: "ping"})
+	if err != nil || res.Reply != "" || res.Message != nil {
+		t.Fatalf("empty answer should be a silent success: %+v %v", res, err)
+	}
+}
@@ -0,0 +1,84 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"src/models"
+	"src/webhook"
+)
+
+// Response types an external command may answer with.
+const (
+	ResponseEphemeral = "ephemeral"
+	ResponseInChannel = "in_channel"
+)
+
+// RemoteRequest is the signed JSON body POSTed to an external command's URL.
+type RemoteRequest struct {
+	Command   string    `json:"command"`
+	Text      string    `json:"text"`
+	UserID    string    `json:"user_id"`
+	RoomID    string    `json:"room_id"`
+	Role      string    `json:"role"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// RemoteResponse is what an external command answers. An empty body means "no reply".
+type RemoteResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// maxRemoteResponse bounds how much of an endpoint's answer is read.
+const maxRemoteResponse = 64 << 10
+
+// Remote returns a handler that forwards invocations to url, signed with secret like outgoing
+// webhooks. An "in_channel" answer is posted to the room as a bot message authored by the
+// command; anything else is an ephemeral reply. client should carry a short timeout since the
+// caller waits for the answer.
+func Remote(url, secret string, client *http.Client) Handler {
+	return func(ctx context.Context, inv Invocation) (Result, error) {
+		body, err := json.Marshal(RemoteRequest{Command: inv.Name, Text: inv.Args, UserID: inv.Message.UserID, RoomID: inv.Message.RoomID, Role: string(inv.Role), Timestamp: inv.Message.Timestamp})
+		if err != nil {
+			return Result{}, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return Result{}, err
+		}
+		ts := time.Now()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhook.TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, ts, body))
+		resp, err := client.Do(req)
+		if err != nil {
+			return Result{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return Result{}, fmt.Errorf("command endpoint returned %d", resp.StatusCode)
+		}
+		raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteResponse))
+		if err != nil {
+			return Result{}, err
+		}
+		var out RemoteResponse
+		if len(bytes.TrimSpace(raw)) == 0 {
+			return Result{}, nil
+		}
+		if err := json.Unmarshal(raw, &out); err != nil {
+			return Result{}, fmt.Errorf("decode command response: %w", err)
+		}
+		if out.ResponseType != ResponseInChannel || out.Text == "" {
+			return Result{Reply: out.Text}, nil
+		}
+		return Result{Message: &models.Message{UserID: "command:" + inv.Name, DisplayName: inv.Name, RoomID: inv.Message.RoomID, Content: out.Text, Format: "markdown", Bot: true}}, nil
+	}
+}
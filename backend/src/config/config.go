@@ -1,16 +1,26 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 var (
 	KafkaBroker = GetEnv("KAFKA_BROKER", "kafka:9092")
 	Topic       = GetEnv("KAFKA_TOPIC", "chat-messages")
 	DLQTopic    = GetEnv("KAFKA_DLQ_TOPIC", "chat-messages-dlq")
-	DexIssuer   = GetEnv("DEX_ISSUER_URL", "http://dex:5556/dex")
+	// ControlTopic fans out moderation events (bans, kicks) to every replica.
+	ControlTopic = GetEnv("KAFKA_CONTROL_TOPIC", "chat-control")
+	// AuditTopic, when set, receives a copy of every audit log entry.
+	AuditTopic = GetEnv("KAFKA_AUDIT_TOPIC", "")
+	DexIssuer  = GetEnv("DEX_ISSUER_URL", "http://dex:5556/dex")
 	// DexIssuerDialOverride allows dialing a different host:port while preserving the issuer Host header.
 	// Example: ingress-nginx-controller.ingress-nginx.svc.cluster.local:80
 	DexIssuerDialOverride = GetEnv("DEX_ISSUER_DIAL_ADDRESS", "")
 	// Internal dial target used automatically if issuer host resolves only to loopback and no explicit override is set.
+	// Set to an empty value to talk to a loopback issuer directly.
 	DexIssuerInternalDial = GetEnv("DEX_ISSUER_INTERNAL_DIAL", "ingress-nginx-controller.ingress-nginx.svc.cluster.local:80")
 	// Internal fallback issuer (cluster-internal service) used if primary issuer discovery fails.
 	InternalDexIssuer = GetEnv("DEX_INTERNAL_ISSUER_URL", "http://dex:5556/dex")
@@ -27,6 +37,74 @@ var (
 	ApiPort       = GetEnv("API_PORT", "8080")
 	MongoURI      = GetEnv("MONGO_URI", "mongodb://mongodb:27017")
 	MessageMaxLen = GetEnv("MESSAGE_MAX_LENGTH", "1000")
+	// Total decoded ciphertext bytes an end-to-end encrypted message may carry.
+	EncryptedMaxBytes = GetEnv("ENCRYPTED_MESSAGE_MAX_BYTES", "262144")
+	// JSON array of trusted issuers ({"issuer","client_id","audiences","groups_claim","name_claim","email_claim","optional"}).
+	// Empty trusts only DEX_ISSUER_URL with DEX_CLIENT_ID / DEX_AUDIENCE / ROLE_CLAIM.
+	OIDCTrustedIssuers = GetEnv("OIDC_TRUSTED_ISSUERS", "")
+	// Period between OIDC re-discoveries (fresh JWKS / metadata) once auth is ready.
+	OIDCRefreshInterval = GetEnv("OIDC_REFRESH_INTERVAL", "1h")
+	// Pause before retrying discovery after a full backoff cycle failed (auth stays degraded meanwhile).
+	OIDCRetryInterval = GetEnv("OIDC_RETRY_INTERVAL", "30s")
+	// Comma separated origins allowed to open WebSockets besides the API's own origin,
+	// e.g. "https://chat.example.com,https://*.example.com" ("*" allows any origin).
+	WSAllowedOrigins = GetEnv("WS_ALLOWED_ORIGINS", "")
+	// Development mode: also allow any localhost / loopback origin.
+	WSAllowLocalhost = GetEnv("WS_ALLOW_LOCALHOST", "false")
+	// Requests a minute each incoming webhook may post (bursts up to the same number).
+	WebhookRateLimit = GetEnv("WEBHOOK_RATE_LIMIT", "30")
+	// Kafka consumer group of the outgoing webhook dispatcher (one group across replicas).
+	WebhookConsumerGroup = GetEnv("WEBHOOK_CONSUMER_GROUP", "chatapp-webhooks")
+	// Outgoing webhook poll interval, attempts before parking, backoff bounds and per-request timeout.
+	WebhookDispatchInterval = GetEnv("WEBHOOK_DISPATCH_INTERVAL", "5s")
+	WebhookMaxAttempts      = GetEnv("WEBHOOK_MAX_ATTEMPTS", "8")
+	WebhookBaseBackoff      = GetEnv("WEBHOOK_BASE_BACKOFF", "30s")
+	WebhookMaxBackoff       = GetEnv("WEBHOOK_MAX_BACKOFF", "1h")
+	WebhookTimeout          = GetEnv("WEBHOOK_TIMEOUT", "10s")
+	// How long an externally registered slash command may take to answer (the caller waits).
+	CommandTimeout = GetEnv("COMMAND_TIMEOUT", "3s")
+	// Ingestion rate limits as "<rate>/<s|m|h>[:<burst>]" ("off" disables), per authenticated user and
+	// per client IP, for POST /api/messages and for frames read from WebSockets.
+	RateLimitMessagesUser = GetEnv("RATE_LIMIT_MESSAGES_USER", "60/m")
+	RateLimitMessagesIP   = GetEnv("RATE_LIMIT_MESSAGES_IP", "300/m")
+	RateLimitWSUser       = GetEnv("RATE_LIMIT_WS_USER", "60/m")
+	RateLimitWSIP         = GetEnv("RATE_LIMIT_WS_IP", "300/m")
+	// "memory" keeps buckets per replica; "mongo" shares them across replicas.
+	RateLimitBackend = GetEnv("RATE_LIMIT_BACKEND", "memory")
+	// Trusted proxies in front of the API appending to X-Forwarded-For (0 = use the peer address).
+	RateLimitProxyHops = GetEnv("RATE_LIMIT_PROXY_HOPS", "0")
+	// How often each replica reloads the moderation configuration from Mongo.
+	ModerationReloadInterval = GetEnv("MODERATION_RELOAD_INTERVAL", "30s")
+	// Reports after which a message is hidden until an admin reviews it (0 = never hide).
+	ReportHideThreshold = GetEnv("REPORT_HIDE_THRESHOLD", "5")
+	// Token claim holding the caller's groups, used for role mapping.
+	RoleClaim = GetEnv("ROLE_CLAIM", "groups")
+	// Comma separated groups mapped to the global moderator role (moderate any room).
+	ModeratorGroups = GetEnv("MODERATOR_GROUPS", "chat-moderators")
+	// Comma separated groups mapped to the global admin role (admin endpoints, exports, legal holds).
+	AdminGroups = GetEnv("ADMIN_GROUPS", "chat-admins")
+	// How often the scheduler polls for due scheduled messages.
+	SchedulerInterval = GetEnv("SCHEDULER_INTERVAL", "5s")
+	// Furthest ahead a message may be scheduled.
+	ScheduleMaxAhead = GetEnv("SCHEDULE_MAX_AHEAD", "720h")
+	// Global message retention period (e.g. "2160h"); empty or 0 keeps messages forever.
+	RetentionPeriod = GetEnv("RETENTION_PERIOD", "")
+	// How often the retention job runs and how many messages each delete batch removes.
+	RetentionInterval  = GetEnv("RETENTION_INTERVAL", "1h")
+	RetentionBatchSize = GetEnv("RETENTION_BATCH_SIZE", "500")
+	// When "true" the retention job only counts what it would delete.
+	RetentionDryRun = GetEnv("RETENTION_DRY_RUN", "false")
+	// File of master keys ("<id>:<base64 32 bytes>" per line, last active) for encrypting message
+	// content at rest; empty stores content in plain text.
+	MessageEncryptionKeyFile = GetEnv("MESSAGE_ENCRYPTION_KEY_FILE", "")
+	// How often room keys are rewrapped and messages re-encrypted under current keys, and how many
+	// messages each batch rewrites.
+	MessageEncryptionRekeyInterval = GetEnv("MESSAGE_ENCRYPTION_REKEY_INTERVAL", "10m")
+	MessageEncryptionRekeyBatch    = GetEnv("MESSAGE_ENCRYPTION_REKEY_BATCH", "500")
+	// Room keys older than this are rotated automatically; empty or 0 only rotates on request.
+	MessageEncryptionKeyMaxAge = GetEnv("MESSAGE_ENCRYPTION_KEY_MAX_AGE", "")
+	// WebSocket inactivity after which users are set away automatically; 0 never does.
+	StatusAwayAfter = GetEnv("STATUS_AWAY_AFTER", "10m")
 )
 
 // GetEnv returns the value of the environment variable or a default value
@@ -36,3 +114,30 @@ func GetEnv(key, defaultVal string) string {
 	}
 	return defaultVal
 }
+
+// SplitList splits a comma separated value, trimming blanks and dropping empty entries.
+func SplitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ParseInt parses v as a positive int, returning fallback otherwise.
+func ParseInt(v string, fallback int) int {
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// ParseDuration parses v as a time.Duration, returning fallback when empty, invalid or negative.
+func ParseDuration(v string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return d
+	}
+	return fallback
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestGetEnv(t *testing.T) {
@@ -20,3 +21,22 @@ func TestGetEnv(t *testing.T) {
 		t.Errorf("Expected 'default_value', but got '%s'", value)
 	}
 }
+
+func TestSplitList(t *testing.T) {
+	got := SplitList(" a, ,b ,")
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("unexpected split result %v", got)
+	}
+	if SplitList("") != nil {
+		t.Errorf("expected nil for empty input")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	if d := ParseDuration("90s", time.Second); d != 90*time.Second {
+		t.Errorf("expected 90s got %s", d)
+	}
+	if d := ParseDuration("bogus", time.Second); d != time.Second {
+		t.Errorf("expected fallback got %s", d)
+	}
+}
@@ -0,0 +1,274 @@
+// Package dispatcher delivers chat events to outgoing webhook subscriptions.
+//
+// Events are turned into one queued delivery per matching subscription as they are consumed from
+// Kafka. A polling loop then POSTs due deliveries, signed like incoming webhooks (see package
+// webhook). Failed attempts are retried with exponential backoff; deliveries that keep failing, or
+// that the receiver rejects outright, are parked until an admin requeues them.
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"src/logger"
+	"src/metrics"
+	"src/models"
+	"src/webhook"
+
+	"github.com/google/uuid"
+)
+
+// Store is the persistence the dispatcher needs (implemented by store.SubscriptionAdapter).
+type Store interface {
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	CreateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error
+	DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, id string, a models.DeliveryAttempt, status string, next time.Time) error
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+}
+
+// Config controls delivery polling and retries.
+type Config struct {
+	// Interval is the poll period for due deliveries.
+	Interval time.Duration
+	// MaxAttempts is the number of attempts before a delivery is parked.
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failure; it doubles per attempt up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Timeout bounds each HTTP request.
+	Timeout time.Duration
+}
+
+// Headers sent with every delivery besides the signature headers.
+const (
+	EventHeader    = "X-Chatapp-Event"
+	DeliveryHeader = "X-Chatapp-Delivery"
+)
+
+const leaseName = "webhook-dispatcher"
+
+// Dispatcher queues and delivers webhook events. Every replica queues the events it consumes;
+// only the replica holding the lease sends.
+type Dispatcher struct {
+	store  Store
+	cfg    Config
+	holder string
+	client *http.Client
+	now    func() time.Time
+	batch  int
+
+	mu       sync.Mutex
+	subs     []models.WebhookSubscription
+	subsAt   time.Time
+	subsByID map[string]models.WebhookSubscription
+}
+
+// New creates a dispatcher identified by holder (unique per replica). client may be nil.
+func New(st Store, cfg Config, holder string, client *http.Client) *Dispatcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = 30 * time.Second
+	}
+	if cfg.MaxBackoff < cfg.BaseBackoff {
+		cfg.MaxBackoff = max(time.Hour, cfg.BaseBackoff)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &Dispatcher{store: st, cfg: cfg, holder: holder, client: client, now: func() time.Time { return time.Now().UTC() }, batch: 100}
+}
+
+// MessageCreated is the event for a newly published chat message.
+func MessageCreated(msg models.Message) models.HookEvent {
+	return models.HookEvent{ID: msg.MessageID, Type: models.HookEventMessageCreated, RoomID: msg.RoomID, Timestamp: msg.Timestamp, Data: msg}
+}
+
+// MemberJoined is the event for a user added to a room.
+func MemberJoined(m models.RoomMember) models.HookEvent {
+	return models.HookEvent{ID: uuid.NewString(), Type: models.HookEventMemberJoined, RoomID: m.RoomID, Timestamp: m.AddedAt, Data: m}
+}
+
+// Enqueue queues ev for every subscription that matches it.
+func (d *Dispatcher) Enqueue(ctx context.Context, ev models.HookEvent) error {
+	subs, err := d.subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, s := range subs {
+		if !s.Matches(ev.Type, ev.RoomID) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(ev); err != nil {
+				return err
+			}
+		}
+		now := d.now()
+		del := models.WebhookDelivery{ID: uuid.NewString(), SubscriptionID: s.ID, EventID: ev.Type + ":" + ev.ID, EventType: ev.Type,
+			Payload: string(payload), Status: models.DeliveryPending, Attempts: []models.DeliveryAttempt{}, NextAttemptAt: now, CreatedAt: now}
+		if err := d.store.CreateWebhookDelivery(ctx, del); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscriptions returns the subscription list, re-read from the store at most once per interval.
+func (d *Dispatcher) subscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subsByID != nil && d.now().Sub(d.subsAt) < d.cfg.Interval {
+		return d.subs, nil
+	}
+	subs, err := d.store.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.subs, d.subsAt, d.subsByID = subs, d.now(), make(map[string]models.WebhookSubscription, len(subs))
+	for _, s := range subs {
+		d.subsByID[s.ID] = s
+	}
+	return subs, nil
+}
+
+func (d *Dispatcher) subscription(ctx context.Context, id string) (models.WebhookSubscription, bool, error) {
+	if _, err := d.subscriptions(ctx); err != nil {
+		return models.WebhookSubscription{}, false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.subsByID[id]
+	return s, ok, nil
+}
+
+// Run delivers due deliveries every interval until ctx is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	logger.Info("webhook dispatcher started", logger.FieldKV("holder", d.holder), logger.FieldKV("interval", d.cfg.Interval.String()))
+	t := time.NewTicker(d.cfg.Interval)
+	defer t.Stop()
+	for {
+		if _, err := d.Tick(ctx); err != nil {
+			logger.Error("webhook dispatcher tick", err)
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("webhook dispatcher stopped")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick attempts every due delivery once if this replica holds the lease. It returns the number
+// delivered successfully.
+func (d *Dispatcher) Tick(ctx context.Context) (int, error) {
+	now := d.now()
+	// The lease outlives a pass of slow receivers so deliveries are not sent twice concurrently.
+	leader, err := d.store.AcquireLease(ctx, leaseName, d.holder, now, 2*d.cfg.Interval+d.cfg.Timeout*time.Duration(d.batch))
+	if err != nil || !leader {
+		return 0, err
+	}
+	due, err := d.store.DueWebhookDeliveries(ctx, now, d.batch)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	for _, del := range due {
+		if d.attempt(ctx, del) {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// attempt sends one delivery and records the outcome.
+func (d *Dispatcher) attempt(ctx context.Context, del models.WebhookDelivery) bool {
+	start := d.now()
+	a := models.DeliveryAttempt{At: start}
+	sub, ok, err := d.subscription(ctx, del.SubscriptionID)
+	if err != nil {
+		logger.Error("webhook subscription lookup", err, logger.FieldKV("delivery_id", del.ID))
+		return false
+	}
+	status, next := models.DeliveryParked, start
+	switch {
+	case !ok || sub.DisabledAt != nil:
+		a.Error = "subscription disabled"
+	default:
+		a.StatusCode, err = d.post(ctx, sub, del, start)
+		a.DurationMS = d.now().Sub(start).Milliseconds()
+		switch {
+		case err == nil:
+			status = models.DeliveryDelivered
+		case permanent(a.StatusCode):
+			a.Error = err.Error()
+		case len(del.Attempts)+1 >= d.cfg.MaxAttempts:
+			a.Error = err.Error() + " (giving up)"
+		default:
+			a.Error = err.Error()
+			status, next = models.DeliveryPending, start.Add(d.backoff(len(del.Attempts)+1))
+		}
+	}
+	if rerr := d.store.RecordWebhookAttempt(ctx, del.ID, a, status, next); rerr != nil {
+		logger.Error("webhook record attempt", rerr, logger.FieldKV("delivery_id", del.ID))
+	}
+	metrics.IncWebhookDelivery(status)
+	if status != models.DeliveryDelivered {
+		logger.Info("webhook delivery failed", logger.FieldKV("delivery_id", del.ID), logger.FieldKV("status", status), logger.FieldKV("reason", a.Error))
+	}
+	return status == models.DeliveryDelivered
+}
+
+func (d *Dispatcher) post(ctx context.Context, sub models.WebhookSubscription, del models.WebhookDelivery, now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, del.EventType)
+	req.Header.Set(DeliveryHeader, del.ID)
+	req.Header.Set(webhook.TimestampHeader, fmt.Sprint(now.Unix()))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(sub.Secret, now, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// permanent reports whether a response status means retrying cannot help: client errors other
+// than timeouts and rate limiting.
+func permanent(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// backoff is the wait after the n-th failed attempt: BaseBackoff doubled per attempt, capped.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	b := d.cfg.BaseBackoff
+	for i := 1; i < n && b < d.cfg.MaxBackoff; i++ {
+		b *= 2
+	}
+	return min(b, d.cfg.MaxBackoff)
+}
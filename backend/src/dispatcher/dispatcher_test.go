@@ -0,0 +1,238 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"src/models"
+	"src/webhook"
+)
+
+type memStore struct {
+	subs        []models.WebhookSubscription
+	deliveries  map[string]*models.WebhookDelivery
+	leaseHolder string
+	leaseUntil  time.Time
+}
+
+func newMemStore(subs ...models.WebhookSubscription) *memStore {
+	return &memStore{subs: subs, deliveries: map[string]*models.WebhookDelivery{}}
+}
+
+func (m *memStore) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return m.subs, nil
+}
+func (m *memStore) CreateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	for _, cur := range m.deliveries {
+		if cur.SubscriptionID == d.SubscriptionID && cur.EventID == d.EventID {
+			return nil
+		}
+	}
+	m.deliveries[d.ID] = &d
+	return nil
+}
+func (m *memStore) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var out []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(now) {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SubscriptionID < out[j].SubscriptionID })
+	return out, nil
+}
+func (m *memStore) RecordWebhookAttempt(ctx context.Context, id string, a models.DeliveryAttempt, status string, next time.Time) error {
+	d := m.deliveries[id]
+	d.Attempts = append(d.Attempts, a)
+	d.Status, d.NextAttemptAt = status, next
+	return nil
+}
+func (m *memStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	if m.leaseHolder != "" && m.leaseHolder != holder && now.Before(m.leaseUntil) {
+		return false, nil
+	}
+	m.leaseHolder, m.leaseUntil = holder, now.Add(ttl)
+	return true, nil
+}
+
+func (m *memStore) only(t *testing.T, subID string) *models.WebhookDelivery {
+	t.Helper()
+	var found []*models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subID {
+			found = append(found, d)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected one delivery for %s, got %d", subID, len(found))
+	}
+	return found[0]
+}
+
+// receiver is a local endpoint answering with the queued status codes (200 once they run out).
+type receiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	rc.requests, rc.bodies = append(rc.requests, r), append(rc.bodies, body)
+	code := http.StatusOK
+	if len(rc.codes) > 0 {
+		code, rc.codes = rc.codes[0], rc.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func newDispatcher(st Store, now *time.Time) *Dispatcher {
+	d := New(st, Config{Interval: time.Second, MaxAttempts: 3, BaseBackoff: 10 * time.Second, MaxBackoff: time.Minute, Timeout: time.Second}, "r1", nil)
+	d.now = func() time.Time { return *now }
+	return d
+}
+
+func TestEnqueueFiltersByEventAndRoom(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	disabled := now
+	st := newMemStore(
+		models.WebhookSubscription{ID: "all", URL: "http://x"},
+		models.WebhookSubscription{ID: "ops", URL: "http://x", Rooms: []string{"ops"}},
+		models.WebhookSubscription{ID: "joins", URL: "http://x", Events: []string{models.HookEventMemberJoined}},
+		models.WebhookSubscription{ID: "off", URL: "http://x", DisabledAt: &disabled},
+	)
+	d := newDispatcher(st, &now)
+	msg := models.Message{MessageID: "m1", RoomID: "general", Content: "hi", Timestamp: now}
+	for i := 0; i < 2; i++ { // Kafka may redeliver; the second enqueue must not duplicate.
+		if err := d.Enqueue(context.Background(), MessageCreated(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(st.deliveries) != 1 || st.only(t, "all").EventType != models.HookEventMessageCreated {
+		t.Fatalf("expected one delivery for the catch-all subscription, got %+v", st.deliveries)
+	}
+}
+
+func TestEnqueueSkipsDMsUnlessListed(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	dm := models.DMRoomID("alice", "bob")
+	st := newMemStore(
+		models.WebhookSubscription{ID: "all", URL: "http://x"},
+		models.WebhookSubscription{ID: "dm", URL: "http://x", Rooms: []string{dm}},
+	)
+	d := newDispatcher(st, &now)
+	msg := models.Message{MessageID: "m1", RoomID: dm, Content: "hi", Timestamp: now}
+	if err := d.Enqueue(context.Background(), MessageCreated(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.deliveries) != 1 || st.only(t, "dm").EventID != models.HookEventMessageCreated+":m1" {
+		t.Fatalf("expected the DM only for the subscription naming it, got %+v", st.deliveries)
+	}
+}
+
+func TestDeliverySignedAndRetriedWithBackoff(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newMemStore(models.WebhookSubscription{ID: "s1", URL: srv.URL, Secret: "s3cret"})
+	d := newDispatcher(st, &now)
+	_ = d.Enqueue(context.Background(), MessageCreated(models.Message{MessageID: "m1", RoomID: "general", Content: "hi", Timestamp: now}))
+
+	if n, err := d.Tick(context.Background()); n != 0 || err != nil {
+		t.Fatalf("first attempt should fail, delivered %d err %v", n, err)
+	}
+	del := st.only(t, "s1")
+	if del.Status != models.DeliveryPending || !del.NextAttemptAt.Equal(now.Add(10*time.Second)) || del.Attempts[0].StatusCode != 500 {
+		t.Fatalf("expected a retry in 10s, got %+v", del)
+	}
+	if n, _ := d.Tick(context.Background()); n != 0 || len(rc.requests) != 1 {
+		t.Fatalf("retry must wait for the backoff, got %d requests", len(rc.requests))
+	}
+	now = now.Add(10 * time.Second)
+	if n, _ := d.Tick(context.Background()); n != 1 || del.Status != models.DeliveryDelivered || len(del.Attempts) != 2 {
+		t.Fatalf("second attempt should deliver, got %d %+v", n, del)
+	}
+
+	r, body := rc.requests[1], rc.bodies[1]
+	if err := webhook.Verify("s3cret", r.Header.Get(webhook.TimestampHeader), r.Header.Get(webhook.SignatureHeader), body, now, time.Minute); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	if r.Header.Get(EventHeader) != models.HookEventMessageCreated || r.Header.Get(DeliveryHeader) != del.ID {
+		t.Fatalf("unexpected headers %v", r.Header)
+	}
+	var ev struct {
+		Type string         `json:"type"`
+		Data models.Message `json:"data"`
+	}
+	if err := json.Unmarshal(body, &ev); err != nil || ev.Type != models.HookEventMessageCreated || ev.Data.Content != "hi" {
+		t.Fatalf("unexpected payload %s: %v", body, err)
+	}
+}
+
+func TestFailingDeliveriesAreParked(t *testing.T) {
+	rc := &receiver{codes: []int{502, 503, 504, http.StatusGone}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newMemStore(models.WebhookSubscription{ID: "a", URL: srv.URL, Rooms: []string{"general"}}, models.WebhookSubscription{ID: "b", URL: srv.URL, Rooms: []string{"ops"}})
+	d := newDispatcher(st, &now)
+	_ = d.Enqueue(context.Background(), MessageCreated(models.Message{MessageID: "m1", RoomID: "general"}))
+
+	// 502, 503 (backoff 10s then 20s), then the third failure exhausts MaxAttempts.
+	for _, wait := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+		now = now.Add(wait)
+		_, _ = d.Tick(context.Background())
+	}
+	if del := st.only(t, "a"); del.Status != models.DeliveryParked || len(del.Attempts) != 3 {
+		t.Fatalf("expected parked after 3 attempts, got %+v", del)
+	}
+
+	// A client error other than 408/429 is not retried at all.
+	_ = d.Enqueue(context.Background(), MessageCreated(models.Message{MessageID: "m2", RoomID: "ops"}))
+	now = now.Add(time.Hour)
+	_, _ = d.Tick(context.Background())
+	if del := st.only(t, "b"); del.Status != models.DeliveryParked || del.Attempts[0].StatusCode != http.StatusGone {
+		t.Fatalf("expected 410 to park immediately, got %+v", del)
+	}
+}
+
+func TestOnlyLeaderDelivers(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newMemStore(models.WebhookSubscription{ID: "s1", URL: srv.URL})
+	leader := newDispatcher(st, &now)
+	follower := newDispatcher(st, &now)
+	follower.holder = "r2"
+	if _, err := leader.Tick(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_ = follower.Enqueue(context.Background(), MessageCreated(models.Message{MessageID: "m1"}))
+	if n, _ := follower.Tick(context.Background()); n != 0 || len(rc.requests) != 0 {
+		t.Fatalf("follower delivered while the lease is held")
+	}
+	if n, _ := leader.Tick(context.Background()); n != 1 {
+		t.Fatalf("leader should deliver the follower's queued event, got %d", n)
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := New(newMemStore(), Config{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}, "r1", nil)
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 20: 5 * time.Second} {
+		if got := d.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"src/config"
+	"src/logger"
+	"src/models"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ControlWriter publishes a control event, keyed by user so a user's events stay ordered.
+func ControlWriter(ctx context.Context, ev models.ControlEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return getWriter(config.ControlTopic).WriteMessages(writeCtx, kafka.Message{Key: []byte(ev.UserID), Value: b})
+}
+
+// AuditWriter mirrors an audit entry to the audit topic, keyed by actor.
+func AuditWriter(ctx context.Context, e models.AuditEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return getWriter(config.AuditTopic).WriteMessages(writeCtx, kafka.Message{Key: []byte(e.Actor), Value: b})
+}
+
+// ControlReader hands every control event published from now on to handle, until ctx is canceled.
+// Every replica reads the whole topic (no consumer group); past events are skipped since bans are
+// also checked against Mongo when connecting. Read errors reconnect after a pause.
+func ControlReader(ctx context.Context, handle func(models.ControlEvent)) {
+	logger.Info("starting kafka control reader", logger.FieldKV("topic", config.ControlTopic))
+	for ctx.Err() == nil {
+		readControl(ctx, handle)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func readControl(ctx context.Context, handle func(models.ControlEvent)) {
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{config.KafkaBroker},
+		Topic:     config.ControlTopic,
+		Partition: 0,
+		MaxBytes:  1e6,
+	})
+	defer r.Close()
+	if err := r.SetOffset(kafka.LastOffset); err != nil {
+		logger.Error("kafka control reader offset", err)
+		return
+	}
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("kafka control read error", err)
+			}
+			return
+		}
+		var ev models.ControlEvent
+		if err := json.Unmarshal(m.Value, &ev); err != nil {
+			logger.Error("kafka control event unmarshal error", err)
+			continue
+		}
+		handle(ev)
+	}
+}
@@ -122,3 +122,51 @@ func Reader(ctx context.Context, broadcast chan<- models.Message) {
 		broadcast <- msg
 	}
 }
+
+// GroupReader consumes the chat topic as a member of consumer group groupID, so every message is
+// handled by exactly one replica, until ctx is canceled. A message is committed only once handle
+// succeeds; failures are retried with backoff so nothing is skipped while a dependency is down.
+func GroupReader(ctx context.Context, groupID string, handle func(context.Context, models.Message) error) {
+	logger.Info("starting kafka group reader", logger.FieldKV("topic", config.Topic), logger.FieldKV("group", groupID))
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  []string{config.KafkaBroker},
+		Topic:    config.Topic,
+		GroupID:  groupID,
+		MinBytes: 1,
+		MaxBytes: 10e6,
+	})
+	defer func() {
+		if err := r.Close(); err != nil {
+			logger.Error("kafka group reader close error", err)
+		}
+	}()
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("kafka group read error", err, logger.FieldKV("group", groupID))
+			}
+			return
+		}
+		var msg models.Message
+		if err := json.Unmarshal(m.Value, &msg); err != nil {
+			logger.Error("kafka message unmarshal error", err, logger.FieldKV("group", groupID))
+		} else {
+			for delay := 100 * time.Millisecond; ; delay = min(2*delay, 30*time.Second) {
+				err := handle(ctx, msg)
+				if err == nil {
+					break
+				}
+				logger.Error("kafka group handler failed; retrying", err, logger.FieldKV("group", groupID), logger.FieldKV("message_id", msg.MessageID))
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if err := r.CommitMessages(ctx, m); err != nil && ctx.Err() == nil {
+			logger.Error("kafka commit error", err, logger.FieldKV("group", groupID))
+		}
+	}
+}
@@ -37,3 +37,15 @@ func TestWriterAndReader(t *testing.T) {
 		<-ctx.Done()
 	})
 }
+
+func TestGroupReaderImmediateReturn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	GroupReader(ctx, "test", func(context.Context, models.Message) error { return nil }) // Should return promptly
+}
+
+func TestControlReaderImmediateReturn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ControlReader(ctx, func(models.ControlEvent) {}) // Should return promptly
+}
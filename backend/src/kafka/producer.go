@@ -11,3 +11,17 @@ type ProducerAdapter struct{}
 func (ProducerAdapter) Publish(ctx context.Context, msg models.Message) error {
 	return Writer(ctx, msg)
 }
+
+// ControlAdapter implements api.ControlPublisher on the control topic.
+type ControlAdapter struct{}
+
+func (ControlAdapter) PublishControl(ctx context.Context, ev models.ControlEvent) error {
+	return ControlWriter(ctx, ev)
+}
+
+// AuditAdapter implements api.AuditPublisher on the audit topic.
+type AuditAdapter struct{}
+
+func (AuditAdapter) PublishAudit(ctx context.Context, e models.AuditEntry) error {
+	return AuditWriter(ctx, e)
+}
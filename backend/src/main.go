@@ -2,27 +2,37 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"src/api"
 	"src/config"
+	"src/dispatcher"
 	"src/kafka"
 	"src/logger"
 	"src/metrics"
 	"src/models"
+	"src/moderation"
 	oidcutil "src/oidc"
+	"src/ratelimit"
+	"src/rekey"
+	"src/retention"
+	"src/scheduler"
 	"src/store"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	skafka "github.com/segmentio/kafka-go"
 )
 
 var broadcast = make(chan models.Message)
 var appCtx context.Context
 var appCancel context.CancelFunc
+var auth *oidcutil.Registry
 
 func main() {
 	logger.Info("starting application")
@@ -37,12 +47,15 @@ func main() {
 	}
 	defer store.Close(context.Background())
 
-	// Initialize OIDC (provider + verifier)
-	_, coreVerifier := oidcutil.Init(appCtx)
-	verifier := &oidcutil.Verifier{Fn: func(ctx context.Context, raw string) error {
-		_, err := oidcutil.VerifyToken(ctx, coreVerifier, raw)
-		return err
-	}}
+	// Initialize OIDC in the background; until discovery succeeds auth is degraded and /readyz fails.
+	issuers, err := oidcutil.ParseIssuers(config.OIDCTrustedIssuers)
+	if err != nil {
+		log.Fatalf("oidc config: %v", err)
+	}
+	auth = oidcutil.NewRegistry(issuers)
+	auth.SetIntervals(config.ParseDuration(config.OIDCRefreshInterval, time.Hour), config.ParseDuration(config.OIDCRetryInterval, 30*time.Second))
+	auth.Start(appCtx)
+	verifier := auth.Verifier()
 
 	// Capture OS signals
 	sigCh := make(chan os.Signal, 1)
@@ -63,7 +76,100 @@ func main() {
 	validator := api.NewMessageValidator("../schema.json")
 	producer := kafka.ProducerAdapter{}
 	repo := store.RepositoryAdapter{}
-	server := api.NewServer(producer, repo, verifier, validator, broadcast, maxLen)
+	origins, err := api.NewOriginPolicy(config.SplitList(config.WSAllowedOrigins), strings.EqualFold(config.WSAllowLocalhost, "true"))
+	if err != nil {
+		log.Fatalf("websocket origins: %v", err)
+	}
+	limits, err := rateLimits()
+	if err != nil {
+		log.Fatalf("rate limits: %v", err)
+	}
+	// Moderation chain; admins edit it through the API and every replica picks it up on reload.
+	modEngine := moderation.NewEngine(store.ModerationAdapter{}, config.ParseDuration(config.ModerationReloadInterval, 30*time.Second))
+	go modEngine.Run(appCtx)
+	// Message content is encrypted at rest only when a master key file is configured.
+	var roomKeys api.KeyRotator
+	if config.MessageEncryptionKeyFile != "" {
+		wrapper, err := store.LoadKeyFile(config.MessageEncryptionKeyFile)
+		if err != nil {
+			log.Fatalf("message encryption keys: %v", err)
+		}
+		store.EnableEncryption(wrapper)
+		roomKeys = store.EncryptionAdapter{}
+	}
+	// Audit entries are mirrored to Kafka only when a topic is configured.
+	var auditMirror api.AuditPublisher
+	if config.AuditTopic != "" {
+		auditMirror = kafka.AuditAdapter{}
+	}
+	// instanceID identifies this replica in leader-election leases.
+	hostname, _ := os.Hostname()
+	instanceID := hostname + "-" + uuid.NewString()
+
+	// Outgoing webhook dispatcher; messages reach it through Kafka below, room events from the API.
+	hooks := dispatcher.New(store.SubscriptionAdapter{}, dispatcher.Config{
+		Interval:    config.ParseDuration(config.WebhookDispatchInterval, 5*time.Second),
+		MaxAttempts: config.ParseInt(config.WebhookMaxAttempts, 8),
+		BaseBackoff: config.ParseDuration(config.WebhookBaseBackoff, 30*time.Second),
+		MaxBackoff:  config.ParseDuration(config.WebhookMaxBackoff, time.Hour),
+		Timeout:     config.ParseDuration(config.WebhookTimeout, 10*time.Second),
+	}, instanceID, nil)
+	server := api.NewServer(producer, repo, verifier, validator, broadcast, maxLen,
+		api.WithRooms(store.RoomAdapter{}),
+		api.WithModeratorGroups(config.SplitList(config.ModeratorGroups)),
+		api.WithAdminGroups(config.SplitList(config.AdminGroups)),
+		api.WithHolds(store.HoldAdapter{}),
+		api.WithScheduled(store.ScheduledAdapter{}, config.ParseDuration(config.ScheduleMaxAhead, 30*24*time.Hour)),
+		api.WithOriginPolicy(origins),
+		api.WithTokens(store.TokenAdapter{}),
+		api.WithWebhooks(store.WebhookAdapter{}, config.ParseInt(config.WebhookRateLimit, 30)),
+		api.WithSubscriptions(store.SubscriptionAdapter{}),
+		api.WithHookEvents(hooks),
+		api.WithCommands(store.CommandAdapter{}, config.ParseDuration(config.CommandTimeout, 3*time.Second)),
+		api.WithSanctions(store.SanctionAdapter{}),
+		api.WithRateLimits(limits),
+		api.WithModeration(modEngine, store.ModerationAdapter{}),
+		api.WithAudit(store.AuditAdapter{}),
+		api.WithAuditMirror(auditMirror),
+		api.WithControl(kafka.ControlAdapter{}),
+		api.WithBlocks(store.BlockAdapter{}),
+		api.WithReports(store.ReportAdapter{}, config.ParseInt(config.ReportHideThreshold, 5)),
+		api.WithKeys(store.KeyAdapter{}),
+		api.WithMaxEncrypted(config.ParseInt(config.EncryptedMaxBytes, 256<<10)),
+		api.WithEncryption(roomKeys),
+		api.WithProfiles(store.ProfileAdapter{}),
+		api.WithAutoAway(config.ParseDuration(config.StatusAwayAfter, 10*time.Minute)),
+	)
+	// Bans, kicks and blocks made on any replica apply to the user's connections here too.
+	go kafka.ControlReader(appCtx, server.ApplyControl)
+
+	// Scheduled message publisher; replicas elect a leader through a Mongo lease.
+	sched := scheduler.New(store.ScheduledAdapter{}, producer, scheduler.SystemClock{}, instanceID,
+		config.ParseDuration(config.SchedulerInterval, 5*time.Second))
+	go sched.Run(appCtx)
+
+	// Retention job; per-room overrides live on room documents.
+	go retention.New(store.RetentionAdapter{}, retention.Config{
+		Global:    config.ParseDuration(config.RetentionPeriod, 0),
+		Interval:  config.ParseDuration(config.RetentionInterval, time.Hour),
+		BatchSize: config.ParseInt(config.RetentionBatchSize, 500),
+		DryRun:    strings.EqualFold(config.RetentionDryRun, "true"),
+	}, instanceID).Run(appCtx)
+
+	// Re-encryption job; moves messages onto current room keys and off retired master keys.
+	if roomKeys != nil {
+		go rekey.New(store.EncryptionAdapter{}, rekey.Config{
+			Interval:  config.ParseDuration(config.MessageEncryptionRekeyInterval, 10*time.Minute),
+			BatchSize: config.ParseInt(config.MessageEncryptionRekeyBatch, 500),
+			MaxAge:    config.ParseDuration(config.MessageEncryptionKeyMaxAge, 0),
+		}, instanceID).Run(appCtx)
+	}
+
+	// Outgoing webhooks: the consumer group queues deliveries, the lease holder sends them.
+	go kafka.GroupReader(appCtx, config.WebhookConsumerGroup, func(ctx context.Context, msg models.Message) error {
+		return hooks.Enqueue(ctx, dispatcher.MessageCreated(msg))
+	})
+	go hooks.Run(appCtx)
 
 	http.HandleFunc("/healthz", handleHealth)
 	http.HandleFunc("/readyz", handleReady)
@@ -76,6 +182,31 @@ func main() {
 	}
 }
 
+// rateLimits builds the ingestion rate limits from the RATE_LIMIT_* settings.
+func rateLimits() (api.RateLimits, error) {
+	limits := api.RateLimits{Rules: map[string]ratelimit.Rule{}, ProxyHops: config.ParseInt(config.RateLimitProxyHops, 0)}
+	for key, spec := range map[string]string{
+		"messages.user": config.RateLimitMessagesUser,
+		"messages.ip":   config.RateLimitMessagesIP,
+		"ws.user":       config.RateLimitWSUser,
+		"ws.ip":         config.RateLimitWSIP,
+	} {
+		rule, err := ratelimit.ParseRule(spec)
+		if err != nil {
+			return limits, err
+		}
+		limits.Rules[key] = rule
+	}
+	switch config.RateLimitBackend {
+	case "memory":
+	case "mongo":
+		limits.Limiter = ratelimit.NewShared(store.RateLimitAdapter{})
+	default:
+		return limits, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q", config.RateLimitBackend)
+	}
+	return limits, nil
+}
+
 // keep health/ready/metrics handlers below
 
 // Health endpoint
@@ -88,6 +219,10 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 func handleReady(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Second)
 	defer cancel()
+	if err := auth.Ready(); err != nil {
+		http.Error(w, "auth not ready", http.StatusServiceUnavailable)
+		return
+	}
 	if err := store.Ping(ctx); err != nil {
 		http.Error(w, "mongo not ready", http.StatusServiceUnavailable)
 		return
@@ -3,6 +3,8 @@ package metrics
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
 	"sync/atomic"
 )
 
@@ -14,9 +16,21 @@ var (
 	oidcLoopbackAutoDial  atomic.Uint64
 	oidcFallbackActivated atomic.Uint64
 	oidcLastInitAttempts  atomic.Uint64 // gauge semantics
+	oidcRefreshSuccess    atomic.Uint64
+	oidcRefreshFailure    atomic.Uint64
+	oidcReady             atomic.Uint64 // gauge semantics
 	wsConnections         atomic.Uint64
+	wsSessionsExpired     atomic.Uint64
+	wsOriginNotAllowed    atomic.Uint64
+	wsOriginMalformed     atomic.Uint64
 	msgIngestedTotal      atomic.Uint64
 	msgBroadcastTotal     atomic.Uint64
+	scheduledPublished    atomic.Uint64
+	retentionPruned       atomic.Uint64
+	retentionCandidates   atomic.Uint64 // gauge semantics
+	webhookDelivered      atomic.Uint64
+	webhookRetried        atomic.Uint64
+	webhookParked         atomic.Uint64
 )
 
 // Increment helpers
@@ -34,12 +48,76 @@ func IncOIDCInitFailure(attempts uint64) {
 }
 func IncOIDCLoopbackAutoDial()  { oidcLoopbackAutoDial.Add(1) }
 func IncOIDCFallbackActivated() { oidcFallbackActivated.Add(1) }
+func IncOIDCRefresh(ok bool) {
+	if ok {
+		oidcRefreshSuccess.Add(1)
+	} else {
+		oidcRefreshFailure.Add(1)
+	}
+}
+func IncOIDCReadyIssuers() { oidcReady.Add(1) }
 
 // WebSocket metrics
-func IncWSConnections() { wsConnections.Add(1) }
-func DecWSConnections() { wsConnections.Add(^uint64(0)) } // atomic decrement
-func IncMsgIngested()   { msgIngestedTotal.Add(1) }
-func IncMsgBroadcast()  { msgBroadcastTotal.Add(1) }
+func IncWSConnections()     { wsConnections.Add(1) }
+func DecWSConnections()     { wsConnections.Add(^uint64(0)) } // atomic decrement
+func IncMsgIngested()       { msgIngestedTotal.Add(1) }
+func IncWSSessionsExpired() { wsSessionsExpired.Add(1) }
+
+// IncWSOriginRejected counts refused upgrades; reason is "malformed origin" or "origin not allowed".
+func IncWSOriginRejected(reason string) {
+	if reason == "malformed origin" {
+		wsOriginMalformed.Add(1)
+	} else {
+		wsOriginNotAllowed.Add(1)
+	}
+}
+func IncMsgBroadcast() { msgBroadcastTotal.Add(1) }
+
+// Scheduler metrics
+func IncScheduledPublished() { scheduledPublished.Add(1) }
+
+// Retention metrics
+func IncRetentionPruned(n uint64)           { retentionPruned.Add(n) }
+func SetRetentionDryRunCandidates(n uint64) { retentionCandidates.Store(n) }
+
+// IncWebhookDelivery counts outgoing webhook attempts by resulting delivery status
+// ("delivered", "parked", anything else is a retry).
+func IncWebhookDelivery(status string) {
+	switch status {
+	case "delivered":
+		webhookDelivered.Add(1)
+	case "parked":
+		webhookParked.Add(1)
+	default:
+		webhookRetried.Add(1)
+	}
+}
+
+// rateLimited counts throttled requests by endpoint and limit key ("user", "ip", "hook").
+var rateLimited = struct {
+	sync.Mutex
+	counts map[[2]string]uint64
+}{counts: map[[2]string]uint64{}}
+
+// IncRateLimited counts a request refused by a rate limit.
+func IncRateLimited(endpoint, key string) {
+	rateLimited.Lock()
+	rateLimited.counts[[2]string{endpoint, key}]++
+	rateLimited.Unlock()
+}
+
+// moderated counts messages acted on by the moderation chain, by action.
+var moderated = struct {
+	sync.Mutex
+	counts map[string]uint64
+}{counts: map[string]uint64{}}
+
+// IncModerated counts a message rejected, masked or flagged by moderation.
+func IncModerated(action string) {
+	moderated.Lock()
+	moderated.counts[action]++
+	moderated.Unlock()
+}
 
 // Handler exposes metrics in a minimal Prometheus exposition format.
 func Handler(w http.ResponseWriter, _ *http.Request) {
@@ -49,7 +127,7 @@ func Handler(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "chatapp_oidc_provider_init_success_total{mode=\"primary\"} %d\n", oidcPrimarySuccess.Load())
 	fmt.Fprintf(w, "chatapp_oidc_provider_init_success_total{mode=\"fallback\"} %d\n", oidcFallbackSuccess.Load())
 
-	fmt.Fprintf(w, "# HELP chatapp_oidc_provider_init_failure_total OIDC provider initialization failures (process exiting)\n")
+	fmt.Fprintf(w, "# HELP chatapp_oidc_provider_init_failure_total OIDC provider initialization failures (all attempts exhausted)\n")
 	fmt.Fprintf(w, "# TYPE chatapp_oidc_provider_init_failure_total counter\n")
 	fmt.Fprintf(w, "chatapp_oidc_provider_init_failure_total %d\n", oidcInitFailure.Load())
 
@@ -65,10 +143,28 @@ func Handler(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "# TYPE chatapp_oidc_last_init_attempts gauge\n")
 	fmt.Fprintf(w, "chatapp_oidc_last_init_attempts %d\n", oidcLastInitAttempts.Load())
 
+	fmt.Fprintf(w, "# HELP chatapp_oidc_refresh_total OIDC discovery / key set refreshes by result\n")
+	fmt.Fprintf(w, "# TYPE chatapp_oidc_refresh_total counter\n")
+	fmt.Fprintf(w, "chatapp_oidc_refresh_total{result=\"success\"} %d\n", oidcRefreshSuccess.Load())
+	fmt.Fprintf(w, "chatapp_oidc_refresh_total{result=\"failure\"} %d\n", oidcRefreshFailure.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_oidc_ready_issuers Trusted issuers whose keys are loaded (0 means auth is degraded)\n")
+	fmt.Fprintf(w, "# TYPE chatapp_oidc_ready_issuers gauge\n")
+	fmt.Fprintf(w, "chatapp_oidc_ready_issuers %d\n", oidcReady.Load())
+
 	fmt.Fprintf(w, "# HELP chatapp_ws_connections Current websocket connections\n")
 	fmt.Fprintf(w, "# TYPE chatapp_ws_connections gauge\n")
 	fmt.Fprintf(w, "chatapp_ws_connections %d\n", wsConnections.Load())
 
+	fmt.Fprintf(w, "# HELP chatapp_ws_sessions_expired_total Websocket sessions closed because the token expired\n")
+	fmt.Fprintf(w, "# TYPE chatapp_ws_sessions_expired_total counter\n")
+	fmt.Fprintf(w, "chatapp_ws_sessions_expired_total %d\n", wsSessionsExpired.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_ws_origin_rejected_total Websocket upgrades refused by the origin allow-list\n")
+	fmt.Fprintf(w, "# TYPE chatapp_ws_origin_rejected_total counter\n")
+	fmt.Fprintf(w, "chatapp_ws_origin_rejected_total{reason=\"not_allowed\"} %d\n", wsOriginNotAllowed.Load())
+	fmt.Fprintf(w, "chatapp_ws_origin_rejected_total{reason=\"malformed\"} %d\n", wsOriginMalformed.Load())
+
 	fmt.Fprintf(w, "# HELP chatapp_messages_ingested_total Messages accepted and enqueued\n")
 	fmt.Fprintf(w, "# TYPE chatapp_messages_ingested_total counter\n")
 	fmt.Fprintf(w, "chatapp_messages_ingested_total %d\n", msgIngestedTotal.Load())
@@ -76,4 +172,50 @@ func Handler(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprintf(w, "# HELP chatapp_messages_broadcast_total Messages broadcast to websocket clients\n")
 	fmt.Fprintf(w, "# TYPE chatapp_messages_broadcast_total counter\n")
 	fmt.Fprintf(w, "chatapp_messages_broadcast_total %d\n", msgBroadcastTotal.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_scheduled_messages_published_total Scheduled messages published by the scheduler\n")
+	fmt.Fprintf(w, "# TYPE chatapp_scheduled_messages_published_total counter\n")
+	fmt.Fprintf(w, "chatapp_scheduled_messages_published_total %d\n", scheduledPublished.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_retention_pruned_total Messages deleted by retention\n")
+	fmt.Fprintf(w, "# TYPE chatapp_retention_pruned_total counter\n")
+	fmt.Fprintf(w, "chatapp_retention_pruned_total %d\n", retentionPruned.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_retention_dry_run_candidates Messages the last dry-run retention pass would delete\n")
+	fmt.Fprintf(w, "# TYPE chatapp_retention_dry_run_candidates gauge\n")
+	fmt.Fprintf(w, "chatapp_retention_dry_run_candidates %d\n", retentionCandidates.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_webhook_deliveries_total Outgoing webhook delivery attempts by outcome\n")
+	fmt.Fprintf(w, "# TYPE chatapp_webhook_deliveries_total counter\n")
+	fmt.Fprintf(w, "chatapp_webhook_deliveries_total{result=\"delivered\"} %d\n", webhookDelivered.Load())
+	fmt.Fprintf(w, "chatapp_webhook_deliveries_total{result=\"retry\"} %d\n", webhookRetried.Load())
+	fmt.Fprintf(w, "chatapp_webhook_deliveries_total{result=\"parked\"} %d\n", webhookParked.Load())
+
+	fmt.Fprintf(w, "# HELP chatapp_rate_limited_total Requests refused by rate limits\n")
+	fmt.Fprintf(w, "# TYPE chatapp_rate_limited_total counter\n")
+	rateLimited.Lock()
+	labels := make([][2]string, 0, len(rateLimited.counts))
+	for l := range rateLimited.counts {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i][0] < labels[j][0] || (labels[i][0] == labels[j][0] && labels[i][1] < labels[j][1])
+	})
+	for _, l := range labels {
+		fmt.Fprintf(w, "chatapp_rate_limited_total{endpoint=%q,key=%q} %d\n", l[0], l[1], rateLimited.counts[l])
+	}
+	rateLimited.Unlock()
+
+	fmt.Fprintf(w, "# HELP chatapp_moderation_actions_total Messages rejected, masked or flagged by moderation\n")
+	fmt.Fprintf(w, "# TYPE chatapp_moderation_actions_total counter\n")
+	moderated.Lock()
+	actions := make([]string, 0, len(moderated.counts))
+	for a := range moderated.counts {
+		actions = append(actions, a)
+	}
+	sort.Strings(actions)
+	for _, a := range actions {
+		fmt.Fprintf(w, "chatapp_moderation_actions_total{action=%q} %d\n", a, moderated.counts[a])
+	}
+	moderated.Unlock()
 }
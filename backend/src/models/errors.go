@@ -0,0 +1,11 @@
+package models
+
+import "errors"
+
+// Sentinel errors shared by the store and api layers.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("already exists")
+	// ErrAuthUnavailable means tokens cannot be verified yet (identity provider not discovered).
+	ErrAuthUnavailable = errors.New("authentication unavailable")
+)
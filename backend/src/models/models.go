@@ -1,10 +1,690 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
+
+// DefaultRoomID is assumed for messages posted without a room (and legacy documents).
+const DefaultRoomID = "general"
+
+// dmRoomPrefix marks direct message rooms, "dm:<a>,<b>" with the two subjects sorted.
+const dmRoomPrefix = "dm:"
+
+// DMRoomID returns the direct message room of users a and b.
+func DMRoomID(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return dmRoomPrefix + a + "," + b
+}
+
+// DMParticipants returns the two users of a direct message room; ok is false for other rooms.
+func DMParticipants(roomID string) (a, b string, ok bool) {
+	rest, found := strings.CutPrefix(roomID, dmRoomPrefix)
+	if !found {
+		return "", "", false
+	}
+	a, b, ok = strings.Cut(rest, ",")
+	return a, b, ok && a != "" && b != ""
+}
 
 type Message struct {
 	MessageID string    `json:"message_id"`
 	UserID    string    `json:"user_id"`
+	RoomID    string    `json:"room_id,omitempty"`
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	// Format is "plain" (default) or "markdown"; HTML is the server-rendered, sanitized form of Content.
+	Format string `json:"format,omitempty"`
+	HTML   string `json:"html,omitempty"`
+	// Bot is set by the server for messages posted with a service account token, an incoming webhook
+	// or an external command. UserID then names the poster ("bot:<id>", "webhook:<id>",
+	// "command:<name>") and DisplayName carries the name to show, which is never a user's identity.
+	Bot         bool   `json:"bot,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	// Hidden is set on messages hidden after user reports; they are left out of history.
+	Hidden bool `json:"hidden,omitempty"`
+	// Encrypted carries an end-to-end encrypted DM instead of Content; the server relays it unread.
+	Encrypted *Encrypted `json:"encrypted,omitempty"`
+}
+
+// Room is a conversation; the creator becomes its owner.
+type Room struct {
+	RoomID    string    `json:"room_id" bson:"room_id"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	OwnerID   string    `json:"owner_id" bson:"owner_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// RetentionSeconds overrides the global retention period for this room (0 = use global).
+	RetentionSeconds int64 `json:"retention_seconds,omitempty" bson:"retention_seconds,omitempty"`
+	// Topic is set by room moderators with /topic.
+	Topic string `json:"topic,omitempty" bson:"topic,omitempty"`
+}
+
+// RoomMember records that a user was added to a room (e.g. with /invite).
+type RoomMember struct {
+	RoomID  string    `json:"room_id" bson:"room_id"`
+	UserID  string    `json:"user_id" bson:"user_id"`
+	AddedBy string    `json:"added_by" bson:"added_by"`
+	AddedAt time.Time `json:"added_at" bson:"added_at"`
+}
+
+// Pin marks a message as pinned in a room.
+type Pin struct {
+	RoomID    string    `json:"room_id" bson:"room_id"`
+	MessageID string    `json:"message_id" bson:"message_id"`
+	PinnedBy  string    `json:"pinned_by" bson:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at" bson:"pinned_at"`
+}
+
+// Event is a non-message frame pushed to WebSocket clients; Type distinguishes it from chat messages.
+type Event struct {
+	Type      string `json:"type"`
+	RoomID    string `json:"room_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Actor     string `json:"actor,omitempty"`
+	// UserID is the user an event is about (e.g. the invited member); Text carries e.g. the new topic.
+	UserID    string    `json:"user_id,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Profile is the changed profile of UserID in profile events.
+	Profile *Profile `json:"profile,omitempty"`
+	// Status is the new status of UserID in status events; absent when it was cleared.
+	Status *UserStatus `json:"status,omitempty"`
+}
+
+// Event types.
+const (
+	EventPin          = "pin"
+	EventUnpin        = "unpin"
+	EventTopic        = "topic"
+	EventMemberJoined = "member_joined"
+	// EventHide and EventUnhide tell clients a reported message was hidden or restored.
+	EventHide   = "hide"
+	EventUnhide = "unhide"
+	// EventProfile tells clients a user changed their profile.
+	EventProfile = "profile"
+	// EventStatus tells clients a user set or cleared their status.
+	EventStatus = "status"
+)
+
+// WebSocket session frames. Clients send auth (first frame, when no token was offered during the
+// handshake) and reauth (to extend the session with a fresh token); the server answers with
+// auth_ok, error, or session_expired right before closing an expired session.
+const (
+	FrameAuth           = "auth"
+	FrameReauth         = "reauth"
+	FrameAuthOK         = "auth_ok"
+	FrameError          = "error"
+	FrameSessionExpired = "session_expired"
+	// FrameCommandReply carries a slash command's ephemeral reply to the invoking connection only.
+	FrameCommandReply = "command_reply"
+	// FrameHeld tells the sender that its message was held for moderator review.
+	FrameHeld = "held"
+	// FrameActivity is sent by clients on user input; like any frame it ends automatic away.
+	FrameActivity = "activity"
+)
+
+// CommandReply is the ephemeral answer to a slash command.
+type CommandReply struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	RoomID  string `json:"room_id,omitempty"`
+	Text    string `json:"text"`
+}
+
+// SessionFrame is a WebSocket control frame; chat messages never carry a type.
+type SessionFrame struct {
+	Type      string     `json:"type"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// RetryAfter accompanies rate limit errors: seconds until the next frame is accepted.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// MessageID identifies the message a held frame is about.
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// Identity is the authenticated caller derived from a verified token.
+// Groups come from the configured role claim; Role is the global role mapped from them.
+// Issuer is the trusted issuer that signed the token.
+// Service account tokens set Bot and restrict the caller to Scopes (and Rooms, when non-empty);
+// OIDC identities leave Scopes nil, meaning unrestricted.
+type Identity struct {
+	Issuer    string    `json:"iss,omitempty"`
+	Subject   string    `json:"sub"`
+	Name      string    `json:"name,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Picture   string    `json:"picture,omitempty"`
+	Groups    []string  `json:"groups,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	ExpiresAt time.Time `json:"-"`
+	Bot       bool      `json:"bot,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	Rooms     []string  `json:"rooms,omitempty"`
+}
+
+// Allows reports whether the identity may use scope in room (room "" = no specific room).
+func (id Identity) Allows(scope, room string) bool {
+	if id.Scopes == nil {
+		return true
+	}
+	has := false
+	for _, s := range id.Scopes {
+		if s == scope {
+			has = true
+			break
+		}
+	}
+	if !has || room == "" || len(id.Rooms) == 0 {
+		return has
+	}
+	for _, r := range id.Rooms {
+		if r == room {
+			return true
+		}
+	}
+	return false
+}
+
+// Role is an authorization level. User, moderator and admin are global (mapped from token
+// groups); moderator and owner can also be granted per room.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleOwner     Role = "owner"
+	RoleAdmin     Role = "admin"
+)
+
+// Rank orders roles so checks can require "at least" a role; unknown roles rank lowest.
+func (r Role) Rank() int {
+	switch r {
+	case RoleUser:
+		return 1
+	case RoleModerator:
+		return 2
+	case RoleOwner:
+		return 3
+	case RoleAdmin:
+		return 4
+	}
+	return 0
+}
+
+// RoomRole grants a user a role within a single room.
+type RoomRole struct {
+	RoomID    string    `json:"room_id" bson:"room_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Role      Role      `json:"role" bson:"role"`
+	GrantedBy string    `json:"granted_by" bson:"granted_by"`
+	GrantedAt time.Time `json:"granted_at" bson:"granted_at"`
+}
+
+// Scheduled message states.
+const (
+	ScheduledPending  = "pending"
+	ScheduledSending  = "sending"
+	ScheduledSent     = "sent"
+	ScheduledCanceled = "canceled"
+)
+
+// ScheduledMessage is a message held back until SendAt, then published by the scheduler.
+type ScheduledMessage struct {
+	ID        string    `json:"id" bson:"_id"`
+	Message   Message   `json:"message" bson:"message"`
+	SendAt    time.Time `json:"send_at" bson:"send_at"`
+	CreatedBy string    `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Status    string    `json:"status" bson:"status"`
+	ClaimedAt time.Time `json:"-" bson:"claimed_at,omitempty"`
+}
+
+// MessageFilter selects messages for exports; empty fields match everything.
+type MessageFilter struct {
+	RoomID string    `json:"room_id,omitempty"`
+	UserID string    `json:"user_id,omitempty"`
+	From   time.Time `json:"from,omitempty"`
+	To     time.Time `json:"to,omitempty"`
+}
+
+// LegalHold exempts matching messages from deletion and retention pruning until released.
+// Empty RoomID / UserID match any room / user; zero From / To leave the range open.
+type LegalHold struct {
+	ID         string     `json:"id" bson:"_id"`
+	RoomID     string     `json:"room_id,omitempty" bson:"room_id,omitempty"`
+	UserID     string     `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	From       time.Time  `json:"from,omitempty" bson:"from,omitempty"`
+	To         time.Time  `json:"to,omitempty" bson:"to,omitempty"`
+	Reason     string     `json:"reason" bson:"reason"`
+	CreatedBy  string     `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty" bson:"released_at,omitempty"`
+}
+
+// API token scopes.
+const (
+	ScopeRead = "read" // list messages, receive them over the WebSocket
+	ScopePost = "post" // post messages
+)
+
+// ServiceAccount is a non-human identity (CI, integrations) that authenticates with API tokens.
+type ServiceAccount struct {
+	ID          string     `json:"id" bson:"_id"`
+	Name        string     `json:"name" bson:"name"`
+	Description string     `json:"description,omitempty" bson:"description,omitempty"`
+	CreatedBy   string     `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	DisabledAt  *time.Time `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+}
+
+// APIToken is a long-lived service account credential. Only the secret's hash is stored.
+// Empty Rooms means every room.
+type APIToken struct {
+	ID         string     `json:"id" bson:"_id"`
+	AccountID  string     `json:"account_id" bson:"account_id"`
+	Name       string     `json:"name" bson:"name"`
+	Hash       string     `json:"-" bson:"hash"`
+	Scopes     []string   `json:"scopes" bson:"scopes"`
+	Rooms      []string   `json:"rooms,omitempty" bson:"rooms,omitempty"`
+	CreatedBy  string     `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+// IncomingWebhook lets an external system post into one room. The secret signs requests (HMAC)
+// so it is kept in plaintext and only returned once, at creation.
+type IncomingWebhook struct {
+	ID        string     `json:"id" bson:"_id"`
+	RoomID    string     `json:"room_id" bson:"room_id"`
+	Name      string     `json:"name" bson:"name"`
+	Secret    string     `json:"-" bson:"secret"`
+	CreatedBy string     `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+// Outgoing webhook event types a subscription can filter on.
+const (
+	HookEventMessageCreated = "message.created"
+	HookEventMemberJoined   = "member.joined"
+)
+
+// HookEventTypes lists every event type a subscription may name; only emitted types belong here.
+var HookEventTypes = []string{HookEventMessageCreated, HookEventMemberJoined}
+
+// HookEvent is the JSON body delivered to subscribed endpoints.
+type HookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	RoomID    string      `json:"room_id,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookSubscription delivers events to an external URL. Empty Events match every type and
+// empty Rooms every room except direct messages, which are only delivered when listed. The
+// secret signs deliveries (HMAC) and is only returned once, at creation.
+type WebhookSubscription struct {
+	ID         string     `json:"id" bson:"_id"`
+	URL        string     `json:"url" bson:"url"`
+	Secret     string     `json:"-" bson:"secret"`
+	Events     []string   `json:"events,omitempty" bson:"events,omitempty"`
+	Rooms      []string   `json:"rooms,omitempty" bson:"rooms,omitempty"`
+	CreatedBy  string     `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+}
+
+// Matches reports whether the subscription wants an event of type eventType in room.
+func (s WebhookSubscription) Matches(eventType, room string) bool {
+	if s.DisabledAt != nil || (len(s.Events) > 0 && !contains(s.Events, eventType)) {
+		return false
+	}
+	if len(s.Rooms) == 0 {
+		_, _, dm := DMParticipants(room)
+		return !dm
+	}
+	return contains(s.Rooms, room)
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery states. Parked deliveries failed permanently and are only retried on request.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryParked    = "parked"
+)
+
+// WebhookDelivery is one event queued for one subscription, with every attempt made so far.
+type WebhookDelivery struct {
+	ID             string            `json:"id" bson:"_id"`
+	SubscriptionID string            `json:"subscription_id" bson:"subscription_id"`
+	EventID        string            `json:"event_id" bson:"event_id"`
+	EventType      string            `json:"event_type" bson:"event_type"`
+	Payload        string            `json:"payload" bson:"payload"`
+	Status         string            `json:"status" bson:"status"`
+	Attempts       []DeliveryAttempt `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time         `json:"next_attempt_at" bson:"next_attempt_at"`
+	CreatedAt      time.Time         `json:"created_at" bson:"created_at"`
+}
+
+// DeliveryAttempt records one HTTP request of a delivery.
+type DeliveryAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// Sanction kinds.
+const (
+	SanctionMute = "mute"
+	SanctionBan  = "ban"
+	// SanctionKick disconnects the user's live connections once; kicks are audited, not stored.
+	SanctionKick = "kick"
+)
+
+// Sanction restricts a user, in one room or globally (RoomID ""), until ExpiresAt (nil = until lifted).
+// A muted user can still read but cannot post. A banned user can neither read nor post in the room;
+// a global ban refuses every request and closes the user's connections.
+type Sanction struct {
+	ID        string     `json:"id" bson:"_id"`
+	Kind      string     `json:"kind" bson:"kind"`
+	UserID    string     `json:"user_id" bson:"user_id"`
+	RoomID    string     `json:"room_id,omitempty" bson:"room_id,omitempty"`
+	Reason    string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedBy string     `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LiftedAt  *time.Time `json:"lifted_at,omitempty" bson:"lifted_at,omitempty"`
+}
+
+// Applies reports whether the sanction is in force for room at now.
+func (s Sanction) Applies(room string, now time.Time) bool {
+	return s.LiftedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt)) && (s.RoomID == "" || s.RoomID == room)
+}
+
+// Control event types. Control events are fanned out to every replica so each can act on its own
+// WebSocket connections.
+const (
+	ControlBan     = "ban"
+	ControlUnban   = "unban"
+	ControlKick    = "kick"
+	ControlBlock   = "block"
+	ControlUnblock = "unblock"
+	ControlProfile = "profile"
+	ControlStatus  = "status"
+)
+
+// ControlEvent tells replicas that UserID was banned (globally or from RoomID), unbanned or kicked,
+// that UserID blocked or unblocked TargetID, or that UserID changed their Profile or Status. Status
+// changes carry the Rooms UserID takes part in, which decide who is told.
+type ControlEvent struct {
+	Type      string      `json:"type"`
+	UserID    string      `json:"user_id"`
+	RoomID    string      `json:"room_id,omitempty"`
+	TargetID  string      `json:"target_id,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	Profile   *Profile    `json:"profile,omitempty"`
+	Status    *UserStatus `json:"status,omitempty"`
+	Rooms     []string    `json:"rooms,omitempty"`
+}
+
+// Block hides BlockedID's messages from UserID and refuses BlockedID's direct messages to UserID.
+type Block struct {
+	UserID    string    `json:"user_id" bson:"user_id"`
+	BlockedID string    `json:"blocked_id" bson:"blocked_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// E2EEAlgorithm is the only end-to-end encryption scheme clients may use: X3DH key agreement with
+// Double Ratchet sessions, as in Signal.
+const E2EEAlgorithm = "x3dh-double-ratchet-v1"
+
+// Encrypted is an end-to-end encrypted message body: one ciphertext for every device of both DM
+// participants (the sender's other devices included), all encrypted by SenderDevice.
+type Encrypted struct {
+	Algorithm    string             `json:"algorithm" bson:"algorithm"`
+	SenderDevice string             `json:"sender_device" bson:"sender_device"`
+	Ciphertexts  []DeviceCiphertext `json:"ciphertexts" bson:"ciphertexts"`
+}
+
+// DeviceCiphertext is the ciphertext for one recipient device. Type is 0 for a message that
+// starts a session from a claimed key bundle and 1 for later ones; Body is base64.
+type DeviceCiphertext struct {
+	UserID   string `json:"user_id" bson:"user_id"`
+	DeviceID string `json:"device_id" bson:"device_id"`
+	Type     int    `json:"type" bson:"type"`
+	Body     string `json:"body" bson:"body"`
+}
+
+// PreKey is a public key in a device's bundle; Signature (by the identity key) is set on signed
+// prekeys. Keys are base64.
+type PreKey struct {
+	KeyID     int64  `json:"key_id" bson:"key_id"`
+	PublicKey string `json:"public_key" bson:"public_key"`
+	Signature string `json:"signature,omitempty" bson:"signature,omitempty"`
+}
+
+// DeviceKeys is the public key directory entry of one device. One-time prekeys are handed out
+// once each and are never returned by listings; PreKeysLeft counts them.
+type DeviceKeys struct {
+	UserID         string    `json:"user_id" bson:"user_id"`
+	DeviceID       string    `json:"device_id" bson:"device_id"`
+	IdentityKey    string    `json:"identity_key" bson:"identity_key"`
+	SignedPreKey   PreKey    `json:"signed_prekey" bson:"signed_prekey"`
+	OneTimePreKeys []PreKey  `json:"-" bson:"one_time_prekeys"`
+	PreKeysLeft    int       `json:"prekeys_left" bson:"-"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// KeyBundle is what a sender claims to start a session with one device; OneTimePreKey is nil once
+// the device has run out.
+type KeyBundle struct {
+	UserID        string  `json:"user_id"`
+	DeviceID      string  `json:"device_id"`
+	IdentityKey   string  `json:"identity_key"`
+	SignedPreKey  PreKey  `json:"signed_prekey"`
+	OneTimePreKey *PreKey `json:"one_time_prekey,omitempty"`
+}
+
+// AuditEntry records a privileged action. Entries are only ever appended. Before and After hold
+// the changed fields of the target (flat values only), RequestID the X-Request-ID of the call.
+type AuditEntry struct {
+	ID        string                 `json:"id" bson:"_id"`
+	At        time.Time              `json:"at" bson:"at"`
+	Actor     string                 `json:"actor" bson:"actor"`
+	Action    string                 `json:"action" bson:"action"`
+	Target    string                 `json:"target,omitempty" bson:"target,omitempty"`
+	RoomID    string                 `json:"room_id,omitempty" bson:"room_id,omitempty"`
+	Reason    string                 `json:"reason,omitempty" bson:"reason,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	RequestID string                 `json:"request_id,omitempty" bson:"request_id,omitempty"`
+}
+
+// AuditFilter selects audit entries; empty fields match any. Limit bounds the result (0 = default).
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	RoomID string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// ExternalCommand is a slash command served by an HTTP endpoint. Invocations are signed with Secret,
+// which is only returned once, at registration.
+type ExternalCommand struct {
+	Name        string    `json:"name" bson:"_id"`
+	URL         string    `json:"url" bson:"url"`
+	Secret      string    `json:"-" bson:"secret"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Usage       string    `json:"usage,omitempty" bson:"usage,omitempty"`
+	MinRole     Role      `json:"min_role" bson:"min_role"`
+	CreatedBy   string    `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Moderation filters and actions.
+const (
+	FilterBlocklist = "blocklist"
+	FilterRegex     = "regex"
+	FilterLinks     = "links"
+	FilterSpam      = "spam"
+
+	ActionReject = "reject"
+	ActionMask   = "mask"
+	ActionFlag   = "flag"
+)
+
+// ModerationRule configures one filter of the moderation chain and what happens to messages it
+// matches. Only the fields of the rule's filter are used: Words (blocklist), Patterns (regex),
+// AllowDomains (links) and Repeats within Window (spam, e.g. 3 in "1m").
+type ModerationRule struct {
+	Filter       string   `json:"filter" bson:"filter"`
+	Action       string   `json:"action" bson:"action"`
+	Words        []string `json:"words,omitempty" bson:"words,omitempty"`
+	Patterns     []string `json:"patterns,omitempty" bson:"patterns,omitempty"`
+	AllowDomains []string `json:"allow_domains,omitempty" bson:"allow_domains,omitempty"`
+	Repeats      int      `json:"repeats,omitempty" bson:"repeats,omitempty"`
+	Window       string   `json:"window,omitempty" bson:"window,omitempty"`
+}
+
+// ModerationConfig is the moderation chain, applied in order. It is a single document that every
+// replica reloads periodically.
+type ModerationConfig struct {
+	Rules     []ModerationRule `json:"rules" bson:"rules"`
+	UpdatedBy string           `json:"updated_by,omitempty" bson:"updated_by,omitempty"`
+	UpdatedAt time.Time        `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// Review states of flagged messages.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// FlaggedMessage is a message held for review by the moderation chain. It is only published once a
+// moderator approves it.
+type FlaggedMessage struct {
+	ID         string     `json:"id" bson:"_id"`
+	Message    Message    `json:"message" bson:"message"`
+	Reasons    []string   `json:"reasons" bson:"reasons"`
+	Status     string     `json:"status" bson:"status"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	ReviewedBy string     `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+}
+
+// Resolution states of reports.
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportActioned  = "actioned"
+)
+
+// ReportEntry is one user's report of a message.
+type ReportEntry struct {
+	ReporterID string    `json:"reporter_id" bson:"reporter_id"`
+	Reason     string    `json:"reason" bson:"reason"`
+	At         time.Time `json:"at" bson:"at"`
+}
+
+// Report collects the reports of one message; each user reports a message at most once. A new
+// report reopens a resolved one.
+type Report struct {
+	MessageID  string        `json:"message_id" bson:"_id"`
+	RoomID     string        `json:"room_id" bson:"room_id"`
+	AuthorID   string        `json:"author_id" bson:"author_id"`
+	Reports    []ReportEntry `json:"reports" bson:"reports"`
+	Count      int           `json:"count" bson:"count"`
+	Status     string        `json:"status" bson:"status"`
+	Hidden     bool          `json:"hidden" bson:"hidden"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" bson:"updated_at"`
+	ResolvedBy string        `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+	Note       string        `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// ReportFilter selects reports; empty fields match any.
+type ReportFilter struct {
+	Status   string
+	RoomID   string
+	AuthorID string
+}
+
+// RoomKey is one version of a room's data key for encrypting message content at rest. Wrapped is
+// the data key encrypted under the master key MasterKeyID; the plain key is never stored.
+type RoomKey struct {
+	RoomID      string    `json:"room_id" bson:"room_id"`
+	Version     int       `json:"version" bson:"version"`
+	Wrapped     []byte    `json:"-" bson:"wrapped"`
+	MasterKeyID string    `json:"master_key_id" bson:"master_key_id"`
+	CreatedAt   time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Profile is how a user is shown to others. It is created from token claims on the user's first
+// login; afterwards the user edits it and later logins leave it alone.
+type Profile struct {
+	UserID      string `json:"user_id" bson:"_id"`
+	DisplayName string `json:"display_name" bson:"display_name"`
+	// Email is only shown to the user themselves.
+	Email     string      `json:"email,omitempty" bson:"email,omitempty"`
+	AvatarURL string      `json:"avatar_url,omitempty" bson:"avatar_url,omitempty"`
+	Status    *UserStatus `json:"status,omitempty" bson:"status,omitempty"`
+	CreatedAt time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at" bson:"updated_at"`
+}
+
+// Public is the profile as shown to other users.
+func (p Profile) Public() Profile {
+	p.Email = ""
+	return p
+}
+
+// ProfileUpdate changes the set fields of a profile.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+// User status states.
+const (
+	StatusAvailable = "available"
+	StatusBusy      = "busy"
+	StatusAway      = "away"
+	StatusDND       = "dnd"
+)
+
+// UserStatus is what a user tells others about their availability, optionally with a text and an
+// expiry after which it no longer applies.
+type UserStatus struct {
+	State     string     `json:"state" bson:"state"`
+	Text      string     `json:"text,omitempty" bson:"text,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// Auto marks the away status set by the server after WebSocket inactivity; the user's next
+	// activity clears it.
+	Auto      bool      `json:"auto,omitempty" bson:"auto,omitempty"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Active reports whether the status is set and not expired at now.
+func (s *UserStatus) Active(now time.Time) bool {
+	return s != nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
 }
@@ -26,3 +26,41 @@ func TestMessageStruct(t *testing.T) {
 		t.Errorf("Expected non-zero Timestamp")
 	}
 }
+
+func TestIdentityAllows(t *testing.T) {
+	human := Identity{Subject: "alice"}
+	postOnly := Identity{Bot: true, Scopes: []string{ScopePost}}
+	roomBot := Identity{Bot: true, Scopes: []string{ScopePost, ScopeRead}, Rooms: []string{"builds"}}
+	cases := []struct {
+		id          Identity
+		scope, room string
+		want        bool
+	}{
+		{human, ScopePost, "any", true},
+		{postOnly, ScopePost, "general", true},
+		{postOnly, ScopeRead, "", false},
+		{roomBot, ScopePost, "builds", true},
+		{roomBot, ScopePost, "general", false},
+		{roomBot, ScopeRead, "", true},
+	}
+	for _, tc := range cases {
+		if got := tc.id.Allows(tc.scope, tc.room); got != tc.want {
+			t.Errorf("%+v Allows(%q, %q) = %v, want %v", tc.id, tc.scope, tc.room, got, tc.want)
+		}
+	}
+}
+
+func TestDMRoomID(t *testing.T) {
+	room := DMRoomID("bob", "alice")
+	if room != DMRoomID("alice", "bob") || room != "dm:alice,bob" {
+		t.Fatalf("DM room ids must not depend on the order of the users, got %q", room)
+	}
+	if a, b, ok := DMParticipants(room); !ok || a != "alice" || b != "bob" {
+		t.Fatalf("DMParticipants(%q) = %q, %q, %v", room, a, b, ok)
+	}
+	for _, room := range []string{"general", "dm:alice", "dm:,bob"} {
+		if _, _, ok := DMParticipants(room); ok {
+			t.Errorf("%q is not a DM room", room)
+		}
+	}
+}
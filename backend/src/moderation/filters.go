@@ -0,0 +1,204 @@
+package moderation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"src/models"
+)
+
+// stars masks a match with one asterisk per character.
+func stars(s string) string { return strings.Repeat("*", utf8.RuneCountInString(s)) }
+
+// Blocklist matches whole words from a list, ignoring case.
+type Blocklist struct {
+	re *regexp.Regexp
+}
+
+func NewBlocklist(words []string) (*Blocklist, error) {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil, fmt.Errorf("words required")
+	}
+	return &Blocklist{re: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)}, nil
+}
+
+func (b *Blocklist) Match(msg models.Message, _ string, _ time.Time) (string, bool) {
+	if b.re.MatchString(msg.Content) {
+		return "contains a blocked word", true
+	}
+	return "", false
+}
+
+func (b *Blocklist) Mask(content string) string { return b.re.ReplaceAllStringFunc(content, stars) }
+
+// Regex matches any of a list of regular expressions (RE2 syntax).
+type Regex struct {
+	res []*regexp.Regexp
+}
+
+func NewRegex(patterns []string) (*Regex, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("patterns required")
+	}
+	r := &Regex{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", p, err)
+		}
+		r.res = append(r.res, re)
+	}
+	return r, nil
+}
+
+func (r *Regex) Match(msg models.Message, _ string, _ time.Time) (string, bool) {
+	for _, re := range r.res {
+		if re.MatchString(msg.Content) {
+			return "matches " + re.String(), true
+		}
+	}
+	return "", false
+}
+
+func (r *Regex) Mask(content string) string {
+	for _, re := range r.res {
+		content = re.ReplaceAllStringFunc(content, stars)
+	}
+	return content
+}
+
+// linkPattern finds URLs and bare www. hosts, including those inside markdown links.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>()\[\]"']+`)
+
+// linkRemoved replaces masked links.
+const linkRemoved = "[link removed]"
+
+// Links matches links to hosts outside an allow-list; subdomains of an allowed domain are allowed.
+type Links struct {
+	allow []string
+}
+
+func NewLinks(allowDomains []string) *Links {
+	l := &Links{}
+	for _, d := range allowDomains {
+		if d = strings.ToLower(strings.Trim(strings.TrimSpace(d), ".")); d != "" {
+			l.allow = append(l.allow, d)
+		}
+	}
+	return l
+}
+
+func (l *Links) Match(msg models.Message, _ string, _ time.Time) (string, bool) {
+	for _, link := range linkPattern.FindAllString(msg.Content, -1) {
+		if host := linkHost(link); !l.allowed(host) {
+			return "link to " + host, true
+		}
+	}
+	return "", false
+}
+
+func (l *Links) Mask(content string) string {
+	return linkPattern.ReplaceAllStringFunc(content, func(link string) string {
+		if l.allowed(linkHost(link)) {
+			return link
+		}
+		return linkRemoved
+	})
+}
+
+func (l *Links) allowed(host string) bool {
+	for _, d := range l.allow {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Spam matches a sender submitting the same content (ignoring case and spacing) repeats times
+// within window, counting the current message. It counts per authenticated sender, never per the
+// author named in the message, so a client cannot spread repeats over made-up user IDs. History is
+// kept per replica and lost on reload of a changed configuration.
+type Spam struct {
+	repeats   int
+	window    time.Duration
+	mu        sync.Mutex
+	seen      map[string][]sent
+	lastSweep time.Time
+}
+
+type sent struct {
+	content string
+	at      time.Time
+}
+
+func NewSpam(repeats int, window time.Duration) (*Spam, error) {
+	if repeats < 2 {
+		return nil, fmt.Errorf("repeats must be at least 2")
+	}
+	return &Spam{repeats: repeats, window: window, seen: make(map[string][]sent)}, nil
+}
+
+func (s *Spam) Match(msg models.Message, sender string, now time.Time) (string, bool) {
+	content := strings.ToLower(strings.Join(strings.Fields(msg.Content), " "))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	recent := s.recent(sender, now)
+	count := 1
+	for _, m := range recent {
+		if m.content == content {
+			count++
+		}
+	}
+	s.seen[sender] = append(recent, sent{content: content, at: now})
+	if count >= s.repeats {
+		return fmt.Sprintf("repeated %d times within %s", count, s.window), true
+	}
+	return "", false
+}
+
+// recent drops the user's messages older than the window.
+func (s *Spam) recent(user string, now time.Time) []sent {
+	list := s.seen[user]
+	i := 0
+	for i < len(list) && now.Sub(list[i].at) >= s.window {
+		i++
+	}
+	return list[i:]
+}
+
+// sweep forgets users with nothing inside the window, at most once per window.
+func (s *Spam) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.window {
+		return
+	}
+	s.lastSweep = now
+	for user := range s.seen {
+		if len(s.recent(user, now)) == 0 {
+			delete(s.seen, user)
+		}
+	}
+}
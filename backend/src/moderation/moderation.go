@@ -0,0 +1,204 @@
+// Package moderation checks messages at ingest against a chain of filters.
+//
+// Each rule pairs a Filter with an action: reject refuses the message, mask hides the offending
+// parts and lets it through, flag holds it for a moderator's review. Rules run in order; a reject
+// stops the chain, masks apply to the content seen by later rules. The chain is compiled from a
+// models.ModerationConfig and an Engine swaps in new configurations at runtime.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"src/logger"
+	"src/models"
+)
+
+// Filter inspects a message. Match reports why the message trips the filter, if it does. sender
+// is the authenticated principal that submitted it, which may differ from msg.UserID.
+type Filter interface {
+	Match(msg models.Message, sender string, now time.Time) (reason string, ok bool)
+}
+
+// Masker is implemented by filters that can hide the parts of a message they match.
+type Masker interface {
+	Mask(content string) string
+}
+
+// Rule applies Action to messages matched by Filter. Name identifies the filter in reasons.
+type Rule struct {
+	Name   string
+	Filter Filter
+	Action string
+}
+
+// Verdict is the outcome of a chain. Action is models.ActionReject, models.ActionFlag,
+// models.ActionMask or "" (allow); Content is the message content after masking. Reasons lists
+// the matching rules as "<filter>: <reason>".
+type Verdict struct {
+	Action  string
+	Content string
+	Reasons []string
+}
+
+// Chain is an ordered list of rules. The zero Chain allows everything.
+type Chain struct {
+	rules []Rule
+}
+
+func NewChain(rules ...Rule) *Chain { return &Chain{rules: rules} }
+
+// Check runs msg, submitted by sender, through the chain.
+func (c *Chain) Check(msg models.Message, sender string, now time.Time) Verdict {
+	v := Verdict{Content: msg.Content}
+	var masked, flagged bool
+	for _, r := range c.rules {
+		msg.Content = v.Content
+		reason, ok := r.Filter.Match(msg, sender, now)
+		if !ok {
+			continue
+		}
+		v.Reasons = append(v.Reasons, r.Name+": "+reason)
+		switch r.Action {
+		case models.ActionReject:
+			return Verdict{Action: models.ActionReject, Content: v.Content, Reasons: v.Reasons[len(v.Reasons)-1:]}
+		case models.ActionMask:
+			if m, ok := r.Filter.(Masker); ok {
+				v.Content, masked = m.Mask(v.Content), true
+			}
+		case models.ActionFlag:
+			flagged = true
+		}
+	}
+	switch {
+	case flagged:
+		v.Action = models.ActionFlag
+	case masked:
+		v.Action = models.ActionMask
+	}
+	return v
+}
+
+// Compile builds the chain described by cfg, rejecting unknown filters or actions and rules
+// missing their settings.
+func Compile(cfg models.ModerationConfig) (*Chain, error) {
+	rules := make([]Rule, 0, len(cfg.Rules))
+	for i, rc := range cfg.Rules {
+		f, err := newFilter(rc)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, rc.Filter, err)
+		}
+		switch rc.Action {
+		case models.ActionReject, models.ActionFlag:
+		case models.ActionMask:
+			if _, ok := f.(Masker); !ok {
+				return nil, fmt.Errorf("rule %d (%s): filter cannot mask", i+1, rc.Filter)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d (%s): unknown action %q", i+1, rc.Filter, rc.Action)
+		}
+		rules = append(rules, Rule{Name: rc.Filter, Filter: f, Action: rc.Action})
+	}
+	return NewChain(rules...), nil
+}
+
+func newFilter(rc models.ModerationRule) (Filter, error) {
+	switch rc.Filter {
+	case models.FilterBlocklist:
+		return NewBlocklist(rc.Words)
+	case models.FilterRegex:
+		return NewRegex(rc.Patterns)
+	case models.FilterLinks:
+		return NewLinks(rc.AllowDomains), nil
+	case models.FilterSpam:
+		window, err := time.ParseDuration(rc.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("window must be a positive duration")
+		}
+		return NewSpam(rc.Repeats, window)
+	default:
+		return nil, fmt.Errorf("unknown filter")
+	}
+}
+
+// Source loads the stored configuration (implemented by store.ModerationAdapter).
+type Source interface {
+	GetModerationConfig(ctx context.Context) (models.ModerationConfig, error)
+}
+
+// Engine holds the active chain and reloads it from a Source. Checks never block on a reload.
+type Engine struct {
+	src      Source
+	interval time.Duration
+	chain    atomic.Pointer[Chain]
+	mu       sync.Mutex
+	// version is the UpdatedAt of the active configuration; an unchanged configuration is not
+	// recompiled, so stateful filters (spam) keep their history.
+	version time.Time
+}
+
+// NewEngine returns an engine with an empty chain that reloads from src every interval.
+func NewEngine(src Source, interval time.Duration) *Engine {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	e := &Engine{src: src, interval: interval}
+	e.chain.Store(NewChain())
+	return e
+}
+
+// Check runs msg, submitted by sender, through the active chain.
+func (e *Engine) Check(msg models.Message, sender string, now time.Time) Verdict {
+	return e.chain.Load().Check(msg, sender, now)
+}
+
+// Apply compiles cfg and makes it the active chain; on error the active chain is kept.
+func (e *Engine) Apply(cfg models.ModerationConfig) error {
+	chain, err := Compile(cfg)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.chain.Store(chain)
+	e.version = cfg.UpdatedAt
+	return nil
+}
+
+// Reload applies the stored configuration if it changed since the last load.
+func (e *Engine) Reload(ctx context.Context) error {
+	cfg, err := e.src.GetModerationConfig(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	unchanged := !e.version.IsZero() && cfg.UpdatedAt.Equal(e.version)
+	e.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	if err := e.Apply(cfg); err != nil {
+		return err
+	}
+	logger.Info("moderation config loaded", logger.FieldKV("rules", len(cfg.Rules)), logger.FieldKV("updated_at", cfg.UpdatedAt))
+	return nil
+}
+
+// Run reloads the configuration every interval until ctx is canceled.
+func (e *Engine) Run(ctx context.Context) {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		if err := e.Reload(ctx); err != nil {
+			logger.Error("moderation config reload", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"src/models"
+)
+
+func mustCompile(t *testing.T, rules ...models.ModerationRule) *Chain {
+	t.Helper()
+	c, err := Compile(models.ModerationConfig{Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestChainActions(t *testing.T) {
+	now := time.Now()
+	c := mustCompile(t,
+		models.ModerationRule{Filter: models.FilterBlocklist, Action: models.ActionMask, Words: []string{"darn", "heck"}},
+		models.ModerationRule{Filter: models.FilterLinks, Action: models.ActionFlag, AllowDomains: []string{"example.com"}},
+		models.ModerationRule{Filter: models.FilterRegex, Action: models.ActionReject, Patterns: []string{`\b\d{4}-\d{4}-\d{4}-\d{4}\b`}},
+	)
+	cases := []struct {
+		content, action, want string
+	}{
+		{"hello", "", "hello"},
+		{"Darn it, HECK", models.ActionMask, "**** it, ****"},
+		{"darnit", "", "darnit"},
+		{"see https://docs.example.com/x and www.example.com", "", "see https://docs.example.com/x and www.example.com"},
+		{"darn, see [this](https://evil.test/a)", models.ActionFlag, "****, see [this](https://evil.test/a)"},
+		{"card 1234-5678-9012-3456", models.ActionReject, "card 1234-5678-9012-3456"},
+	}
+	for _, tc := range cases {
+		v := c.Check(models.Message{UserID: "u", Content: tc.content}, "u", now)
+		if v.Action != tc.action || v.Content != tc.want {
+			t.Errorf("%q: got %q %q %v", tc.content, v.Action, v.Content, v.Reasons)
+		}
+	}
+	v := c.Check(models.Message{Content: "heck https://evil.test 1234-5678-9012-3456"}, "u", now)
+	if len(v.Reasons) != 1 || !strings.HasPrefix(v.Reasons[0], "regex: ") {
+		t.Fatalf("reject should report only its own reason: %v", v.Reasons)
+	}
+}
+
+func TestLinksMask(t *testing.T) {
+	l := NewLinks([]string{"example.com"})
+	got := l.Mask("a https://example.com/ok b http://bad.test/x c www.other.test")
+	if got != "a https://example.com/ok b [link removed] c [link removed]" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSpam(t *testing.T) {
+	c := mustCompile(t, models.ModerationRule{Filter: models.FilterSpam, Action: models.ActionReject, Repeats: 3, Window: "1m"})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	send := func(user, content string, at time.Time) string {
+		return c.Check(models.Message{UserID: user, Content: content}, user, at).Action
+	}
+	if send("a", "buy now", now) != "" || send("a", "Buy   NOW", now.Add(time.Second)) != "" {
+		t.Fatalf("first two repeats must pass")
+	}
+	if send("b", "buy now", now.Add(2*time.Second)) != "" {
+		t.Fatalf("other users are counted separately")
+	}
+	if send("a", "buy now", now.Add(3*time.Second)) != models.ActionReject {
+		t.Fatalf("third repeat must be rejected")
+	}
+	if send("a", "buy now", now.Add(2*time.Minute)) != "" {
+		t.Fatalf("repeats outside the window must pass")
+	}
+}
+
+func TestSpamCountsSender(t *testing.T) {
+	c := mustCompile(t, models.ModerationRule{Filter: models.FilterSpam, Action: models.ActionReject, Repeats: 3, Window: "1m"})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, author := range []string{"x", "", "y"} {
+		v := c.Check(models.Message{UserID: author, Content: "buy now"}, "mallory", now.Add(time.Duration(i)*time.Second))
+		if (i == 2) != (v.Action == models.ActionReject) {
+			t.Fatalf("repeat %d with user_id %q: got %q", i+1, author, v.Action)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		v := c.Check(models.Message{Content: "hello"}, "", now.Add(time.Duration(i)*time.Second))
+		if (i == 2) != (v.Action == models.ActionReject) {
+			t.Fatalf("an empty sender must still be tracked, repeat %d got %q", i+1, v.Action)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	bad := []models.ModerationRule{
+		{Filter: "nope", Action: models.ActionReject},
+		{Filter: models.FilterBlocklist, Action: models.ActionReject},
+		{Filter: models.FilterBlocklist, Action: "delete", Words: []string{"x"}},
+		{Filter: models.FilterRegex, Action: models.ActionReject, Patterns: []string{"("}},
+		{Filter: models.FilterSpam, Action: models.ActionMask, Repeats: 3, Window: "1m"},
+		{Filter: models.FilterSpam, Action: models.ActionFlag, Repeats: 1, Window: "1m"},
+		{Filter: models.FilterSpam, Action: models.ActionFlag, Repeats: 3},
+	}
+	for _, rule := range bad {
+		if _, err := Compile(models.ModerationConfig{Rules: []models.ModerationRule{rule}}); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+}
+
+type fakeSource struct{ cfg models.ModerationConfig }
+
+func (f *fakeSource) GetModerationConfig(ctx context.Context) (models.ModerationConfig, error) {
+	return f.cfg, nil
+}
+
+func TestEngineReload(t *testing.T) {
+	src := &fakeSource{}
+	e := NewEngine(src, time.Minute)
+	msg := models.Message{UserID: "u", Content: "spam spam"}
+	if v := e.Check(msg, "u", time.Now()); v.Action != "" {
+		t.Fatalf("empty engine must allow, got %q", v.Action)
+	}
+
+	src.cfg = models.ModerationConfig{UpdatedAt: time.Unix(1, 0), Rules: []models.ModerationRule{
+		{Filter: models.FilterSpam, Action: models.ActionFlag, Repeats: 2, Window: "1h"},
+	}}
+	if err := e.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	e.Check(msg, "u", now)
+	// An unchanged configuration keeps the spam history.
+	if err := e.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := e.Check(msg, "u", now); v.Action != models.ActionFlag {
+		t.Fatalf("expected flag after reload of the same config, got %q", v.Action)
+	}
+
+	src.cfg = models.ModerationConfig{UpdatedAt: time.Unix(2, 0), Rules: []models.ModerationRule{{Filter: "bogus"}}}
+	if err := e.Reload(context.Background()); err == nil {
+		t.Fatalf("invalid config must fail to load")
+	}
+	if v := e.Check(msg, "u", now); v.Action != models.ActionFlag {
+		t.Fatalf("failed reload must keep the active chain, got %q", v.Action)
+	}
+}
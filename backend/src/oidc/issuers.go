@@ -0,0 +1,190 @@
+package oidcutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"src/config"
+	"src/models"
+)
+
+// IssuerConfig is one trusted issuer and the audiences accepted from it, plus how its claims
+// map onto models.Identity. Empty claim names fall back to the defaults of IdentityFromToken.
+type IssuerConfig struct {
+	Issuer string `json:"issuer"`
+	// ClientID, when set, must appear in "aud" (go-oidc check); empty skips that check.
+	ClientID string `json:"client_id,omitempty"`
+	// Audiences accepted in "aud" (string or array); a token must carry at least one of them.
+	Audiences   []string `json:"audiences,omitempty"`
+	GroupsClaim string   `json:"groups_claim,omitempty"`
+	NameClaim   string   `json:"name_claim,omitempty"`
+	EmailClaim  string   `json:"email_claim,omitempty"`
+	// PictureClaim holds the avatar URL (default "picture").
+	PictureClaim string `json:"picture_claim,omitempty"`
+	// Optional issuers do not gate readiness; tokens from them fail until they are discovered.
+	Optional bool `json:"optional,omitempty"`
+	// GlobalRoles lets the groups of a secondary issuer map to ADMIN_GROUPS / MODERATOR_GROUPS.
+	// Groups of the primary (first) issuer always do.
+	GlobalRoles bool `json:"global_roles,omitempty"`
+
+	// subjectPrefix namespaces the subjects of secondary issuers; empty for the primary one.
+	subjectPrefix string
+}
+
+// subjectPrefix is the namespace of a secondary issuer's subjects, so that it cannot mint tokens for
+// users of another issuer.
+func subjectPrefix(issuer string) string { return issuer + "#" }
+
+// ErrUntrustedIssuer is returned for tokens whose "iss" is not configured.
+type ErrUntrustedIssuer struct{ Issuer string }
+
+func (e ErrUntrustedIssuer) Error() string { return "untrusted issuer: " + e.Issuer }
+
+// ParseIssuers reads OIDC_TRUSTED_ISSUERS (a JSON array of IssuerConfig). An empty value yields the
+// single Dex issuer from DEX_ISSUER_URL / DEX_CLIENT_ID / DEX_AUDIENCE / ROLE_CLAIM.
+func ParseIssuers(raw string) ([]IssuerConfig, error) {
+	if strings.TrimSpace(raw) == "" {
+		return []IssuerConfig{{Issuer: config.DexIssuer, ClientID: config.ClientID, Audiences: []string{config.Audience}, GroupsClaim: config.RoleClaim}}, nil
+	}
+	var out []IssuerConfig
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("parse trusted issuers: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, c := range out {
+		if c.Issuer == "" {
+			return nil, errors.New("parse trusted issuers: issuer is required")
+		}
+		if c.ClientID == "" && len(c.Audiences) == 0 {
+			return nil, fmt.Errorf("parse trusted issuers: %s needs client_id or audiences", c.Issuer)
+		}
+		if seen[c.Issuer] {
+			return nil, fmt.Errorf("parse trusted issuers: duplicate issuer %s", c.Issuer)
+		}
+		seen[c.Issuer] = true
+	}
+	if len(out) == 0 {
+		return nil, errors.New("parse trusted issuers: empty list")
+	}
+	return out, nil
+}
+
+// Registry routes tokens to the Manager of the issuer named in their "iss" claim.
+type Registry struct {
+	managers map[string]*Manager
+	order    []*Manager
+}
+
+// NewRegistry creates one Manager per trusted issuer. The first issuer is the primary one whose
+// subjects are user IDs as they are; the others' are prefixed with their issuer.
+func NewRegistry(issuers []IssuerConfig) *Registry {
+	r := &Registry{managers: make(map[string]*Manager, len(issuers))}
+	for i, c := range issuers {
+		if i > 0 {
+			c.subjectPrefix = subjectPrefix(c.Issuer)
+		}
+		m := NewManager(c)
+		r.managers[c.Issuer] = m
+		r.order = append(r.order, m)
+	}
+	return r
+}
+
+// SetIntervals applies refresh and retry intervals to every manager.
+func (r *Registry) SetIntervals(refresh, retry time.Duration) {
+	for _, m := range r.order {
+		m.RefreshInterval = refresh
+		m.RetryInterval = retry
+	}
+}
+
+// Start begins background discovery for every issuer.
+func (r *Registry) Start(ctx context.Context) {
+	for _, m := range r.order {
+		m.Start(ctx)
+	}
+}
+
+// Ready returns nil when every non-optional issuer is discovered.
+func (r *Registry) Ready() error {
+	for _, m := range r.order {
+		if m.cfg.Optional {
+			continue
+		}
+		if err := m.Ready(); err != nil {
+			return fmt.Errorf("%s: %w", m.cfg.Issuer, err)
+		}
+	}
+	return nil
+}
+
+// Manager returns the manager for issuer, if trusted.
+func (r *Registry) Manager(issuer string) (*Manager, bool) {
+	m, ok := r.managers[issuer]
+	return m, ok
+}
+
+func (r *Registry) route(raw string) (*Manager, error) {
+	iss, err := tokenIssuer(raw)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := r.managers[iss]
+	if !ok {
+		return nil, ErrUntrustedIssuer{Issuer: iss}
+	}
+	return m, nil
+}
+
+// VerifyToken verifies raw with its issuer's keys and audiences.
+func (r *Registry) VerifyToken(ctx context.Context, raw string) (*coreoidc.IDToken, error) {
+	m, err := r.route(raw)
+	if err != nil {
+		return nil, err
+	}
+	return m.VerifyToken(ctx, raw)
+}
+
+// Verifier adapts the registry to api.TokenVerifier / api.IdentityVerifier.
+func (r *Registry) Verifier() *Verifier {
+	return &Verifier{
+		Fn: func(ctx context.Context, raw string) error {
+			_, err := r.VerifyToken(ctx, raw)
+			return err
+		},
+		IdentityFn: func(ctx context.Context, raw string) (models.Identity, error) {
+			m, err := r.route(raw)
+			if err != nil {
+				return models.Identity{}, err
+			}
+			return m.Identity(ctx, raw)
+		},
+	}
+}
+
+// tokenIssuer reads "iss" from an unverified JWT payload; it only selects which issuer's keys
+// verify the token, and go-oidc re-checks it against the discovered issuer.
+func tokenIssuer(raw string) (string, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	return claims.Iss, nil
+}
@@ -0,0 +1,143 @@
+package oidcutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"src/config"
+	"src/models"
+	"src/oidc/oidctest"
+)
+
+func TestParseIssuers(t *testing.T) {
+	def, err := ParseIssuers("")
+	if err != nil || len(def) != 1 || def[0].Issuer != config.DexIssuer || def[0].Audiences[0] != config.Audience {
+		t.Fatalf("unexpected default issuers %+v (%v)", def, err)
+	}
+	got, err := ParseIssuers(`[{"issuer":"https://dex","client_id":"backend"},{"issuer":"https://bots","audiences":["chat-api"],"groups_claim":"roles","optional":true,"global_roles":true}]`)
+	if err != nil || len(got) != 2 || got[1].GroupsClaim != "roles" || !got[1].Optional || !got[1].GlobalRoles {
+		t.Fatalf("unexpected issuers %+v (%v)", got, err)
+	}
+	for _, bad := range []string{
+		`not json`,
+		`[]`,
+		`[{"client_id":"x"}]`,
+		`[{"issuer":"https://a"}]`,
+		`[{"issuer":"https://a","client_id":"x"},{"issuer":"https://a","client_id":"y"}]`,
+	} {
+		if _, err := ParseIssuers(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRegistryRoutesByIssuer(t *testing.T) {
+	dex := oidctest.New("backend")
+	defer dex.Close()
+	bots := oidctest.New("chat-api")
+	defer bots.Close()
+	stranger := oidctest.New("backend")
+	defer stranger.Close()
+	useIssuer(t, dex)
+
+	reg := NewRegistry([]IssuerConfig{
+		{Issuer: dex.URL, ClientID: "backend", Audiences: []string{"backend"}, GroupsClaim: "groups"},
+		{Issuer: bots.URL, Audiences: []string{"chat-api"}, GroupsClaim: "roles", NameClaim: "client_name", GlobalRoles: true},
+	})
+	ctx := context.Background()
+	for _, iss := range []string{dex.URL, bots.URL} {
+		m, _ := reg.Manager(iss)
+		if err := m.Refresh(ctx); err != nil {
+			t.Fatalf("discover %s: %v", iss, err)
+		}
+	}
+	v := reg.Verifier()
+
+	human, err := v.VerifyIdentity(ctx, dex.Token("alice", oidctest.Claims{"groups": []string{"staff"}, "name": "Alice", "picture": "https://img.example/a.png"}))
+	if err != nil || human.Issuer != dex.URL || human.Subject != "alice" || human.Name != "Alice" || human.Picture != "https://img.example/a.png" || len(human.Groups) != 1 || human.Groups[0] != "staff" {
+		t.Fatalf("unexpected human identity %+v (%v)", human, err)
+	}
+	bot, err := v.VerifyIdentity(ctx, bots.Token("deploy-bot", oidctest.Claims{"aud": []string{"other", "chat-api"}, "roles": "chat-moderators", "client_name": "Deploy Bot"}))
+	if err != nil || bot.Issuer != bots.URL || bot.Subject != bots.URL+"#deploy-bot" || bot.Name != "Deploy Bot" || len(bot.Groups) != 1 || bot.Groups[0] != "chat-moderators" {
+		t.Fatalf("unexpected bot identity %+v (%v)", bot, err)
+	}
+
+	rejected := map[string]string{
+		"audience of another issuer": bots.Token("deploy-bot", oidctest.Claims{"aud": "backend"}),
+		"array without trusted aud":  dex.Token("alice", oidctest.Claims{"aud": []string{"a", "b"}}),
+		"issuer claim forged":        bots.Token("alice", oidctest.Claims{"iss": dex.URL, "aud": "backend"}),
+		"untrusted issuer":           stranger.Token("alice", nil),
+		"not a jwt":                  "garbage",
+	}
+	for name, tok := range rejected {
+		if err := v.Verify(ctx, tok); err == nil {
+			t.Errorf("%s: expected rejection", name)
+		}
+	}
+	if err := v.Verify(ctx, stranger.Token("alice", nil)); !errors.As(err, &ErrUntrustedIssuer{}) {
+		t.Fatalf("expected ErrUntrustedIssuer got %v", err)
+	}
+}
+
+func TestSecondaryIssuerCannotImpersonate(t *testing.T) {
+	dex := oidctest.New("backend")
+	defer dex.Close()
+	partner := oidctest.New("chat-api")
+	defer partner.Close()
+	useIssuer(t, dex)
+
+	reg := NewRegistry([]IssuerConfig{
+		{Issuer: dex.URL, Audiences: []string{"backend"}, GroupsClaim: "groups"},
+		{Issuer: partner.URL, Audiences: []string{"chat-api"}, GroupsClaim: "groups"},
+	})
+	ctx := context.Background()
+	for _, iss := range []string{dex.URL, partner.URL} {
+		m, _ := reg.Manager(iss)
+		if err := m.Refresh(ctx); err != nil {
+			t.Fatalf("discover %s: %v", iss, err)
+		}
+	}
+	id, err := reg.Verifier().VerifyIdentity(ctx, partner.Token("alice", oidctest.Claims{"groups": []string{"chat-admins"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject == "alice" || id.Subject != partner.URL+"#alice" {
+		t.Fatalf("secondary issuer took over a primary subject: %q", id.Subject)
+	}
+	if len(id.Groups) != 0 {
+		t.Fatalf("secondary issuer groups should not map to global roles without global_roles: %v", id.Groups)
+	}
+}
+
+func TestRegistryReadyIgnoresOptionalIssuers(t *testing.T) {
+	dex := oidctest.New("backend")
+	defer dex.Close()
+	reg := NewRegistry([]IssuerConfig{
+		{Issuer: dex.URL, Audiences: []string{"backend"}},
+		{Issuer: "http://127.0.0.1:1/unreachable", Audiences: []string{"chat-api"}, Optional: true},
+	})
+	if err := reg.Ready(); !errors.Is(err, models.ErrAuthUnavailable) {
+		t.Fatalf("expected not ready before discovery, got %v", err)
+	}
+	m, _ := reg.Manager(dex.URL)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Ready(); err != nil {
+		t.Fatalf("optional issuer gated readiness: %v", err)
+	}
+}
+
+func TestVerifyTokenArrayAudience(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	_, verifier, err := Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyToken(context.Background(), verifier, iss.Token("u1", oidctest.Claims{"aud": []string{"other", config.Audience}})); err != nil {
+		t.Fatalf("array audience containing %s rejected: %v", config.Audience, err)
+	}
+}
@@ -0,0 +1,189 @@
+package oidcutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"src/logger"
+	"src/metrics"
+	"src/models"
+)
+
+// Manager owns the provider and verifier for one issuer. Discovery runs in the background so
+// the process starts without a reachable issuer (tokens are refused with models.ErrAuthUnavailable
+// until it succeeds). Once ready the provider is re-discovered periodically, and in the background
+// when a token is signed by a key the JWKS does not have, so changed metadata is picked up without
+// a restart. A failed re-discovery keeps the last good verifier.
+type Manager struct {
+	cfg IssuerConfig
+	// RefreshInterval is the period between re-discoveries once ready.
+	RefreshInterval time.Duration
+	// RetryInterval is the pause after a full backoff cycle failed.
+	RetryInterval time.Duration
+	// MinRefreshInterval rate limits on-demand re-discovery triggered by unknown signing keys.
+	MinRefreshInterval time.Duration
+
+	mu          sync.RWMutex
+	verifier    *coreoidc.IDTokenVerifier
+	lastErr     error
+	lastRefresh time.Time
+	// refreshQueued is set while an on-demand re-discovery runs.
+	refreshQueued bool
+	refreshing    sync.Mutex
+	discover      func(ctx context.Context, issuer string) (*coreoidc.Provider, error)
+}
+
+// NewManager returns a Manager for one trusted issuer; call Start to begin discovery.
+func NewManager(cfg IssuerConfig) *Manager {
+	return &Manager{
+		cfg:                cfg,
+		RefreshInterval:    time.Hour,
+		RetryInterval:      30 * time.Second,
+		MinRefreshInterval: 30 * time.Second,
+		lastErr:            errors.New("discovery pending"),
+		discover:           initProviderWithBackoff,
+	}
+}
+
+// Start runs discovery and periodic re-discovery until ctx is canceled.
+func (m *Manager) Start(ctx context.Context) {
+	go m.run(ctx)
+}
+
+func (m *Manager) run(ctx context.Context) {
+	for m.Ready() != nil {
+		if err := m.Refresh(ctx); err != nil {
+			logger.Error("oidc discovery failed; auth degraded", err, logger.FieldKV("issuer", m.cfg.Issuer), logger.FieldKV("retry_in", m.RetryInterval.String()))
+			select {
+			case <-time.After(m.RetryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	t := time.NewTicker(m.RefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := m.Refresh(ctx); err != nil {
+				logger.Error("oidc re-discovery failed; keeping previous keys", err, logger.FieldKV("issuer", m.cfg.Issuer))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh re-runs discovery and swaps in a verifier with a fresh key set on success.
+func (m *Manager) Refresh(ctx context.Context) error {
+	m.refreshing.Lock()
+	defer m.refreshing.Unlock()
+	p, err := m.discover(ctx, m.cfg.Issuer)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRefresh = time.Now()
+	if err != nil {
+		metrics.IncOIDCRefresh(false)
+		if m.verifier == nil {
+			m.lastErr = err
+		}
+		return err
+	}
+	metrics.IncOIDCRefresh(true)
+	if m.verifier == nil {
+		metrics.IncOIDCReadyIssuers()
+	}
+	m.verifier = p.Verifier(&coreoidc.Config{ClientID: m.cfg.ClientID, SkipClientIDCheck: m.cfg.ClientID == ""})
+	m.lastErr = nil
+	logger.Info("oidc verifier refreshed", logger.FieldKV("issuer", m.cfg.Issuer))
+	return nil
+}
+
+// Ready returns nil once a verifier is available, else the last discovery error.
+func (m *Manager) Ready() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.verifier == nil {
+		return fmt.Errorf("%w: %v", models.ErrAuthUnavailable, m.lastErr)
+	}
+	return nil
+}
+
+// VerifyToken verifies raw with the current verifier. go-oidc's RemoteKeySet already refetches
+// the JWKS when a token names an unknown kid, so newly published keys verify at once. A token no
+// key verifies may still mean the provider moved its jwks_uri: it is refused, and a rate limited
+// re-discovery starts in the background so requests never wait on the provider.
+func (m *Manager) VerifyToken(ctx context.Context, raw string) (*coreoidc.IDToken, error) {
+	v, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+	tok, err := verifyToken(ctx, v, raw, m.cfg.Audiences)
+	if err != nil && unknownKey(err) {
+		m.refreshSoon()
+	}
+	return tok, err
+}
+
+// unknownKey reports whether verification failed because no key of the JWKS verified the
+// signature. go-oidc does not wrap that error, so its text is matched.
+func unknownKey(err error) bool {
+	return strings.Contains(err.Error(), "failed to verify id token signature")
+}
+
+// refreshSoon starts a re-discovery unless one is running or ran within MinRefreshInterval.
+func (m *Manager) refreshSoon() {
+	m.mu.Lock()
+	if m.refreshQueued || time.Since(m.lastRefresh) < m.MinRefreshInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.refreshQueued = true
+	m.mu.Unlock()
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			m.refreshQueued = false
+			m.mu.Unlock()
+		}()
+		if err := m.Refresh(context.Background()); err != nil {
+			logger.Error("oidc on-demand re-discovery failed", err, logger.FieldKV("issuer", m.cfg.Issuer))
+		}
+	}()
+}
+
+// Identity verifies raw and maps its claims with the issuer's claim configuration.
+func (m *Manager) Identity(ctx context.Context, raw string) (models.Identity, error) {
+	tok, err := m.VerifyToken(ctx, raw)
+	if err != nil {
+		return models.Identity{}, err
+	}
+	return identityFromToken(tok, m.cfg)
+}
+
+// Verifier adapts the manager to api.TokenVerifier / api.IdentityVerifier.
+func (m *Manager) Verifier() *Verifier {
+	return &Verifier{
+		Fn: func(ctx context.Context, raw string) error {
+			_, err := m.VerifyToken(ctx, raw)
+			return err
+		},
+		IdentityFn: m.Identity,
+	}
+}
+
+func (m *Manager) current() (*coreoidc.IDTokenVerifier, error) {
+	if err := m.Ready(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.verifier, nil
+}
@@ -0,0 +1,170 @@
+package oidcutil
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"src/config"
+	"src/models"
+	"src/oidc/oidctest"
+)
+
+func readyManager(t *testing.T, iss *oidctest.Issuer) *Manager {
+	t.Helper()
+	m := NewManager(IssuerConfig{Issuer: iss.URL, ClientID: config.ClientID, Audiences: []string{config.Audience}})
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("discovery: %v", err)
+	}
+	return m
+}
+
+func TestManagerStartsDegradedAndRecovers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for one backoff interval")
+	}
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	config.DexOIDCMaxAttempts = "1"
+	iss.SetAvailable(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManager(IssuerConfig{Issuer: iss.URL, ClientID: config.ClientID, Audiences: []string{config.Audience}})
+	m.RetryInterval = 50 * time.Millisecond
+	m.Start(ctx)
+
+	if err := m.Ready(); !errors.Is(err, models.ErrAuthUnavailable) {
+		t.Fatalf("expected degraded start, got %v", err)
+	}
+	if _, err := m.VerifyToken(ctx, iss.Token("u1", nil)); !errors.Is(err, models.ErrAuthUnavailable) {
+		t.Fatalf("expected ErrAuthUnavailable while degraded, got %v", err)
+	}
+	iss.SetAvailable(true)
+	deadline := time.Now().Add(10 * time.Second)
+	for m.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("manager never became ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := m.VerifyToken(ctx, iss.Token("u1", nil)); err != nil {
+		t.Fatalf("verify after recovery: %v", err)
+	}
+}
+
+func TestManagerRefreshDropsRetiredKeys(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	m := readyManager(t, iss)
+	m.MinRefreshInterval = time.Hour
+	ctx := context.Background()
+
+	old := iss.Token("u1", nil)
+	if _, err := m.VerifyToken(ctx, old); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	iss.RotateKey()
+	iss.RetireOldKeys()
+	if _, err := m.VerifyToken(ctx, iss.Token("u1", nil)); err != nil {
+		t.Fatalf("token signed with rotated key rejected: %v", err)
+	}
+	if err := m.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerifyToken(ctx, old); err == nil {
+		t.Fatal("token signed with retired key still accepted after refresh")
+	}
+}
+
+func TestManagerKeepsVerifierWhenRediscoveryFails(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	config.DexOIDCMaxAttempts = "1"
+	m := readyManager(t, iss)
+	ctx := context.Background()
+	tok := iss.Token("u1", nil)
+
+	// A provider suddenly advertising another issuer must not be trusted.
+	iss.SetAdvertisedIssuer("https://moved.example")
+	if err := m.Refresh(ctx); err == nil {
+		t.Fatal("expected issuer mismatch to fail re-discovery")
+	}
+	if err := m.Ready(); err != nil {
+		t.Fatalf("manager degraded after failed re-discovery: %v", err)
+	}
+	if _, err := m.VerifyToken(ctx, tok); err != nil {
+		t.Fatalf("previous verifier dropped: %v", err)
+	}
+	if _, err := m.VerifyToken(ctx, iss.Token("u1", nil)); err == nil {
+		t.Fatal("token from the moved issuer accepted")
+	}
+}
+
+func TestManagerRediscoversInBackgroundOnUnknownKey(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	m := readyManager(t, iss)
+	m.MinRefreshInterval = 0
+	started, release := make(chan struct{}, 4), make(chan struct{})
+	discover := m.discover
+	m.discover = func(ctx context.Context, issuer string) (*coreoidc.Provider, error) {
+		started <- struct{}{}
+		<-release
+		return discover(ctx, issuer)
+	}
+	ctx := context.Background()
+
+	// Expired tokens and foreign audiences are refused without asking the provider.
+	for _, tok := range []string{
+		iss.Token("u1", oidctest.Claims{"exp": time.Now().Add(-time.Hour).Unix()}),
+		iss.Token("u1", oidctest.Claims{"aud": "someone-else"}),
+	} {
+		if _, err := m.VerifyToken(ctx, tok); err == nil {
+			t.Fatal("expected the token to be refused")
+		}
+	}
+	select {
+	case <-started:
+		t.Fatal("re-discovery started for a failure a new key set cannot fix")
+	default:
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := iss.TokenWithKey(other, "unpublished", "u1", nil)
+	done := make(chan error, 1)
+	go func() { _, err := m.VerifyToken(ctx, unknown); done <- err }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("token signed by an unpublished key accepted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification waited for re-discovery")
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unknown key did not start a re-discovery")
+	}
+	// While it runs, further unknown keys do not queue more.
+	_, _ = m.VerifyToken(ctx, unknown)
+	close(release)
+	select {
+	case <-started:
+		t.Fatal("a second re-discovery started while one was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"log"
+	"fmt"
 	"math"
 	"net"
 	"net/http"
@@ -19,35 +19,112 @@ import (
 	"src/config"
 	"src/logger"
 	"src/metrics"
+	"src/models"
 )
 
 // Init initializes the OIDC provider (with backoff, fallback, optional dial override) and returns the provider & verifier.
-func Init(ctx context.Context) (*coreoidc.Provider, *coreoidc.IDTokenVerifier) {
-	p := initProviderWithBackoff(ctx, config.DexIssuer)
-	verifier := p.Verifier(&coreoidc.Config{ClientID: config.ClientID})
-	return p, verifier
+// It blocks until discovery succeeds or every attempt failed; use a Manager to keep retrying in the background.
+func Init(ctx context.Context) (*coreoidc.Provider, *coreoidc.IDTokenVerifier, error) {
+	p, err := initProviderWithBackoff(ctx, config.DexIssuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, p.Verifier(&coreoidc.Config{ClientID: config.ClientID}), nil
 }
 
 // VerifyToken verifies a raw token using the provided verifier and validates audience & expiration.
 func VerifyToken(ctx context.Context, verifier *coreoidc.IDTokenVerifier, raw string) (*coreoidc.IDToken, error) {
+	return verifyToken(ctx, verifier, raw, []string{config.Audience})
+}
+
+// verifyToken is VerifyToken accepting any of audiences. The "aud" claim may be a string or an
+// array; it is accepted when any of its values is trusted. Empty audiences skip the check.
+func verifyToken(ctx context.Context, verifier *coreoidc.IDTokenVerifier, raw string, audiences []string) (*coreoidc.IDToken, error) {
 	tok, err := verifier.Verify(ctx, raw)
 	if err != nil {
 		return nil, err
 	}
-	var claims struct {
-		Aud string `json:"aud"`
-		Exp int64  `json:"exp"`
+	if len(audiences) > 0 && !audienceAllowed(tok.Audience, audiences) {
+		return nil, ErrInvalidAudience{Expected: strings.Join(audiences, ","), Got: strings.Join(tok.Audience, ",")}
+	}
+	if tok.Expiry.IsZero() || time.Now().After(tok.Expiry) {
+		return nil, ErrTokenExpired{}
+	}
+	return tok, nil
+}
+
+func audienceAllowed(got, trusted []string) bool {
+	for _, a := range got {
+		for _, t := range trusted {
+			if a == t {
+				return true
+			}
+		}
 	}
+	return false
+}
+
+// IdentityFromToken extracts the caller identity from a verified token. Groups are read from
+// the claim named by config.RoleClaim, which may hold a string array or a single string.
+func IdentityFromToken(tok *coreoidc.IDToken) (models.Identity, error) {
+	return identityFromToken(tok, IssuerConfig{})
+}
+
+// identityFromToken maps claims using cfg's claim names, defaulting to config.RoleClaim for
+// groups, name/preferred_username for the display name, email and picture. Subjects of secondary
+// issuers get their namespace, and their groups are dropped unless cfg.GlobalRoles.
+func identityFromToken(tok *coreoidc.IDToken, cfg IssuerConfig) (models.Identity, error) {
+	var claims map[string]interface{}
 	if err := tok.Claims(&claims); err != nil {
-		return nil, err
+		return models.Identity{}, err
 	}
-	if claims.Aud != config.Audience {
-		return nil, ErrInvalidAudience{Expected: config.Audience, Got: claims.Aud}
+	str := func(key string) string {
+		v, _ := claims[key].(string)
+		return v
 	}
-	if time.Now().Unix() > claims.Exp {
-		return nil, ErrTokenExpired{}
+	var name string
+	if cfg.NameClaim != "" {
+		name = str(cfg.NameClaim)
+	} else if name = str("name"); name == "" {
+		name = str("preferred_username")
 	}
-	return tok, nil
+	emailClaim := cfg.EmailClaim
+	if emailClaim == "" {
+		emailClaim = "email"
+	}
+	pictureClaim := cfg.PictureClaim
+	if pictureClaim == "" {
+		pictureClaim = "picture"
+	}
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = config.RoleClaim
+	}
+	groups := claimStrings(claims[groupsClaim])
+	if cfg.subjectPrefix != "" && !cfg.GlobalRoles {
+		groups = nil
+	}
+	return models.Identity{Issuer: tok.Issuer, Subject: cfg.subjectPrefix + tok.Subject, Name: name, Email: str(emailClaim), Picture: str(pictureClaim), Groups: groups, ExpiresAt: tok.Expiry}, nil
+}
+
+// claimStrings normalizes a claim holding a string or an array of strings.
+func claimStrings(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return nil
+		}
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
 }
 
 // AuthMiddleware returns an HTTP middleware enforcing Bearer token auth.
@@ -83,7 +160,7 @@ type ErrTokenExpired struct{}
 func (e ErrTokenExpired) Error() string { return "token expired" }
 
 // Internal backoff + fallback logic (moved from main)
-func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provider {
+func initProviderWithBackoff(ctx context.Context, issuer string) (*coreoidc.Provider, error) {
 	var provider *coreoidc.Provider
 	var err error
 	maxAttempts := 8
@@ -91,9 +168,12 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 		maxAttempts = v
 	}
 
+	// Dial overrides, loopback detection and the internal fallback only apply to the Dex issuer;
+	// other trusted issuers are discovered directly.
+	dex := issuer == config.DexIssuer
 	var httpClient *http.Client
 	loopbackDetected := false
-	if config.DexIssuerDialOverride != "" {
+	if dex && config.DexIssuerDialOverride != "" {
 		logger.Info("using dex issuer dial override", logger.FieldKV("dial", config.DexIssuerDialOverride))
 		transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
 		baseDialer := &net.Dialer{Timeout: 5 * time.Second}
@@ -101,7 +181,7 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 			return baseDialer.DialContext(c, network, config.DexIssuerDialOverride)
 		}
 		httpClient = &http.Client{Transport: transport, Timeout: 10 * time.Second}
-	} else {
+	} else if dex {
 		// No explicit override; detect if issuer host resolves only to loopback -> switch to internal dial target
 		if u, uerr := url.Parse(issuer); uerr == nil {
 			if addrs, rerr := net.DefaultResolver.LookupHost(ctx, u.Hostname()); rerr == nil {
@@ -112,7 +192,8 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 						break
 					}
 				}
-				if loopOnly {
+				// An empty internal dial target disables the rewrite (local issuers, tests).
+				if loopOnly && config.DexIssuerInternalDial != "" {
 					loopbackDetected = true
 					metrics.IncOIDCLoopbackAutoDial()
 					internalDial := config.DexIssuerInternalDial
@@ -159,7 +240,7 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 			attemptsUsed = uint64(attempt)
 			logger.Info("oidc provider initialized", logger.FieldKV("issuer", issuer), logger.FieldKV("attempt", attempt))
 			metrics.IncOIDCPrimarySuccess(attemptsUsed)
-			return provider
+			return provider, nil
 		}
 		// Detect common misconfiguration: using https issuer while endpoint serves plain http
 		if strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
@@ -174,11 +255,11 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 			continue
 		case <-ctx.Done():
 			logger.Error("context canceled during oidc init", ctx.Err())
-			log.Fatalf("Failed to initialize OIDC provider: %v", err)
+			return nil, fmt.Errorf("initialize OIDC provider: %w", err)
 		}
 	}
 
-	if strings.EqualFold(config.DexOIDCFallbackEnabled, "true") && issuer != config.InternalDexIssuer {
+	if dex && strings.EqualFold(config.DexOIDCFallbackEnabled, "true") && issuer != config.InternalDexIssuer {
 		metrics.IncOIDCFallbackActivated()
 		logger.Error("primary issuer failed, attempting internal fallback", err, logger.FieldKV("primary_issuer", issuer), logger.FieldKV("fallback_issuer", config.InternalDexIssuer))
 		fallbackIssuer := config.InternalDexIssuer
@@ -187,7 +268,7 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 			if ferr == nil {
 				logger.Info("oidc provider initialized via fallback", logger.FieldKV("issuer", fallbackIssuer), logger.FieldKV("attempt", attempt))
 				metrics.IncOIDCFallbackSuccess(uint64(attempt))
-				return provider
+				return provider, nil
 			}
 			sleep := time.Duration(500*time.Millisecond) * time.Duration(attempt)
 			logger.Error("fallback issuer init failed", ferr, logger.FieldKV("attempt", attempt), logger.FieldKV("next_sleep", sleep.String()))
@@ -196,15 +277,15 @@ func initProviderWithBackoff(ctx context.Context, issuer string) *coreoidc.Provi
 				continue
 			case <-ctx.Done():
 				logger.Error("context canceled during fallback oidc init", ctx.Err())
-				log.Fatalf("Failed to initialize OIDC provider (fallback): %v", ferr)
+				return nil, fmt.Errorf("initialize OIDC provider (fallback): %w", ferr)
 			}
 		}
-		log.Fatalf("Failed to initialize OIDC provider after fallback attempts: %v", err)
+		metrics.IncOIDCInitFailure(uint64(maxAttempts))
+		return nil, fmt.Errorf("initialize OIDC provider after fallback attempts: %w", err)
 	}
 
 	metrics.IncOIDCInitFailure(uint64(maxAttempts))
-	log.Fatalf("Failed to initialize OIDC provider after retries: %v", err)
-	return nil
+	return nil, fmt.Errorf("initialize OIDC provider after %d attempts: %w", maxAttempts, err)
 }
 
 // isLoopbackIP determines if a string IP is loopback (IPv4 127.0.0.0/8 or IPv6 ::1)
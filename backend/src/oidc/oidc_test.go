@@ -2,49 +2,155 @@ package oidcutil
 
 import (
 	"context"
-	"net/http"
-	"net/http/httptest"
+	"crypto/rand"
+	"crypto/rsa"
 	"testing"
+	"time"
 
 	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"src/config"
+	"src/oidc/oidctest"
 )
 
-// minimal test issuer server serving discovery + jwks
-func newTestOIDCServer(t *testing.T, issuerURL string) *httptest.Server {
-	mux := http.NewServeMux()
-	jwks := `{ "keys": [] }`
-	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"issuer":"` + issuerURL + `","jwks_uri":"` + issuerURL + `/keys"}`))
-	})
-	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(jwks))
+// useIssuer points the package config at iss for the duration of the test.
+func useIssuer(t *testing.T, iss *oidctest.Issuer) {
+	t.Helper()
+	origIssuer, origDial, origAttempts, origFallback := config.DexIssuer, config.DexIssuerInternalDial, config.DexOIDCMaxAttempts, config.DexOIDCFallbackEnabled
+	t.Cleanup(func() {
+		config.DexIssuer, config.DexIssuerInternalDial, config.DexOIDCMaxAttempts, config.DexOIDCFallbackEnabled = origIssuer, origDial, origAttempts, origFallback
 	})
-	return httptest.NewServer(mux)
+	config.DexIssuer = iss.URL
+	config.DexIssuerInternalDial = ""
+	config.DexOIDCFallbackEnabled = "false"
 }
 
 func TestVerifyTokenAudienceExpired(t *testing.T) {
-	ctx := context.Background()
-	// Create a fake verifier that returns a token with wrong audience then expired
-	fakeVerifier := &coreoidc.IDTokenVerifier{}
-	// We can't easily construct core oidc tokens; instead focus on our claim validation logic by
-	// simulating VerifyToken path via wrapping (white-box). We'll test error types directly.
-
-	// Since VerifyToken uses verifier.Verify which we can't mock without interface change, we limit test
-	// to error types instantiation (unit tests for claims require integration with dex or refactor).
-	if (ErrInvalidAudience{Expected: "a", Got: "b"}).Error() == "" {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	_, verifier, err := Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name  string
+		token string
+		check func(error) bool
+	}{
+		{"valid", iss.Token("u1", nil), func(err error) bool { return err == nil }},
+		{"wrong audience", iss.Token("u1", oidctest.Claims{"aud": "other"}), func(err error) bool { return err != nil }},
+		{"expired", iss.Token("u1", oidctest.Claims{"exp": time.Now().Add(-time.Minute).Unix()}), func(err error) bool { return err != nil }},
+		{"unknown key", iss.TokenWithKey(otherKey, "key-1", "u1", nil), func(err error) bool { return err != nil }},
+		{"foreign issuer", iss.Token("u1", oidctest.Claims{"iss": "https://evil.example"}), func(err error) bool { return err != nil }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := VerifyToken(context.Background(), verifier, tc.token); !tc.check(err) {
+				t.Fatalf("unexpected verify result: %v", err)
+			}
+		})
+	}
+	if (ErrInvalidAudience{Expected: "a", Got: "b"}).Error() == "" || (ErrTokenExpired{}).Error() == "" {
 		t.Fatal("unexpected empty error string")
 	}
-	if (ErrTokenExpired{}).Error() == "" {
-		t.Fatal("unexpected empty expired error string")
+}
+
+func TestVerifyTokenAfterKeyRotation(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	_, verifier, err := Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := iss.Token("u1", nil)
+	if _, err := VerifyToken(context.Background(), verifier, before); err != nil {
+		t.Fatalf("verify before rotation: %v", err)
+	}
+	fetches := iss.JWKSRequests()
+	iss.RotateKey()
+	after := iss.Token("u1", nil)
+	if _, err := VerifyToken(context.Background(), verifier, after); err != nil {
+		t.Fatalf("verify after rotation: %v", err)
+	}
+	if iss.JWKSRequests() <= fetches {
+		t.Fatal("expected unknown key id to trigger a JWKS refetch")
+	}
+	if _, err := VerifyToken(context.Background(), verifier, before); err != nil {
+		t.Fatalf("token signed by still published key rejected: %v", err)
 	}
-	_ = fakeVerifier
-	_ = ctx
 }
 
-func TestInitBackoffFailureFallback(t *testing.T) {
-	// We can't force internal fallback without altering env; ensure function panics/fatals after attempts.
-	// Running the real backoff would call log.Fatalf (os.Exit). Skip heavy integration for now.
-	// This test is a placeholder demonstrating where we'd inject an interface for provider creation.
+func TestInitBackoffRecoversWhenIssuerComesUp(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for one backoff interval")
+	}
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	config.DexOIDCMaxAttempts = "3"
+	iss.SetAvailable(false)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		iss.SetAvailable(true)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p, err := initProviderWithBackoff(ctx, iss.URL)
+	if err != nil || iss.DiscoveryRequests() < 2 {
+		t.Fatalf("expected provider after retry, discovery requests=%d", iss.DiscoveryRequests())
+	}
+	if _, err := VerifyToken(ctx, p.Verifier(&coreoidc.Config{ClientID: config.ClientID}), iss.Token("u1", nil)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+}
+
+func TestIdentityFromTokenRoleClaim(t *testing.T) {
+	iss := oidctest.New(config.ClientID)
+	defer iss.Close()
+	useIssuer(t, iss)
+	_, verifier, err := Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name, claim string
+		claims      oidctest.Claims
+		want        []string
+	}{
+		{"array groups", "groups", oidctest.Claims{"groups": []string{"a", "b"}}, []string{"a", "b"}},
+		{"custom claim", "roles", oidctest.Claims{"groups": []string{"ignored"}, "roles": []string{"chat-admins"}}, []string{"chat-admins"}},
+		{"single string", "roles", oidctest.Claims{"roles": "chat-moderators"}, []string{"chat-moderators"}},
+		{"missing claim", "groups", oidctest.Claims{"name": "x"}, nil},
+	}
+	orig := config.RoleClaim
+	defer func() { config.RoleClaim = orig }()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config.RoleClaim = tc.claim
+			tok, err := VerifyToken(context.Background(), verifier, iss.Token("u1", tc.claims))
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			id, err := IdentityFromToken(tok)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Subject != "u1" || len(id.Groups) != len(tc.want) {
+				t.Fatalf("unexpected identity %+v", id)
+			}
+			for i := range tc.want {
+				if id.Groups[i] != tc.want[i] {
+					t.Fatalf("expected groups %v got %v", tc.want, id.Groups)
+				}
+			}
+		})
+	}
 }
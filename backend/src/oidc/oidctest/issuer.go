@@ -0,0 +1,176 @@
+// Package oidctest provides an in-process OIDC issuer (discovery document, JWKS and token
+// minting with rotating RSA keys) so auth paths can be tested against real signatures offline.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+)
+
+// Claims are token claims; values override the defaults set by Issuer.Token.
+type Claims map[string]interface{}
+
+type signingKey struct {
+	id  string
+	key *rsa.PrivateKey
+}
+
+// Issuer is a fake OIDC provider backed by an httptest.Server.
+type Issuer struct {
+	// URL is the issuer identifier and base URL of the discovery document.
+	URL string
+	// Audience is the default "aud" claim of minted tokens.
+	Audience string
+
+	srv        *httptest.Server
+	mu         sync.RWMutex
+	keys       []signingKey // last entry signs new tokens
+	issuer     string       // advertised "issuer"; differs from URL only after SetAdvertisedIssuer
+	available  bool
+	keySeq     int
+	jwksHits   atomic.Int64
+	discovered atomic.Int64
+}
+
+// New starts an issuer with one signing key. Close it when done.
+func New(audience string) *Issuer {
+	i := &Issuer{Audience: audience, available: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.serveDiscovery)
+	mux.HandleFunc("/keys", i.serveKeys)
+	i.srv = httptest.NewServer(mux)
+	i.URL = i.srv.URL
+	i.issuer = i.srv.URL
+	i.RotateKey()
+	return i
+}
+
+// Close shuts the server down.
+func (i *Issuer) Close() { i.srv.Close() }
+
+// RotateKey adds a new signing key (used for subsequently minted tokens) while keeping the
+// previous keys published, like a provider mid-rotation. It returns the new key id.
+func (i *Issuer) RotateKey() string {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: generate key: " + err.Error())
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keySeq++
+	id := fmt.Sprintf("key-%d", i.keySeq)
+	i.keys = append(i.keys, signingKey{id: id, key: k})
+	return id
+}
+
+// RetireOldKeys unpublishes every key except the current signing key.
+func (i *Issuer) RetireOldKeys() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = i.keys[len(i.keys)-1:]
+}
+
+// SetAvailable toggles whether discovery and JWKS requests succeed (503 when unavailable).
+func (i *Issuer) SetAvailable(ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.available = ok
+}
+
+// SetAdvertisedIssuer changes the "issuer" in the discovery document and in minted tokens,
+// simulating a reconfigured provider.
+func (i *Issuer) SetAdvertisedIssuer(iss string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.issuer = iss
+}
+
+// JWKSRequests returns how many times the key set was fetched.
+func (i *Issuer) JWKSRequests() int64 { return i.jwksHits.Load() }
+
+// DiscoveryRequests returns how many times the discovery document was fetched.
+func (i *Issuer) DiscoveryRequests() int64 { return i.discovered.Load() }
+
+// Token mints an RS256 token for sub signed with the current key. Defaults: iss, aud=Audience,
+// iat=now, exp=now+1h; extra claims override them (e.g. Claims{"exp": past, "groups": [...]}).
+func (i *Issuer) Token(sub string, extra Claims) string {
+	i.mu.RLock()
+	k := i.keys[len(i.keys)-1]
+	iss := i.issuer
+	i.mu.RUnlock()
+	return i.sign(k, sub, iss, extra)
+}
+
+// TokenWithKey mints a token signed by an arbitrary (e.g. unpublished) key.
+func (i *Issuer) TokenWithKey(key *rsa.PrivateKey, keyID, sub string, extra Claims) string {
+	i.mu.RLock()
+	iss := i.issuer
+	i.mu.RUnlock()
+	return i.sign(signingKey{id: keyID, key: key}, sub, iss, extra)
+}
+
+func (i *Issuer) sign(k signingKey, sub, iss string, extra Claims) string {
+	now := time.Now()
+	claims := Claims{"iss": iss, "aud": i.Audience, "sub": sub, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for key, v := range extra {
+		claims[key] = v
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		panic("oidctest: marshal claims: " + err.Error())
+	}
+	return oidctest.SignIDToken(k.key, k.id, coreoidc.RS256, string(b))
+}
+
+func (i *Issuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	i.discovered.Add(1)
+	i.mu.RLock()
+	ok, iss := i.available, i.issuer
+	i.mu.RUnlock()
+	if !ok {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                iss,
+		"authorization_endpoint":                i.URL + "/auth",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{coreoidc.RS256},
+	})
+}
+
+func (i *Issuer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	i.jwksHits.Add(1)
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if !i.available {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	keys := make([]map[string]string, 0, len(i.keys))
+	for _, k := range i.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA", "use": "sig", "alg": coreoidc.RS256, "kid": k.id,
+			"n": base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -2,6 +2,10 @@ package oidcutil
 
 import (
 	"context"
+
+	coreoidc "github.com/coreos/go-oidc/v3/oidc"
+
+	"src/models"
 )
 
 // VerifierAdapter wraps the existing VerifyToken for injection.
@@ -19,8 +23,36 @@ type IDToken interface{}
 type Adapter struct{ Verifier *Verifier }
 
 // Verifier is a thin wrapper around underlying id token verifier to match method signature.
+// IdentityFn is optional; when set the verifier also satisfies api.IdentityVerifier.
 type Verifier struct {
-	Fn func(ctx context.Context, raw string) error
+	Fn         func(ctx context.Context, raw string) error
+	IdentityFn func(ctx context.Context, raw string) (models.Identity, error)
 }
 
 func (v *Verifier) Verify(ctx context.Context, raw string) error { return v.Fn(ctx, raw) }
+
+// VerifyIdentity verifies raw and returns the caller identity (empty when IdentityFn is unset).
+func (v *Verifier) VerifyIdentity(ctx context.Context, raw string) (models.Identity, error) {
+	if v.IdentityFn == nil {
+		return models.Identity{}, v.Fn(ctx, raw)
+	}
+	return v.IdentityFn(ctx, raw)
+}
+
+// NewVerifier wraps core so tokens are checked by VerifyToken and identities come from
+// IdentityFromToken.
+func NewVerifier(core *coreoidc.IDTokenVerifier) *Verifier {
+	return &Verifier{
+		Fn: func(ctx context.Context, raw string) error {
+			_, err := VerifyToken(ctx, core, raw)
+			return err
+		},
+		IdentityFn: func(ctx context.Context, raw string) (models.Identity, error) {
+			tok, err := VerifyToken(ctx, core, raw)
+			if err != nil {
+				return models.Identity{}, err
+			}
+			return IdentityFromToken(tok)
+		},
+	}
+}
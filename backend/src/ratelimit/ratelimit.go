@@ -0,0 +1,190 @@
+// Package ratelimit implements token bucket rate limits.
+//
+// A bucket holds up to Burst tokens and refills at Rate tokens per Per. Every request takes one
+// token; a request finding the bucket empty is refused with the time until the next token. Memory
+// keeps buckets in process; Shared keeps them in a store so a limit holds across replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"src/logger"
+)
+
+// Rule is a rate limit. The zero Rule is disabled.
+type Rule struct {
+	Rate  int
+	Per   time.Duration
+	Burst int
+}
+
+// PerMinute is Rule{n, time.Minute, n}.
+func PerMinute(n int) Rule { return Rule{Rate: n, Per: time.Minute, Burst: n} }
+
+// Enabled reports whether the rule limits anything.
+func (r Rule) Enabled() bool { return r.Rate > 0 && r.Per > 0 }
+
+// capacity and perSecond are the bucket size and refill speed.
+func (r Rule) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Rate)
+}
+
+func (r Rule) perSecond() float64 { return float64(r.Rate) / r.Per.Seconds() }
+
+func (r Rule) String() string {
+	if !r.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s:%d", r.Rate, r.Per, int(r.capacity()))
+}
+
+// ParseRule parses "<rate>/<s|m|h>[:<burst>]", e.g. "60/m" or "5/s:20". The burst defaults to the
+// rate. "", "0" and "off" yield the disabled rule.
+func ParseRule(v string) (Rule, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "0" || v == "off" {
+		return Rule{}, nil
+	}
+	spec, burst, hasBurst := strings.Cut(v, ":")
+	rate, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("rate limit %q: want <rate>/<s|m|h>[:<burst>]", v)
+	}
+	var r Rule
+	var err error
+	if r.Rate, err = strconv.Atoi(rate); err != nil || r.Rate <= 0 {
+		return Rule{}, fmt.Errorf("rate limit %q: rate must be a positive integer", v)
+	}
+	switch unit {
+	case "s":
+		r.Per = time.Second
+	case "m":
+		r.Per = time.Minute
+	case "h":
+		r.Per = time.Hour
+	default:
+		return Rule{}, fmt.Errorf("rate limit %q: unit must be s, m or h", v)
+	}
+	r.Burst = r.Rate
+	if hasBurst {
+		if r.Burst, err = strconv.Atoi(burst); err != nil || r.Burst <= 0 {
+			return Rule{}, fmt.Errorf("rate limit %q: burst must be a positive integer", v)
+		}
+	}
+	return r, nil
+}
+
+// Limiter takes a token for key under rule. When the request is refused, retryAfter is the time
+// until a token is available.
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule, now time.Time) (ok bool, retryAfter time.Duration)
+}
+
+// refill returns the tokens of a bucket last seen at last with tokens left, at now.
+func refill(rule Rule, tokens float64, last, now time.Time) float64 {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens += elapsed * rule.perSecond()
+	}
+	return math.Min(rule.capacity(), tokens)
+}
+
+// wait is how long a bucket with tokens left needs for the next whole token.
+func wait(rule Rule, tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / rule.perSecond() * float64(time.Second))
+}
+
+// Memory is an in-process Limiter.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will be full again; idle buckets past it are dropped.
+	full time.Time
+}
+
+// sweepEvery bounds how often idle buckets are dropped.
+const sweepEvery = time.Minute
+
+func NewMemory() *Memory { return &Memory{buckets: make(map[string]*bucket)} }
+
+func (m *Memory) Allow(_ context.Context, key string, rule Rule, now time.Time) (bool, time.Duration) {
+	if !rule.Enabled() {
+		return true, 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rule.capacity(), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens, b.last = refill(rule, b.tokens, b.last, now), now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((rule.capacity() - b.tokens) / rule.perSecond() * float64(time.Second)))
+	if !allowed {
+		return false, wait(rule, b.tokens)
+	}
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely; a new bucket starts full anyway.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepEvery {
+		return
+	}
+	m.lastSweep = now
+	for k, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, k)
+		}
+	}
+}
+
+// TokenStore keeps buckets outside the process (implemented by store.RateLimitAdapter). TakeToken
+// atomically refills the bucket key and takes a token if one is available, returning whether it
+// did and the tokens left.
+type TokenStore interface {
+	TakeToken(ctx context.Context, key string, capacity, perSecond float64, now time.Time) (bool, float64, error)
+}
+
+// Shared is a Limiter backed by a TokenStore. When the store fails it logs and falls back to a
+// per-replica Memory limiter, so limits loosen rather than fail open entirely.
+type Shared struct {
+	store    TokenStore
+	fallback *Memory
+}
+
+func NewShared(st TokenStore) *Shared { return &Shared{store: st, fallback: NewMemory()} }
+
+func (s *Shared) Allow(ctx context.Context, key string, rule Rule, now time.Time) (bool, time.Duration) {
+	if !rule.Enabled() {
+		return true, 0
+	}
+	ok, tokens, err := s.store.TakeToken(ctx, key, rule.capacity(), rule.perSecond(), now)
+	if err != nil {
+		logger.Error("shared rate limit", err, logger.FieldKV("key", key))
+		return s.fallback.Allow(ctx, key, rule, now)
+	}
+	if !ok {
+		return false, wait(rule, tokens)
+	}
+	return true, 0
+}
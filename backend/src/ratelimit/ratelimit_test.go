@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Rule
+		bad  bool
+	}{
+		{"60/m", Rule{60, time.Minute, 60}, false},
+		{"5/s:20", Rule{5, time.Second, 20}, false},
+		{" 1000/h ", Rule{1000, time.Hour, 1000}, false},
+		{"off", Rule{}, false},
+		{"", Rule{}, false},
+		{"60", Rule{}, true},
+		{"60/d", Rule{}, true},
+		{"-1/m", Rule{}, true},
+		{"10/m:0", Rule{}, true},
+	}
+	for _, tc := range cases {
+		got, err := ParseRule(tc.in)
+		if (err != nil) != tc.bad || got != tc.want {
+			t.Errorf("ParseRule(%q) = %+v, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestMemoryTokenBucket(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	rule := Rule{Rate: 2, Per: time.Second, Burst: 3}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := m.Allow(ctx, "u", rule, now); !ok {
+			t.Fatalf("burst request %d refused", i)
+		}
+	}
+	ok, wait := m.Allow(ctx, "u", rule, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected refusal with 500ms wait, got %v %v", ok, wait)
+	}
+	if ok, _ := m.Allow(ctx, "other", rule, now); !ok {
+		t.Fatalf("keys must not share buckets")
+	}
+	if ok, _ := m.Allow(ctx, "u", rule, now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("token should have refilled")
+	}
+	if ok, _ := m.Allow(ctx, "u", Rule{}, now); !ok {
+		t.Fatalf("disabled rule must allow")
+	}
+
+	// Idle full buckets are swept.
+	m.Allow(ctx, "late", rule, now.Add(time.Hour))
+	if _, ok := m.buckets["u"]; ok {
+		t.Fatalf("idle bucket not swept: %d buckets", len(m.buckets))
+	}
+}
+
+type fakeStore struct {
+	mem  *Memory
+	fail bool
+}
+
+func (f *fakeStore) TakeToken(ctx context.Context, key string, capacity, perSecond float64, now time.Time) (bool, float64, error) {
+	if f.fail {
+		return false, 0, errors.New("down")
+	}
+	rule := Rule{Rate: int(perSecond), Per: time.Second, Burst: int(capacity)}
+	ok, _ := f.mem.Allow(ctx, key, rule, now)
+	return ok, f.mem.buckets[key].tokens, nil
+}
+
+func TestSharedFallsBackWhenStoreFails(t *testing.T) {
+	st := &fakeStore{mem: NewMemory()}
+	s := NewShared(st)
+	ctx := context.Background()
+	rule := Rule{Rate: 1, Per: time.Second, Burst: 1}
+	now := time.Now()
+
+	if ok, _ := s.Allow(ctx, "k", rule, now); !ok {
+		t.Fatalf("first request refused")
+	}
+	if ok, wait := s.Allow(ctx, "k", rule, now); ok || wait != time.Second {
+		t.Fatalf("expected refusal with 1s wait got %v %v", ok, wait)
+	}
+	st.fail = true
+	if ok, _ := s.Allow(ctx, "k", rule, now); !ok {
+		t.Fatalf("fallback bucket should start full")
+	}
+	if ok, _ := s.Allow(ctx, "k", rule, now); ok {
+		t.Fatalf("fallback should still limit")
+	}
+}
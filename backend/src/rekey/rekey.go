@@ -0,0 +1,101 @@
+package rekey
+
+import (
+	"context"
+	"time"
+
+	"src/logger"
+)
+
+// Store is the persistence the job needs (implemented by store.EncryptionAdapter).
+type Store interface {
+	RewrapRoomKeys(ctx context.Context) (int, error)
+	RotateStaleRoomKeys(ctx context.Context, now time.Time, maxAge time.Duration) (int, error)
+	ReencryptMessages(ctx context.Context, limit int) (int, error)
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+}
+
+// Config controls the periodic re-encryption job.
+type Config struct {
+	Interval  time.Duration
+	BatchSize int
+	// MaxAge rotates room keys older than it; 0 leaves rotation to admins.
+	MaxAge time.Duration
+}
+
+const leaseName = "rekey"
+
+// Result counts what one pass changed.
+type Result struct {
+	Rewrapped   int `json:"rewrapped"`
+	Rotated     int `json:"rotated"`
+	Reencrypted int `json:"reencrypted"`
+}
+
+// Job keeps encrypted message content on current keys: it rewraps room keys held under a retired
+// master key, rotates stale room keys and re-encrypts messages sealed with older room key versions
+// or stored before encryption was turned on. Replicas share a lease so only one runs at a time.
+type Job struct {
+	store  Store
+	cfg    Config
+	holder string
+	now    func() time.Time
+}
+
+// New creates a re-encryption job identified by holder (unique per replica).
+func New(st Store, cfg Config, holder string) *Job {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	return &Job{store: st, cfg: cfg, holder: holder, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run runs a pass every interval until ctx is canceled.
+func (j *Job) Run(ctx context.Context) {
+	logger.Info("rekey job started", logger.FieldKV("interval", j.cfg.Interval.String()), logger.FieldKV("max_age", j.cfg.MaxAge.String()))
+	t := time.NewTicker(j.cfg.Interval)
+	defer t.Stop()
+	for {
+		if res, err := j.Tick(ctx); err != nil {
+			logger.Error("rekey pass", err)
+		} else if res != (Result{}) {
+			logger.Info("rekey pass complete", logger.FieldKV("rewrapped", res.Rewrapped), logger.FieldKV("rotated", res.Rotated),
+				logger.FieldKV("reencrypted", res.Reencrypted))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick runs one pass if this replica holds the lease; it is a no-op on other replicas. Messages
+// are re-encrypted in batches until none are left or the interval has passed, so a large backlog
+// is worked off over several passes.
+func (j *Job) Tick(ctx context.Context) (res Result, err error) {
+	start := j.now()
+	leader, err := j.store.AcquireLease(ctx, leaseName, j.holder, start, 2*j.cfg.Interval)
+	if err != nil || !leader {
+		return res, err
+	}
+	if res.Rewrapped, err = j.store.RewrapRoomKeys(ctx); err != nil {
+		return res, err
+	}
+	if j.cfg.MaxAge > 0 {
+		if res.Rotated, err = j.store.RotateStaleRoomKeys(ctx, start, j.cfg.MaxAge); err != nil {
+			return res, err
+		}
+	}
+	for ctx.Err() == nil && j.now().Sub(start) < j.cfg.Interval {
+		n, err := j.store.ReencryptMessages(ctx, j.cfg.BatchSize)
+		res.Reencrypted += n
+		if err != nil || n < j.cfg.BatchSize {
+			return res, err
+		}
+	}
+	return res, nil
+}
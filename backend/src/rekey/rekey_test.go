@@ -0,0 +1,68 @@
+package rekey
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	pending int
+	rotated []time.Duration
+	holder  string
+	calls   int
+}
+
+func (f *fakeStore) RewrapRoomKeys(ctx context.Context) (int, error) { return 2, nil }
+func (f *fakeStore) RotateStaleRoomKeys(ctx context.Context, now time.Time, maxAge time.Duration) (int, error) {
+	f.rotated = append(f.rotated, maxAge)
+	return 1, nil
+}
+func (f *fakeStore) ReencryptMessages(ctx context.Context, limit int) (int, error) {
+	f.calls++
+	n := min(limit, f.pending)
+	f.pending -= n
+	return n, nil
+}
+func (f *fakeStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	if f.holder == "" {
+		f.holder = holder
+	}
+	return f.holder == holder, nil
+}
+
+func TestTickWorksOffBacklogInBatches(t *testing.T) {
+	fs := &fakeStore{pending: 25}
+	res, err := New(fs, Config{BatchSize: 10, MaxAge: 24 * time.Hour}, "r1").Tick(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Rewrapped: 2, Rotated: 1, Reencrypted: 25}) || fs.calls != 3 || fs.rotated[0] != 24*time.Hour {
+		t.Fatalf("unexpected result %+v after %d batches", res, fs.calls)
+	}
+}
+
+func TestTickStopsAtInterval(t *testing.T) {
+	fs := &fakeStore{pending: 1000}
+	j := New(fs, Config{Interval: time.Minute, BatchSize: 10}, "r1")
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Each batch takes 20s of the minute.
+	j.now = func() time.Time { now = now.Add(20 * time.Second); return now }
+	res, err := j.Tick(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reencrypted != 20 || len(fs.rotated) != 0 {
+		t.Fatalf("expected two batches and no rotation, got %+v", res)
+	}
+}
+
+func TestTickSkipsWithoutLease(t *testing.T) {
+	fs := &fakeStore{holder: "other", pending: 5}
+	if _, err := New(fs, Config{}, "r1").Tick(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fs.calls != 0 {
+		t.Fatalf("expected no re-encryption without lease, got %d batches", fs.calls)
+	}
+}
@@ -0,0 +1,74 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"src/logger"
+	"src/store"
+)
+
+// Store is the persistence the job needs (implemented by store.RetentionAdapter).
+type Store interface {
+	Prune(ctx context.Context, policy store.RetentionPolicy) (store.PruneResult, error)
+	RoomRetentions(ctx context.Context) (map[string]time.Duration, error)
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+}
+
+// Config controls the periodic pruning job.
+type Config struct {
+	Global    time.Duration
+	Interval  time.Duration
+	BatchSize int
+	DryRun    bool
+}
+
+const leaseName = "retention"
+
+// Job periodically deletes expired messages. Replicas share a lease so only one prunes at a time.
+type Job struct {
+	store  Store
+	cfg    Config
+	holder string
+	now    func() time.Time
+}
+
+// New creates a retention job identified by holder (unique per replica).
+func New(st Store, cfg Config, holder string) *Job {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	return &Job{store: st, cfg: cfg, holder: holder, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run prunes every interval until ctx is canceled.
+func (j *Job) Run(ctx context.Context) {
+	logger.Info("retention job started", logger.FieldKV("global", j.cfg.Global.String()), logger.FieldKV("interval", j.cfg.Interval.String()), logger.FieldKV("dry_run", j.cfg.DryRun))
+	t := time.NewTicker(j.cfg.Interval)
+	defer t.Stop()
+	for {
+		if _, err := j.Tick(ctx); err != nil {
+			logger.Error("retention pass", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick runs one pass if this replica holds the lease; it is a no-op on other replicas.
+func (j *Job) Tick(ctx context.Context) (res store.PruneResult, err error) {
+	now := j.now()
+	// The lease outlives a pass so a slow batch delete is not run twice concurrently.
+	leader, err := j.store.AcquireLease(ctx, leaseName, j.holder, now, 2*j.cfg.Interval)
+	if err != nil || !leader {
+		return res, err
+	}
+	rooms, err := j.store.RoomRetentions(ctx)
+	if err != nil {
+		return res, err
+	}
+	return j.store.Prune(ctx, store.RetentionPolicy{Global: j.cfg.Global, Rooms: rooms, BatchSize: j.cfg.BatchSize, DryRun: j.cfg.DryRun, Now: now})
+}
@@ -0,0 +1,57 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"src/store"
+)
+
+type fakeStore struct {
+	rooms    map[string]time.Duration
+	policies []store.RetentionPolicy
+	holder   string
+}
+
+func (f *fakeStore) Prune(ctx context.Context, p store.RetentionPolicy) (store.PruneResult, error) {
+	f.policies = append(f.policies, p)
+	return store.PruneResult{Deleted: map[string]int64{"*": 3}, DryRun: p.DryRun}, nil
+}
+func (f *fakeStore) RoomRetentions(ctx context.Context) (map[string]time.Duration, error) {
+	return f.rooms, nil
+}
+func (f *fakeStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	if f.holder == "" {
+		f.holder = holder
+	}
+	return f.holder == holder, nil
+}
+
+func TestTickAppliesGlobalAndRoomPolicy(t *testing.T) {
+	fs := &fakeStore{rooms: map[string]time.Duration{"dev": time.Hour}}
+	j := New(fs, Config{Global: 24 * time.Hour, BatchSize: 10, DryRun: true}, "r1")
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	j.now = func() time.Time { return now }
+	res, err := j.Tick(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total() != 3 || !res.DryRun {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	p := fs.policies[0]
+	if p.Global != 24*time.Hour || p.Rooms["dev"] != time.Hour || p.BatchSize != 10 || !p.DryRun || !p.Now.Equal(now) {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+}
+
+func TestTickSkipsWithoutLease(t *testing.T) {
+	fs := &fakeStore{holder: "other"}
+	if _, err := New(fs, Config{Global: time.Hour}, "r1").Tick(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.policies) != 0 {
+		t.Fatalf("expected no prune without lease, got %d", len(fs.policies))
+	}
+}
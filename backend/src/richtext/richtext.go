@@ -0,0 +1,346 @@
+package richtext
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// Supported message formats.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// ErrUnsupportedFormat is returned for formats other than plain / markdown.
+type ErrUnsupportedFormat struct{ Format string }
+
+func (e ErrUnsupportedFormat) Error() string { return "unsupported message format: " + e.Format }
+
+// NormalizeFormat maps an empty format to plain and rejects unknown values.
+func NormalizeFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatPlain:
+		return FormatPlain, nil
+	case FormatMarkdown:
+		return FormatMarkdown, nil
+	default:
+		return "", ErrUnsupportedFormat{Format: format}
+	}
+}
+
+// Render converts content into sanitized HTML for the given format.
+// Output only ever contains the tags emitted by this package; all user text is escaped
+// and raw HTML in the source is dropped (dangerous elements together with their body).
+func Render(format, content string) (string, error) {
+	f, err := NormalizeFormat(format)
+	if err != nil {
+		return "", err
+	}
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if f == FormatPlain {
+		return strings.ReplaceAll(html.EscapeString(content), "\n", "<br>"), nil
+	}
+	return renderMarkdown(content), nil
+}
+
+// renderMarkdown handles the block level subset: paragraphs, fenced code, blockquotes and lists.
+func renderMarkdown(src string) string {
+	lines := strings.Split(src, "\n")
+	var b strings.Builder
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		b.WriteString("<p>")
+		b.WriteString(renderInline(strings.Join(para, "\n")))
+		b.WriteString("</p>")
+		para = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")))
+			}
+			i--
+			b.WriteString("<blockquote>")
+			b.WriteString(renderInline(strings.Join(quote, "\n")))
+			b.WriteString("</blockquote>")
+		case listItem(trimmed, false) != "" || listItem(trimmed, true) != "":
+			flush()
+			ordered := listItem(trimmed, true) != ""
+			tag := "ul"
+			if ordered {
+				tag = "ol"
+			}
+			b.WriteString("<" + tag + ">")
+			for ; i < len(lines); i++ {
+				item := listItem(strings.TrimSpace(lines[i]), ordered)
+				if item == "" {
+					break
+				}
+				b.WriteString("<li>")
+				b.WriteString(renderInline(item))
+				b.WriteString("</li>")
+			}
+			i--
+			b.WriteString("</" + tag + ">")
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// listItem returns the item text if line is a list item of the requested kind, else "".
+func listItem(line string, ordered bool) string {
+	if !ordered {
+		for _, p := range []string{"- ", "* ", "+ "} {
+			if strings.HasPrefix(line, p) {
+				return strings.TrimSpace(line[len(p):])
+			}
+		}
+		return ""
+	}
+	n := 0
+	for n < len(line) && line[n] >= '0' && line[n] <= '9' {
+		n++
+	}
+	if n == 0 || n > 9 || !strings.HasPrefix(line[n:], ". ") {
+		return ""
+	}
+	return strings.TrimSpace(line[n+2:])
+}
+
+// dangerousElements are dropped together with everything up to their closing tag.
+var dangerousElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"svg": true, "math": true, "template": true, "noscript": true, "textarea": true,
+	"title": true, "xmp": true, "noembed": true, "noframes": true, "plaintext": true,
+}
+
+const escapable = "\\`*_~[]()<>#+-.!|"
+
+// EscapeMarkdown backslash-escapes the characters markdown treats as markup, so that s renders as
+// typed when embedded in markdown content.
+func EscapeMarkdown(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(escapable, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// renderInline handles code spans, emphasis, strikethrough, links and raw HTML removal.
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '\n':
+			b.WriteString("<br>")
+		case c == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				b.WriteString("<code>")
+				b.WriteString(html.EscapeString(s[i+1 : i+1+end]))
+				b.WriteString("</code>")
+				i += end + 2
+				continue
+			}
+			b.WriteString("`")
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			if n := wrapped(&b, s[i:], s[i:i+2], "strong"); n > 0 {
+				i += n
+				continue
+			}
+			b.WriteString(s[i : i+2])
+			i += 2
+			continue
+		case strings.HasPrefix(s[i:], "~~"):
+			if n := wrapped(&b, s[i:], "~~", "del"); n > 0 {
+				i += n
+				continue
+			}
+			b.WriteString("~~")
+			i += 2
+			continue
+		case c == '*' || c == '_':
+			if n := wrapped(&b, s[i:], s[i:i+1], "em"); n > 0 {
+				i += n
+				continue
+			}
+			b.WriteByte(c)
+		case c == '[':
+			if n := link(&b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+			b.WriteByte(c)
+		case c == '<':
+			if n := rawHTML(&b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+			b.WriteString("&lt;")
+		default:
+			b.WriteString(html.EscapeString(s[i : i+1]))
+		}
+		i++
+	}
+	return b.String()
+}
+
+// wrapped renders delim...delim as <tag>; returns consumed bytes or 0 if not closed.
+func wrapped(b *strings.Builder, s, delim, tag string) int {
+	rest := s[len(delim):]
+	end := unescapedIndex(rest, delim)
+	if end <= 0 || strings.TrimSpace(rest[:end]) != rest[:end] {
+		// Like CommonMark, delimiters must hug the text ("2 * 3 * 4" stays literal).
+		return 0
+	}
+	b.WriteString("<" + tag + ">")
+	b.WriteString(renderInline(rest[:end]))
+	b.WriteString("</" + tag + ">")
+	return len(delim)*2 + end
+}
+
+// unescapedIndex is strings.Index skipping backslash-escaped characters, or -1.
+func unescapedIndex(s, sub string) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			i++
+		case strings.HasPrefix(s[i:], sub):
+			return i
+		}
+	}
+	return -1
+}
+
+// link renders [text](url); unsafe URLs degrade to the escaped text only.
+func link(b *strings.Builder, s string) int {
+	closeText := strings.Index(s, "](")
+	if closeText < 0 || strings.ContainsAny(s[1:closeText], "[\n") {
+		return 0
+	}
+	closeURL := strings.IndexByte(s[closeText+2:], ')')
+	if closeURL < 0 {
+		return 0
+	}
+	text := s[1:closeText]
+	href := strings.TrimSpace(s[closeText+2 : closeText+2+closeURL])
+	if safe, ok := SafeURL(href); ok {
+		fmt.Fprintf(b, `<a href="%s" rel="nofollow noopener noreferrer" target="_blank">%s</a>`, html.EscapeString(safe), renderInline(text))
+	} else {
+		b.WriteString(renderInline(text))
+	}
+	return closeText + 2 + closeURL + 1
+}
+
+// rawHTML consumes an autolink, comment or tag starting at s[0]=='<'. Tags are dropped;
+// dangerous elements are dropped with their content. Returns 0 if s is not markup.
+func rawHTML(b *strings.Builder, s string) int {
+	if end := strings.IndexByte(s, '>'); end > 0 && !strings.ContainsAny(s[1:end], " \n<") {
+		if safe, ok := SafeURL(s[1:end]); ok && strings.Contains(s[1:end], ":") {
+			fmt.Fprintf(b, `<a href="%s" rel="nofollow noopener noreferrer" target="_blank">%s</a>`, html.EscapeString(safe), html.EscapeString(s[1:end]))
+			return end + 1
+		}
+	}
+	if strings.HasPrefix(s, "<!--") {
+		if end := strings.Index(s[4:], "-->"); end >= 0 {
+			return 4 + end + 3
+		}
+		return len(s)
+	}
+	name, closing := tagName(s)
+	if name == "" {
+		return 0
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		// Unterminated tag: drop the remainder so a browser never sees a dangling opener.
+		return len(s)
+	}
+	if !closing && dangerousElements[name] {
+		lower := strings.ToLower(s)
+		if c := strings.Index(lower[end:], "</"+name); c >= 0 {
+			after := end + c
+			if gt := strings.IndexByte(s[after:], '>'); gt >= 0 {
+				return after + gt + 1
+			}
+		}
+		return len(s)
+	}
+	return end + 1
+}
+
+// tagName extracts a lowercase element name from "<name" or "</name".
+func tagName(s string) (string, bool) {
+	i := 1
+	closing := false
+	if i < len(s) && s[i] == '/' {
+		closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isAlpha(s[i]) || (i > start && (s[i] == '-' || (s[i] >= '0' && s[i] <= '9')))) {
+		i++
+	}
+	if i == start {
+		return "", false
+	}
+	return strings.ToLower(s[start:i]), closing
+}
+
+func isAlpha(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+// SafeURL accepts only absolute http(s) and mailto URLs without control characters.
+func SafeURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.ContainsAny(raw, "\"'<>` \t\r\n\\") {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
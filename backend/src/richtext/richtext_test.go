@@ -0,0 +1,232 @@
+package richtext
+
+import (
+	"html"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownSubset(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"paragraph", "hello", "<p>hello</p>"},
+		{"line break", "a\nb", "<p>a<br>b</p>"},
+		{"two paragraphs", "a\n\nb", "<p>a</p><p>b</p>"},
+		{"bold", "**hi**", "<p><strong>hi</strong></p>"},
+		{"italic", "_hi_", "<p><em>hi</em></p>"},
+		{"strike", "~~hi~~", "<p><del>hi</del></p>"},
+		{"nested", "**a _b_**", "<p><strong>a <em>b</em></strong></p>"},
+		{"code span", "`a<b>`", "<p><code>a&lt;b&gt;</code></p>"},
+		{"fenced", "```\n<x>\n```", "<pre><code>&lt;x&gt;</code></pre>"},
+		{"quote", "> q1\n> q2", "<blockquote>q1<br>q2</blockquote>"},
+		{"ul", "- a\n- b", "<ul><li>a</li><li>b</li></ul>"},
+		{"ol", "1. a\n2. b", "<ol><li>a</li><li>b</li></ol>"},
+		{"link", "[x](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer" target="_blank">x</a></p>`},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">https://example.com</a></p>`},
+		{"escaped star", `\*no\*`, "<p>*no*</p>"},
+		{"escaped star in emphasis", `*a \*b\**`, "<p><em>a *b*</em></p>"},
+		{"less than", "a < b", "<p>a &lt; b</p>"},
+		{"unclosed emphasis", "**a", "<p>**a</p>"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Render(FormatMarkdown, tc.in)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	for _, in := range []string{"likes *bold* and _x_", "> not a quote", "- [link](https://x) `code` <b>", `back\slash`} {
+		got, err := Render(FormatMarkdown, EscapeMarkdown(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "<p>" + html.EscapeString(in) + "</p>"; got != want {
+			t.Fatalf("%q: expected %q got %q", in, want, got)
+		}
+	}
+}
+
+func TestRenderPlainEscapes(t *testing.T) {
+	got, err := Render("", "<b>x</b>\n&")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "&lt;b&gt;x&lt;/b&gt;<br>&amp;" {
+		t.Fatalf("unexpected plain render %q", got)
+	}
+}
+
+func TestNormalizeFormat(t *testing.T) {
+	if f, _ := NormalizeFormat(""); f != FormatPlain {
+		t.Fatalf("expected plain default got %q", f)
+	}
+	if f, _ := NormalizeFormat("Markdown"); f != FormatMarkdown {
+		t.Fatalf("expected markdown got %q", f)
+	}
+	if _, err := NormalizeFormat("html"); err == nil {
+		t.Fatal("expected error for html format")
+	}
+}
+
+// TestRenderXSS feeds well known XSS vectors through both formats and asserts that no
+// active markup survives in the output.
+func TestRenderXSS(t *testing.T) {
+	vectors := []string{
+		`<script>alert(1)</script>`,
+		`<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+		`<scr<script>ipt>alert(1)</script>`,
+		`<img src=x onerror=alert(1)>`,
+		`<img src="x" onerror="alert(1)"/>`,
+		`<svg onload=alert(1)>`,
+		`<svg><script>alert(1)</script></svg>`,
+		`<body onload=alert(1)>`,
+		`<iframe src="javascript:alert(1)"></iframe>`,
+		`<iframe srcdoc="<script>alert(1)</script>">`,
+		`<object data="javascript:alert(1)">`,
+		`<embed src="javascript:alert(1)">`,
+		`<a href="javascript:alert(1)">x</a>`,
+		`<a href=javascript:alert(1)>x</a>`,
+		`<div style="background:url(javascript:alert(1))">`,
+		`<style>*{background:url("javascript:alert(1)")}</style>`,
+		`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+		`<details open ontoggle=alert(1)>`,
+		`<input autofocus onfocus=alert(1)>`,
+		`<marquee onstart=alert(1)>`,
+		`<video><source onerror=alert(1)>`,
+		`<form action=javascript:alert(1)><button>x</button></form>`,
+		`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+		`<base href="javascript:alert(1)//">`,
+		`<link rel=stylesheet href=javascript:alert(1)>`,
+		`<!--<img src=x onerror=alert(1)>-->`,
+		`<!-- unterminated <script>alert(1)</script>`,
+		`<img src=x onerror=alert(1)`,
+		`<<script>script>alert(1)<</script>/script>`,
+		`<noscript><p title="</noscript><img src=x onerror=alert(1)>">`,
+		`<template><script>alert(1)</script></template>`,
+		`<textarea><script>alert(1)</script></textarea>`,
+		`[x](javascript:alert(1))`,
+		`[x](JaVaScRiPt:alert(1))`,
+		`[x]( javascript:alert(1) )`,
+		`[x](java	script:alert(1))`,
+		"[x](java\nscript:alert(1))",
+		`[x](javascript&#58;alert(1))`,
+		`[x](javascript&colon;alert(1))`,
+		`[x](&#106;avascript:alert(1))`,
+		`[x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+		`[x](vbscript:msgbox(1))`,
+		`[x](file:///etc/passwd)`,
+		`[x](//evil.example)`,
+		`[x](https://ok.example" onclick="alert(1))`,
+		`[x](https://ok.example'onmouseover='alert(1))`,
+		`[<img src=x onerror=alert(1)>](https://ok.example)`,
+		`[x](https://ok.example/<script>)`,
+		`<javascript:alert(1)>`,
+		`<data:text/html,<script>alert(1)</script>>`,
+		"```\n<script>alert(1)</script>\n```",
+		"`<script>alert(1)</script>`",
+		`**<script>alert(1)</script>**`,
+		`_<img src=x onerror=alert(1)>_`,
+		`> <script>alert(1)</script>`,
+		`- <img src=x onerror=alert(1)>`,
+		`1. <svg/onload=alert(1)>`,
+		`\<script>alert(1)\</script>`,
+		`&lt;script&gt;alert(1)&lt;/script&gt;`,
+		`"><script>alert(1)</script>`,
+		`'><img src=x onerror=alert(1)>`,
+		`<a href="&#x6A;avascript:alert(1)">x</a>`,
+		`<isindex type=image src=1 onerror=alert(1)>`,
+		`<x onclick=alert(1)>click</x>`,
+		"<img\nsrc=x\nonerror=alert(1)>",
+		`<IMG """><SCRIPT>alert(1)</SCRIPT>">`,
+		`<plaintext><img src=x onerror=alert(1)>`,
+	}
+	forbidden := []string{"<script", "<img", "<svg", "<iframe", "<object", "<embed", "<style", "<body",
+		"<div", "<input", "<form", "<meta", "<base", "<link", "<math", "<details", "<video", "<x ",
+		"<textarea", "<template", "<noscript", "<marquee", "<isindex", "<plaintext", "<button",
+		"javascript:", "vbscript:", "data:", "file:", "onerror=", "onload=", "onclick=", "onfocus="}
+	for _, format := range []string{FormatPlain, FormatMarkdown} {
+		for _, v := range vectors {
+			out, err := Render(format, v)
+			if err != nil {
+				t.Fatalf("%s render %q: %v", format, v, err)
+			}
+			lower := strings.ToLower(out)
+			for _, f := range forbidden {
+				if format == FormatPlain && !strings.HasPrefix(f, "<") {
+					// Plain output escapes all markup; attribute-looking text is inert.
+					continue
+				}
+				if strings.Contains(lower, f) && !inertText(lower, f) {
+					t.Errorf("%s: %q rendered %q containing %q", format, v, out, f)
+				}
+			}
+			assertOnlyAllowedTags(t, format, v, out)
+		}
+	}
+}
+
+// inertText reports whether every occurrence of needle sits outside of a tag, i.e. is
+// plain escaped text that a browser will not interpret.
+func inertText(out, needle string) bool {
+	for idx := 0; ; {
+		i := strings.Index(out[idx:], needle)
+		if i < 0 {
+			return true
+		}
+		pos := idx + i
+		lt := strings.LastIndex(out[:pos], "<")
+		gt := strings.LastIndex(out[:pos], ">")
+		if lt > gt {
+			if !strings.HasPrefix(out[lt:], `<a href="https://`) && !strings.HasPrefix(out[lt:], `<a href="http://`) && !strings.HasPrefix(out[lt:], `<a href="mailto:`) {
+				return false
+			}
+			// Inside our own anchor: the needle can only be part of an escaped http(s) URL.
+			if strings.Contains(out[lt:pos], `"`+" ") {
+				return false
+			}
+		}
+		idx = pos + len(needle)
+	}
+}
+
+var allowedTags = map[string]bool{"p": true, "br": true, "strong": true, "em": true, "del": true, "code": true,
+	"pre": true, "blockquote": true, "ul": true, "ol": true, "li": true, "a": true}
+
+func assertOnlyAllowedTags(t *testing.T, format, in, out string) {
+	t.Helper()
+	for i := 0; i < len(out); i++ {
+		if out[i] != '<' {
+			continue
+		}
+		name, _ := tagName(out[i:])
+		if !allowedTags[name] {
+			t.Errorf("%s: %q produced disallowed tag %q in %q", format, in, name, out)
+		}
+		if name == "a" && !strings.HasPrefix(out[i:], `<a href="`) && !strings.HasPrefix(out[i:], "</a>") {
+			t.Errorf("%s: %q produced unexpected anchor in %q", format, in, out)
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	ok := []string{"https://example.com", "http://example.com/a?b=c#d", "mailto:a@example.com"}
+	bad := []string{"", "javascript:alert(1)", "/relative", "//host", "https://", "ftp://x", "https://a b", "data:,x"}
+	for _, u := range ok {
+		if _, good := SafeURL(u); !good {
+			t.Errorf("expected %q to be allowed", u)
+		}
+	}
+	for _, u := range bad {
+		if _, good := SafeURL(u); good {
+			t.Errorf("expected %q to be rejected", u)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"src/logger"
+	"src/metrics"
+	"src/models"
+)
+
+// Clock abstracts time so tests can drive the scheduler deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now().UTC() }
+
+// Store is the persistence the scheduler needs (implemented by store.ScheduledAdapter).
+type Store interface {
+	DueScheduled(ctx context.Context, now time.Time, staleAfter time.Duration, limit int) ([]models.ScheduledMessage, error)
+	ClaimScheduled(ctx context.Context, sm models.ScheduledMessage, now time.Time) (bool, error)
+	SetScheduledStatus(ctx context.Context, id, status string) error
+	AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error)
+	ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error)
+}
+
+// Publisher matches api.Producer.
+type Publisher interface {
+	Publish(ctx context.Context, msg models.Message) error
+}
+
+const leaseName = "scheduler"
+
+// Scheduler publishes due scheduled messages. Only the replica holding the lease publishes.
+type Scheduler struct {
+	store     Store
+	publisher Publisher
+	clock     Clock
+	holder    string
+	interval  time.Duration
+	leaseTTL  time.Duration
+	batch     int
+}
+
+// New creates a scheduler identified by holder (unique per replica) polling every interval.
+func New(st Store, p Publisher, clock Clock, holder string, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Scheduler{store: st, publisher: p, clock: clock, holder: holder, interval: interval, leaseTTL: 3 * interval, batch: 100}
+}
+
+// Run polls until ctx is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	logger.Info("scheduler started", logger.FieldKV("holder", s.holder), logger.FieldKV("interval", s.interval.String()))
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		if _, err := s.Tick(ctx); err != nil {
+			logger.Error("scheduler tick", err)
+		}
+		select {
+		case <-ctx.Done():
+			logger.Info("scheduler stopped")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick runs a single pass: renew leadership, then publish due messages. It returns the number published.
+func (s *Scheduler) Tick(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+	leader, err := s.store.AcquireLease(ctx, leaseName, s.holder, now, s.leaseTTL)
+	if err != nil || !leader {
+		return 0, err
+	}
+	due, err := s.store.DueScheduled(ctx, now, s.leaseTTL, s.batch)
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for _, sm := range due {
+		ok, err := s.store.ClaimScheduled(ctx, sm, now)
+		if err != nil {
+			logger.Error("scheduled claim", err, logger.FieldKV("id", sm.ID))
+			continue
+		}
+		if !ok {
+			continue
+		}
+		// The author may have been banned or muted since scheduling; their message is dropped.
+		sanctioned, err := s.sanctioned(ctx, sm, now)
+		if err != nil || sanctioned {
+			status := models.ScheduledCanceled
+			if err != nil {
+				logger.Error("scheduled sanctions", err, logger.FieldKV("id", sm.ID))
+				status = models.ScheduledPending
+			} else {
+				logger.Info("scheduled message canceled, author sanctioned", logger.FieldKV("id", sm.ID), logger.FieldKV("created_by", sm.CreatedBy))
+			}
+			if serr := s.store.SetScheduledStatus(ctx, sm.ID, status); serr != nil {
+				logger.Error("scheduled release", serr, logger.FieldKV("id", sm.ID))
+			}
+			continue
+		}
+		msg := sm.Message
+		msg.Timestamp = now
+		if err := s.publisher.Publish(ctx, msg); err != nil {
+			logger.Error("scheduled publish", err, logger.FieldKV("id", sm.ID), logger.FieldKV("message_id", msg.MessageID))
+			if serr := s.store.SetScheduledStatus(ctx, sm.ID, models.ScheduledPending); serr != nil {
+				logger.Error("scheduled release", serr, logger.FieldKV("id", sm.ID))
+			}
+			continue
+		}
+		if err := s.store.SetScheduledStatus(ctx, sm.ID, models.ScheduledSent); err != nil {
+			logger.Error("scheduled mark sent", err, logger.FieldKV("id", sm.ID))
+		}
+		metrics.IncScheduledPublished()
+		published++
+	}
+	return published, nil
+}
+
+// sanctioned reports whether a global or room ban or mute of the author is in force for the
+// message's room.
+func (s *Scheduler) sanctioned(ctx context.Context, sm models.ScheduledMessage, now time.Time) (bool, error) {
+	list, err := s.store.ActiveSanctions(ctx, sm.CreatedBy, now)
+	if err != nil {
+		return false, err
+	}
+	for _, sn := range list {
+		if sn.Applies(sm.Message.RoomID, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
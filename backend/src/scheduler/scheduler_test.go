@@ -0,0 +1,186 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"src/models"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+type memStore struct {
+	items       map[string]*models.ScheduledMessage
+	leaseHolder string
+	leaseUntil  time.Time
+	sanctions   []models.Sanction
+}
+
+func (m *memStore) DueScheduled(ctx context.Context, now time.Time, stale time.Duration, limit int) ([]models.ScheduledMessage, error) {
+	var out []models.ScheduledMessage
+	for _, sm := range m.items {
+		due := !sm.SendAt.After(now)
+		if due && (sm.Status == models.ScheduledPending || (sm.Status == models.ScheduledSending && sm.ClaimedAt.Before(now.Add(-stale)))) {
+			out = append(out, *sm)
+		}
+	}
+	return out, nil
+}
+func (m *memStore) ClaimScheduled(ctx context.Context, sm models.ScheduledMessage, now time.Time) (bool, error) {
+	cur := m.items[sm.ID]
+	if cur.Status != sm.Status {
+		return false, nil
+	}
+	cur.Status, cur.ClaimedAt = models.ScheduledSending, now
+	return true, nil
+}
+func (m *memStore) SetScheduledStatus(ctx context.Context, id, status string) error {
+	m.items[id].Status = status
+	return nil
+}
+func (m *memStore) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	if m.leaseHolder != "" && m.leaseHolder != holder && now.Before(m.leaseUntil) {
+		return false, nil
+	}
+	m.leaseHolder, m.leaseUntil = holder, now.Add(ttl)
+	return true, nil
+}
+
+func (m *memStore) ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error) {
+	var out []models.Sanction
+	for _, sn := range m.sanctions {
+		if sn.UserID == userID {
+			out = append(out, sn)
+		}
+	}
+	return out, nil
+}
+
+type recordingPublisher struct {
+	fail bool
+	sent []models.Message
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, msg models.Message) error {
+	if p.fail {
+		return errors.New("kafka down")
+	}
+	p.sent = append(p.sent, msg)
+	return nil
+}
+
+func TestTickPublishesOnlyDueMessages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	st := &memStore{items: map[string]*models.ScheduledMessage{
+		"a": {ID: "a", Message: models.Message{MessageID: "m-a"}, SendAt: start.Add(time.Minute), Status: models.ScheduledPending},
+		"b": {ID: "b", Message: models.Message{MessageID: "m-b"}, SendAt: start.Add(time.Hour), Status: models.ScheduledPending},
+		"c": {ID: "c", Message: models.Message{MessageID: "m-c"}, SendAt: start, Status: models.ScheduledCanceled},
+	}}
+	pub := &recordingPublisher{}
+	s := New(st, pub, clock, "replica-1", time.Second)
+
+	if n, _ := s.Tick(context.Background()); n != 0 {
+		t.Fatalf("expected nothing due yet, published %d", n)
+	}
+	clock.now = start.Add(2 * time.Minute)
+	if n, _ := s.Tick(context.Background()); n != 1 {
+		t.Fatalf("expected 1 published got %d", n)
+	}
+	if pub.sent[0].MessageID != "m-a" || !pub.sent[0].Timestamp.Equal(clock.now) {
+		t.Fatalf("unexpected published message %+v", pub.sent[0])
+	}
+	if st.items["a"].Status != models.ScheduledSent || st.items["b"].Status != models.ScheduledPending {
+		t.Fatalf("unexpected states a=%s b=%s", st.items["a"].Status, st.items["b"].Status)
+	}
+	if n, _ := s.Tick(context.Background()); n != 0 {
+		t.Fatalf("expected no re-publish got %d", n)
+	}
+}
+
+func TestTickReleasesOnPublishFailure(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := &memStore{items: map[string]*models.ScheduledMessage{
+		"a": {ID: "a", SendAt: now, Status: models.ScheduledPending},
+	}}
+	pub := &recordingPublisher{fail: true}
+	s := New(st, pub, &fakeClock{now: now}, "r1", time.Second)
+	if n, _ := s.Tick(context.Background()); n != 0 {
+		t.Fatalf("expected 0 published got %d", n)
+	}
+	if st.items["a"].Status != models.ScheduledPending {
+		t.Fatalf("expected message released to pending got %s", st.items["a"].Status)
+	}
+	pub.fail = false
+	if n, _ := s.Tick(context.Background()); n != 1 {
+		t.Fatalf("expected retry to publish got %d", n)
+	}
+}
+
+func TestOnlyLeaderPublishes(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: now}
+	st := &memStore{items: map[string]*models.ScheduledMessage{
+		"a": {ID: "a", SendAt: now, Status: models.ScheduledPending},
+	}}
+	pub1, pub2 := &recordingPublisher{}, &recordingPublisher{}
+	leader := New(st, pub1, clock, "r1", time.Second)
+	follower := New(st, pub2, clock, "r2", time.Second)
+	if _, err := leader.Tick(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	st.items["b"] = &models.ScheduledMessage{ID: "b", SendAt: now, Status: models.ScheduledPending}
+	if n, _ := follower.Tick(context.Background()); n != 0 {
+		t.Fatalf("follower published %d while lease held", n)
+	}
+	// Leader disappears; after the lease expires the follower takes over.
+	clock.now = now.Add(10 * time.Second)
+	if n, _ := follower.Tick(context.Background()); n != 1 {
+		t.Fatalf("expected follower to take over and publish, got %d", n)
+	}
+	if len(pub1.sent) != 1 || len(pub2.sent) != 1 {
+		t.Fatalf("unexpected publish split leader=%d follower=%d", len(pub1.sent), len(pub2.sent))
+	}
+}
+
+func TestStaleClaimIsRetried(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := &memStore{items: map[string]*models.ScheduledMessage{
+		"a": {ID: "a", SendAt: now.Add(-time.Minute), Status: models.ScheduledSending, ClaimedAt: now.Add(-time.Minute)},
+	}}
+	pub := &recordingPublisher{}
+	if n, _ := New(st, pub, &fakeClock{now: now}, "r1", time.Second).Tick(context.Background()); n != 1 {
+		t.Fatalf("expected stale claim to be re-published, got %d", n)
+	}
+}
+
+func TestSanctionedAuthorIsCanceled(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Minute)
+	st := &memStore{
+		items: map[string]*models.ScheduledMessage{
+			"muted":  {ID: "muted", Message: models.Message{RoomID: "general"}, CreatedBy: "alice", SendAt: now, Status: models.ScheduledPending},
+			"banned": {ID: "banned", Message: models.Message{RoomID: "ops"}, CreatedBy: "bob", SendAt: now, Status: models.ScheduledPending},
+			"other":  {ID: "other", Message: models.Message{RoomID: "general"}, CreatedBy: "bob", SendAt: now, Status: models.ScheduledPending},
+			"lapsed": {ID: "lapsed", Message: models.Message{RoomID: "general"}, CreatedBy: "carol", SendAt: now, Status: models.ScheduledPending},
+		},
+		sanctions: []models.Sanction{
+			{UserID: "alice", Kind: models.SanctionMute},
+			{UserID: "bob", Kind: models.SanctionBan, RoomID: "ops"},
+			{UserID: "carol", Kind: models.SanctionBan, ExpiresAt: &expired},
+		},
+	}
+	pub := &recordingPublisher{}
+	if n, _ := New(st, pub, &fakeClock{now: now}, "r1", time.Second).Tick(context.Background()); n != 2 {
+		t.Fatalf("expected only unsanctioned messages to publish, got %d", n)
+	}
+	for id, want := range map[string]string{"muted": models.ScheduledCanceled, "banned": models.ScheduledCanceled, "other": models.ScheduledSent, "lapsed": models.ScheduledSent} {
+		if got := st.items[id].Status; got != want {
+			t.Errorf("%s: status %q, want %q", id, got, want)
+		}
+	}
+}
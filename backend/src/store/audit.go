@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordAudit appends an entry to the audit log. The store offers no way to change or delete
+// entries.
+func RecordAudit(ctx context.Context, e models.AuditEntry) error {
+	if auditColl == nil {
+		return fmt.Errorf("audit collection not initialized")
+	}
+	_, err := auditColl.InsertOne(ctx, e)
+	return err
+}
+
+// maxAuditPage bounds ListAudit; the default page is 100 entries.
+const maxAuditPage = 500
+
+// ListAudit returns audit entries matching f, newest first. An Action ending in "." matches every
+// action with that prefix (e.g. "sanction.").
+func ListAudit(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	if auditColl == nil {
+		return nil, fmt.Errorf("audit collection not initialized")
+	}
+	q := bson.M{}
+	for field, v := range map[string]string{"actor": f.Actor, "target": f.Target, "room_id": f.RoomID} {
+		if v != "" {
+			q[field] = v
+		}
+	}
+	switch {
+	case strings.HasSuffix(f.Action, "."):
+		q["action"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.Action)}
+	case f.Action != "":
+		q["action"] = f.Action
+	}
+	at := bson.M{}
+	if !f.From.IsZero() {
+		at["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		at["$lt"] = f.To
+	}
+	if len(at) > 0 {
+		q["at"] = at
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if limit > maxAuditPage {
+		limit = maxAuditPage
+	}
+	cur, err := auditColl.Find(ctx, q, options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.AuditEntry{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlockUser records that b.UserID blocked b.BlockedID; blocking twice returns models.ErrConflict.
+func BlockUser(ctx context.Context, b models.Block) error {
+	if blocksColl == nil {
+		return fmt.Errorf("blocks collection not initialized")
+	}
+	if _, err := blocksColl.InsertOne(ctx, b); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// UnblockUser removes a block; models.ErrNotFound if userID had not blocked blockedID.
+func UnblockUser(ctx context.Context, userID, blockedID string) error {
+	if blocksColl == nil {
+		return fmt.Errorf("blocks collection not initialized")
+	}
+	res, err := blocksColl.DeleteOne(ctx, bson.M{"user_id": userID, "blocked_id": blockedID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// ListBlocks returns the users userID blocked, oldest first.
+func ListBlocks(ctx context.Context, userID string) ([]models.Block, error) {
+	if blocksColl == nil {
+		return nil, fmt.Errorf("blocks collection not initialized")
+	}
+	cur, err := blocksColl.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Block{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IsBlocked reports whether userID blocked blockedID.
+func IsBlocked(ctx context.Context, userID, blockedID string) (bool, error) {
+	if blocksColl == nil {
+		return false, fmt.Errorf("blocks collection not initialized")
+	}
+	err := blocksColl.FindOne(ctx, bson.M{"user_id": userID, "blocked_id": blockedID}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
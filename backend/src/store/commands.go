@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateCommand registers an external command; models.ErrConflict if the name is taken.
+func CreateCommand(ctx context.Context, c models.ExternalCommand) error {
+	if commandsColl == nil {
+		return fmt.Errorf("commands collection not initialized")
+	}
+	if _, err := commandsColl.InsertOne(ctx, c); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// ListCommands returns the external commands sorted by name.
+func ListCommands(ctx context.Context) ([]models.ExternalCommand, error) {
+	if commandsColl == nil {
+		return nil, fmt.Errorf("commands collection not initialized")
+	}
+	cur, err := commandsColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.ExternalCommand{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetCommand returns the external command called name or models.ErrNotFound.
+func GetCommand(ctx context.Context, name string) (models.ExternalCommand, error) {
+	var c models.ExternalCommand
+	if commandsColl == nil {
+		return c, fmt.Errorf("commands collection not initialized")
+	}
+	err := commandsColl.FindOne(ctx, bson.M{"_id": name}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c, models.ErrNotFound
+	}
+	return c, err
+}
+
+// DeleteCommand unregisters an external command; models.ErrNotFound if there is none.
+func DeleteCommand(ctx context.Context, name string) error {
+	if commandsColl == nil {
+		return fmt.Errorf("commands collection not initialized")
+	}
+	res, err := commandsColl.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"src/models"
+)
+
+// KeyWrapper protects room data keys with a master key. FileKeyWrapper keeps master keys in
+// memory; a KMS client can implement it so master keys never enter this process.
+type KeyWrapper interface {
+	// ActiveKeyID names the master key Wrap uses; keys wrapped under another ID are rewrapped by
+	// RewrapRoomKeys.
+	ActiveKeyID() string
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// FileKeyWrapper wraps data keys with AES-256-GCM master keys read from a file.
+type FileKeyWrapper struct {
+	keys   map[string][]byte
+	active string
+}
+
+// LoadKeyFile reads master keys, one "<id>:<base64 of 32 bytes>" per line; blank lines and lines
+// starting with # are skipped. The last key is active, so a master key is rotated by appending a
+// line and keeping the old ones until RewrapRoomKeys has run.
+func LoadKeyFile(path string) (*FileKeyWrapper, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := &FileKeyWrapper{keys: map[string][]byte{}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, enc, ok := strings.Cut(line, ":")
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+		if !ok || id == "" || err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s:%d: want <id>:<base64 of 32 bytes>", path, n)
+		}
+		w.keys[id], w.active = key, id
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if w.active == "" {
+		return nil, fmt.Errorf("%s: no master keys", path)
+	}
+	return w, nil
+}
+
+func (w *FileKeyWrapper) ActiveKeyID() string { return w.active }
+
+func (w *FileKeyWrapper) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return seal(w.keys[w.active], dataKey, []byte("room-key"))
+}
+
+func (w *FileKeyWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := w.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+	return open(key, wrapped, []byte("room-key"))
+}
+
+// seal encrypts plaintext with AES-256-GCM, returning the nonce followed by the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedContent replaces the content and HTML of a message at rest.
+type sealedContent struct {
+	KeyVersion int    `bson:"key_version"`
+	Data       []byte `bson:"data"`
+}
+
+// storedMessage is a message document. With encryption on, Content and HTML are empty and Sealed
+// holds them; documents written before it was turned on have no Sealed.
+type storedMessage struct {
+	models.Message `bson:",inline"`
+	Sealed         *sealedContent `bson:"sealed,omitempty"`
+}
+
+type plainContent struct {
+	Content string `json:"content"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// contentAAD binds sealed content to its message and room, so it cannot be moved to another one.
+func contentAAD(msg models.Message) []byte {
+	return []byte(msg.MessageID + "\x00" + roomKeyID(msg.RoomID))
+}
+
+// sealContent encrypts the content and HTML of msg with a room data key.
+func sealContent(key []byte, version int, msg models.Message) (storedMessage, error) {
+	b, err := json.Marshal(plainContent{Content: msg.Content, HTML: msg.HTML})
+	if err != nil {
+		return storedMessage{}, err
+	}
+	data, err := seal(key, b, contentAAD(msg))
+	if err != nil {
+		return storedMessage{}, err
+	}
+	msg.Content, msg.HTML = "", ""
+	return storedMessage{Message: msg, Sealed: &sealedContent{KeyVersion: version, Data: data}}, nil
+}
+
+// openContent restores the content and HTML of a sealed message.
+func openContent(key []byte, sm storedMessage) (models.Message, error) {
+	msg := sm.Message
+	b, err := open(key, sm.Sealed.Data, contentAAD(msg))
+	if err != nil {
+		return msg, fmt.Errorf("open message %s: %w", msg.MessageID, err)
+	}
+	var pc plainContent
+	if err := json.Unmarshal(b, &pc); err != nil {
+		return msg, err
+	}
+	msg.Content, msg.HTML = pc.Content, pc.HTML
+	return msg, nil
+}
+
+// roomKeyID is the room whose data key protects a message; legacy room-less messages use the
+// default room's.
+func roomKeyID(roomID string) string {
+	if roomID == "" {
+		return models.DefaultRoomID
+	}
+	return roomID
+}
+
+// currentKeyTTL bounds how long a replica keeps sealing with a room key version after another
+// replica rotated it; messages sealed meanwhile are re-encrypted by the next pass.
+const currentKeyTTL = time.Minute
+
+// keyring caches unwrapped room data keys. It is nil while encryption is off.
+type keyring struct {
+	wrapper KeyWrapper
+	mu      sync.Mutex
+	keys    map[string][]byte // "<room>\x00<version>"
+	current map[string]currentKey
+}
+
+type currentKey struct {
+	version  int
+	loadedAt time.Time
+}
+
+var ring *keyring
+
+// EnableEncryption seals the content of messages written from now on with per-room data keys
+// wrapped by w, and lets reads open them. Call it before serving traffic.
+func EnableEncryption(w KeyWrapper) {
+	ring = &keyring{wrapper: w, keys: map[string][]byte{}, current: map[string]currentKey{}}
+}
+
+// EncryptionEnabled reports whether EnableEncryption was called.
+func EncryptionEnabled() bool { return ring != nil }
+
+func cacheKey(room string, version int) string { return room + "\x00" + strconv.Itoa(version) }
+
+// sealMessage prepares msg for storage: sealed when encryption is on and it has content.
+// End-to-end encrypted messages carry no content and are stored as they are.
+func sealMessage(ctx context.Context, msg models.Message) (storedMessage, error) {
+	if ring == nil || (msg.Content == "" && msg.HTML == "") {
+		return storedMessage{Message: msg}, nil
+	}
+	version, key, err := ring.currentKey(ctx, roomKeyID(msg.RoomID))
+	if err != nil {
+		return storedMessage{}, fmt.Errorf("room key: %w", err)
+	}
+	return sealContent(key, version, msg)
+}
+
+// openMessage returns the message of a stored document, decrypting it when sealed.
+func openMessage(ctx context.Context, sm storedMessage) (models.Message, error) {
+	if sm.Sealed == nil {
+		return sm.Message, nil
+	}
+	if ring == nil {
+		return sm.Message, fmt.Errorf("message %s is encrypted but no master key is configured", sm.MessageID)
+	}
+	key, err := ring.key(ctx, roomKeyID(sm.RoomID), sm.Sealed.KeyVersion)
+	if err != nil {
+		return sm.Message, fmt.Errorf("room key: %w", err)
+	}
+	return openContent(key, sm)
+}
+
+// currentKey returns the newest data key of room, creating the room's first key if needed.
+func (k *keyring) currentKey(ctx context.Context, room string) (int, []byte, error) {
+	k.mu.Lock()
+	cur, ok := k.current[room]
+	k.mu.Unlock()
+	if !ok || time.Since(cur.loadedAt) > currentKeyTTL {
+		rk, err := latestRoomKey(ctx, room)
+		if errors.Is(err, models.ErrNotFound) {
+			rk, err = createRoomKey(ctx, room, 1)
+			if errors.Is(err, models.ErrConflict) {
+				// Another replica created it first.
+				rk, err = latestRoomKey(ctx, room)
+			}
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		cur = currentKey{version: rk.Version, loadedAt: time.Now()}
+		k.mu.Lock()
+		k.current[room] = cur
+		k.mu.Unlock()
+	}
+	key, err := k.key(ctx, room, cur.version)
+	return cur.version, key, err
+}
+
+// key returns a room data key version, unwrapping and caching it on first use.
+func (k *keyring) key(ctx context.Context, room string, version int) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[cacheKey(room, version)]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	rk, err := getRoomKey(ctx, room, version)
+	if err != nil {
+		return nil, err
+	}
+	key, err = k.wrapper.Unwrap(ctx, rk.MasterKeyID, rk.Wrapped)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.keys[cacheKey(room, version)] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+// setCurrent records a version this replica just created as the room's current one.
+func (k *keyring) setCurrent(room string, version int) {
+	k.mu.Lock()
+	k.current[room] = currentKey{version: version, loadedAt: time.Now()}
+	k.mu.Unlock()
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"src/models"
+)
+
+func writeKeyFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	var b bytes.Buffer
+	for _, l := range lines {
+		b.WriteString(l + "\n")
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func masterKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32))
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	ctx := context.Background()
+	old, err := LoadKeyFile(writeKeyFile(t, "# master keys", "k1:"+masterKey(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := old.Wrap(ctx, []byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating the master key appends a line; keys wrapped under the old one still unwrap.
+	w, err := LoadKeyFile(writeKeyFile(t, "k1:"+masterKey(1), "", "k2:"+masterKey(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ActiveKeyID() != "k2" {
+		t.Fatalf("the last key should be active, got %q", w.ActiveKeyID())
+	}
+	if got, err := w.Unwrap(ctx, "k1", wrapped); err != nil || string(got) != "data key" {
+		t.Fatalf("unwrap under k1: %q %v", got, err)
+	}
+	if _, err := w.Unwrap(ctx, "k2", wrapped); err == nil {
+		t.Fatalf("unwrapping under the wrong master key should fail")
+	}
+	if _, err := w.Unwrap(ctx, "k3", wrapped); err == nil {
+		t.Fatalf("unwrapping under an unknown master key should fail")
+	}
+
+	for name, line := range map[string]string{"no id": ":" + masterKey(1), "short key": "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "no keys": "# empty"} {
+		if _, err := LoadKeyFile(writeKeyFile(t, line)); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSealContentRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	msg := models.Message{MessageID: "m1", UserID: "alice", RoomID: "dev", Content: "**secret**", HTML: "<strong>secret</strong>"}
+	sm, err := sealContent(key, 3, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sm.Content != "" || sm.HTML != "" || sm.Sealed.KeyVersion != 3 || bytes.Contains(sm.Sealed.Data, []byte("secret")) {
+		t.Fatalf("content should only be stored sealed: %+v", sm)
+	}
+	got, err := openContent(key, sm)
+	if err != nil || got != msg {
+		t.Fatalf("round trip: %+v %v", got, err)
+	}
+
+	// Sealed content is bound to its message and room.
+	moved := sm
+	moved.MessageID = "m2"
+	if _, err := openContent(key, moved); err == nil {
+		t.Fatalf("content copied to another message should not open")
+	}
+	moved = sm
+	moved.RoomID = "general"
+	if _, err := openContent(key, moved); err == nil {
+		t.Fatalf("content copied to another room should not open")
+	}
+	if _, err := openContent(bytes.Repeat([]byte{8}, 32), sm); err == nil {
+		t.Fatalf("content should not open with another room key")
+	}
+
+	// Legacy room-less messages belong to the default room.
+	legacy := models.Message{MessageID: "m3", Content: "hi"}
+	sm, _ = sealContent(key, 1, legacy)
+	sm.RoomID = models.DefaultRoomID
+	if _, err := openContent(key, sm); err != nil {
+		t.Fatalf("room-less messages should use the default room: %v", err)
+	}
+}
+
+func TestOpenMessageWithoutKeys(t *testing.T) {
+	ctx := context.Background()
+	plain := storedMessage{Message: models.Message{MessageID: "m1", Content: "hi"}}
+	if got, err := openMessage(ctx, plain); err != nil || got.Content != "hi" {
+		t.Fatalf("plain messages should read as stored: %+v %v", got, err)
+	}
+	sealed := storedMessage{Message: models.Message{MessageID: "m1"}, Sealed: &sealedContent{KeyVersion: 1}}
+	if _, err := openMessage(ctx, sealed); err == nil {
+		t.Fatalf("sealed messages should not read without a master key")
+	}
+	// Without encryption, messages are stored unchanged.
+	if sm, err := sealMessage(ctx, plain.Message); err != nil || sm.Sealed != nil || sm.Content != "hi" {
+		t.Fatalf("expected the message unchanged: %+v %v", sm, err)
+	}
+}
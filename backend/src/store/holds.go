@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateLegalHold stores a new active hold.
+func CreateLegalHold(ctx context.Context, h models.LegalHold) error {
+	if holdsColl == nil {
+		return fmt.Errorf("legal holds collection not initialized")
+	}
+	_, err := holdsColl.InsertOne(ctx, h)
+	return err
+}
+
+// ListLegalHolds returns all holds (active and released), newest first.
+func ListLegalHolds(ctx context.Context) ([]models.LegalHold, error) {
+	if holdsColl == nil {
+		return nil, fmt.Errorf("legal holds collection not initialized")
+	}
+	cur, err := holdsColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.LegalHold{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReleaseLegalHold marks an active hold released; models.ErrNotFound if none is active with id.
+func ReleaseLegalHold(ctx context.Context, id string, at time.Time) error {
+	if holdsColl == nil {
+		return fmt.Errorf("legal holds collection not initialized")
+	}
+	filter := bson.M{"_id": id, "released_at": bson.M{"$exists": false}}
+	res, err := holdsColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"released_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxOneTimePreKeys bounds the one-time prekeys stored per device; uploads beyond it drop the oldest.
+const maxOneTimePreKeys = 500
+
+// PutDeviceKeys registers a device's key bundle, replacing any earlier bundle of the device.
+func PutDeviceKeys(ctx context.Context, k models.DeviceKeys) error {
+	if keysColl == nil {
+		return fmt.Errorf("device keys collection not initialized")
+	}
+	if k.OneTimePreKeys == nil {
+		k.OneTimePreKeys = []models.PreKey{}
+	}
+	_, err := keysColl.ReplaceOne(ctx, bson.M{"user_id": k.UserID, "device_id": k.DeviceID}, k, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetDeviceKeys returns one device's keys or models.ErrNotFound.
+func GetDeviceKeys(ctx context.Context, userID, deviceID string) (models.DeviceKeys, error) {
+	var k models.DeviceKeys
+	if keysColl == nil {
+		return k, fmt.Errorf("device keys collection not initialized")
+	}
+	err := keysColl.FindOne(ctx, bson.M{"user_id": userID, "device_id": deviceID}).Decode(&k)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return k, models.ErrNotFound
+	}
+	k.PreKeysLeft = len(k.OneTimePreKeys)
+	return k, err
+}
+
+// ListDeviceKeys returns the devices of userID, oldest registration first.
+func ListDeviceKeys(ctx context.Context, userID string) ([]models.DeviceKeys, error) {
+	if keysColl == nil {
+		return nil, fmt.Errorf("device keys collection not initialized")
+	}
+	cur, err := keysColl.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.DeviceKeys{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].PreKeysLeft = len(out[i].OneTimePreKeys)
+	}
+	return out, nil
+}
+
+// AddOneTimePreKeys appends to a device's one-time prekeys; models.ErrNotFound if the device is not
+// registered.
+func AddOneTimePreKeys(ctx context.Context, userID, deviceID string, keys []models.PreKey) error {
+	if keysColl == nil {
+		return fmt.Errorf("device keys collection not initialized")
+	}
+	res, err := keysColl.UpdateOne(ctx, bson.M{"user_id": userID, "device_id": deviceID},
+		bson.M{"$push": bson.M{"one_time_prekeys": bson.M{"$each": keys, "$slice": -maxOneTimePreKeys}}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// ClaimKeyBundles returns a bundle for every device of userID (or only deviceID when set), each
+// taking the device's oldest one-time prekey so no two senders get the same one.
+func ClaimKeyBundles(ctx context.Context, userID, deviceID string) ([]models.KeyBundle, error) {
+	devices, err := ListDeviceKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := []models.KeyBundle{}
+	for _, d := range devices {
+		if deviceID != "" && d.DeviceID != deviceID {
+			continue
+		}
+		var k models.DeviceKeys
+		err := keysColl.FindOneAndUpdate(ctx, bson.M{"user_id": userID, "device_id": d.DeviceID},
+			bson.M{"$pop": bson.M{"one_time_prekeys": -1}}, options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&k)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Removed since the listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		b := models.KeyBundle{UserID: k.UserID, DeviceID: k.DeviceID, IdentityKey: k.IdentityKey, SignedPreKey: k.SignedPreKey}
+		if len(k.OneTimePreKeys) > 0 {
+			b.OneTimePreKey = &k.OneTimePreKeys[0]
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// DeleteDeviceKeys removes a device from the directory; models.ErrNotFound if it is not registered.
+func DeleteDeviceKeys(ctx context.Context, userID, deviceID string) error {
+	if keysColl == nil {
+		return fmt.Errorf("device keys collection not initialized")
+	}
+	res, err := keysColl.DeleteOne(ctx, bson.M{"user_id": userID, "device_id": deviceID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
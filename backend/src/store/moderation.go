@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// moderationConfigID is the _id of the single moderation configuration document.
+const moderationConfigID = "active"
+
+// GetModerationConfig returns the stored moderation configuration; an empty one if none was saved.
+func GetModerationConfig(ctx context.Context) (models.ModerationConfig, error) {
+	var cfg models.ModerationConfig
+	if modConfColl == nil {
+		return cfg, fmt.Errorf("moderation config collection not initialized")
+	}
+	err := modConfColl.FindOne(ctx, bson.M{"_id": moderationConfigID}).Decode(&cfg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.ModerationConfig{Rules: []models.ModerationRule{}}, nil
+	}
+	return cfg, err
+}
+
+// SaveModerationConfig replaces the moderation configuration.
+func SaveModerationConfig(ctx context.Context, cfg models.ModerationConfig) error {
+	if modConfColl == nil {
+		return fmt.Errorf("moderation config collection not initialized")
+	}
+	_, err := modConfColl.ReplaceOne(ctx, bson.M{"_id": moderationConfigID}, cfg, options.Replace().SetUpsert(true))
+	return err
+}
+
+// QueueFlagged stores a message held for review.
+func QueueFlagged(ctx context.Context, f models.FlaggedMessage) error {
+	if queueColl == nil {
+		return fmt.Errorf("moderation queue collection not initialized")
+	}
+	_, err := queueColl.InsertOne(ctx, f)
+	return err
+}
+
+// ListFlagged returns flagged messages with status (all if empty), in roomID (all if empty),
+// oldest first.
+func ListFlagged(ctx context.Context, roomID, status string) ([]models.FlaggedMessage, error) {
+	if queueColl == nil {
+		return nil, fmt.Errorf("moderation queue collection not initialized")
+	}
+	q := bson.M{}
+	if status != "" {
+		q["status"] = status
+	}
+	if roomID != "" {
+		q["message."+fieldRoomID] = roomID
+	}
+	cur, err := queueColl.Find(ctx, q, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(500))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.FlaggedMessage{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetFlagged returns the flagged message id or models.ErrNotFound.
+func GetFlagged(ctx context.Context, id string) (models.FlaggedMessage, error) {
+	var f models.FlaggedMessage
+	if queueColl == nil {
+		return f, fmt.Errorf("moderation queue collection not initialized")
+	}
+	err := queueColl.FindOne(ctx, bson.M{"_id": id}).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return f, models.ErrNotFound
+	}
+	return f, err
+}
+
+// ReviewFlagged moves a pending flagged message to status and returns it. Only one reviewer wins:
+// models.ErrConflict if it was already reviewed, models.ErrNotFound if there is no such message.
+func ReviewFlagged(ctx context.Context, id, status, by string, at time.Time) (models.FlaggedMessage, error) {
+	var f models.FlaggedMessage
+	if queueColl == nil {
+		return f, fmt.Errorf("moderation queue collection not initialized")
+	}
+	err := queueColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": models.ReviewPending},
+		bson.M{"$set": bson.M{"status": status, "reviewed_by": by, "reviewed_at": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&f)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, gerr := GetFlagged(ctx, id); gerr != nil {
+			return f, gerr
+		}
+		return f, models.ErrConflict
+	}
+	return f, err
+}
@@ -3,7 +3,6 @@ package store
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"src/config"
 	"src/logger"
@@ -16,8 +15,31 @@ import (
 )
 
 var (
-	client       *mongo.Client
-	messagesColl *mongo.Collection
+	client        *mongo.Client
+	messagesColl  *mongo.Collection
+	roomsColl     *mongo.Collection
+	pinsColl      *mongo.Collection
+	scheduledColl *mongo.Collection
+	leasesColl    *mongo.Collection
+	holdsColl     *mongo.Collection
+	roomRolesColl *mongo.Collection
+	accountsColl  *mongo.Collection
+	tokensColl    *mongo.Collection
+	hooksColl     *mongo.Collection
+	subsColl      *mongo.Collection
+	deliveryColl  *mongo.Collection
+	membersColl   *mongo.Collection
+	sanctionsColl *mongo.Collection
+	commandsColl  *mongo.Collection
+	limitsColl    *mongo.Collection
+	modConfColl   *mongo.Collection
+	queueColl     *mongo.Collection
+	auditColl     *mongo.Collection
+	blocksColl    *mongo.Collection
+	reportsColl   *mongo.Collection
+	keysColl      *mongo.Collection
+	roomKeysColl  *mongo.Collection
+	profilesColl  *mongo.Collection
 )
 
 // Init connects to MongoDB, pings, ensures indexes and prepares collections.
@@ -30,7 +52,31 @@ func Init(ctx context.Context) error {
 	if err := client.Ping(ctx, readpref.Primary()); err != nil {
 		return fmt.Errorf("mongo ping: %w", err)
 	}
-	messagesColl = client.Database("chatapp").Collection("messages")
+	db := client.Database("chatapp")
+	messagesColl = db.Collection("messages")
+	roomsColl = db.Collection("rooms")
+	pinsColl = db.Collection("pins")
+	scheduledColl = db.Collection("scheduled_messages")
+	leasesColl = db.Collection("leases")
+	holdsColl = db.Collection("legal_holds")
+	roomRolesColl = db.Collection("room_roles")
+	accountsColl = db.Collection("service_accounts")
+	tokensColl = db.Collection("api_tokens")
+	hooksColl = db.Collection("incoming_webhooks")
+	subsColl = db.Collection("webhook_subscriptions")
+	deliveryColl = db.Collection("webhook_deliveries")
+	membersColl = db.Collection("room_members")
+	sanctionsColl = db.Collection("sanctions")
+	commandsColl = db.Collection("commands")
+	limitsColl = db.Collection("rate_limits")
+	modConfColl = db.Collection("moderation_config")
+	queueColl = db.Collection("moderation_queue")
+	auditColl = db.Collection("audit_log")
+	blocksColl = db.Collection("blocks")
+	reportsColl = db.Collection("reports")
+	keysColl = db.Collection("device_keys")
+	roomKeysColl = db.Collection("room_keys")
+	profilesColl = db.Collection("profiles")
 	if err := ensureIndexes(ctx); err != nil {
 		return fmt.Errorf("ensure indexes: %w", err)
 	}
@@ -59,13 +105,33 @@ func InsertMessage(ctx context.Context, msg models.Message) error {
 	if messagesColl == nil {
 		return fmt.Errorf("messages collection not initialized")
 	}
+	doc, err := sealMessage(ctx, msg)
+	if err != nil {
+		return err
+	}
 	filter := bson.M{"message_id": msg.MessageID}
-	update := bson.M{"$setOnInsert": msg}
+	update := bson.M{"$setOnInsert": doc}
 	opts := options.Update().SetUpsert(true)
-	_, err := messagesColl.UpdateOne(ctx, filter, update, opts)
+	_, err = messagesColl.UpdateOne(ctx, filter, update, opts)
 	return err
 }
 
+// GetMessage returns a single message by id or models.ErrNotFound.
+func GetMessage(ctx context.Context, messageID string) (models.Message, error) {
+	var sm storedMessage
+	if messagesColl == nil {
+		return sm.Message, fmt.Errorf("messages collection not initialized")
+	}
+	err := messagesColl.FindOne(ctx, bson.M{"message_id": messageID}).Decode(&sm)
+	if err == mongo.ErrNoDocuments {
+		return sm.Message, models.ErrNotFound
+	}
+	if err != nil {
+		return sm.Message, err
+	}
+	return openMessage(ctx, sm)
+}
+
 // GetAllMessages returns all stored messages.
 func GetAllMessages(ctx context.Context) ([]models.Message, error) {
 	if messagesColl == nil {
@@ -78,8 +144,12 @@ func GetAllMessages(ctx context.Context) ([]models.Message, error) {
 	defer cur.Close(ctx)
 	var out []models.Message
 	for cur.Next(ctx) {
-		var m models.Message
-		if err := cur.Decode(&m); err != nil {
+		var sm storedMessage
+		if err := cur.Decode(&sm); err != nil {
+			return nil, err
+		}
+		m, err := openMessage(ctx, sm)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, m)
@@ -87,6 +157,34 @@ func GetAllMessages(ctx context.Context) ([]models.Message, error) {
 	return out, nil
 }
 
+// StreamMessages calls fn for every message matching filter in timestamp order without
+// buffering the result set, so exports of large rooms run in constant memory.
+func StreamMessages(ctx context.Context, filter models.MessageFilter, fn func(models.Message) error) error {
+	if messagesColl == nil {
+		return fmt.Errorf("messages collection not initialized")
+	}
+	q := HoldFilter(models.LegalHold{RoomID: filter.RoomID, UserID: filter.UserID, From: filter.From, To: filter.To})
+	cur, err := messagesColl.Find(ctx, q, options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}).SetBatchSize(500))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var sm storedMessage
+		if err := cur.Decode(&sm); err != nil {
+			return err
+		}
+		m, err := openMessage(ctx, sm)
+		if err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
 func ensureIndexes(ctx context.Context) error {
 	if messagesColl == nil {
 		return fmt.Errorf("messages collection not initialized")
@@ -94,9 +192,100 @@ func ensureIndexes(ctx context.Context) error {
 	_, err := messagesColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "message_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_message_id")},
 		{Keys: bson.D{{Key: "timestamp", Value: 1}}, Options: options.Index().SetName("idx_timestamp")},
+		{Keys: bson.D{{Key: fieldRoomID, Value: 1}, {Key: fieldKeyVersion, Value: 1}}, Options: options.Index().SetName("idx_roomid_key_version")},
+		{Keys: bson.D{{Key: fieldUserID, Value: 1}, {Key: fieldRoomID, Value: 1}}, Options: options.Index().SetName("idx_userid_roomid")},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := roomsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_room_id"),
+	}); err != nil {
+		return err
+	}
+	if _, err := pinsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "message_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_room_pin"),
+	}); err != nil {
+		return err
+	}
+	if _, err := roomRolesColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_room_user"),
+	}); err != nil {
+		return err
+	}
+	if _, err := accountsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_account_name"),
+	}); err != nil {
+		return err
+	}
+	if _, err := tokensColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("idx_account_created"),
+	}); err != nil {
+		return err
+	}
+	if _, err := hooksColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("idx_room_created"),
+	}); err != nil {
+		return err
+	}
+	if _, err := deliveryColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_subscription_event")},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}, Options: options.Index().SetName("idx_status_next_attempt")},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("idx_subscription_created")},
+	}); err != nil {
+		return err
+	}
+	if _, err := membersColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_room_member")},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("idx_user_id")},
+	}); err != nil {
+		return err
+	}
+	if _, err := sanctionsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "kind", Value: 1}}, Options: options.Index().SetName("idx_user_kind"),
+	}); err != nil {
+		return err
+	}
+	if _, err := limitsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0).SetName("ttl_expires_at"),
+	}); err != nil {
+		return err
+	}
+	if _, err := queueColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "message." + fieldRoomID, Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("idx_status_roomid_created"),
+	}); err != nil {
+		return err
+	}
+	if _, err := auditColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "at", Value: -1}}, Options: options.Index().SetName("idx_at")},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "at", Value: -1}}, Options: options.Index().SetName("idx_target_at")},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}}, Options: options.Index().SetName("idx_actor_at")},
+	}); err != nil {
+		return err
+	}
+	if _, err := blocksColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "blocked_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_user_blocked"),
+	}); err != nil {
+		return err
+	}
+	if _, err := reportsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "room_id", Value: 1}, {Key: "created_at", Value: 1}}, Options: options.Index().SetName("idx_status_room_created"),
+	}); err != nil {
+		return err
+	}
+	if _, err := keysColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "device_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_user_device"),
+	}); err != nil {
+		return err
+	}
+	if _, err := roomKeysColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "room_id", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true).SetName("uniq_room_version"),
+	}); err != nil {
+		return err
+	}
+	_, err = scheduledColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "send_at", Value: 1}}, Options: options.Index().SetName("idx_status_send_at")},
+		{Keys: bson.D{{Key: "created_by", Value: 1}, {Key: "status", Value: 1}}, Options: options.Index().SetName("idx_created_by_status")},
 	})
 	return err
 }
-
-// Helper for TTL or future retention policies (placeholder).
-func PruneOldMessages(ctx context.Context, olderThan time.Duration) error { return nil }
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"testing"
+	"time"
 
 	"src/models"
 )
@@ -36,3 +37,223 @@ func TestGetAllMessagesWithoutInit(t *testing.T) {
 func dummyMessage() models.Message {
 	return models.Message{MessageID: "test-id", UserID: "u", Content: "c"}
 }
+
+func TestRoomsAndPinsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateRoom(ctx, models.Room{RoomID: "r"}); err == nil {
+		t.Fatalf("expected error when creating room before Init")
+	}
+	if err := PinMessage(ctx, models.Pin{RoomID: "r", MessageID: "m"}); err == nil {
+		t.Fatalf("expected error when pinning before Init")
+	}
+	if _, err := ListPins(ctx, "r"); err == nil {
+		t.Fatalf("expected error when listing pins before Init")
+	}
+	if _, err := UserRooms(ctx, "u"); err == nil {
+		t.Fatalf("expected error when listing a user's rooms before Init")
+	}
+	if err := PruneOldMessages(ctx, time.Hour); err == nil {
+		t.Fatalf("expected error when pruning before Init")
+	}
+}
+
+func TestExportAndHoldsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := StreamMessages(ctx, models.MessageFilter{}, func(models.Message) error { return nil }); err == nil {
+		t.Fatalf("expected error when streaming before Init")
+	}
+	if err := CreateLegalHold(ctx, models.LegalHold{ID: "h"}); err == nil {
+		t.Fatalf("expected error when creating hold before Init")
+	}
+	if err := ReleaseLegalHold(ctx, "h", time.Now()); err == nil {
+		t.Fatalf("expected error when releasing hold before Init")
+	}
+}
+
+func TestServiceAccountsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateServiceAccount(ctx, models.ServiceAccount{ID: "a", Name: "ci"}); err == nil {
+		t.Fatalf("expected error when creating account before Init")
+	}
+	if _, err := GetAPIToken(ctx, "t"); err == nil {
+		t.Fatalf("expected error when reading token before Init")
+	}
+	if err := RevokeAPIToken(ctx, "a", "t", time.Now()); err == nil {
+		t.Fatalf("expected error when revoking token before Init")
+	}
+}
+
+func TestIncomingWebhooksWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateIncomingWebhook(ctx, models.IncomingWebhook{ID: "h", RoomID: "general"}); err == nil {
+		t.Fatalf("expected error when creating hook before Init")
+	}
+	if _, err := GetIncomingWebhook(ctx, "h"); err == nil {
+		t.Fatalf("expected error when reading hook before Init")
+	}
+	if err := RevokeIncomingWebhook(ctx, "h", time.Now()); err == nil {
+		t.Fatalf("expected error when revoking hook before Init")
+	}
+}
+
+func TestWebhookSubscriptionsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateWebhookSubscription(ctx, models.WebhookSubscription{ID: "s"}); err == nil {
+		t.Fatalf("expected error when creating subscription before Init")
+	}
+	if _, err := DueWebhookDeliveries(ctx, time.Now(), 10); err == nil {
+		t.Fatalf("expected error when polling deliveries before Init")
+	}
+	if err := RequeueWebhookDelivery(ctx, "s", "d", time.Now()); err == nil {
+		t.Fatalf("expected error when requeueing before Init")
+	}
+}
+
+func TestCommandsAndSanctionsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateCommand(ctx, models.ExternalCommand{Name: "deploy"}); err == nil {
+		t.Fatalf("expected error when registering command before Init")
+	}
+	if _, err := GetCommand(ctx, "deploy"); err == nil {
+		t.Fatalf("expected error when reading command before Init")
+	}
+	if err := CreateSanction(ctx, models.Sanction{ID: "s", Kind: models.SanctionMute, UserID: "bob"}); err == nil {
+		t.Fatalf("expected error when creating sanction before Init")
+	}
+	if _, err := ActiveSanctions(ctx, "bob", time.Now()); err == nil {
+		t.Fatalf("expected error when reading sanctions before Init")
+	}
+	if err := AddRoomMember(ctx, models.RoomMember{RoomID: "r", UserID: "bob"}); err == nil {
+		t.Fatalf("expected error when adding member before Init")
+	}
+}
+
+func TestTakeRateTokenWithoutInit(t *testing.T) {
+	if _, _, err := TakeRateToken(context.Background(), "k", 10, 1, time.Now()); err == nil {
+		t.Fatalf("expected error when taking a token before Init")
+	}
+}
+
+func TestModerationWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if _, err := GetModerationConfig(ctx); err == nil {
+		t.Fatalf("expected error when reading moderation config before Init")
+	}
+	if err := SaveModerationConfig(ctx, models.ModerationConfig{}); err == nil {
+		t.Fatalf("expected error when saving moderation config before Init")
+	}
+	if err := QueueFlagged(ctx, models.FlaggedMessage{ID: "m"}); err == nil {
+		t.Fatalf("expected error when queueing before Init")
+	}
+	if _, err := ReviewFlagged(ctx, "m", models.ReviewApproved, "mod", time.Now()); err == nil {
+		t.Fatalf("expected error when reviewing before Init")
+	}
+}
+
+func TestSanctionAdminAndAuditWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if _, err := ListSanctions(ctx, "bob", ""); err == nil {
+		t.Fatalf("expected error when listing sanctions before Init")
+	}
+	if _, err := GetSanction(ctx, "s"); err == nil {
+		t.Fatalf("expected error when getting a sanction before Init")
+	}
+	if _, err := LiftSanction(ctx, "s", time.Now()); err == nil {
+		t.Fatalf("expected error when lifting a sanction before Init")
+	}
+	if err := RecordAudit(ctx, models.AuditEntry{ID: "a", Action: "sanction.ban"}); err == nil {
+		t.Fatalf("expected error when recording audit before Init")
+	}
+	if _, err := ListAudit(ctx, models.AuditFilter{Action: "sanction."}); err == nil {
+		t.Fatalf("expected error when listing audit before Init")
+	}
+}
+
+func TestBlocksWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := BlockUser(ctx, models.Block{UserID: "alice", BlockedID: "bob"}); err == nil {
+		t.Fatalf("expected error when blocking before Init")
+	}
+	if err := UnblockUser(ctx, "alice", "bob"); err == nil {
+		t.Fatalf("expected error when unblocking before Init")
+	}
+	if _, err := ListBlocks(ctx, "alice"); err == nil {
+		t.Fatalf("expected error when listing blocks before Init")
+	}
+	if _, err := IsBlocked(ctx, "alice", "bob"); err == nil {
+		t.Fatalf("expected error when checking a block before Init")
+	}
+}
+
+func TestDeviceKeysWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := PutDeviceKeys(ctx, models.DeviceKeys{UserID: "alice", DeviceID: "phone"}); err == nil {
+		t.Fatalf("expected error when registering a device before Init")
+	}
+	if _, err := GetDeviceKeys(ctx, "alice", "phone"); err == nil {
+		t.Fatalf("expected error when getting a device before Init")
+	}
+	if err := AddOneTimePreKeys(ctx, "alice", "phone", []models.PreKey{{KeyID: 1}}); err == nil {
+		t.Fatalf("expected error when adding prekeys before Init")
+	}
+	if _, err := ClaimKeyBundles(ctx, "alice", ""); err == nil {
+		t.Fatalf("expected error when claiming bundles before Init")
+	}
+	if err := DeleteDeviceKeys(ctx, "alice", "phone"); err == nil {
+		t.Fatalf("expected error when removing a device before Init")
+	}
+}
+
+func TestReportsWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if _, err := AddReport(ctx, models.Message{MessageID: "m"}, models.ReportEntry{ReporterID: "bob"}); err == nil {
+		t.Fatalf("expected error when reporting before Init")
+	}
+	if _, err := ListReports(ctx, models.ReportFilter{}); err == nil {
+		t.Fatalf("expected error when listing reports before Init")
+	}
+	if _, err := GetReport(ctx, "m"); err == nil {
+		t.Fatalf("expected error when getting a report before Init")
+	}
+	if _, err := ResolveReport(ctx, "m", models.ReportDismissed, "admin", "", time.Now()); err == nil {
+		t.Fatalf("expected error when resolving a report before Init")
+	}
+	if err := SetMessageHidden(ctx, "m", true); err == nil {
+		t.Fatalf("expected error when hiding a message before Init")
+	}
+}
+
+func TestRoomKeysWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if _, err := RotateRoomKey(ctx, "dev"); err == nil {
+		t.Fatalf("expected error when rotating without encryption")
+	}
+	if _, err := ReencryptMessages(ctx, 10); err == nil {
+		t.Fatalf("expected error when re-encrypting without encryption")
+	}
+	if _, err := RotateStaleRoomKeys(ctx, time.Now(), time.Hour); err == nil {
+		t.Fatalf("expected error when rotating stale keys before Init")
+	}
+}
+
+func TestProfilesWithoutInit(t *testing.T) {
+	ctx := context.Background()
+	if err := CreateProfile(ctx, models.Profile{UserID: "alice"}); err == nil {
+		t.Fatalf("expected error when creating a profile before Init")
+	}
+	if _, err := GetProfile(ctx, "alice"); err == nil {
+		t.Fatalf("expected error when getting a profile before Init")
+	}
+	if _, err := GetProfiles(ctx, []string{"alice"}); err == nil {
+		t.Fatalf("expected error when listing profiles before Init")
+	}
+	if _, err := UpdateProfile(ctx, "alice", models.ProfileUpdate{}, time.Now()); err == nil {
+		t.Fatalf("expected error when updating a profile before Init")
+	}
+	if _, err := SetStatus(ctx, "alice", &models.UserStatus{State: models.StatusBusy}); err == nil {
+		t.Fatalf("expected error when setting a status before Init")
+	}
+	if _, err := SetAutoStatus(ctx, "alice", nil, time.Now()); err == nil {
+		t.Fatalf("expected error when clearing an automatic status before Init")
+	}
+}
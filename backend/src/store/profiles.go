@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateProfile stores a new profile; models.ErrConflict if the user already has one.
+func CreateProfile(ctx context.Context, p models.Profile) error {
+	if profilesColl == nil {
+		return fmt.Errorf("profiles collection not initialized")
+	}
+	if _, err := profilesColl.InsertOne(ctx, p); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// GetProfile returns a user's profile or models.ErrNotFound.
+func GetProfile(ctx context.Context, userID string) (models.Profile, error) {
+	var p models.Profile
+	if profilesColl == nil {
+		return p, fmt.Errorf("profiles collection not initialized")
+	}
+	err := profilesColl.FindOne(ctx, bson.M{"_id": userID}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return p, models.ErrNotFound
+	}
+	return p, err
+}
+
+// GetProfiles returns the profiles of userIDs that exist, in no particular order.
+func GetProfiles(ctx context.Context, userIDs []string) ([]models.Profile, error) {
+	if profilesColl == nil {
+		return nil, fmt.Errorf("profiles collection not initialized")
+	}
+	cur, err := profilesColl.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Profile{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpdateProfile applies the set fields of u and returns the updated profile, or models.ErrNotFound.
+func UpdateProfile(ctx context.Context, userID string, u models.ProfileUpdate, at time.Time) (models.Profile, error) {
+	var p models.Profile
+	if profilesColl == nil {
+		return p, fmt.Errorf("profiles collection not initialized")
+	}
+	set := bson.M{"updated_at": at}
+	unset := bson.M{}
+	if u.DisplayName != nil {
+		set["display_name"] = *u.DisplayName
+	}
+	if u.AvatarURL != nil && *u.AvatarURL != "" {
+		set["avatar_url"] = *u.AvatarURL
+	} else if u.AvatarURL != nil {
+		unset["avatar_url"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := profilesColl.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update, opts).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return p, models.ErrNotFound
+	}
+	return p, err
+}
+
+// SetStatus sets a user's status (nil clears it) and returns the profile, or models.ErrNotFound.
+func SetStatus(ctx context.Context, userID string, st *models.UserStatus) (models.Profile, error) {
+	update := bson.M{"$unset": bson.M{"status": ""}}
+	if st != nil {
+		update = bson.M{"$set": bson.M{"status": st}}
+	}
+	return updateStatus(ctx, bson.M{"_id": userID}, update)
+}
+
+// SetAutoStatus sets a status on the user's behalf without overriding one they chose: it applies
+// only while they have no unexpired status or an automatic one. A nil st clears an automatic
+// status only. models.ErrConflict when nothing changed.
+func SetAutoStatus(ctx context.Context, userID string, st *models.UserStatus, now time.Time) (models.Profile, error) {
+	filter := bson.M{"_id": userID, "status.auto": true}
+	update := bson.M{"$unset": bson.M{"status": ""}}
+	if st != nil {
+		filter = bson.M{"_id": userID, "$or": []bson.M{{"status": nil}, {"status.auto": true}, {"status.expires_at": bson.M{"$lte": now}}}}
+		update = bson.M{"$set": bson.M{"status": st}}
+	}
+	p, err := updateStatus(ctx, filter, update)
+	if errors.Is(err, models.ErrNotFound) {
+		return p, models.ErrConflict
+	}
+	return p, err
+}
+
+func updateStatus(ctx context.Context, filter, update bson.M) (models.Profile, error) {
+	var p models.Profile
+	if profilesColl == nil {
+		return p, fmt.Errorf("profiles collection not initialized")
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := profilesColl.FindOneAndUpdate(ctx, filter, update, opts).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return p, models.ErrNotFound
+	}
+	return p, err
+}
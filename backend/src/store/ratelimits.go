@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TakeRateToken refills the token bucket key (capacity tokens, refilled at perSecond) and takes a
+// token if one is left, in a single pipeline update so concurrent replicas cannot both take the
+// last token. It returns whether a token was taken and how many remain. Idle buckets expire
+// through a TTL index once they would be full again.
+func TakeRateToken(ctx context.Context, key string, capacity, perSecond float64, now time.Time) (bool, float64, error) {
+	if limitsColl == nil {
+		return false, 0, fmt.Errorf("rate limits collection not initialized")
+	}
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}}
+	refilled := bson.M{"$min": bson.A{capacity, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", capacity}}, bson.M{"$multiply": bson.A{elapsed, perSecond}}}}}}
+	take := bson.M{"$gte": bson.A{"$tokens", 1}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "updated_at": now}}},
+		{{Key: "$set", Value: bson.M{"allowed": take, "tokens": bson.M{"$cond": bson.A{take, bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+		{{Key: "$set", Value: bson.M{"expires_at": bson.M{"$add": bson.A{now, bson.M{"$multiply": bson.A{bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{capacity, "$tokens"}}, perSecond}}, 1000}}}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var doc struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := limitsColl.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&doc)
+	if mongo.IsDuplicateKeyError(err) {
+		// Two replicas created the bucket at once; the loser retries against the stored document.
+		err = limitsColl.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&doc)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return doc.Allowed, doc.Tokens, nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AddReport records e against msg and returns the updated report. The update only matches while
+// e.ReporterID has not reported msg, so a second report by the same user falls through to the
+// upsert, hits the _id and returns models.ErrConflict.
+func AddReport(ctx context.Context, msg models.Message, e models.ReportEntry) (models.Report, error) {
+	var rep models.Report
+	if reportsColl == nil {
+		return rep, fmt.Errorf("reports collection not initialized")
+	}
+	room := msg.RoomID
+	if room == "" {
+		room = models.DefaultRoomID
+	}
+	err := reportsColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": msg.MessageID, "reports.reporter_id": bson.M{"$ne": e.ReporterID}},
+		bson.M{
+			"$push":        bson.M{"reports": e},
+			"$inc":         bson.M{"count": 1},
+			"$set":         bson.M{"status": models.ReportOpen, "updated_at": e.At},
+			"$setOnInsert": bson.M{"room_id": room, "author_id": msg.UserID, "hidden": false, "created_at": e.At},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&rep)
+	if mongo.IsDuplicateKeyError(err) {
+		return rep, models.ErrConflict
+	}
+	return rep, err
+}
+
+// ListReports returns reports matching f, oldest first.
+func ListReports(ctx context.Context, f models.ReportFilter) ([]models.Report, error) {
+	if reportsColl == nil {
+		return nil, fmt.Errorf("reports collection not initialized")
+	}
+	q := bson.M{}
+	if f.Status != "" {
+		q["status"] = f.Status
+	}
+	if f.RoomID != "" {
+		q["room_id"] = f.RoomID
+	}
+	if f.AuthorID != "" {
+		q["author_id"] = f.AuthorID
+	}
+	cur, err := reportsColl.Find(ctx, q, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(500))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Report{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetReport returns the report of messageID or models.ErrNotFound.
+func GetReport(ctx context.Context, messageID string) (models.Report, error) {
+	var rep models.Report
+	if reportsColl == nil {
+		return rep, fmt.Errorf("reports collection not initialized")
+	}
+	err := reportsColl.FindOne(ctx, bson.M{"_id": messageID}).Decode(&rep)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return rep, models.ErrNotFound
+	}
+	return rep, err
+}
+
+// ResolveReport settles an open report; models.ErrConflict if it is not open.
+func ResolveReport(ctx context.Context, messageID, status, by, note string, at time.Time) (models.Report, error) {
+	var rep models.Report
+	if reportsColl == nil {
+		return rep, fmt.Errorf("reports collection not initialized")
+	}
+	err := reportsColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": messageID, "status": models.ReportOpen},
+		bson.M{"$set": bson.M{"status": status, "resolved_by": by, "resolved_at": at, "note": note}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&rep)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, gerr := GetReport(ctx, messageID); gerr != nil {
+			return rep, gerr
+		}
+		return rep, models.ErrConflict
+	}
+	return rep, err
+}
+
+// SetMessageHidden hides or restores a message and records it on the message's report.
+func SetMessageHidden(ctx context.Context, messageID string, hidden bool) error {
+	if messagesColl == nil || reportsColl == nil {
+		return fmt.Errorf("messages collection not initialized")
+	}
+	if _, err := messagesColl.UpdateOne(ctx, bson.M{fieldMessageID: messageID}, bson.M{"$set": bson.M{fieldHidden: hidden}}); err != nil {
+		return err
+	}
+	_, err := reportsColl.UpdateOne(ctx, bson.M{"_id": messageID}, bson.M{"$set": bson.M{"hidden": hidden}})
+	return err
+}
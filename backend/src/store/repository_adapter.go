@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"src/models"
+	"time"
 )
 
 // RepositoryAdapter exposes store functions as an object implementing api.Repository.
@@ -14,3 +15,364 @@ func (RepositoryAdapter) InsertMessage(ctx context.Context, msg models.Message)
 func (RepositoryAdapter) GetAllMessages(ctx context.Context) ([]models.Message, error) {
 	return GetAllMessages(ctx)
 }
+func (RepositoryAdapter) StreamMessages(ctx context.Context, filter models.MessageFilter, fn func(models.Message) error) error {
+	return StreamMessages(ctx, filter, fn)
+}
+
+// RoomAdapter exposes room and pin functions as an object implementing api.RoomRepository.
+type RoomAdapter struct{}
+
+func (RoomAdapter) CreateRoom(ctx context.Context, room models.Room) error {
+	return CreateRoom(ctx, room)
+}
+func (RoomAdapter) GetRoom(ctx context.Context, roomID string) (models.Room, error) {
+	return GetRoom(ctx, roomID)
+}
+func (RoomAdapter) GetMessage(ctx context.Context, messageID string) (models.Message, error) {
+	return GetMessage(ctx, messageID)
+}
+func (RoomAdapter) PinMessage(ctx context.Context, pin models.Pin) error {
+	return PinMessage(ctx, pin)
+}
+func (RoomAdapter) UnpinMessage(ctx context.Context, roomID, messageID string) error {
+	return UnpinMessage(ctx, roomID, messageID)
+}
+func (RoomAdapter) GetRoomRole(ctx context.Context, roomID, userID string) (models.Role, error) {
+	return GetRoomRole(ctx, roomID, userID)
+}
+func (RoomAdapter) SetRoomRole(ctx context.Context, rr models.RoomRole) error {
+	return SetRoomRole(ctx, rr)
+}
+func (RoomAdapter) RemoveRoomRole(ctx context.Context, roomID, userID string) error {
+	return RemoveRoomRole(ctx, roomID, userID)
+}
+func (RoomAdapter) ListRoomRoles(ctx context.Context, roomID string) ([]models.RoomRole, error) {
+	return ListRoomRoles(ctx, roomID)
+}
+func (RoomAdapter) SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error {
+	return SetRoomRetention(ctx, roomID, retention)
+}
+func (RoomAdapter) SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	return SetRoomTopic(ctx, roomID, topic)
+}
+func (RoomAdapter) AddRoomMember(ctx context.Context, m models.RoomMember) error {
+	return AddRoomMember(ctx, m)
+}
+func (RoomAdapter) ListRoomMembers(ctx context.Context, roomID string) ([]models.RoomMember, error) {
+	return ListRoomMembers(ctx, roomID)
+}
+func (RoomAdapter) ListPins(ctx context.Context, roomID string) ([]models.Pin, error) {
+	return ListPins(ctx, roomID)
+}
+func (RoomAdapter) UserRooms(ctx context.Context, userID string) ([]string, error) {
+	return UserRooms(ctx, userID)
+}
+
+// ScheduledAdapter exposes scheduled message, lease and sanction functions (api.ScheduledRepository,
+// scheduler.Store).
+type ScheduledAdapter struct{}
+
+func (ScheduledAdapter) CreateScheduled(ctx context.Context, sm models.ScheduledMessage) error {
+	return CreateScheduled(ctx, sm)
+}
+func (ScheduledAdapter) ListScheduled(ctx context.Context, createdBy string) ([]models.ScheduledMessage, error) {
+	return ListScheduled(ctx, createdBy)
+}
+func (ScheduledAdapter) CancelScheduled(ctx context.Context, id, createdBy string) error {
+	return CancelScheduled(ctx, id, createdBy)
+}
+func (ScheduledAdapter) DueScheduled(ctx context.Context, now time.Time, staleAfter time.Duration, limit int) ([]models.ScheduledMessage, error) {
+	return DueScheduled(ctx, now, staleAfter, limit)
+}
+func (ScheduledAdapter) ClaimScheduled(ctx context.Context, sm models.ScheduledMessage, now time.Time) (bool, error) {
+	return ClaimScheduled(ctx, sm, now)
+}
+func (ScheduledAdapter) SetScheduledStatus(ctx context.Context, id, status string) error {
+	return SetScheduledStatus(ctx, id, status)
+}
+func (ScheduledAdapter) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	return AcquireLease(ctx, name, holder, now, ttl)
+}
+func (ScheduledAdapter) ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error) {
+	return ActiveSanctions(ctx, userID, now)
+}
+
+// RetentionAdapter exposes retention functions (retention.Store).
+type RetentionAdapter struct{}
+
+func (RetentionAdapter) Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	return Prune(ctx, policy)
+}
+func (RetentionAdapter) RoomRetentions(ctx context.Context) (map[string]time.Duration, error) {
+	return RoomRetentions(ctx)
+}
+func (RetentionAdapter) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	return AcquireLease(ctx, name, holder, now, ttl)
+}
+
+// HoldAdapter exposes legal hold functions as an object implementing api.HoldRepository.
+type HoldAdapter struct{}
+
+func (HoldAdapter) CreateLegalHold(ctx context.Context, h models.LegalHold) error {
+	return CreateLegalHold(ctx, h)
+}
+func (HoldAdapter) ListLegalHolds(ctx context.Context) ([]models.LegalHold, error) {
+	return ListLegalHolds(ctx)
+}
+func (HoldAdapter) ReleaseLegalHold(ctx context.Context, id string, at time.Time) error {
+	return ReleaseLegalHold(ctx, id, at)
+}
+
+// TokenAdapter exposes service account and API token functions as an object implementing api.TokenRepository.
+type TokenAdapter struct{}
+
+func (TokenAdapter) CreateServiceAccount(ctx context.Context, a models.ServiceAccount) error {
+	return CreateServiceAccount(ctx, a)
+}
+func (TokenAdapter) ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	return ListServiceAccounts(ctx)
+}
+func (TokenAdapter) GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error) {
+	return GetServiceAccount(ctx, id)
+}
+func (TokenAdapter) DisableServiceAccount(ctx context.Context, id string, at time.Time) error {
+	return DisableServiceAccount(ctx, id, at)
+}
+func (TokenAdapter) CreateAPIToken(ctx context.Context, t models.APIToken) error {
+	return CreateAPIToken(ctx, t)
+}
+func (TokenAdapter) ListAPITokens(ctx context.Context, accountID string) ([]models.APIToken, error) {
+	return ListAPITokens(ctx, accountID)
+}
+func (TokenAdapter) GetAPIToken(ctx context.Context, id string) (models.APIToken, error) {
+	return GetAPIToken(ctx, id)
+}
+func (TokenAdapter) RevokeAPIToken(ctx context.Context, accountID, id string, at time.Time) error {
+	return RevokeAPIToken(ctx, accountID, id, at)
+}
+func (TokenAdapter) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	return TouchAPIToken(ctx, id, at)
+}
+
+// WebhookAdapter exposes incoming webhook functions as an object implementing api.WebhookRepository.
+type WebhookAdapter struct{}
+
+func (WebhookAdapter) CreateIncomingWebhook(ctx context.Context, h models.IncomingWebhook) error {
+	return CreateIncomingWebhook(ctx, h)
+}
+func (WebhookAdapter) ListIncomingWebhooks(ctx context.Context, roomID string) ([]models.IncomingWebhook, error) {
+	return ListIncomingWebhooks(ctx, roomID)
+}
+func (WebhookAdapter) GetIncomingWebhook(ctx context.Context, id string) (models.IncomingWebhook, error) {
+	return GetIncomingWebhook(ctx, id)
+}
+func (WebhookAdapter) RevokeIncomingWebhook(ctx context.Context, id string, at time.Time) error {
+	return RevokeIncomingWebhook(ctx, id, at)
+}
+
+// SubscriptionAdapter exposes outgoing webhook functions as an object implementing
+// api.SubscriptionRepository and dispatcher.Store.
+type SubscriptionAdapter struct{}
+
+func (SubscriptionAdapter) CreateWebhookSubscription(ctx context.Context, s models.WebhookSubscription) error {
+	return CreateWebhookSubscription(ctx, s)
+}
+func (SubscriptionAdapter) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return ListWebhookSubscriptions(ctx)
+}
+func (SubscriptionAdapter) DisableWebhookSubscription(ctx context.Context, id string, at time.Time) error {
+	return DisableWebhookSubscription(ctx, id, at)
+}
+func (SubscriptionAdapter) CreateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	return CreateWebhookDelivery(ctx, d)
+}
+func (SubscriptionAdapter) DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	return DueWebhookDeliveries(ctx, now, limit)
+}
+func (SubscriptionAdapter) RecordWebhookAttempt(ctx context.Context, id string, a models.DeliveryAttempt, status string, next time.Time) error {
+	return RecordWebhookAttempt(ctx, id, a, status, next)
+}
+func (SubscriptionAdapter) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]models.WebhookDelivery, error) {
+	return ListWebhookDeliveries(ctx, subscriptionID, limit)
+}
+func (SubscriptionAdapter) RequeueWebhookDelivery(ctx context.Context, subscriptionID, id string, now time.Time) error {
+	return RequeueWebhookDelivery(ctx, subscriptionID, id, now)
+}
+func (SubscriptionAdapter) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	return AcquireLease(ctx, name, holder, now, ttl)
+}
+
+// SanctionAdapter exposes sanction functions as an object implementing api.SanctionRepository.
+type SanctionAdapter struct{}
+
+func (SanctionAdapter) CreateSanction(ctx context.Context, s models.Sanction) error {
+	return CreateSanction(ctx, s)
+}
+func (SanctionAdapter) ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error) {
+	return ActiveSanctions(ctx, userID, now)
+}
+func (SanctionAdapter) ListSanctions(ctx context.Context, userID, roomID string) ([]models.Sanction, error) {
+	return ListSanctions(ctx, userID, roomID)
+}
+func (SanctionAdapter) GetSanction(ctx context.Context, id string) (models.Sanction, error) {
+	return GetSanction(ctx, id)
+}
+func (SanctionAdapter) LiftSanction(ctx context.Context, id string, at time.Time) (models.Sanction, error) {
+	return LiftSanction(ctx, id, at)
+}
+
+// AuditAdapter exposes the audit log as an object implementing api.AuditRepository.
+type AuditAdapter struct{}
+
+func (AuditAdapter) RecordAudit(ctx context.Context, e models.AuditEntry) error {
+	return RecordAudit(ctx, e)
+}
+
+func (AuditAdapter) ListAudit(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	return ListAudit(ctx, f)
+}
+
+// CommandAdapter exposes external command functions as an object implementing api.CommandRepository.
+type CommandAdapter struct{}
+
+func (CommandAdapter) CreateCommand(ctx context.Context, c models.ExternalCommand) error {
+	return CreateCommand(ctx, c)
+}
+func (CommandAdapter) ListCommands(ctx context.Context) ([]models.ExternalCommand, error) {
+	return ListCommands(ctx)
+}
+func (CommandAdapter) GetCommand(ctx context.Context, name string) (models.ExternalCommand, error) {
+	return GetCommand(ctx, name)
+}
+func (CommandAdapter) DeleteCommand(ctx context.Context, name string) error {
+	return DeleteCommand(ctx, name)
+}
+
+// RateLimitAdapter exposes shared token buckets as an object implementing ratelimit.TokenStore.
+type RateLimitAdapter struct{}
+
+func (RateLimitAdapter) TakeToken(ctx context.Context, key string, capacity, perSecond float64, now time.Time) (bool, float64, error) {
+	return TakeRateToken(ctx, key, capacity, perSecond, now)
+}
+
+// ModerationAdapter exposes the moderation configuration and review queue as an object implementing
+// api.ModerationRepository and moderation.Source.
+type ModerationAdapter struct{}
+
+func (ModerationAdapter) GetModerationConfig(ctx context.Context) (models.ModerationConfig, error) {
+	return GetModerationConfig(ctx)
+}
+func (ModerationAdapter) SaveModerationConfig(ctx context.Context, cfg models.ModerationConfig) error {
+	return SaveModerationConfig(ctx, cfg)
+}
+func (ModerationAdapter) QueueFlagged(ctx context.Context, f models.FlaggedMessage) error {
+	return QueueFlagged(ctx, f)
+}
+func (ModerationAdapter) ListFlagged(ctx context.Context, roomID, status string) ([]models.FlaggedMessage, error) {
+	return ListFlagged(ctx, roomID, status)
+}
+func (ModerationAdapter) GetFlagged(ctx context.Context, id string) (models.FlaggedMessage, error) {
+	return GetFlagged(ctx, id)
+}
+func (ModerationAdapter) ReviewFlagged(ctx context.Context, id, status, by string, at time.Time) (models.FlaggedMessage, error) {
+	return ReviewFlagged(ctx, id, status, by, at)
+}
+
+// BlockAdapter exposes block functions as an object implementing api.BlockRepository.
+type BlockAdapter struct{}
+
+func (BlockAdapter) BlockUser(ctx context.Context, b models.Block) error { return BlockUser(ctx, b) }
+func (BlockAdapter) UnblockUser(ctx context.Context, userID, blockedID string) error {
+	return UnblockUser(ctx, userID, blockedID)
+}
+func (BlockAdapter) ListBlocks(ctx context.Context, userID string) ([]models.Block, error) {
+	return ListBlocks(ctx, userID)
+}
+func (BlockAdapter) IsBlocked(ctx context.Context, userID, blockedID string) (bool, error) {
+	return IsBlocked(ctx, userID, blockedID)
+}
+
+// ReportAdapter exposes report functions as an object implementing api.ReportRepository.
+type ReportAdapter struct{}
+
+func (ReportAdapter) GetMessage(ctx context.Context, messageID string) (models.Message, error) {
+	return GetMessage(ctx, messageID)
+}
+func (ReportAdapter) AddReport(ctx context.Context, msg models.Message, e models.ReportEntry) (models.Report, error) {
+	return AddReport(ctx, msg, e)
+}
+func (ReportAdapter) ListReports(ctx context.Context, f models.ReportFilter) ([]models.Report, error) {
+	return ListReports(ctx, f)
+}
+func (ReportAdapter) GetReport(ctx context.Context, messageID string) (models.Report, error) {
+	return GetReport(ctx, messageID)
+}
+func (ReportAdapter) ResolveReport(ctx context.Context, messageID, status, by, note string, at time.Time) (models.Report, error) {
+	return ResolveReport(ctx, messageID, status, by, note, at)
+}
+func (ReportAdapter) SetMessageHidden(ctx context.Context, messageID string, hidden bool) error {
+	return SetMessageHidden(ctx, messageID, hidden)
+}
+
+// KeyAdapter exposes the device key directory as an object implementing api.KeyRepository.
+type KeyAdapter struct{}
+
+func (KeyAdapter) PutDeviceKeys(ctx context.Context, k models.DeviceKeys) error {
+	return PutDeviceKeys(ctx, k)
+}
+func (KeyAdapter) GetDeviceKeys(ctx context.Context, userID, deviceID string) (models.DeviceKeys, error) {
+	return GetDeviceKeys(ctx, userID, deviceID)
+}
+func (KeyAdapter) ListDeviceKeys(ctx context.Context, userID string) ([]models.DeviceKeys, error) {
+	return ListDeviceKeys(ctx, userID)
+}
+func (KeyAdapter) AddOneTimePreKeys(ctx context.Context, userID, deviceID string, keys []models.PreKey) error {
+	return AddOneTimePreKeys(ctx, userID, deviceID, keys)
+}
+func (KeyAdapter) ClaimKeyBundles(ctx context.Context, userID, deviceID string) ([]models.KeyBundle, error) {
+	return ClaimKeyBundles(ctx, userID, deviceID)
+}
+func (KeyAdapter) DeleteDeviceKeys(ctx context.Context, userID, deviceID string) error {
+	return DeleteDeviceKeys(ctx, userID, deviceID)
+}
+
+// EncryptionAdapter exposes room key rotation and re-encryption (rekey.Store, api.KeyRotator).
+type EncryptionAdapter struct{}
+
+func (EncryptionAdapter) RotateRoomKey(ctx context.Context, roomID string) (models.RoomKey, error) {
+	return RotateRoomKey(ctx, roomID)
+}
+func (EncryptionAdapter) RewrapRoomKeys(ctx context.Context) (int, error) {
+	return RewrapRoomKeys(ctx)
+}
+func (EncryptionAdapter) RotateStaleRoomKeys(ctx context.Context, now time.Time, maxAge time.Duration) (int, error) {
+	return RotateStaleRoomKeys(ctx, now, maxAge)
+}
+func (EncryptionAdapter) ReencryptMessages(ctx context.Context, limit int) (int, error) {
+	return ReencryptMessages(ctx, limit)
+}
+func (EncryptionAdapter) AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	return AcquireLease(ctx, name, holder, now, ttl)
+}
+
+// ProfileAdapter exposes profile functions as an object implementing api.ProfileRepository.
+type ProfileAdapter struct{}
+
+func (ProfileAdapter) CreateProfile(ctx context.Context, p models.Profile) error {
+	return CreateProfile(ctx, p)
+}
+func (ProfileAdapter) GetProfile(ctx context.Context, userID string) (models.Profile, error) {
+	return GetProfile(ctx, userID)
+}
+func (ProfileAdapter) GetProfiles(ctx context.Context, userIDs []string) ([]models.Profile, error) {
+	return GetProfiles(ctx, userIDs)
+}
+func (ProfileAdapter) UpdateProfile(ctx context.Context, userID string, u models.ProfileUpdate, at time.Time) (models.Profile, error) {
+	return UpdateProfile(ctx, userID, u, at)
+}
+func (ProfileAdapter) SetStatus(ctx context.Context, userID string, st *models.UserStatus) (models.Profile, error) {
+	return SetStatus(ctx, userID, st)
+}
+func (ProfileAdapter) SetAutoStatus(ctx context.Context, userID string, st *models.UserStatus, now time.Time) (models.Profile, error) {
+	return SetAutoStatus(ctx, userID, st, now)
+}
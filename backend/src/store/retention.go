@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"src/logger"
+	"src/metrics"
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Message documents are stored without bson tags, so the driver lowercases field names
+// (RoomID -> roomid). message_id is additionally set by the upsert filter in InsertMessage.
+const (
+	fieldMessageID = "message_id"
+	fieldRoomID    = "roomid"
+	fieldUserID    = "userid"
+	fieldTimestamp = "timestamp"
+	fieldHidden    = "hidden"
+)
+
+// RetentionPolicy describes one pruning pass.
+type RetentionPolicy struct {
+	// Global applies to rooms without an override; 0 disables it.
+	Global time.Duration
+	// Rooms holds per-room overrides keyed by room id.
+	Rooms map[string]time.Duration
+	// BatchSize bounds each delete so a large backlog does not hold long locks.
+	BatchSize int
+	// DryRun only counts what would be deleted.
+	DryRun bool
+	Now    time.Time
+}
+
+// PruneResult reports how many messages were (or in dry-run, would be) deleted per room;
+// the "*" key covers rooms governed by the global period.
+type PruneResult struct {
+	Deleted map[string]int64 `json:"deleted"`
+	DryRun  bool             `json:"dry_run"`
+}
+
+// Total sums deleted counts.
+func (r PruneResult) Total() int64 {
+	var n int64
+	for _, v := range r.Deleted {
+		n += v
+	}
+	return n
+}
+
+// Prune applies policy, never touching pinned messages or messages under an active legal hold.
+func Prune(ctx context.Context, policy RetentionPolicy) (PruneResult, error) {
+	res := PruneResult{Deleted: map[string]int64{}, DryRun: policy.DryRun}
+	if messagesColl == nil {
+		return res, fmt.Errorf("messages collection not initialized")
+	}
+	pinned, err := PinnedMessageIDs(ctx)
+	if err != nil {
+		return res, err
+	}
+	holds, err := ActiveLegalHolds(ctx)
+	if err != nil {
+		return res, err
+	}
+	if policy.Now.IsZero() {
+		policy.Now = time.Now().UTC()
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 500
+	}
+	for room, filter := range retentionFilters(policy, pinned, holds) {
+		n, err := pruneFilter(ctx, filter, policy)
+		res.Deleted[room] = n
+		if err != nil {
+			return res, err
+		}
+	}
+	if policy.DryRun {
+		metrics.SetRetentionDryRunCandidates(uint64(res.Total()))
+	} else {
+		metrics.IncRetentionPruned(uint64(res.Total()))
+	}
+	logger.Info("retention pass complete", logger.FieldKV("dry_run", policy.DryRun), logger.FieldKV("deleted", res.Deleted),
+		logger.FieldKV("exempt_pinned", len(pinned)), logger.FieldKV("active_holds", len(holds)))
+	return res, nil
+}
+
+// PruneOldMessages deletes messages older than olderThan in every room, keeping pinned and held messages.
+func PruneOldMessages(ctx context.Context, olderThan time.Duration) error {
+	_, err := Prune(ctx, RetentionPolicy{Global: olderThan})
+	return err
+}
+
+func pruneFilter(ctx context.Context, filter bson.M, policy RetentionPolicy) (int64, error) {
+	if policy.DryRun {
+		return messagesColl.CountDocuments(ctx, filter)
+	}
+	var total int64
+	opts := options.Find().SetProjection(bson.M{fieldMessageID: 1}).SetLimit(int64(policy.BatchSize))
+	for {
+		cur, err := messagesColl.Find(ctx, filter, opts)
+		if err != nil {
+			return total, err
+		}
+		var batch []struct {
+			MessageID string `bson:"message_id"`
+		}
+		if err := cur.All(ctx, &batch); err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+		ids := make([]string, len(batch))
+		for i, b := range batch {
+			ids[i] = b.MessageID
+		}
+		// Re-apply the filter so a message pinned or held since the read is not deleted.
+		res, err := messagesColl.DeleteMany(ctx, bson.M{"$and": []bson.M{{fieldMessageID: bson.M{"$in": ids}}, filter}})
+		if err != nil {
+			return total, err
+		}
+		total += res.DeletedCount
+		if len(batch) < policy.BatchSize || res.DeletedCount == 0 {
+			return total, nil
+		}
+	}
+}
+
+// retentionFilters builds one delete filter per room override plus "*" for the global period.
+func retentionFilters(policy RetentionPolicy, pinned []string, holds []models.LegalHold) map[string]bson.M {
+	exempt := []bson.M{}
+	if len(pinned) > 0 {
+		exempt = append(exempt, bson.M{fieldMessageID: bson.M{"$in": pinned}})
+	}
+	for _, h := range holds {
+		exempt = append(exempt, HoldFilter(h))
+	}
+	build := func(scope bson.M, period time.Duration) bson.M {
+		clauses := []bson.M{scope, {fieldTimestamp: bson.M{"$lt": policy.Now.Add(-period)}}}
+		if len(exempt) > 0 {
+			clauses = append(clauses, bson.M{"$nor": exempt})
+		}
+		return bson.M{"$and": clauses}
+	}
+	out := map[string]bson.M{}
+	overridden := []string{}
+	for room, period := range policy.Rooms {
+		if period <= 0 {
+			continue
+		}
+		overridden = append(overridden, room)
+		out[room] = build(roomScope(room), period)
+	}
+	if policy.Global > 0 {
+		scope := bson.M{}
+		if len(overridden) > 0 {
+			nor := make([]bson.M, len(overridden))
+			for i, room := range overridden {
+				nor[i] = roomScope(room)
+			}
+			scope = bson.M{"$nor": nor}
+		}
+		out["*"] = build(scope, policy.Global)
+	}
+	return out
+}
+
+// roomScope matches messages of a room; legacy messages without a room belong to the default room.
+func roomScope(room string) bson.M {
+	if room == models.DefaultRoomID {
+		return bson.M{"$or": []bson.M{{fieldRoomID: room}, {fieldRoomID: bson.M{"$in": []interface{}{nil, ""}}}}}
+	}
+	return bson.M{fieldRoomID: room}
+}
+
+// HoldFilter matches the messages covered by a legal hold.
+func HoldFilter(h models.LegalHold) bson.M {
+	f := bson.M{}
+	if h.RoomID != "" {
+		f = roomScope(h.RoomID)
+	}
+	if h.UserID != "" {
+		f[fieldUserID] = h.UserID
+	}
+	ts := bson.M{}
+	if !h.From.IsZero() {
+		ts["$gte"] = h.From
+	}
+	if !h.To.IsZero() {
+		ts["$lte"] = h.To
+	}
+	if len(ts) > 0 {
+		f[fieldTimestamp] = ts
+	}
+	return f
+}
+
+// ActiveLegalHolds returns holds that have not been released.
+func ActiveLegalHolds(ctx context.Context) ([]models.LegalHold, error) {
+	if holdsColl == nil {
+		return nil, fmt.Errorf("legal holds collection not initialized")
+	}
+	cur, err := holdsColl.Find(ctx, bson.M{"released_at": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []models.LegalHold
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RoomRetentions returns per-room retention overrides.
+func RoomRetentions(ctx context.Context) (map[string]time.Duration, error) {
+	if roomsColl == nil {
+		return nil, fmt.Errorf("rooms collection not initialized")
+	}
+	cur, err := roomsColl.Find(ctx, bson.M{"retention_seconds": bson.M{"$gt": 0}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var rooms []models.Room
+	if err := cur.All(ctx, &rooms); err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Duration, len(rooms))
+	for _, r := range rooms {
+		out[r.RoomID] = time.Duration(r.RetentionSeconds) * time.Second
+	}
+	return out, nil
+}
+
+// SetRoomRetention sets (or with 0 clears) a room's retention override. It upserts so the
+// default room, which has no record until configured, can carry an override too.
+func SetRoomRetention(ctx context.Context, roomID string, retention time.Duration) error {
+	if roomsColl == nil {
+		return fmt.Errorf("rooms collection not initialized")
+	}
+	update := bson.M{"$set": bson.M{"retention_seconds": int64(retention / time.Second)}}
+	if retention <= 0 {
+		update = bson.M{"$unset": bson.M{"retention_seconds": ""}}
+	}
+	_, err := roomsColl.UpdateOne(ctx, bson.M{"room_id": roomID}, update, options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"src/models"
+)
+
+func TestRetentionFilters(t *testing.T) {
+	now := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	policy := RetentionPolicy{Global: 48 * time.Hour, Rooms: map[string]time.Duration{"dev": time.Hour, "off": 0}, Now: now}
+	holds := []models.LegalHold{{RoomID: "dev", UserID: "mallory", From: now.Add(-72 * time.Hour)}}
+	filters := retentionFilters(policy, []string{"pinned-1"}, holds)
+	if len(filters) != 2 {
+		t.Fatalf("expected dev + global filters, got %v", filters)
+	}
+	dev, _ := json.Marshal(filters["dev"])
+	for _, want := range []string{`"roomid":"dev"`, `"pinned-1"`, `"userid":"mallory"`, `"$nor"`, now.Add(-time.Hour).Format("2006-01-02T15:04")} {
+		if !strings.Contains(string(dev), want) {
+			t.Errorf("dev filter %s missing %s", dev, want)
+		}
+	}
+	global, _ := json.Marshal(filters["*"])
+	if !strings.Contains(string(global), `{"$nor":[{"roomid":"dev"}]}`) {
+		t.Errorf("global filter should exclude overridden rooms: %s", global)
+	}
+	if strings.Contains(string(global), `"off"`) {
+		t.Errorf("zero override must fall back to global: %s", global)
+	}
+}
+
+func TestHoldFilterDefaultRoomIncludesLegacy(t *testing.T) {
+	f, _ := json.Marshal(HoldFilter(models.LegalHold{RoomID: models.DefaultRoomID}))
+	if !strings.Contains(string(f), `"$in":[null,""]`) {
+		t.Fatalf("default room hold should cover room-less messages: %s", f)
+	}
+}
+
+func TestPruneWithoutInit(t *testing.T) {
+	if _, err := Prune(context.Background(), RetentionPolicy{Global: time.Hour}); err == nil {
+		t.Fatal("expected error when pruning before Init")
+	}
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/logger"
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const fieldKeyVersion = "sealed.key_version"
+
+var errEncryptionDisabled = errors.New("message encryption not enabled")
+
+func getRoomKey(ctx context.Context, room string, version int) (models.RoomKey, error) {
+	var k models.RoomKey
+	if roomKeysColl == nil {
+		return k, fmt.Errorf("room keys collection not initialized")
+	}
+	err := roomKeysColl.FindOne(ctx, bson.M{"room_id": room, "version": version}).Decode(&k)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return k, models.ErrNotFound
+	}
+	return k, err
+}
+
+// latestRoomKey returns the newest key version of room or models.ErrNotFound.
+func latestRoomKey(ctx context.Context, room string) (models.RoomKey, error) {
+	var k models.RoomKey
+	if roomKeysColl == nil {
+		return k, fmt.Errorf("room keys collection not initialized")
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+	err := roomKeysColl.FindOne(ctx, bson.M{"room_id": room}, opts).Decode(&k)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return k, models.ErrNotFound
+	}
+	return k, err
+}
+
+// createRoomKey generates and stores a wrapped data key; models.ErrConflict if the version exists.
+func createRoomKey(ctx context.Context, room string, version int) (models.RoomKey, error) {
+	if ring == nil {
+		return models.RoomKey{}, errEncryptionDisabled
+	}
+	if roomKeysColl == nil {
+		return models.RoomKey{}, fmt.Errorf("room keys collection not initialized")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return models.RoomKey{}, err
+	}
+	wrapped, err := ring.wrapper.Wrap(ctx, dataKey)
+	if err != nil {
+		return models.RoomKey{}, fmt.Errorf("wrap room key: %w", err)
+	}
+	k := models.RoomKey{RoomID: room, Version: version, Wrapped: wrapped, MasterKeyID: ring.wrapper.ActiveKeyID(), CreatedAt: time.Now().UTC()}
+	if _, err := roomKeysColl.InsertOne(ctx, k); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return k, models.ErrConflict
+		}
+		return k, err
+	}
+	ring.mu.Lock()
+	ring.keys[cacheKey(room, version)] = dataKey
+	ring.mu.Unlock()
+	return k, nil
+}
+
+// RotateRoomKey starts a new data key version for room. New messages are sealed with it at once on
+// this replica and within a minute on others; ReencryptMessages moves older messages onto it.
+// models.ErrConflict if another rotation of the room won the race.
+func RotateRoomKey(ctx context.Context, room string) (models.RoomKey, error) {
+	if ring == nil {
+		return models.RoomKey{}, errEncryptionDisabled
+	}
+	room = roomKeyID(room)
+	cur, err := latestRoomKey(ctx, room)
+	if err != nil && !errors.Is(err, models.ErrNotFound) {
+		return cur, err
+	}
+	k, err := createRoomKey(ctx, room, cur.Version+1)
+	if err != nil {
+		return k, err
+	}
+	ring.setCurrent(room, k.Version)
+	logger.Info("room key rotated", logger.FieldKV("room_id", room), logger.FieldKV("version", k.Version))
+	return k, nil
+}
+
+// RotateStaleRoomKeys rotates the key of every room whose current version is older than maxAge and
+// returns how many rooms were rotated.
+func RotateStaleRoomKeys(ctx context.Context, now time.Time, maxAge time.Duration) (int, error) {
+	if roomKeysColl == nil {
+		return 0, fmt.Errorf("room keys collection not initialized")
+	}
+	cur, err := roomKeysColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "room_id", Value: 1}, {Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$room_id", "created_at": bson.M{"$first": "$created_at"}}}},
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$lt": now.Add(-maxAge)}}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var stale []struct {
+		RoomID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &stale); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, s := range stale {
+		if _, err := RotateRoomKey(ctx, s.RoomID); err != nil && !errors.Is(err, models.ErrConflict) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RewrapRoomKeys rewraps room keys held under a master key other than the active one, so the old
+// master key can be retired. The data keys, and so the messages, are unchanged.
+func RewrapRoomKeys(ctx context.Context) (int, error) {
+	if ring == nil {
+		return 0, errEncryptionDisabled
+	}
+	if roomKeysColl == nil {
+		return 0, fmt.Errorf("room keys collection not initialized")
+	}
+	active := ring.wrapper.ActiveKeyID()
+	cur, err := roomKeysColl.Find(ctx, bson.M{"master_key_id": bson.M{"$ne": active}})
+	if err != nil {
+		return 0, err
+	}
+	var keys []models.RoomKey
+	if err := cur.All(ctx, &keys); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, k := range keys {
+		dataKey, err := ring.wrapper.Unwrap(ctx, k.MasterKeyID, k.Wrapped)
+		if err != nil {
+			return n, fmt.Errorf("unwrap key %s/%d: %w", k.RoomID, k.Version, err)
+		}
+		wrapped, err := ring.wrapper.Wrap(ctx, dataKey)
+		if err != nil {
+			return n, fmt.Errorf("wrap key %s/%d: %w", k.RoomID, k.Version, err)
+		}
+		filter := bson.M{"room_id": k.RoomID, "version": k.Version, "master_key_id": k.MasterKeyID}
+		if _, err := roomKeysColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"wrapped": wrapped, "master_key_id": active}}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// needsSealing matches messages stored in plain text, before encryption was turned on.
+var needsSealing = bson.M{"sealed": bson.M{"$exists": false}, "$or": []bson.M{{"content": bson.M{"$nin": []interface{}{nil, ""}}}, {"html": bson.M{"$nin": []interface{}{nil, ""}}}}}
+
+// ReencryptMessages seals up to limit messages that are in plain text or sealed with an older key
+// version than their room's current one, and returns how many it rewrote. Once a room has no
+// messages left under old versions, those versions are deleted.
+func ReencryptMessages(ctx context.Context, limit int) (int, error) {
+	if ring == nil {
+		return 0, errEncryptionDisabled
+	}
+	if messagesColl == nil || roomKeysColl == nil {
+		return 0, fmt.Errorf("messages collection not initialized")
+	}
+	rooms, err := roomKeysColl.Distinct(ctx, "room_id", bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	plain, err := messagesColl.Distinct(ctx, fieldRoomID, needsSealing)
+	if err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	n := 0
+	for _, r := range append(rooms, plain...) {
+		room, _ := r.(string)
+		room = roomKeyID(room)
+		if seen[room] || n >= limit {
+			continue
+		}
+		seen[room] = true
+		done, err := reencryptRoom(ctx, room, limit-n)
+		n += done
+		if err != nil {
+			return n, fmt.Errorf("re-encrypt %s: %w", room, err)
+		}
+	}
+	return n, nil
+}
+
+func reencryptRoom(ctx context.Context, room string, limit int) (int, error) {
+	version, key, err := ring.currentKey(ctx, room)
+	if err != nil {
+		return 0, err
+	}
+	filter := bson.M{"$and": []bson.M{roomScope(room), {"$or": []bson.M{needsSealing, {fieldKeyVersion: bson.M{"$lt": version}}}}}}
+	cur, err := messagesColl.Find(ctx, filter, options.Find().SetLimit(int64(limit)))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	n := 0
+	for cur.Next(ctx) {
+		var sm storedMessage
+		if err := cur.Decode(&sm); err != nil {
+			return n, err
+		}
+		msg, err := openMessage(ctx, sm)
+		if err != nil {
+			return n, err
+		}
+		resealed, err := sealContent(key, version, msg)
+		if err != nil {
+			return n, err
+		}
+		// Only replace what was read, in case another pass got there first.
+		match := bson.M{fieldMessageID: sm.MessageID, "sealed": bson.M{"$exists": false}}
+		if sm.Sealed != nil {
+			match = bson.M{fieldMessageID: sm.MessageID, fieldKeyVersion: sm.Sealed.KeyVersion}
+		}
+		update := bson.M{"$set": bson.M{"content": "", "html": "", "sealed": resealed.Sealed}}
+		if _, err := messagesColl.UpdateOne(ctx, match, update); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, retireRoomKeys(ctx, room, version)
+	}
+	return n, nil
+}
+
+// retireRoomKeys deletes the versions of room before version once no message uses them. Replicas
+// may keep sealing with the previous version for currentKeyTTL after a rotation, so versions are
+// only retired once the current one is older than twice that.
+func retireRoomKeys(ctx context.Context, room string, version int) error {
+	k, err := getRoomKey(ctx, room, version)
+	if err != nil || version <= 1 || time.Since(k.CreatedAt) < 2*currentKeyTTL {
+		return err
+	}
+	if used, err := messagesColl.CountDocuments(ctx, bson.M{"$and": []bson.M{roomScope(room), {fieldKeyVersion: bson.M{"$lt": version}}}}, options.Count().SetLimit(1)); err != nil || used > 0 {
+		return err
+	}
+	res, err := roomKeysColl.DeleteMany(ctx, bson.M{"room_id": room, "version": bson.M{"$lt": version}})
+	if err == nil && res.DeletedCount > 0 {
+		logger.Info("old room keys retired", logger.FieldKV("room_id", room), logger.FieldKV("deleted", res.DeletedCount))
+	}
+	return err
+}
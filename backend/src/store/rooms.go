@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateRoom inserts a new room; returns models.ErrConflict if the id is taken.
+func CreateRoom(ctx context.Context, room models.Room) error {
+	if roomsColl == nil {
+		return fmt.Errorf("rooms collection not initialized")
+	}
+	if _, err := roomsColl.InsertOne(ctx, room); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// GetRoom returns a room by id or models.ErrNotFound.
+func GetRoom(ctx context.Context, roomID string) (models.Room, error) {
+	var r models.Room
+	if roomsColl == nil {
+		return r, fmt.Errorf("rooms collection not initialized")
+	}
+	err := roomsColl.FindOne(ctx, bson.M{"room_id": roomID}).Decode(&r)
+	if err == mongo.ErrNoDocuments {
+		return r, models.ErrNotFound
+	}
+	return r, err
+}
+
+// PinMessage records a pin (idempotent per room/message).
+func PinMessage(ctx context.Context, pin models.Pin) error {
+	if pinsColl == nil {
+		return fmt.Errorf("pins collection not initialized")
+	}
+	filter := bson.M{"room_id": pin.RoomID, "message_id": pin.MessageID}
+	_, err := pinsColl.UpdateOne(ctx, filter, bson.M{"$setOnInsert": pin}, options.Update().SetUpsert(true))
+	return err
+}
+
+// UnpinMessage removes a pin; returns models.ErrNotFound if it was not pinned.
+func UnpinMessage(ctx context.Context, roomID, messageID string) error {
+	if pinsColl == nil {
+		return fmt.Errorf("pins collection not initialized")
+	}
+	res, err := pinsColl.DeleteOne(ctx, bson.M{"room_id": roomID, "message_id": messageID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// ListPins returns the pins of a room, newest first.
+func ListPins(ctx context.Context, roomID string) ([]models.Pin, error) {
+	if pinsColl == nil {
+		return nil, fmt.Errorf("pins collection not initialized")
+	}
+	cur, err := pinsColl.Find(ctx, bson.M{"room_id": roomID}, options.Find().SetSort(bson.D{{Key: "pinned_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Pin{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PinnedMessageIDs returns the ids of all pinned messages across rooms (used by retention).
+func PinnedMessageIDs(ctx context.Context) ([]string, error) {
+	if pinsColl == nil {
+		return nil, fmt.Errorf("pins collection not initialized")
+	}
+	vals, err := pinsColl.Distinct(ctx, "message_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if id, ok := v.(string); ok {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// GetRoomRole returns the role granted to user in room, or "" if none.
+func GetRoomRole(ctx context.Context, roomID, userID string) (models.Role, error) {
+	if roomRolesColl == nil {
+		return "", fmt.Errorf("room roles collection not initialized")
+	}
+	var rr models.RoomRole
+	err := roomRolesColl.FindOne(ctx, bson.M{"room_id": roomID, "user_id": userID}).Decode(&rr)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return rr.Role, err
+}
+
+// SetRoomRole grants (or replaces) a user's role in a room.
+func SetRoomRole(ctx context.Context, rr models.RoomRole) error {
+	if roomRolesColl == nil {
+		return fmt.Errorf("room roles collection not initialized")
+	}
+	filter := bson.M{"room_id": rr.RoomID, "user_id": rr.UserID}
+	_, err := roomRolesColl.ReplaceOne(ctx, filter, rr, options.Replace().SetUpsert(true))
+	return err
+}
+
+// RemoveRoomRole revokes a user's room role; models.ErrNotFound if none was granted.
+func RemoveRoomRole(ctx context.Context, roomID, userID string) error {
+	if roomRolesColl == nil {
+		return fmt.Errorf("room roles collection not initialized")
+	}
+	res, err := roomRolesColl.DeleteOne(ctx, bson.M{"room_id": roomID, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// ListRoomRoles returns the role grants of a room.
+func ListRoomRoles(ctx context.Context, roomID string) ([]models.RoomRole, error) {
+	if roomRolesColl == nil {
+		return nil, fmt.Errorf("room roles collection not initialized")
+	}
+	cur, err := roomRolesColl.Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.RoomRole{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetRoomTopic sets a room's topic, creating the room record for the default room if needed.
+func SetRoomTopic(ctx context.Context, roomID, topic string) error {
+	if roomsColl == nil {
+		return fmt.Errorf("rooms collection not initialized")
+	}
+	update := bson.M{"$set": bson.M{"topic": topic}}
+	if topic == "" {
+		update = bson.M{"$unset": bson.M{"topic": ""}}
+	}
+	_, err := roomsColl.UpdateOne(ctx, bson.M{"room_id": roomID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// AddRoomMember records a member; adding an existing member returns models.ErrConflict.
+func AddRoomMember(ctx context.Context, m models.RoomMember) error {
+	if membersColl == nil {
+		return fmt.Errorf("room members collection not initialized")
+	}
+	if _, err := membersColl.InsertOne(ctx, m); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// ListRoomMembers returns the members added to a room, oldest first.
+func ListRoomMembers(ctx context.Context, roomID string) ([]models.RoomMember, error) {
+	if membersColl == nil {
+		return nil, fmt.Errorf("room members collection not initialized")
+	}
+	cur, err := membersColl.Find(ctx, bson.M{"room_id": roomID}, options.Find().SetSort(bson.D{{Key: "added_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.RoomMember{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserRooms returns the rooms userID takes part in: rooms they own, were added to or posted in,
+// and direct message rooms with them.
+func UserRooms(ctx context.Context, userID string) ([]string, error) {
+	if messagesColl == nil || roomsColl == nil || membersColl == nil {
+		return nil, fmt.Errorf("rooms collections not initialized")
+	}
+	q := regexp.QuoteMeta(userID)
+	posted, err := messagesColl.Distinct(ctx, fieldRoomID, bson.M{"$or": []bson.M{
+		{fieldUserID: userID},
+		{fieldRoomID: bson.M{"$regex": "^dm:(" + q + ",|[^,]*," + q + "$)"}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	owned, err := roomsColl.Distinct(ctx, "room_id", bson.M{"owner_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	added, err := membersColl.Distinct(ctx, "room_id", bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, list := range [][]interface{}{posted, owned, added} {
+		for _, v := range list {
+			room, _ := v.(string)
+			if room = roomKeyID(room); !seen[room] {
+				seen[room] = true
+				out = append(out, room)
+			}
+		}
+	}
+	return out, nil
+}
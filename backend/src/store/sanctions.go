@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateSanction stores a new sanction.
+func CreateSanction(ctx context.Context, s models.Sanction) error {
+	if sanctionsColl == nil {
+		return fmt.Errorf("sanctions collection not initialized")
+	}
+	_, err := sanctionsColl.InsertOne(ctx, s)
+	return err
+}
+
+// ActiveSanctions returns the sanctions of user that are neither lifted nor expired at now, in any room.
+func ActiveSanctions(ctx context.Context, userID string, now time.Time) ([]models.Sanction, error) {
+	if sanctionsColl == nil {
+		return nil, fmt.Errorf("sanctions collection not initialized")
+	}
+	filter := bson.M{
+		"user_id":   userID,
+		"lifted_at": bson.M{"$exists": false},
+		"$or":       bson.A{bson.M{"expires_at": bson.M{"$exists": false}}, bson.M{"expires_at": bson.M{"$gt": now}}},
+	}
+	cur, err := sanctionsColl.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Sanction{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListSanctions returns sanctions, including lifted and expired ones, newest first. Empty userID or
+// roomID match any; roomID "*" matches only global sanctions.
+func ListSanctions(ctx context.Context, userID, roomID string) ([]models.Sanction, error) {
+	if sanctionsColl == nil {
+		return nil, fmt.Errorf("sanctions collection not initialized")
+	}
+	q := bson.M{}
+	if userID != "" {
+		q["user_id"] = userID
+	}
+	switch roomID {
+	case "":
+	case "*":
+		q["room_id"] = bson.M{"$exists": false}
+	default:
+		q["room_id"] = roomID
+	}
+	cur, err := sanctionsColl.Find(ctx, q, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(500))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.Sanction{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSanction returns sanction id, or models.ErrNotFound.
+func GetSanction(ctx context.Context, id string) (models.Sanction, error) {
+	var sn models.Sanction
+	if sanctionsColl == nil {
+		return sn, fmt.Errorf("sanctions collection not initialized")
+	}
+	err := sanctionsColl.FindOne(ctx, bson.M{"_id": id}).Decode(&sn)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return sn, models.ErrNotFound
+	}
+	return sn, err
+}
+
+// LiftSanction ends sanction id at and returns it; models.ErrConflict if it was already lifted,
+// models.ErrNotFound if there is none.
+func LiftSanction(ctx context.Context, id string, at time.Time) (models.Sanction, error) {
+	var sn models.Sanction
+	if sanctionsColl == nil {
+		return sn, fmt.Errorf("sanctions collection not initialized")
+	}
+	err := sanctionsColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "lifted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"lifted_at": at}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&sn)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if ferr := sanctionsColl.FindOne(ctx, bson.M{"_id": id}).Err(); errors.Is(ferr, mongo.ErrNoDocuments) {
+			return sn, models.ErrNotFound
+		}
+		return sn, models.ErrConflict
+	}
+	return sn, err
+}
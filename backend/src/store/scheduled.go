@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateScheduled stores a pending scheduled message.
+func CreateScheduled(ctx context.Context, sm models.ScheduledMessage) error {
+	if scheduledColl == nil {
+		return fmt.Errorf("scheduled collection not initialized")
+	}
+	_, err := scheduledColl.InsertOne(ctx, sm)
+	return err
+}
+
+// ListScheduled returns pending scheduled messages created by user, soonest first.
+func ListScheduled(ctx context.Context, createdBy string) ([]models.ScheduledMessage, error) {
+	if scheduledColl == nil {
+		return nil, fmt.Errorf("scheduled collection not initialized")
+	}
+	filter := bson.M{"created_by": createdBy, "status": models.ScheduledPending}
+	cur, err := scheduledColl.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.ScheduledMessage{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CancelScheduled cancels a pending message owned by createdBy; models.ErrNotFound otherwise.
+func CancelScheduled(ctx context.Context, id, createdBy string) error {
+	if scheduledColl == nil {
+		return fmt.Errorf("scheduled collection not initialized")
+	}
+	filter := bson.M{"_id": id, "created_by": createdBy, "status": models.ScheduledPending}
+	res, err := scheduledColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.ScheduledCanceled}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// DueScheduled returns messages due at now: pending ones plus claims older than staleAfter
+// (a leader that crashed mid-publish). Re-publishing is safe because message ids are fixed.
+func DueScheduled(ctx context.Context, now time.Time, staleAfter time.Duration, limit int) ([]models.ScheduledMessage, error) {
+	if scheduledColl == nil {
+		return nil, fmt.Errorf("scheduled collection not initialized")
+	}
+	filter := bson.M{"send_at": bson.M{"$lte": now}, "$or": []bson.M{
+		{"status": models.ScheduledPending},
+		{"status": models.ScheduledSending, "claimed_at": bson.M{"$lt": now.Add(-staleAfter)}},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}}).SetLimit(int64(limit))
+	cur, err := scheduledColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var out []models.ScheduledMessage
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClaimScheduled atomically moves a due message to sending; false if another worker won or it was canceled.
+func ClaimScheduled(ctx context.Context, sm models.ScheduledMessage, now time.Time) (bool, error) {
+	if scheduledColl == nil {
+		return false, fmt.Errorf("scheduled collection not initialized")
+	}
+	filter := bson.M{"_id": sm.ID, "status": sm.Status}
+	if sm.Status == models.ScheduledSending {
+		filter["claimed_at"] = sm.ClaimedAt
+	}
+	res, err := scheduledColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.ScheduledSending, "claimed_at": now}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// SetScheduledStatus records the outcome of a publish attempt.
+func SetScheduledStatus(ctx context.Context, id, status string) error {
+	if scheduledColl == nil {
+		return fmt.Errorf("scheduled collection not initialized")
+	}
+	_, err := scheduledColl.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": status}})
+	return err
+}
+
+// AcquireLease takes or renews the named lease for holder until now+ttl. It returns false while
+// another holder's lease is unexpired; used for leader election across replicas.
+func AcquireLease(ctx context.Context, name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	if leasesColl == nil {
+		return false, fmt.Errorf("leases collection not initialized")
+	}
+	filter := bson.M{"_id": name, "$or": []bson.M{{"holder": holder}, {"expires_at": bson.M{"$lt": now}}}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}}
+	_, err := leasesColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease document exists and is held by someone else.
+		return false, nil
+	}
+	return err == nil, err
+}
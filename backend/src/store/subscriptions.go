@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateWebhookSubscription stores a new subscription.
+func CreateWebhookSubscription(ctx context.Context, s models.WebhookSubscription) error {
+	if subsColl == nil {
+		return fmt.Errorf("webhook subscriptions collection not initialized")
+	}
+	_, err := subsColl.InsertOne(ctx, s)
+	return err
+}
+
+// ListWebhookSubscriptions returns all subscriptions (including disabled ones), oldest first.
+func ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	if subsColl == nil {
+		return nil, fmt.Errorf("webhook subscriptions collection not initialized")
+	}
+	cur, err := subsColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.WebhookSubscription{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DisableWebhookSubscription disables an active subscription; models.ErrNotFound if there is none.
+func DisableWebhookSubscription(ctx context.Context, id string, at time.Time) error {
+	if subsColl == nil {
+		return fmt.Errorf("webhook subscriptions collection not initialized")
+	}
+	res, err := subsColl.UpdateOne(ctx, bson.M{"_id": id, "disabled_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"disabled_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// CreateWebhookDelivery queues a delivery. Queuing the same event for the same subscription again
+// (Kafka redelivers after a crash) is a no-op.
+func CreateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	if deliveryColl == nil {
+		return fmt.Errorf("webhook deliveries collection not initialized")
+	}
+	if _, err := deliveryColl.InsertOne(ctx, d); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// DueWebhookDeliveries returns pending deliveries whose next attempt is due, oldest first.
+func DueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	if deliveryColl == nil {
+		return nil, fmt.Errorf("webhook deliveries collection not initialized")
+	}
+	filter := bson.M{"status": models.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(int64(limit))
+	cur, err := deliveryColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.WebhookDelivery{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RecordWebhookAttempt appends an attempt and moves the delivery to status, due again at next.
+func RecordWebhookAttempt(ctx context.Context, id string, a models.DeliveryAttempt, status string, next time.Time) error {
+	if deliveryColl == nil {
+		return fmt.Errorf("webhook deliveries collection not initialized")
+	}
+	_, err := deliveryColl.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$push": bson.M{"attempts": a},
+		"$set":  bson.M{"status": status, "next_attempt_at": next},
+	})
+	return err
+}
+
+// ListWebhookDeliveries returns a subscription's most recent deliveries, newest first.
+func ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]models.WebhookDelivery, error) {
+	if deliveryColl == nil {
+		return nil, fmt.Errorf("webhook deliveries collection not initialized")
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit))
+	cur, err := deliveryColl.Find(ctx, bson.M{"subscription_id": subscriptionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.WebhookDelivery{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RequeueWebhookDelivery makes a parked delivery of subscriptionID due at now; models.ErrNotFound
+// if there is no such parked delivery.
+func RequeueWebhookDelivery(ctx context.Context, subscriptionID, id string, now time.Time) error {
+	if deliveryColl == nil {
+		return fmt.Errorf("webhook deliveries collection not initialized")
+	}
+	filter := bson.M{"_id": id, "subscription_id": subscriptionID, "status": models.DeliveryParked}
+	res, err := deliveryColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.DeliveryPending, "next_attempt_at": now}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateServiceAccount stores a new account; models.ErrConflict if the name is taken.
+func CreateServiceAccount(ctx context.Context, a models.ServiceAccount) error {
+	if accountsColl == nil {
+		return fmt.Errorf("service accounts collection not initialized")
+	}
+	if _, err := accountsColl.InsertOne(ctx, a); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// ListServiceAccounts returns all accounts, oldest first.
+func ListServiceAccounts(ctx context.Context) ([]models.ServiceAccount, error) {
+	if accountsColl == nil {
+		return nil, fmt.Errorf("service accounts collection not initialized")
+	}
+	cur, err := accountsColl.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.ServiceAccount{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetServiceAccount returns the account with id or models.ErrNotFound.
+func GetServiceAccount(ctx context.Context, id string) (models.ServiceAccount, error) {
+	var a models.ServiceAccount
+	if accountsColl == nil {
+		return a, fmt.Errorf("service accounts collection not initialized")
+	}
+	err := accountsColl.FindOne(ctx, bson.M{"_id": id}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return a, models.ErrNotFound
+	}
+	return a, err
+}
+
+// DisableServiceAccount disables an active account and revokes all of its tokens.
+func DisableServiceAccount(ctx context.Context, id string, at time.Time) error {
+	if accountsColl == nil || tokensColl == nil {
+		return fmt.Errorf("service accounts collection not initialized")
+	}
+	res, err := accountsColl.UpdateOne(ctx, bson.M{"_id": id, "disabled_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"disabled_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	_, err = tokensColl.UpdateMany(ctx, bson.M{"account_id": id, "revoked_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revoked_at": at}})
+	return err
+}
+
+// CreateAPIToken stores a token record (hash only).
+func CreateAPIToken(ctx context.Context, t models.APIToken) error {
+	if tokensColl == nil {
+		return fmt.Errorf("api tokens collection not initialized")
+	}
+	_, err := tokensColl.InsertOne(ctx, t)
+	return err
+}
+
+// ListAPITokens returns an account's tokens (including revoked ones), newest first.
+func ListAPITokens(ctx context.Context, accountID string) ([]models.APIToken, error) {
+	if tokensColl == nil {
+		return nil, fmt.Errorf("api tokens collection not initialized")
+	}
+	cur, err := tokensColl.Find(ctx, bson.M{"account_id": accountID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.APIToken{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetAPIToken returns the token record with id or models.ErrNotFound.
+func GetAPIToken(ctx context.Context, id string) (models.APIToken, error) {
+	var t models.APIToken
+	if tokensColl == nil {
+		return t, fmt.Errorf("api tokens collection not initialized")
+	}
+	err := tokensColl.FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return t, models.ErrNotFound
+	}
+	return t, err
+}
+
+// RevokeAPIToken revokes an active token of accountID; models.ErrNotFound if there is none.
+func RevokeAPIToken(ctx context.Context, accountID, id string, at time.Time) error {
+	if tokensColl == nil {
+		return fmt.Errorf("api tokens collection not initialized")
+	}
+	res, err := tokensColl.UpdateOne(ctx, bson.M{"_id": id, "account_id": accountID, "revoked_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIToken records the last use of a token.
+func TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	if tokensColl == nil {
+		return fmt.Errorf("api tokens collection not initialized")
+	}
+	_, err := tokensColl.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIncomingWebhook stores a new hook.
+func CreateIncomingWebhook(ctx context.Context, h models.IncomingWebhook) error {
+	if hooksColl == nil {
+		return fmt.Errorf("incoming webhooks collection not initialized")
+	}
+	_, err := hooksColl.InsertOne(ctx, h)
+	return err
+}
+
+// ListIncomingWebhooks returns hooks (including revoked ones), newest first; roomID "" lists all rooms.
+func ListIncomingWebhooks(ctx context.Context, roomID string) ([]models.IncomingWebhook, error) {
+	if hooksColl == nil {
+		return nil, fmt.Errorf("incoming webhooks collection not initialized")
+	}
+	filter := bson.M{}
+	if roomID != "" {
+		filter["room_id"] = roomID
+	}
+	cur, err := hooksColl.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := []models.IncomingWebhook{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetIncomingWebhook returns the hook with id or models.ErrNotFound.
+func GetIncomingWebhook(ctx context.Context, id string) (models.IncomingWebhook, error) {
+	var h models.IncomingWebhook
+	if hooksColl == nil {
+		return h, fmt.Errorf("incoming webhooks collection not initialized")
+	}
+	err := hooksColl.FindOne(ctx, bson.M{"_id": id}).Decode(&h)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return h, models.ErrNotFound
+	}
+	return h, err
+}
+
+// RevokeIncomingWebhook revokes an active hook; models.ErrNotFound if there is none.
+func RevokeIncomingWebhook(ctx context.Context, id string, at time.Time) error {
+	if hooksColl == nil {
+		return fmt.Errorf("incoming webhooks collection not initialized")
+	}
+	res, err := hooksColl.UpdateOne(ctx, bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return models.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Package webhook signs and verifies webhook payloads.
+//
+// A signature is "sha256=" + hex(HMAC-SHA256(secret, "<unix timestamp>.<body>")). The timestamp
+// travels in its own header and is part of the signed data, so a captured request stops verifying
+// once it is older than the allowed skew.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers carrying the signature and the signed timestamp.
+const (
+	SignatureHeader = "X-Chatapp-Signature"
+	TimestampHeader = "X-Chatapp-Timestamp"
+)
+
+const signaturePrefix = "sha256="
+
+var (
+	ErrMissingSignature = errors.New("missing signature or timestamp")
+	ErrBadSignature     = errors.New("signature mismatch")
+	ErrStaleTimestamp   = errors.New("timestamp outside the allowed skew")
+)
+
+// NewSecret returns a random hex encoded signing secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value for body sent at ts.
+func Sign(secret string, ts time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, strconv.FormatInt(ts.Unix(), 10), body))
+}
+
+// Verify checks signature and timestamp (the raw header values) against body. Timestamps further
+// than skew from now, in either direction, are rejected.
+func Verify(secret, timestamp, signature string, body []byte, now time.Time, skew time.Duration) error {
+	if timestamp == "" || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrMissingSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > skew || d < -skew {
+		return ErrStaleTimestamp
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !hmac.Equal(got, mac(secret, timestamp, body)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
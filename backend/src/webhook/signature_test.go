@@ -0,0 +1,47 @@
+package webhook
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1735732800, 0)
+	body := []byte(`{"text":"deployed"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign("s3cret", now, body)
+
+	cases := []struct {
+		name            string
+		secret, ts, sig string
+		body            []byte
+		at              time.Time
+		want            error
+	}{
+		{"valid", "s3cret", ts, sig, body, now.Add(time.Minute), nil},
+		{"wrong secret", "other", ts, sig, body, now, ErrBadSignature},
+		{"tampered body", "s3cret", ts, sig, []byte(`{"text":"rm -rf"}`), now, ErrBadSignature},
+		{"timestamp not signed", "s3cret", strconv.FormatInt(now.Unix()+1, 10), sig, body, now, ErrBadSignature},
+		{"replayed", "s3cret", ts, sig, body, now.Add(10 * time.Minute), ErrStaleTimestamp},
+		{"from the future", "s3cret", ts, sig, body, now.Add(-10 * time.Minute), ErrStaleTimestamp},
+		{"missing signature", "s3cret", ts, "", body, now, ErrMissingSignature},
+		{"missing timestamp", "s3cret", "", sig, body, now, ErrMissingSignature},
+	}
+	for _, c := range cases {
+		if err := Verify(c.secret, c.ts, c.sig, c.body, c.at, 5*time.Minute); !errors.Is(err, c.want) {
+			t.Errorf("%s: expected %v got %v", c.name, c.want, err)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil || len(a) != 64 {
+		t.Fatalf("unexpected secret %q: %v", a, err)
+	}
+	if b, _ := NewSecret(); a == b {
+		t.Fatal("secrets are not unique")
+	}
+}
@@ -38,6 +38,12 @@ function wsBase() {
   return process.env.VUE_APP_WS_URL || runtime.VUE_APP_WS_URL;
 }
 
+// Subprotocol the backend selects; the token rides along as "bearer.<token>" so it never
+// appears in the URL (and therefore not in proxy access logs).
+const WS_PROTOCOL = "chatapp.v1";
+// Renew the socket session this long before the server would close it.
+const REAUTH_LEEWAY_MS = 60 * 1000;
+
 export const chatService = {
   get userManager() { return getUserManager(); },
 
@@ -105,12 +111,36 @@ export const chatService = {
   async connectWebSocket(onMessage) {
     const token = await this.getAccessToken();
     if (!token) throw new Error("User not authenticated");
-    const socket = new WebSocket(`${wsBase()}?token=${token}`);
+    const socket = new WebSocket(wsBase(), [WS_PROTOCOL, `bearer.${token}`]);
+    let reauthTimer;
+    // The server closes the socket when the token expires; send a fresh one shortly before.
+    const scheduleReauth = (expiresAt) => {
+      clearTimeout(reauthTimer);
+      const delay = Math.max(new Date(expiresAt).getTime() - Date.now() - REAUTH_LEEWAY_MS, 0);
+      reauthTimer = setTimeout(async () => {
+        try {
+          const renewed = await getUserManager().signinSilent();
+          if (socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify({ type: "reauth", token: renewed.access_token }));
+          }
+        } catch (e) {
+          console.error("WebSocket reauth failed:", e);
+        }
+      }, delay);
+    };
     socket.onopen = () => console.log("WebSocket connection established.");
-    socket.onmessage = (ev) => onMessage(JSON.parse(ev.data));
+    socket.onmessage = (ev) => {
+      const data = JSON.parse(ev.data);
+      if (data.type === "auth_ok" && data.expires_at) scheduleReauth(data.expires_at);
+      onMessage(data);
+    };
     socket.onerror = (err) => console.error("WebSocket error:", err);
     socket.onclose = (ev) => {
-      if (ev.wasClean) {
+      clearTimeout(reauthTimer);
+      // 4401: auth failed or expired; 4403: banned or kicked by a moderator
+      if (ev.code === 4401 || ev.code === 4403) {
+        console.error(`WebSocket session ended: ${ev.reason}`);
+      } else if (ev.wasClean) {
         console.log(`WebSocket closed cleanly code=${ev.code} reason=${ev.reason}`);
       } else {
         console.error("WebSocket connection died");
@@ -118,4 +148,4 @@ export const chatService = {
     };
     return socket;
   },
-};
\ No newline at end of file
+};
@@ -17,4 +17,29 @@ describe('ChatWindow.vue', () => {
     expect(wrapper.text()).toContain('Hello');
     expect(wrapper.text()).toContain('Hi');
   });
+
+  it('renders server sanitized html when present', () => {
+    const wrapper = mount(ChatWindow, {
+      props: { messages: [{ user_id: 'alice', content: '**bold**', format: 'markdown', html: '<p><strong>bold</strong></p>' }] },
+    });
+    expect(wrapper.find('.message-html strong').text()).toBe('bold');
+  });
+
+  it('marks messages from service accounts with a bot badge', () => {
+    const wrapper = mount(ChatWindow, {
+      props: { messages: [{ user_id: 'deploy-bot', content: 'deployed', bot: true }, { user_id: 'alice', content: 'thanks' }] },
+    });
+    const rendered = wrapper.findAll('.message');
+    expect(rendered[0].find('.bot-badge').exists()).toBe(true);
+    expect(rendered[1].find('.bot-badge').exists()).toBe(false);
+  });
+
+  it('renders ephemeral command replies as visible only to the caller', () => {
+    const wrapper = mount(ChatWindow, {
+      props: { messages: [{ user_id: '/topic', content: 'topic updated', ephemeral: true, timestamp: new Date().toISOString() }] },
+    });
+    const rendered = wrapper.find('.message');
+    expect(rendered.classes()).toContain('ephemeral');
+    expect(rendered.text()).toContain('only visible to you');
+  });
 });
@@ -32,3 +32,45 @@ describe('chatService runtime config', () => {
     expect(token).toBe('abc');
   });
 });
+
+describe('chatService websocket', () => {
+  let sockets;
+  beforeEach(() => {
+    sockets = [];
+    global.WebSocket = jest.fn().mockImplementation(function (url, protocols) {
+      this.url = url;
+      this.protocols = protocols;
+      this.readyState = 1;
+      this.send = jest.fn();
+      sockets.push(this);
+    });
+    global.WebSocket.OPEN = 1;
+  });
+
+  afterEach(() => {
+    jest.useRealTimers();
+  });
+
+  it('sends the token as a subprotocol instead of the query string', async () => {
+    await chatService.connectWebSocket(() => {});
+    expect(sockets[0].url).toBe('wss://ws');
+    expect(sockets[0].protocols).toEqual(['chatapp.v1', 'bearer.abc']);
+  });
+
+  it('re-authenticates before the session expires', async () => {
+    jest.useFakeTimers();
+    const received = [];
+    await chatService.connectWebSocket((m) => received.push(m));
+    const socket = sockets[0];
+    const expiresAt = new Date(Date.now() + 90 * 1000).toISOString();
+    socket.onmessage({ data: JSON.stringify({ type: 'auth_ok', expires_at: expiresAt }) });
+    expect(received[0].type).toBe('auth_ok');
+
+    jest.advanceTimersByTime(29 * 1000);
+    expect(socket.send).not.toHaveBeenCalled();
+    jest.advanceTimersByTime(2 * 1000);
+    await Promise.resolve();
+    await Promise.resolve();
+    expect(socket.send).toHaveBeenCalledWith(JSON.stringify({ type: 'reauth', token: 'renewed' }));
+  });
+});